@@ -0,0 +1,128 @@
+// Command kkrpc-load replays a session captured with kkrpc.SessionRecorder
+// against a peer, to capacity-test sidecars before release:
+//
+//	kkrpc-load -speed 4 -concurrency 64 -loops 10 session.json 127.0.0.1:7000
+//	kkrpc-load -speed 0 session.json ws://localhost:3000/rpc
+//
+// The peer is reached over a unix socket path, host:port or a ws:// URL. With
+// -debug the replay serves its live metrics for kkrpc-top.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func main() {
+	speed := flag.Float64("speed", 1, "pacing multiplier; 0 sends calls back to back")
+	concurrency := flag.Int("concurrency", 32, "maximum calls in flight; 0 for unbounded")
+	loops := flag.Int("loops", 1, "number of times to play the session")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-call timeout")
+	debug := flag.String("debug", "", "serve live metrics on this unix socket path or host:port")
+	keyText := flag.String("key", os.Getenv("KKRPC_CAPTURE_KEY"), "hex key of an encrypted session (default $KKRPC_CAPTURE_KEY)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kkrpc-load [flags] <session.json> <unix socket path | host:port | ws:// URL>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var key []byte
+	if *keyText != "" {
+		var err error
+		if key, err = kkrpc.ParseCaptureKey(*keyText); err != nil {
+			fmt.Fprintln(os.Stderr, "kkrpc-load:", err)
+			os.Exit(2)
+		}
+	}
+	opts := kkrpc.ReplayOptions{Speed: *speed, Concurrency: *concurrency, Loops: *loops, Metrics: kkrpc.NewMetrics()}
+	if err := run(flag.Arg(0), key, flag.Arg(1), *timeout, *debug, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "kkrpc-load:", err)
+		os.Exit(1)
+	}
+}
+
+func run(sessionPath string, key []byte, address string, timeout time.Duration, debug string, opts kkrpc.ReplayOptions) error {
+	session, err := kkrpc.LoadSessionWithKey(sessionPath, key)
+	if err != nil {
+		return err
+	}
+	transport, err := dial(address)
+	if err != nil {
+		return err
+	}
+	client := kkrpc.NewClient(transport, kkrpc.WithTimeout(timeout))
+	defer client.Close()
+
+	if debug != "" {
+		listener, err := net.Listen(network(debug), debug)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go kkrpc.ServeDebug(listener, kkrpc.DebugTarget{Metrics: opts.Metrics, Client: client})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "replaying %d calls x%d against %s\n", len(session.Calls), max(opts.Loops, 1), address)
+	report(os.Stdout, kkrpc.ReplaySession(ctx, client, session, opts))
+	return nil
+}
+
+func dial(address string) (kkrpc.Transport, error) {
+	if strings.HasPrefix(address, "ws://") || strings.HasPrefix(address, "wss://") {
+		return kkrpc.NewWebSocketTransport(address)
+	}
+	conn, err := net.Dial(network(address), address)
+	if err != nil {
+		return nil, err
+	}
+	return kkrpc.NewConnTransport(conn), nil
+}
+
+func network(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return "tcp"
+	}
+	return "unix"
+}
+
+func report(w io.Writer, r kkrpc.ReplayReport) {
+	fmt.Fprintf(w, "calls %d  errors %d  elapsed %s  throughput %.1f calls/s\n\n",
+		r.Calls, r.Errors, r.Elapsed.Round(time.Millisecond), r.Throughput())
+	width := len("METHOD")
+	for _, m := range r.Methods {
+		width = max(width, len(m.Method))
+	}
+	fmt.Fprintf(w, "%-*s %10s %8s %9s %9s %9s %9s\n", width, "METHOD", "CALLS", "ERRORS", "P50", "P90", "P99", "MAX")
+	for _, m := range r.Methods {
+		fmt.Fprintf(w, "%-*s %10d %8d %9s %9s %9s %9s\n", width, m.Method, m.Calls, m.Errors,
+			formatDuration(m.LatencyQuantile(0.5)), formatDuration(m.LatencyQuantile(0.9)),
+			formatDuration(m.LatencyQuantile(0.99)), formatDuration(m.MaxDuration))
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
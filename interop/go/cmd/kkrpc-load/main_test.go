@@ -0,0 +1,67 @@
+//go:build !kkrpc_minimal
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func TestRunReplaysSessionOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var served atomic.Int64
+	api := map[string]any{"ping": kkrpc.MustFunc(func(n int) int { served.Add(1); return n })}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			kkrpc.NewServer(kkrpc.NewConnTransport(conn), api)
+		}
+	}()
+
+	session := kkrpc.Session{Version: 1, Calls: []kkrpc.SessionCall{
+		{Method: "ping", Args: []any{1}},
+		{At: time.Millisecond, Method: "ping", Args: []any{2}},
+	}}
+	data, _ := json.Marshal(session)
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := kkrpc.ReplayOptions{Concurrency: 4, Loops: 5, Metrics: kkrpc.NewMetrics()}
+	if err := run(path, nil, listener.Addr().String(), time.Second, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	if served.Load() != 10 {
+		t.Fatalf("peer served %d calls, want 10", served.Load())
+	}
+}
+
+func TestReportListsMethods(t *testing.T) {
+	var out bytes.Buffer
+	report(&out, kkrpc.ReplayReport{Calls: 4, Errors: 1, Elapsed: 2 * time.Second, Methods: []kkrpc.MethodMetrics{
+		{Method: "ping", Calls: 4, Errors: 1, MaxDuration: 3 * time.Millisecond},
+	}})
+	lines := strings.Split(out.String(), "\n")
+	if !strings.Contains(lines[0], "throughput 2.0 calls/s") {
+		t.Fatalf("unexpected summary: %q", lines[0])
+	}
+	if fields := strings.Fields(lines[3]); fields[0] != "ping" || fields[1] != "4" || fields[2] != "1" || fields[6] != "3.0ms" {
+		t.Fatalf("unexpected row: %q", lines[3])
+	}
+}
@@ -0,0 +1,206 @@
+// Command kkrpc-top shows live per-method call rates, latency percentiles and
+// pending calls of a running kkrpc peer, refreshed like top. The peer must
+// serve its debug socket with kkrpc.ServeDebug:
+//
+//	kkrpc-top /tmp/app.debug.sock
+//	kkrpc-top -interval 500ms -sort p99 127.0.0.1:6061
+//
+// Rates and percentiles cover the last refresh interval; methods that were
+// not called in it show their all-time percentiles.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func main() {
+	interval := flag.Duration("interval", time.Second, "refresh interval")
+	sortBy := flag.String("sort", "rate", "sort rows by rate, p99, inflight, errors or name")
+	once := flag.Bool("once", false, "print a single snapshot and exit")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kkrpc-top [flags] <unix socket path | host:port>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *interval, *sortBy, *once); err != nil {
+		fmt.Fprintln(os.Stderr, "kkrpc-top:", err)
+		os.Exit(1)
+	}
+}
+
+func run(address string, interval time.Duration, sortBy string, once bool) error {
+	network := "unix"
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		network = "tcp"
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	client := kkrpc.NewClient(kkrpc.NewConnTransport(conn))
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var previous *kkrpc.DebugSnapshot
+	for {
+		callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		current, err := snapshot(callCtx, client)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if once {
+			render(os.Stdout, address, previous, current, sortBy)
+			return nil
+		}
+		// Move home and clear the screen before each frame.
+		fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J")
+		render(os.Stdout, address, previous, current, sortBy)
+		previous = &current
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+type row struct {
+	method   string
+	calls    uint64
+	rate     float64
+	errRate  float64
+	p50      time.Duration
+	p90      time.Duration
+	p99      time.Duration
+	max      time.Duration
+	inFlight int64
+}
+
+// rows derives one row per method, diffing against previous when there is
+// one so rates and percentiles describe the interval between the two.
+func rows(previous *kkrpc.DebugSnapshot, current kkrpc.DebugSnapshot) []row {
+	before := map[string]kkrpc.MethodMetrics{}
+	var elapsed float64
+	if previous != nil {
+		for _, m := range previous.Methods {
+			before[m.Method] = m
+		}
+		elapsed = current.Time.Sub(previous.Time).Seconds()
+	}
+	result := make([]row, 0, len(current.Methods))
+	for _, m := range current.Methods {
+		r := row{method: m.Method, calls: m.Calls, max: m.MaxDuration, inFlight: m.InFlight}
+		window := m
+		if last, ok := before[m.Method]; ok && m.Calls > last.Calls {
+			for i := range window.Latencies.Counts {
+				window.Latencies.Counts[i] -= last.Latencies.Counts[i]
+			}
+		}
+		if elapsed > 0 {
+			last := before[m.Method]
+			r.rate = float64(m.Calls-last.Calls) / elapsed
+			r.errRate = float64(m.Errors-last.Errors) / elapsed
+		}
+		r.p50 = window.LatencyQuantile(0.5)
+		r.p90 = window.LatencyQuantile(0.9)
+		r.p99 = window.LatencyQuantile(0.99)
+		result = append(result, r)
+	}
+	return result
+}
+
+func sortRows(result []row, sortBy string) {
+	less := map[string]func(a, b row) bool{
+		"rate":     func(a, b row) bool { return a.rate > b.rate },
+		"p99":      func(a, b row) bool { return a.p99 > b.p99 },
+		"inflight": func(a, b row) bool { return a.inFlight > b.inFlight },
+		"errors":   func(a, b row) bool { return a.errRate > b.errRate },
+	}[sortBy]
+	sort.SliceStable(result, func(i, j int) bool {
+		if less != nil && less(result[i], result[j]) != less(result[j], result[i]) {
+			return less(result[i], result[j])
+		}
+		return result[i].method < result[j].method
+	})
+}
+
+func render(w io.Writer, address string, previous *kkrpc.DebugSnapshot, current kkrpc.DebugSnapshot, sortBy string) {
+	table := rows(previous, current)
+	sortRows(table, sortBy)
+	var inFlight int64
+	var rate float64
+	for _, r := range table {
+		inFlight += r.inFlight
+		rate += r.rate
+	}
+	fmt.Fprintf(w, "kkrpc-top %s  %s\n", address, current.Time.Format("15:04:05"))
+	fmt.Fprintf(w, "methods %d  calls/s %.1f  in flight %d  pending %d\n\n", len(table), rate, inFlight, current.Pending)
+
+	width := len("METHOD")
+	for _, r := range table {
+		width = max(width, len(r.method))
+	}
+	fmt.Fprintf(w, "%-*s %9s %8s %9s %9s %9s %9s %8s %10s\n", width, "METHOD", "CALLS/S", "ERR/S", "P50", "P90", "P99", "MAX", "INFLIGHT", "CALLS")
+	for _, r := range table {
+		rate, errRate := "-", "-"
+		if previous != nil {
+			rate, errRate = fmt.Sprintf("%.1f", r.rate), fmt.Sprintf("%.1f", r.errRate)
+		}
+		fmt.Fprintf(w, "%-*s %9s %8s %9s %9s %9s %9s %8d %10d\n", width, r.method, rate, errRate,
+			formatDuration(r.p50), formatDuration(r.p90), formatDuration(r.p99), formatDuration(r.max), r.inFlight, r.calls)
+	}
+	if len(table) == 0 {
+		fmt.Fprintln(w, "(no calls yet)")
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
+
+// snapshot fetches the peer's debug snapshot. It decodes through encoding/json
+// rather than CallTuple so the command also builds with kkrpc_minimal.
+func snapshot(ctx context.Context, client *kkrpc.Client) (kkrpc.DebugSnapshot, error) {
+	var current kkrpc.DebugSnapshot
+	value, err := client.CallContext(ctx, "debug.snapshot")
+	if err != nil {
+		return current, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return current, err
+	}
+	err = json.Unmarshal(data, &current)
+	return current, err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func TestRenderDiffsSnapshots(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	previous := kkrpc.DebugSnapshot{Time: start, Methods: []kkrpc.MethodMetrics{
+		{Method: "fast", Calls: 10},
+		{Method: "slow", Calls: 1},
+	}}
+	previous.Methods[0].Latencies.Counts[0] = 10
+	previous.Methods[1].Latencies.Counts[5] = 1
+	current := kkrpc.DebugSnapshot{Time: start.Add(2 * time.Second), Pending: 3, Methods: []kkrpc.MethodMetrics{
+		{Method: "fast", Calls: 30, Errors: 4, MaxDuration: 2 * time.Millisecond},
+		{Method: "slow", Calls: 1, InFlight: 2, MaxDuration: 40 * time.Millisecond},
+	}}
+	current.Methods[0].Latencies.Counts[0] = 10
+	current.Methods[0].Latencies.Counts[1] = 20
+	current.Methods[1].Latencies.Counts[5] = 1
+
+	var out bytes.Buffer
+	render(&out, "debug.sock", &previous, current, "rate")
+	lines := strings.Split(out.String(), "\n")
+	if !strings.Contains(lines[1], "calls/s 10.0") || !strings.Contains(lines[1], "in flight 2") || !strings.Contains(lines[1], "pending 3") {
+		t.Fatalf("unexpected summary: %q", lines[1])
+	}
+	fast := strings.Fields(lines[4])
+	// Only the 20 calls of the interval count, all in the 2.5ms bucket.
+	if fast[0] != "fast" || fast[1] != "10.0" || fast[2] != "2.0" || fast[3] != "2.0ms" {
+		t.Fatalf("unexpected fast row: %q", lines[4])
+	}
+	slow := strings.Fields(lines[5])
+	if slow[0] != "slow" || slow[1] != "0.0" || slow[3] != "40.0ms" || slow[7] != "2" {
+		t.Fatalf("unexpected slow row: %q", lines[5])
+	}
+}
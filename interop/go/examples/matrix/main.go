@@ -0,0 +1,274 @@
+// Command matrix runs the conformance scenarios against every reference peer
+// over every transport it serves and prints which combinations work, e.g. to
+// validate a new transport before release:
+//
+//	go run ./examples/matrix
+//	go run ./examples/matrix -peers bun,python -transports stdio -v
+//
+// Run it from interop/go, or point -root at the interop directory. Peers whose
+// runtime is not installed are skipped; the node peer runs the TypeScript
+// servers through a locally installed tsx. It exits with status 1 if any
+// combination fails.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// peer is a runtime serving the reference API, with the script it runs for
+// each transport it supports, relative to the interop directory.
+type peer struct {
+	name    string
+	command []string
+	scripts map[string]string
+}
+
+var jsScripts = map[string]string{"stdio": "node/server.ts", "ws": "node/ws-server.ts"}
+
+var peers = []peer{
+	{name: "bun", command: []string{"bun"}, scripts: jsScripts},
+	{name: "node", command: []string{"node", "--import", "tsx"}, scripts: jsScripts},
+	{name: "deno", command: []string{"deno", "run", "-A"}, scripts: jsScripts},
+	{name: "python", command: []string{"python3"}, scripts: map[string]string{"stdio": "python/reference_server.py"}},
+}
+
+var transports = []string{"stdio", "ws"}
+
+func main() {
+	root := flag.String("root", "..", "the interop directory holding the reference servers")
+	peerList := flag.String("peers", "", "comma separated peers to run (default all)")
+	transportList := flag.String("transports", "", "comma separated transports to run (default all)")
+	timeout := flag.Duration("timeout", 5*time.Second, "per-call timeout")
+	verbose := flag.Bool("v", false, "pass the peers' stderr through")
+	flag.Parse()
+
+	stderr := io.Discard
+	if *verbose {
+		stderr = os.Stderr
+	}
+	h := &harness{root: *root, timeout: *timeout, stderr: stderr}
+	selectedPeers := selectPeers(*peerList)
+	selectedTransports := selectNames(transports, *transportList)
+	results := h.run(selectedPeers, selectedTransports)
+	printMatrix(os.Stdout, selectedPeers, selectedTransports, results)
+	for _, result := range results {
+		if result.failed() {
+			os.Exit(1)
+		}
+	}
+}
+
+func selectPeers(list string) []peer {
+	names := make([]string, len(peers))
+	for i, p := range peers {
+		names[i] = p.name
+	}
+	var selected []peer
+	for _, name := range selectNames(names, list) {
+		for _, p := range peers {
+			if p.name == name {
+				selected = append(selected, p)
+			}
+		}
+	}
+	return selected
+}
+
+// selectNames keeps the names listed in list, or all of them if it is empty.
+func selectNames(names []string, list string) []string {
+	if list == "" {
+		return names
+	}
+	var selected []string
+	for _, name := range names {
+		if strings.Contains(","+list+",", ","+name+",") {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// result is one cell of the matrix.
+type result struct {
+	// skipped explains why the combination did not run.
+	skipped string
+	passed  int
+	// failures holds "scenario: error" for every scenario that failed, or the
+	// reason the peer could not be reached.
+	failures []string
+}
+
+func (r result) failed() bool { return len(r.failures) > 0 }
+
+func (r result) String() string {
+	switch {
+	case r.skipped != "":
+		return r.skipped
+	case r.passed == len(scenarios):
+		return fmt.Sprintf("ok %d/%d", r.passed, len(scenarios))
+	default:
+		return fmt.Sprintf("FAIL %d/%d", r.passed, len(scenarios))
+	}
+}
+
+type harness struct {
+	root    string
+	timeout time.Duration
+	stderr  io.Writer
+}
+
+// run fills in the matrix, keyed "peer/transport", one cell at a time so the
+// peers do not compete for ports and CPU.
+func (h *harness) run(selected []peer, names []string) map[string]result {
+	results := make(map[string]result)
+	for _, p := range selected {
+		_, lookErr := exec.LookPath(p.command[0])
+		for _, transport := range names {
+			key := p.name + "/" + transport
+			switch {
+			case p.scripts[transport] == "":
+				results[key] = result{skipped: "-"}
+			case lookErr != nil:
+				results[key] = result{skipped: "skip"}
+			default:
+				results[key] = h.runCell(p, transport)
+			}
+		}
+	}
+	return results
+}
+
+func (h *harness) runCell(p peer, transport string) result {
+	client, stop, err := h.connect(p, transport)
+	if err != nil {
+		return result{failures: []string{"connect: " + err.Error()}}
+	}
+	defer stop()
+	var cell result
+	for _, s := range scenarios {
+		err := s.run(client)
+		if err == nil {
+			cell.passed++
+			continue
+		}
+		cell.failures = append(cell.failures, s.name+": "+err.Error())
+		// The peer is gone; the remaining scenarios would fail the same way.
+		if errors.Is(err, kkrpc.ErrTransportClosed) {
+			break
+		}
+	}
+	return cell
+}
+
+// connect starts p's server for transport and returns a client connected to
+// it, and a function stopping both.
+func (h *harness) connect(p peer, transport string) (*kkrpc.Client, func(), error) {
+	args := append(append([]string(nil), p.command[1:]...), filepath.FromSlash(p.scripts[transport]))
+	switch transport {
+	case "stdio":
+		process, err := kkrpc.StartProcess(context.Background(), kkrpc.ProcessOptions{
+			Path: p.command[0], Args: args, Dir: h.root, Stderr: h.stderr,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		client := kkrpc.NewClient(process, kkrpc.WithTimeout(h.timeout))
+		return client, func() { _ = client.Close() }, nil
+	case "ws":
+		return h.connectWebSocket(p.command[0], args)
+	}
+	return nil, nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+var listeningLine = regexp.MustCompile(`listening on (\d+)`)
+
+// connectWebSocket starts a server that prints the port it listens on, as
+// interop/node/ws-server.ts does, and dials it.
+func (h *harness) connectWebSocket(path string, args []string) (*kkrpc.Client, func(), error) {
+	cmd := exec.Command(path, args...)
+	cmd.Dir = h.root
+	cmd.Env = append(os.Environ(), "PORT=0")
+	cmd.Stderr = h.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	kill := func() {
+		once.Do(func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		})
+	}
+	ports := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if match := listeningLine.FindStringSubmatch(scanner.Text()); match != nil {
+				ports <- match[1]
+				break
+			}
+		}
+		close(ports)
+		// Keep draining so the server never blocks on a full pipe.
+		_, _ = io.Copy(io.Discard, stdout)
+	}()
+	var port string
+	select {
+	case port = <-ports:
+	case <-time.After(10 * time.Second):
+	}
+	if port == "" {
+		kill()
+		return nil, nil, errors.New("server did not report its port")
+	}
+	transport, err := kkrpc.NewWebSocketTransport("ws://127.0.0.1:" + port)
+	if err != nil {
+		kill()
+		return nil, nil, err
+	}
+	client := kkrpc.NewClient(transport, kkrpc.WithTimeout(h.timeout))
+	return client, func() {
+		_ = client.Close()
+		kill()
+	}, nil
+}
+
+func printMatrix(w io.Writer, selected []peer, names []string, results map[string]result) {
+	table := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(table, "peer\t%s\n", strings.Join(names, "\t"))
+	for _, p := range selected {
+		cells := make([]string, len(names))
+		for i, transport := range names {
+			cells[i] = results[p.name+"/"+transport].String()
+		}
+		fmt.Fprintf(table, "%s\t%s\n", p.name, strings.Join(cells, "\t"))
+	}
+	_ = table.Flush()
+	fmt.Fprintln(w, "\n- not served by the peer, skip: runtime not installed")
+	for _, p := range selected {
+		for _, transport := range names {
+			for _, failure := range results[p.name+"/"+transport].failures {
+				fmt.Fprintf(w, "%s/%s %s\n", p.name, transport, failure)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//go:build !kkrpc_minimal
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// referenceAPI is interop/node/server.ts in Go.
+func referenceAPI() map[string]any {
+	return map[string]any{
+		"math": map[string]any{
+			"add": kkrpc.MustFunc(func(a, b float64) float64 { return a + b }),
+		},
+		"echo": kkrpc.MustFunc(func(value any) any { return value }),
+		"withCallback": kkrpc.MustFunc(func(value string, cb kkrpc.Callback) string {
+			cb("callback:" + value)
+			return "callback-sent"
+		}),
+		"counter": 42,
+		"settings": map[string]any{
+			"theme":         "light",
+			"notifications": map[string]any{"enabled": true},
+		},
+	}
+}
+
+func TestScenariosPassAgainstGoServer(t *testing.T) {
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, referenceAPI())
+	defer server.Close()
+	client := kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+	defer client.Close()
+	for _, s := range scenarios {
+		if err := s.run(client); err != nil {
+			t.Errorf("%s: %v", s.name, err)
+		}
+	}
+}
+
+func TestMatrixMarksUnavailableCombinations(t *testing.T) {
+	missing := peer{name: "ghost", command: []string{"kkrpc-no-such-runtime"}, scripts: map[string]string{"stdio": "server.ts"}}
+	h := &harness{root: t.TempDir(), timeout: time.Second}
+	results := h.run([]peer{missing}, transports)
+	if results["ghost/stdio"].skipped != "skip" || results["ghost/ws"].skipped != "-" {
+		t.Fatalf("results %+v", results)
+	}
+
+	var out bytes.Buffer
+	results["ghost/stdio"] = result{passed: 4, failures: []string{"echo: boom", "errors: boom"}}
+	printMatrix(&out, []peer{missing}, transports, results)
+	for _, want := range []string{"FAIL 4/6", "ghost/stdio echo: boom"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+type scenario struct {
+	name string
+	run  func(client *kkrpc.Client) error
+}
+
+// scenarios mirror the conformance suite in kkrpc/conformance_test.go, run
+// against the reference API every peer serves.
+var scenarios = []scenario{
+	{"call", func(client *kkrpc.Client) error {
+		return expect(client.Call("math.add", 4, 7))(float64(11))
+	}},
+	{"echo", func(client *kkrpc.Client) error {
+		result, err := client.Call("echo", map[string]any{"name": "kkrpc", "count": 2, "nested": map[string]any{"ok": true}})
+		if err != nil {
+			return err
+		}
+		echoed, _ := result.(map[string]any)
+		nested, _ := echoed["nested"].(map[string]any)
+		if echoed["name"] != "kkrpc" || echoed["count"] != float64(2) || nested["ok"] != true {
+			return fmt.Errorf("echoed %#v", result)
+		}
+		return nil
+	}},
+	{"callback", func(client *kkrpc.Client) error {
+		received := make(chan string, 1)
+		if err := expect(client.Call("withCallback", "pong", kkrpc.Callback(func(args ...any) { received <- fmt.Sprint(args...) })))("callback-sent"); err != nil {
+			return err
+		}
+		select {
+		case payload := <-received:
+			if payload != "callback:pong" {
+				return fmt.Errorf("callback got %q", payload)
+			}
+			return nil
+		case <-time.After(2 * time.Second):
+			return errors.New("callback not received")
+		}
+	}},
+	{"concurrent", func(client *kkrpc.Client) error {
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := expect(client.Call("math.add", i, i+1))(float64(2*i + 1)); err != nil {
+					errs <- fmt.Errorf("call %d: %w", i, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		return <-errs
+	}},
+	{"properties", func(client *kkrpc.Client) error {
+		if err := expect(client.Get([]string{"counter"}))(float64(42)); err != nil {
+			return fmt.Errorf("get counter: %w", err)
+		}
+		if err := expect(client.Get([]string{"settings", "notifications", "enabled"}))(true); err != nil {
+			return fmt.Errorf("get enabled: %w", err)
+		}
+		if _, err := client.Set([]string{"settings", "theme"}, "dark"); err != nil {
+			return fmt.Errorf("set theme: %w", err)
+		}
+		if err := expect(client.Get([]string{"settings", "theme"}))("dark"); err != nil {
+			return fmt.Errorf("get theme: %w", err)
+		}
+		return nil
+	}},
+	{"errors", func(client *kkrpc.Client) error {
+		if _, err := client.Call("missing.method"); err == nil {
+			return errors.New("no error for an unknown method")
+		}
+		if err := expect(client.Call("math.add", 1, 2))(float64(3)); err != nil {
+			return fmt.Errorf("after an error: %w", err)
+		}
+		return nil
+	}},
+}
+
+// expect checks a call's outcome against the value it should return.
+func expect(result any, err error) func(want any) error {
+	return func(want any) error {
+		if err != nil {
+			return err
+		}
+		if result != want {
+			return fmt.Errorf("got %#v, want %#v", result, want)
+		}
+		return nil
+	}
+}
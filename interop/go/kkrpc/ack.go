@@ -0,0 +1,266 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AckOptions struct {
+	// RetryInterval is how long an unacknowledged message waits before it is
+	// sent again. Defaults to one second.
+	RetryInterval time.Duration
+	// MaxAttempts bounds the sends per message, including the first. Zero
+	// retries until the transport is closed.
+	MaxAttempts int
+	// DedupeWindow is how many recently delivered messages are remembered to
+	// drop redeliveries. Defaults to 4096.
+	DedupeWindow int
+	// OnGiveUp is called with a message that exhausted MaxAttempts.
+	OnGiveUp func(message string)
+	// Reliable selects the messages that are sequenced and acknowledged.
+	// Others are sent as plain lines without retransmission. Nil means all.
+	Reliable func(message string) bool
+}
+
+type ackEntry struct {
+	message  string
+	attempts int
+	sent     time.Time
+}
+
+// AckTransport adds at-least-once delivery on top of transports that may lose
+// messages, such as brokers without delivery guarantees. Every message is
+// wrapped in an "am" frame carrying the sender id and a sequence number; the
+// receiver answers with batched "ak" frames and drops redeliveries it has
+// already seen. Both peers must wrap their transport. Lines that are not
+// frames pass through unchanged.
+type AckTransport struct {
+	inner   Transport
+	opts    AckOptions
+	sender  string
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	nextSeq  uint64
+	unacked  map[uint64]*ackEntry
+	acks     map[string][]uint64
+	seen     map[string]struct{}
+	seenList []string
+
+	wake   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+type ackFrame struct {
+	T string   `json:"t"`
+	S string   `json:"s"`
+	N uint64   `json:"n,omitempty"`
+	M string   `json:"m,omitempty"`
+	A []uint64 `json:"a,omitempty"`
+}
+
+func NewAckTransport(inner Transport, opts AckOptions) *AckTransport {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	if opts.DedupeWindow <= 0 {
+		opts.DedupeWindow = 4096
+	}
+	t := &AckTransport{
+		inner:   inner,
+		opts:    opts,
+		sender:  GenerateUUID(),
+		unacked: make(map[uint64]*ackEntry),
+		acks:    make(map[string][]uint64),
+		seen:    make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *AckTransport) Write(message string) error {
+	if t.opts.Reliable != nil && !t.opts.Reliable(message) {
+		t.writeMu.Lock()
+		defer t.writeMu.Unlock()
+		return t.inner.Write(message)
+	}
+	t.mu.Lock()
+	t.nextSeq++
+	seq := t.nextSeq
+	t.unacked[seq] = &ackEntry{message: message, attempts: 1, sent: time.Now()}
+	t.mu.Unlock()
+	return t.writeFrame(ackFrame{T: "am", S: t.sender, N: seq, M: message})
+}
+
+func (t *AckTransport) Read() (string, error) {
+	for {
+		line, err := t.inner.Read()
+		if err != nil {
+			return "", err
+		}
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, `{"t":"a`) {
+			return line, nil
+		}
+		var frame ackFrame
+		if err := json.Unmarshal([]byte(trimmed), &frame); err != nil {
+			return line, nil
+		}
+		switch frame.T {
+		case "ak":
+			if frame.S == t.sender {
+				t.mu.Lock()
+				for _, seq := range frame.A {
+					delete(t.unacked, seq)
+				}
+				t.mu.Unlock()
+			}
+		case "am":
+			if t.accept(frame) {
+				return frame.M, nil
+			}
+		default:
+			return line, nil
+		}
+	}
+}
+
+// accept queues the acknowledgement and reports whether the frame is new.
+func (t *AckTransport) accept(frame ackFrame) bool {
+	key := frame.S + ":" + strconv.FormatUint(frame.N, 10)
+	t.mu.Lock()
+	t.acks[frame.S] = append(t.acks[frame.S], frame.N)
+	_, duplicate := t.seen[key]
+	if !duplicate {
+		t.seen[key] = struct{}{}
+		t.seenList = append(t.seenList, key)
+		if len(t.seenList) > t.opts.DedupeWindow {
+			delete(t.seen, t.seenList[0])
+			t.seenList = t.seenList[1:]
+		}
+	}
+	t.mu.Unlock()
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return !duplicate
+}
+
+func (t *AckTransport) run() {
+	ticker := time.NewTicker(t.opts.RetryInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closed:
+			return
+		case <-t.wake:
+			t.flushAcks()
+		case <-ticker.C:
+			t.retransmit()
+		}
+	}
+}
+
+func (t *AckTransport) flushAcks() {
+	t.mu.Lock()
+	acks := t.acks
+	t.acks = make(map[string][]uint64)
+	t.mu.Unlock()
+	for sender, seqs := range acks {
+		_ = t.writeFrame(ackFrame{T: "ak", S: sender, A: seqs})
+	}
+}
+
+func (t *AckTransport) retransmit() {
+	now := time.Now()
+	var resend []ackFrame
+	var givenUp []string
+	t.mu.Lock()
+	for seq, entry := range t.unacked {
+		if now.Sub(entry.sent) < t.opts.RetryInterval {
+			continue
+		}
+		if t.opts.MaxAttempts > 0 && entry.attempts >= t.opts.MaxAttempts {
+			delete(t.unacked, seq)
+			givenUp = append(givenUp, entry.message)
+			continue
+		}
+		entry.attempts++
+		entry.sent = now
+		resend = append(resend, ackFrame{T: "am", S: t.sender, N: seq, M: entry.message})
+	}
+	t.mu.Unlock()
+	for _, frame := range resend {
+		_ = t.writeFrame(frame)
+	}
+	if t.opts.OnGiveUp != nil {
+		for _, message := range givenUp {
+			t.opts.OnGiveUp(message)
+		}
+	}
+}
+
+// Unacked reports how many sent messages still await acknowledgement.
+func (t *AckTransport) Unacked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.unacked)
+}
+
+func (t *AckTransport) writeFrame(frame ackFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.inner.Write(string(data) + "\n")
+}
+
+func (t *AckTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return t.inner.Close()
+}
+
+// LossyTransport drops and duplicates outgoing messages at the given rates to
+// exercise delivery guarantees in tests.
+type LossyTransport struct {
+	Transport
+	dropRate      float64
+	duplicateRate float64
+	mu            sync.Mutex
+	random        *rand.Rand
+}
+
+func NewLossyTransport(inner Transport, dropRate float64, duplicateRate float64, seed int64) *LossyTransport {
+	return &LossyTransport{
+		Transport:     inner,
+		dropRate:      dropRate,
+		duplicateRate: duplicateRate,
+		random:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (t *LossyTransport) Write(message string) error {
+	t.mu.Lock()
+	drop := t.random.Float64() < t.dropRate
+	duplicate := t.random.Float64() < t.duplicateRate
+	t.mu.Unlock()
+	if drop {
+		return nil
+	}
+	if duplicate {
+		if err := t.Transport.Write(message); err != nil {
+			return err
+		}
+	}
+	return t.Transport.Write(message)
+}
@@ -0,0 +1,70 @@
+package kkrpc
+
+import (
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestAckTransportDeliversOverLossyLink(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	opts := AckOptions{RetryInterval: 20 * time.Millisecond}
+	clientTransport := NewAckTransport(NewLossyTransport(left, 0.3, 0.1, 1), opts)
+	serverTransport := NewAckTransport(NewLossyTransport(right, 0.3, 0.1, 2), opts)
+	calls := 0
+	client := NewClient(clientTransport, WithTimeout(5*time.Second))
+	server := NewServer(serverTransport, map[string]any{
+		"count": func(args ...any) any {
+			calls++
+			return args[0]
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	for i := 0; i < 50; i++ {
+		result, err := client.Call("count", i)
+		if err != nil || !valuesEqual(i, result) {
+			t.Fatalf("call %d: %#v %v", i, result, err)
+		}
+	}
+	if calls != 50 {
+		t.Fatalf("handler ran %d times, want 50", calls)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for clientTransport.Unacked()+serverTransport.Unacked() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("unacked: client=%d server=%d", clientTransport.Unacked(), serverTransport.Unacked())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAckTransportGivesUp(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer right.Close()
+	gaveUp := make(chan string, 1)
+	transport := NewAckTransport(NewLossyTransport(left, 1, 0, 1), AckOptions{
+		RetryInterval: 10 * time.Millisecond,
+		MaxAttempts:   3,
+		OnGiveUp:      func(message string) { gaveUp <- message },
+	})
+	defer transport.Close()
+
+	if err := transport.Write("lost\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-gaveUp:
+		if message != "lost\n" {
+			t.Fatalf("unexpected message %q", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnGiveUp not called")
+	}
+}
@@ -0,0 +1,78 @@
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first descriptor systemd passes, after stdio.
+const listenFDsStart = 3
+
+var ErrNotActivated = errors.New("kkrpc: not started by socket activation")
+
+// ActivationListener is a socket inherited from systemd, named by the
+// FileDescriptorName= of its .socket unit (the unit name by default).
+type ActivationListener struct {
+	net.Listener
+	Name string
+}
+
+// ActivationListeners returns the stream sockets systemd passed to the
+// process through LISTEN_FDS, in the order of the .socket unit, or
+// ErrNotActivated. It unsets the LISTEN_* variables so that child processes
+// do not claim the sockets too; call it once. Serve them with ServeListener,
+// or NewWebSocketListener for TS WebSocket clients.
+func ActivationListeners() ([]ActivationListener, error) {
+	listeners, err := activationListeners(os.Getenv, os.Getpid(), listenFDsStart)
+	if !errors.Is(err, ErrNotActivated) {
+		for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			_ = os.Unsetenv(name)
+		}
+	}
+	return listeners, err
+}
+
+func activationListeners(getenv func(string) string, pid int, first int) ([]ActivationListener, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, ErrNotActivated
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("kkrpc: invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	var names []string
+	if raw := getenv("LISTEN_FDNAMES"); raw != "" {
+		names = strings.Split(raw, ":")
+	}
+	listeners := make([]ActivationListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(first+i)
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(first+i), name)
+		// FileListener works on a duplicate that is close-on-exec.
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("kkrpc: inherited socket %s: %w", name, err)
+		}
+		listeners = append(listeners, ActivationListener{Listener: listener, Name: name})
+	}
+	return listeners, nil
+}
+
+// ServeListener serves api over every connection listener accepts, each with
+// newline framing in its own Channel, until the listener is closed. It is
+// Serve with the same API for every connection; each gets its own copy of
+// api's maps, so a peer's set is only seen on its own connection.
+func ServeListener(listener net.Listener, api map[string]any, opts ...Option) error {
+	return Serve(listener, func(net.Conn) map[string]any { return cloneAPI(api) }, opts...)
+}
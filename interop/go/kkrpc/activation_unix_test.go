@@ -0,0 +1,81 @@
+//go:build unix
+
+package kkrpc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// inheritedSocket leaves a listening socket on a descriptor of its own, as
+// systemd would, and returns the descriptor and address.
+func inheritedSocket(t *testing.T) (int, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd, listener.Addr().String()
+}
+
+func TestActivationListenersServeInheritedSocket(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	fd, addr := inheritedSocket(t)
+	env := map[string]string{"LISTEN_PID": strconv.Itoa(os.Getpid()), "LISTEN_FDS": "1", "LISTEN_FDNAMES": "kkrpc"}
+	listeners, err := activationListeners(func(name string) string { return env[name] }, os.Getpid(), fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || listeners[0].Name != "kkrpc" {
+		t.Fatalf("unexpected listeners %#v", listeners)
+	}
+	defer listeners[0].Close()
+	go func() {
+		_ = ServeListener(listeners[0], map[string]any{"echo": func(args ...any) any { return args[0] }})
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(NewConnTransport(conn), WithTimeout(2*time.Second))
+	defer client.Close()
+	if result, err := client.Call("echo", "activated"); err != nil || result != "activated" {
+		t.Fatalf("%#v %v", result, err)
+	}
+}
+
+func TestActivationListenersRequireOurPID(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	env := map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}
+	getenv := func(name string) string { return env[name] }
+	if _, err := activationListeners(getenv, os.Getpid(), listenFDsStart); !errors.Is(err, ErrNotActivated) {
+		t.Fatalf("expected ErrNotActivated, got %v", err)
+	}
+	env["LISTEN_PID"], env["LISTEN_FDS"] = strconv.Itoa(os.Getpid()), "none"
+	if _, err := activationListeners(getenv, os.Getpid(), listenFDsStart); err == nil || errors.Is(err, ErrNotActivated) {
+		t.Fatalf("expected invalid LISTEN_FDS to fail, got %v", err)
+	}
+	if _, err := ActivationListeners(); !errors.Is(err, ErrNotActivated) {
+		t.Fatalf("test process is not activated: %v", err)
+	}
+}
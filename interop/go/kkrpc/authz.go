@@ -0,0 +1,242 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Identity is who the peer of a connection is, as established by the
+// application: from a verified token, a TLS client certificate, the plugin
+// it spawned. Request metadata is asserted by the peer and is no identity.
+type Identity struct {
+	Subject string
+	Claims  map[string]any
+}
+
+// Request is what an Authorizer is asked about: Op is "call", "get", "set" or
+// "new", Method the dotted path, and Args the arguments; a set has the new
+// value as its only arg. Args are plain JSON values; callbacks, streams and
+// remote references among them are nil.
+type Request struct {
+	Op     string
+	Method string
+	Args   []any
+}
+
+// Authorizer decides whether identity may make request. A refusal fails the
+// request without running it and reaches the peer as a PermissionError unless
+// it already is an RpcError.
+type Authorizer interface {
+	Authorize(ctx context.Context, identity Identity, request Request) error
+}
+
+// AuthorizerFunc adapts a function to Authorizer.
+type AuthorizerFunc func(ctx context.Context, identity Identity, request Request) error
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, identity Identity, request Request) error {
+	return f(ctx, identity, request)
+}
+
+// WithAuthorizer has every request the server receives vetted by authorizer
+// before it is served, the introspection method included.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = authorizer
+	}
+}
+
+// WithIdentity sets the identity of the connection's peer. Server.SetIdentity
+// changes it later, e.g. once the peer has logged in.
+func WithIdentity(identity Identity) Option {
+	return func(o *options) {
+		o.identity.Store(&identity)
+	}
+}
+
+// SetIdentity changes the identity of the peer for requests that follow.
+func (s *Server) SetIdentity(identity Identity) {
+	s.options.identity.Store(&identity)
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity of the peer whose request ctx
+// belongs to, if one was set.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+func (s *Server) withIdentity(ctx context.Context) context.Context {
+	if identity := s.options.identity.Load(); identity != nil {
+		return context.WithValue(ctx, identityKey{}, *identity)
+	}
+	return ctx
+}
+
+func (s *Server) authorize(ctx context.Context, op string, path []string, args []any) error {
+	if s.options.authorizer == nil {
+		return nil
+	}
+	identity, _ := IdentityFromContext(ctx)
+	err := s.options.authorizer.Authorize(ctx, identity, Request{Op: op, Method: strings.Join(path, "."), Args: plainArgs(args)})
+	if err == nil {
+		return nil
+	}
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) {
+		return err
+	}
+	return &RpcError{Name: "PermissionError", Message: err.Error()}
+}
+
+// plainArgs copies wire arguments with value envelopes unwrapped and the
+// references to callbacks, streams and remote objects, meaningless elsewhere,
+// nil.
+func plainArgs(args []any) []any {
+	plain := make([]any, len(args))
+	for i, arg := range args {
+		plain[i] = plainArg(arg)
+	}
+	return plain
+}
+
+func plainArg(arg any) any {
+	switch typed := arg.(type) {
+	case []any:
+		return plainArgs(typed)
+	case map[string]any:
+		switch typed[ArgEnvelopeTag] {
+		case "value":
+			return typed["v"]
+		case "callback":
+			return nil
+		}
+		if _, ok := typed[StreamRefTag]; ok {
+			return nil
+		}
+		if _, ok := typed[RemoteRefTag]; ok {
+			return nil
+		}
+		plain := make(map[string]any, len(typed))
+		for key, value := range typed {
+			plain[key] = plainArg(value)
+		}
+		return plain
+	default:
+		return arg
+	}
+}
+
+func permissionDenied(identity Identity, request Request) error {
+	subject := identity.Subject
+	if subject == "" {
+		subject = "anonymous peer"
+	}
+	return &RpcError{Name: "PermissionError", Message: fmt.Sprintf("%s may not %s %s", subject, request.Op, request.Method)}
+}
+
+// opAllowed reports whether ops grants op; no ops grants only "call" and
+// "get", so that a grant to call a method is not one to replace it.
+func opAllowed(ops []string, op string) bool {
+	if len(ops) == 0 {
+		return op == "call" || op == "get"
+	}
+	for _, allowed := range ops {
+		if allowed == "*" || allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// methodAllowed reports whether any pattern matches method; "*" as the last
+// segment matches one or more segments, elsewhere exactly one.
+func methodAllowed(patterns []string, method string) bool {
+	path := splitMethod(method)
+	for _, pattern := range patterns {
+		if pattern == "*" || matchPattern(splitMethod(pattern), path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACLRule grants Subject (or everyone, with "*") the Ops, "call" and "get"
+// when empty or any with "*", on the methods matching any of Methods, e.g.
+// "fs.read" or "fs.*".
+type ACLRule struct {
+	Subject string
+	Methods []string
+	Ops     []string
+}
+
+// ACL is a static Authorizer that allows what one of its rules grants.
+type ACL []ACLRule
+
+func (a ACL) Authorize(ctx context.Context, identity Identity, request Request) error {
+	for _, rule := range a {
+		if (rule.Subject == "*" || rule.Subject == identity.Subject) && opAllowed(rule.Ops, request.Op) && methodAllowed(rule.Methods, request.Method) {
+			return nil
+		}
+	}
+	return permissionDenied(identity, request)
+}
+
+// ClaimAuthorizer allows the methods an identity's claims grant, as set by
+// ParseJWT: Claim, "scope" by default, holds method patterns like ACLRule's,
+// as a space-separated string or an array. It refuses everything once the
+// "exp" claim has passed, since a connection may outlive its token. The
+// patterns grant Ops, "call" and "get" when empty, as for ACLRule.
+type ClaimAuthorizer struct {
+	Claim string
+	Ops   []string
+}
+
+func (a ClaimAuthorizer) Authorize(ctx context.Context, identity Identity, request Request) error {
+	if exp, ok := identity.Claims["exp"].(float64); ok && time.Now().Unix() >= int64(exp) {
+		return &RpcError{Name: "PermissionError", Message: "token expired"}
+	}
+	claim := a.Claim
+	if claim == "" {
+		claim = "scope"
+	}
+	var patterns []string
+	switch granted := identity.Claims[claim].(type) {
+	case string:
+		patterns = strings.Fields(granted)
+	case []any:
+		for _, value := range granted {
+			if pattern, ok := value.(string); ok {
+				patterns = append(patterns, pattern)
+			}
+		}
+	case []string:
+		patterns = granted
+	}
+	if !opAllowed(a.Ops, request.Op) || !methodAllowed(patterns, request.Method) {
+		return permissionDenied(identity, request)
+	}
+	return nil
+}
+
+// HostAuthorizer asks the host at the other end of client to decide, by
+// calling method with the identity ({subject, claims}), the method, its args
+// and the op. The host allows by returning true; an error it returns is passed
+// on. Use it in a plugin whose permissions the host manages.
+func HostAuthorizer(client *Client, method string) Authorizer {
+	return AuthorizerFunc(func(ctx context.Context, identity Identity, request Request) error {
+		subject := map[string]any{"subject": identity.Subject, "claims": identity.Claims}
+		allowed, err := client.CallContext(ctx, method, subject, request.Method, request.Args, request.Op)
+		if err != nil {
+			return err
+		}
+		if allowed != true {
+			return permissionDenied(identity, request)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,249 @@
+package kkrpc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func authzPair(t *testing.T, api map[string]any, opts ...Option) (*Client, *Server) {
+	t.Helper()
+	clientTransport, serverTransport := NewPipeTransportPair()
+	server := NewServer(serverTransport, api, opts...)
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func expectPermissionError(t *testing.T, err error) {
+	t.Helper()
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("expected PermissionError, got %v", err)
+	}
+}
+
+func TestACLAuthorizesBySubject(t *testing.T) {
+	api := map[string]any{
+		"fs": map[string]any{
+			"read":  func(args ...any) any { return "data" },
+			"write": func(args ...any) any { return true },
+		},
+		"whoami": func(ctx context.Context, args ...any) any {
+			identity, _ := IdentityFromContext(ctx)
+			return identity.Subject
+		},
+		"version": "1.0",
+	}
+	acl := ACL{
+		{Subject: "*", Methods: []string{"whoami"}},
+		{Subject: "reader", Methods: []string{"fs.read", "version"}},
+		{Subject: "admin", Methods: []string{"*"}},
+	}
+	client, server := authzPair(t, api, WithAuthorizer(acl), WithIdentity(Identity{Subject: "reader"}))
+
+	if result, err := client.Call("fs.read", "/a"); err != nil || result != "data" {
+		t.Fatalf("read: %#v %v", result, err)
+	}
+	if result, err := client.Get([]string{"version"}); err != nil || result != "1.0" {
+		t.Fatalf("get: %#v %v", result, err)
+	}
+	_, err := client.Call("fs.write", "/a", "x")
+	expectPermissionError(t, err)
+	_, err = client.Set([]string{"motd"}, "hi")
+	expectPermissionError(t, err)
+
+	server.SetIdentity(Identity{Subject: "admin"})
+	if result, err := client.Call("whoami"); err != nil || result != "admin" {
+		t.Fatalf("whoami: %#v %v", result, err)
+	}
+	if _, err := client.Call("fs.write", "/a", "x"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallGrantDoesNotAllowSet(t *testing.T) {
+	read := func(args ...any) any { return "data" }
+	api := map[string]any{"fs": map[string]any{"read": read}}
+	acl := ACL{
+		{Subject: "reader", Methods: []string{"fs.*"}},
+		{Subject: "admin", Methods: []string{"fs.*"}, Ops: []string{"*"}},
+	}
+	client, server := authzPair(t, api, WithAuthorizer(acl), WithIdentity(Identity{Subject: "reader"}))
+
+	_, err := client.Set([]string{"fs", "read"}, "replaced")
+	expectPermissionError(t, err)
+	if err.Error() != "PermissionError: reader may not set fs.read" {
+		t.Fatalf("unexpected message: %v", err)
+	}
+	if result, err := client.Call("fs.read"); err != nil || result != "data" {
+		t.Fatalf("handler replaced by a refused set: %#v %v", result, err)
+	}
+
+	server.SetIdentity(Identity{Subject: "admin"})
+	if _, err := client.Set([]string{"fs", "motd"}, "hi"); err != nil {
+		t.Fatalf("explicitly granted set: %v", err)
+	}
+}
+
+func TestAuthorizerSeesPlainArgs(t *testing.T) {
+	var seen []any
+	authorizer := AuthorizerFunc(func(ctx context.Context, identity Identity, request Request) error {
+		seen = request.Args
+		if request.Method == "deny" {
+			return errors.New("denied by policy")
+		}
+		return nil
+	})
+	client, _ := authzPair(t, map[string]any{
+		"watch": func(args ...any) any { return true },
+		"deny":  func(args ...any) any { return true },
+	}, WithAuthorizer(authorizer))
+
+	if _, err := client.Call("watch", "/tmp", Callback(func(...any) {})); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != "/tmp" || seen[1] != nil {
+		t.Fatalf("authorizer saw %#v", seen)
+	}
+	_, err := client.Call("deny")
+	expectPermissionError(t, err)
+	if err.Error() != "PermissionError: denied by policy" {
+		t.Fatalf("unexpected message: %v", err)
+	}
+}
+
+func signJWT(t *testing.T, alg string, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]any{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("s3cret")
+	future := float64(time.Now().Add(time.Hour).Unix())
+	token := signJWT(t, "HS256", map[string]any{"sub": "plugin-a", "scope": "fs.read", "exp": future}, hs256(secret))
+	identity, err := ParseJWT(token, secret)
+	if err != nil || identity.Subject != "plugin-a" || identity.Claims["scope"] != "fs.read" {
+		t.Fatalf("%#v %v", identity, err)
+	}
+	if _, err := ParseJWT(token, []byte("wrong")); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("wrong secret: %v", err)
+	}
+	expired := signJWT(t, "HS256", map[string]any{"sub": "a", "exp": float64(time.Now().Add(-time.Minute).Unix())}, hs256(secret))
+	if _, err := ParseJWT(expired, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expired: %v", err)
+	}
+	none := signJWT(t, "none", map[string]any{"sub": "a"}, func([]byte) []byte { return nil })
+	if _, err := ParseJWT(none, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("alg none: %v", err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256 := signJWT(t, "RS256", map[string]any{"sub": "rsa"}, func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		return signature
+	})
+	if identity, err := ParseJWT(rs256, &rsaKey.PublicKey); err != nil || identity.Subject != "rsa" {
+		t.Fatalf("RS256: %#v %v", identity, err)
+	}
+	if _, err := ParseJWT(rs256, secret); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("RS256 token with an HMAC key: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es256 := signJWT(t, "ES256", map[string]any{"sub": "ec"}, func(data []byte) []byte {
+		digest := sha256.Sum256(data)
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	})
+	if identity, err := ParseJWT(es256, &ecKey.PublicKey); err != nil || identity.Subject != "ec" {
+		t.Fatalf("ES256: %#v %v", identity, err)
+	}
+}
+
+func TestClaimAuthorizer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	authorizer := ClaimAuthorizer{}
+	identity := Identity{Subject: "a", Claims: map[string]any{"scope": "fs.* net.fetch"}}
+	for method, allowed := range map[string]bool{"fs.read": true, "fs.dir.list": true, "net.fetch": true, "net.listen": false, "fs": false} {
+		err := authorizer.Authorize(context.Background(), identity, Request{Op: "call", Method: method})
+		if (err == nil) != allowed {
+			t.Errorf("%s: %v", method, err)
+		}
+	}
+	roles := ClaimAuthorizer{Claim: "methods"}
+	identity.Claims["methods"] = []any{"db.query"}
+	if err := roles.Authorize(context.Background(), identity, Request{Op: "call", Method: "db.query"}); err != nil {
+		t.Fatal(err)
+	}
+	expectPermissionError(t, roles.Authorize(context.Background(), identity, Request{Op: "set", Method: "db.query"}))
+	writers := ClaimAuthorizer{Claim: "methods", Ops: []string{"set"}}
+	if err := writers.Authorize(context.Background(), identity, Request{Op: "set", Method: "db.query"}); err != nil {
+		t.Fatal(err)
+	}
+	identity.Claims["exp"] = float64(time.Now().Add(-time.Second).Unix())
+	expectPermissionError(t, roles.Authorize(context.Background(), identity, Request{Op: "call", Method: "db.query"}))
+}
+
+func TestHostAuthorizerAsksTheHost(t *testing.T) {
+	// The host decides what the plugin's peers may call.
+	hostClient, _ := authzPair(t, map[string]any{
+		"authorize": func(args ...any) any {
+			subject, _ := args[0].(map[string]any)
+			if subject["subject"] == "trusted" && args[1] == "run" && args[3] == "call" {
+				return true
+			}
+			return &RpcError{Name: "PermissionError", Message: "host says no"}
+		},
+	})
+	client, server := authzPair(t, map[string]any{
+		"run": func(args ...any) any { return "ran" },
+	}, WithAuthorizer(HostAuthorizer(hostClient, "authorize")), WithIdentity(Identity{Subject: "stranger"}))
+
+	_, err := client.Call("run")
+	expectPermissionError(t, err)
+	if err.Error() != "PermissionError: host says no" {
+		t.Fatalf("unexpected message: %v", err)
+	}
+	server.SetIdentity(Identity{Subject: "trusted"})
+	if result, err := client.Call("run"); err != nil || result != "ran" {
+		t.Fatalf("%#v %v", result, err)
+	}
+}
@@ -0,0 +1,329 @@
+package kkrpc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Blob is a payload sent by content hash between peers with a BlobCache: the
+// bytes cross a connection once and later calls and results carrying the same
+// Blob send only its hash. Use it for large values sent repeatedly, like
+// thumbnails or assets. Handlers may declare Blob or []byte parameters.
+type Blob []byte
+
+// BlobHash returns the content hash identifying data, hex-encoded SHA-256.
+func BlobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type BlobCacheOptions struct {
+	// TTL evicts blobs unused for this long; it defaults to 10 minutes.
+	TTL time.Duration
+	// MaxBytes evicts the least recently used blobs beyond this total; it
+	// defaults to 64 MiB.
+	MaxBytes int64
+}
+
+type blobEntry struct {
+	hash  string
+	data  []byte
+	used  time.Time
+	owner *resourceMeter
+}
+
+// BlobCache holds the blobs exchanged with peers, keyed by hash, so either
+// side can send them again by reference. One cache may be shared by many
+// connections.
+type BlobCache struct {
+	opts    BlobCacheOptions
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewBlobCache(opts BlobCacheOptions) *BlobCache {
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Minute
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 64 << 20
+	}
+	return &BlobCache{opts: opts, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Put stores data and returns its hash.
+func (c *BlobCache) Put(data []byte) string {
+	hash := BlobHash(data)
+	_ = c.put(hash, data, nil)
+	return hash
+}
+
+// put charges a blob new to the cache to owner, the peer that sent it.
+func (c *BlobCache) put(hash string, data []byte, owner *resourceMeter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[hash]; ok {
+		element.Value.(*blobEntry).used = time.Now()
+		c.order.MoveToFront(element)
+		return nil
+	}
+	if err := owner.chargeBlob(int64(len(data))); err != nil {
+		return err
+	}
+	c.entries[hash] = c.order.PushFront(&blobEntry{hash: hash, data: data, used: time.Now(), owner: owner})
+	c.size += int64(len(data))
+	c.evict(time.Now())
+	return nil
+}
+
+// Get returns the blob with hash, refreshing its TTL.
+func (c *BlobCache) Get(hash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.evict(now)
+	element, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*blobEntry)
+	entry.used = now
+	c.order.MoveToFront(element)
+	return entry.data, true
+}
+
+func (c *BlobCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Size returns the total bytes cached.
+func (c *BlobCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *BlobCache) evict(now time.Time) {
+	for element := c.order.Back(); element != nil; element = c.order.Back() {
+		entry := element.Value.(*blobEntry)
+		if c.size <= c.opts.MaxBytes && now.Sub(entry.used) < c.opts.TTL {
+			return
+		}
+		c.order.Remove(element)
+		delete(c.entries, entry.hash)
+		c.size -= int64(len(entry.data))
+		entry.owner.refundBlob(int64(len(entry.data)))
+	}
+}
+
+// WithBlobCache sends Blob values by hash once the peer has them, and keeps
+// the blobs sent and received in cache. Both peers need it; the peer's cache should
+// keep blobs at least as long as this one. Blobs the peer has evicted anyway
+// are sent again in full, retrying the call once.
+func WithBlobCache(cache *BlobCache) Option {
+	return func(o *options) {
+		o.blobs = &blobLink{cache: cache, peerHas: make(map[string]time.Time)}
+	}
+}
+
+// blobMissingError names the RpcError of a call referencing blobs the
+// receiver no longer holds; its Data carries their hashes under "h".
+const blobMissingError = "BlobMissingError"
+
+// blobLink tracks, for one connection, which blobs the peer holds.
+type blobLink struct {
+	cache   *BlobCache
+	owner   *resourceMeter
+	mu      sync.Mutex
+	peerHas map[string]time.Time
+}
+
+// maxPeerBlobs bounds the hashes remembered per connection.
+const maxPeerBlobs = 1 << 14
+
+func (l *blobLink) seen(hash string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.peerHas) >= maxPeerBlobs {
+		for h, at := range l.peerHas {
+			if now.Sub(at) >= l.cache.opts.TTL || len(l.peerHas) >= maxPeerBlobs {
+				delete(l.peerHas, h)
+			}
+		}
+	}
+	l.peerHas[hash] = now
+}
+
+func (l *blobLink) peerHolds(hash string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at, ok := l.peerHas[hash]
+	if ok && time.Since(at) >= l.cache.opts.TTL {
+		delete(l.peerHas, hash)
+		return false
+	}
+	if ok {
+		l.peerHas[hash] = time.Now()
+	}
+	return ok
+}
+
+func (l *blobLink) forget(hashes []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, hash := range hashes {
+		delete(l.peerHas, hash)
+	}
+}
+
+// encodeBlobs replaces the Blobs in value, at the top level or inside slices
+// and maps, by their envelopes.
+func (o *options) encodeBlobs(value any) any {
+	if o.blobs == nil {
+		return value
+	}
+	switch typed := value.(type) {
+	case Blob:
+		return o.blobs.encode(typed)
+	case []any:
+		encoded := make([]any, len(typed))
+		for i, item := range typed {
+			encoded[i] = o.encodeBlobs(item)
+		}
+		return encoded
+	case map[string]any:
+		encoded := make(map[string]any, len(typed))
+		for key, item := range typed {
+			encoded[key] = o.encodeBlobs(item)
+		}
+		return encoded
+	default:
+		return value
+	}
+}
+
+func (l *blobLink) encode(blob Blob) map[string]any {
+	hash := BlobHash(blob)
+	_ = l.cache.put(hash, blob, nil)
+	if l.peerHolds(hash) {
+		return map[string]any{ArgEnvelopeTag: "blob", "h": hash}
+	}
+	l.seen(hash)
+	return map[string]any{ArgEnvelopeTag: "blob", "h": hash, "d": []byte(blob)}
+}
+
+// resolveBlobs replaces the blob envelopes in value by their Blobs, caching
+// those sent in full. It reports every referenced blob missing from the cache
+// as a blobMissingError.
+func (o *options) resolveBlobs(value any) (any, error) {
+	if o.blobs == nil {
+		return value, nil
+	}
+	var missing []any
+	resolved, err := o.blobs.resolve(value, &missing)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, &RpcError{
+			Name:    blobMissingError,
+			Message: fmt.Sprintf("kkrpc: %d blob(s) not cached", len(missing)),
+			Data:    map[string]any{"h": missing},
+		}
+	}
+	return resolved, nil
+}
+
+func (l *blobLink) resolve(value any, missing *[]any) (any, error) {
+	switch typed := value.(type) {
+	case []any:
+		resolved := make([]any, len(typed))
+		for i, item := range typed {
+			item, err := l.resolve(item, missing)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = item
+		}
+		return resolved, nil
+	case map[string]any:
+		if typed[ArgEnvelopeTag] == "blob" {
+			return l.resolveEnvelope(typed, missing)
+		}
+		resolved := make(map[string]any, len(typed))
+		for key, item := range typed {
+			item, err := l.resolve(item, missing)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = item
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+func (l *blobLink) resolveEnvelope(envelope map[string]any, missing *[]any) (any, error) {
+	hash, _ := envelope["h"].(string)
+	if raw, inline := envelope["d"]; inline {
+		data, err := decodeBytes(raw)
+		if err != nil {
+			return nil, fmt.Errorf("kkrpc: blob %s: %w", hash, err)
+		}
+		if BlobHash(data) != hash {
+			return nil, fmt.Errorf("kkrpc: blob %s: content does not match its hash", hash)
+		}
+		if err := l.cache.put(hash, data, l.owner); err != nil {
+			return nil, err
+		}
+		l.seen(hash)
+		return Blob(data), nil
+	}
+	data, ok := l.cache.Get(hash)
+	if !ok {
+		*missing = append(*missing, hash)
+		return nil, nil
+	}
+	l.seen(hash)
+	return Blob(data), nil
+}
+
+// missingBlobs returns the hashes of a blobMissingError.
+func missingBlobs(err error) []string {
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Name != blobMissingError {
+		return nil
+	}
+	data, _ := rpcErr.Data.(map[string]any)
+	return hashList(data["h"])
+}
+
+func hashList(value any) []string {
+	list, _ := value.([]any)
+	hashes := make([]string, 0, len(list))
+	for _, item := range list {
+		if hash, ok := item.(string); ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+func (s *Server) resolveBlobArgs(args []any) ([]any, error) {
+	resolved, err := s.options.resolveBlobs(args)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.([]any), nil
+}
@@ -0,0 +1,99 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type byteCountingTransport struct {
+	Transport
+	written atomic.Int64
+}
+
+func (t *byteCountingTransport) Write(message string) error {
+	t.written.Add(int64(len(message)))
+	return t.Transport.Write(message)
+}
+
+func TestBlobsCrossTheConnectionOnce(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	thumbnail := Blob(bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 4096))
+	clientTransport, serverTransport := newConnectedTestTransports()
+	counting := &byteCountingTransport{Transport: clientTransport}
+	server := NewServer(serverTransport, map[string]any{
+		"echo": MustFunc(func(data Blob) Blob { return data }),
+		"size": MustFunc(func(data []byte) int { return len(data) }),
+	}, WithBlobCache(NewBlobCache(BlobCacheOptions{})))
+	client := NewClient(counting, WithTimeout(2*time.Second), WithBlobCache(NewBlobCache(BlobCacheOptions{})))
+	defer client.Close()
+	defer server.Close()
+
+	size, err := client.Call("size", thumbnail)
+	if err != nil || size != float64(len(thumbnail)) {
+		t.Fatalf("size = %v, %v", size, err)
+	}
+	first := counting.written.Load()
+	if first < int64(len(thumbnail)) {
+		t.Fatalf("first call wrote %d bytes", first)
+	}
+
+	echoed, err := client.Call("echo", thumbnail)
+	if err != nil || !bytes.Equal(echoed.(Blob), thumbnail) {
+		t.Fatalf("echo = %T, %v", echoed, err)
+	}
+	if again := counting.written.Load() - first; again > 512 {
+		t.Fatalf("second call wrote %d bytes, want a hash reference", again)
+	}
+}
+
+func TestBlobsResentAfterEviction(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	first := Blob(bytes.Repeat([]byte("a"), 1000))
+	second := Blob(bytes.Repeat([]byte("b"), 1000))
+	clientTransport, serverTransport := newConnectedTestTransports()
+	serverCache := NewBlobCache(BlobCacheOptions{MaxBytes: 1500})
+	server := NewServer(serverTransport, map[string]any{
+		"first": MustFunc(func(data Blob) string { return string(data[:1]) }),
+	}, WithBlobCache(serverCache))
+	client := NewClient(clientTransport, WithTimeout(2*time.Second), WithBlobCache(NewBlobCache(BlobCacheOptions{})))
+	defer client.Close()
+	defer server.Close()
+
+	for _, blob := range []Blob{first, second, first} {
+		result, err := client.Call("first", blob)
+		if err != nil || result != string(blob[:1]) {
+			t.Fatalf("first(%c) = %v, %v", blob[0], result, err)
+		}
+	}
+	if serverCache.Len() != 1 {
+		t.Fatalf("server cache holds %d blobs", serverCache.Len())
+	}
+}
+
+func TestBlobCacheEvictsByTTLAndSize(t *testing.T) {
+	cache := NewBlobCache(BlobCacheOptions{TTL: 20 * time.Millisecond, MaxBytes: 10})
+	a := cache.Put([]byte("aaaa"))
+	b := cache.Put([]byte("bbbb"))
+	if _, ok := cache.Get(a); !ok {
+		t.Fatal("a evicted early")
+	}
+	cache.Put([]byte("cccc"))
+	if _, ok := cache.Get(b); ok {
+		t.Fatal("least recently used blob kept over MaxBytes")
+	}
+	if cache.Size() != 8 {
+		t.Fatalf("size %d", cache.Size())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.Get(a); ok || cache.Len() != 0 {
+		t.Fatalf("expired blobs kept: %d", cache.Len())
+	}
+}
@@ -0,0 +1,174 @@
+package kkrpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// BroadcastHub fans events out to every member of a named topic, the
+// counterpart of the browser's BroadcastChannel for kkrpc peers. Peers join
+// over RPC with a callback through API; Go code joins with Join. Members are
+// called in the order they joined, one after another, so a slow member delays
+// the others.
+type BroadcastHub struct {
+	mu     sync.Mutex
+	topics map[string][]*hubMember
+}
+
+type hubMember struct {
+	id      string
+	owner   *hubConnection
+	deliver Callback
+}
+
+// hubConnection groups the members one connection joined through API.
+type hubConnection struct {
+	mu      sync.Mutex
+	members map[string]string // subscription id -> topic
+}
+
+func NewBroadcastHub() *BroadcastHub {
+	return &BroadcastHub{topics: make(map[string][]*hubMember)}
+}
+
+// Join adds deliver to topic until leave is called.
+func (h *BroadcastHub) Join(topic string, deliver Callback) (leave func()) {
+	id := h.join(topic, nil, deliver)
+	return func() { h.leave(topic, id) }
+}
+
+// Publish calls every member of topic with args and returns how many it
+// reached.
+func (h *BroadcastHub) Publish(topic string, args ...any) int {
+	return h.publish(nil, topic, args)
+}
+
+// Members returns how many members topic has.
+func (h *BroadcastHub) Members(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics[topic])
+}
+
+// Topics returns the topics with members, sorted.
+func (h *BroadcastHub) Topics() []string {
+	h.mu.Lock()
+	topics := make([]string, 0, len(h.topics))
+	for topic := range h.topics {
+		topics = append(topics, topic)
+	}
+	h.mu.Unlock()
+	sort.Strings(topics)
+	return topics
+}
+
+// API returns the methods to mount for one connection, e.g. under "hub":
+//
+//	join(topic, callback) -> subscription id
+//	leave(id) -> whether it was joined
+//	publish(topic, ...args) -> members reached
+//
+// Like BroadcastChannel, a connection does not receive what it publishes.
+// Call leaveAll when the connection closes to drop its members.
+func (h *BroadcastHub) API() (api map[string]any, leaveAll func()) {
+	conn := &hubConnection{members: make(map[string]string)}
+	api = map[string]any{
+		"join": InvokerFunc(func(ctx context.Context, args []any) (any, error) {
+			topic, err := hubTopic(args)
+			if err != nil {
+				return nil, err
+			}
+			var deliver Callback
+			if len(args) > 1 {
+				deliver, _ = args[1].(Callback)
+			}
+			if deliver == nil {
+				return nil, &RpcError{Name: "TypeError", Message: "argument 1: expected a callback"}
+			}
+			id := h.join(topic, conn, deliver)
+			conn.mu.Lock()
+			conn.members[id] = topic
+			conn.mu.Unlock()
+			return id, nil
+		}),
+		"leave": InvokerFunc(func(ctx context.Context, args []any) (any, error) {
+			id, _ := hubTopic(args)
+			conn.mu.Lock()
+			topic, ok := conn.members[id]
+			delete(conn.members, id)
+			conn.mu.Unlock()
+			if ok {
+				h.leave(topic, id)
+			}
+			return ok, nil
+		}),
+		"publish": InvokerFunc(func(ctx context.Context, args []any) (any, error) {
+			topic, err := hubTopic(args)
+			if err != nil {
+				return nil, err
+			}
+			return h.publish(conn, topic, args[1:]), nil
+		}),
+	}
+	leaveAll = func() {
+		conn.mu.Lock()
+		members := conn.members
+		conn.members = make(map[string]string)
+		conn.mu.Unlock()
+		for id, topic := range members {
+			h.leave(topic, id)
+		}
+	}
+	return api, leaveAll
+}
+
+// hubTopic returns the first argument, the topic or subscription id.
+func hubTopic(args []any) (string, error) {
+	if len(args) > 0 {
+		if topic, ok := args[0].(string); ok {
+			return topic, nil
+		}
+	}
+	return "", &RpcError{Name: "TypeError", Message: "argument 0: expected a string"}
+}
+
+func (h *BroadcastHub) join(topic string, owner *hubConnection, deliver Callback) string {
+	member := &hubMember{id: GenerateUUID(), owner: owner, deliver: deliver}
+	h.mu.Lock()
+	h.topics[topic] = append(h.topics[topic], member)
+	h.mu.Unlock()
+	return member.id
+}
+
+func (h *BroadcastHub) leave(topic string, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	members := h.topics[topic]
+	for i, member := range members {
+		if member.id == id {
+			members = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+	if len(members) == 0 {
+		delete(h.topics, topic)
+		return
+	}
+	h.topics[topic] = members
+}
+
+func (h *BroadcastHub) publish(from *hubConnection, topic string, args []any) int {
+	h.mu.Lock()
+	members := h.topics[topic]
+	h.mu.Unlock()
+	reached := 0
+	for _, member := range members {
+		if from != nil && member.owner == from {
+			continue
+		}
+		member.deliver(args...)
+		reached++
+	}
+	return reached
+}
@@ -0,0 +1,68 @@
+package kkrpc
+
+import (
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestBroadcastHubFansOutAcrossConnections(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	hub := NewBroadcastHub()
+	type peer struct {
+		client   *Client
+		leaveAll func()
+		events   chan any
+	}
+	connect := func() *peer {
+		api, leaveAll := hub.API()
+		serverSide, clientSide := NewPipeTransportPair()
+		NewServer(serverSide, map[string]any{"hub": api})
+		client := NewClient(clientSide, WithTimeout(2*time.Second))
+		t.Cleanup(func() { _ = client.Close() })
+		return &peer{client: client, leaveAll: leaveAll, events: make(chan any, 4)}
+	}
+	alice, bob := connect(), connect()
+	for _, p := range []*peer{alice, bob} {
+		events := p.events
+		if _, err := p.client.Call("hub.join", "presence", Callback(func(args ...any) { events <- args[0] })); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goEvents := make(chan any, 4)
+	leave := hub.Join("presence", func(args ...any) { goEvents <- args[0] })
+	if got := hub.Members("presence"); got != 3 {
+		t.Fatalf("members = %d", got)
+	}
+
+	reached, err := alice.client.Call("hub.publish", "presence", "alice online")
+	if err != nil || reached != float64(2) {
+		t.Fatalf("publish = %v, %v", reached, err)
+	}
+	for _, events := range []chan any{bob.events, goEvents} {
+		select {
+		case event := <-events:
+			if event != "alice online" {
+				t.Fatalf("event %v", event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+	select {
+	case event := <-alice.events:
+		t.Fatalf("publisher received its own event %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	leave()
+	bob.leaveAll()
+	if got := hub.Publish("presence", "bye"); got != 1 {
+		t.Fatalf("reached %d after leaving", got)
+	}
+	if topics := hub.Topics(); len(topics) != 1 || topics[0] != "presence" {
+		t.Fatalf("topics %v", topics)
+	}
+}
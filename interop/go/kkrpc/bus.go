@@ -0,0 +1,59 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const busProtocol = "kkrpc.bus.v1"
+
+// busEnvelope wraps messages on shared buses such as Redis, matching the
+// TypeScript bus-envelope helpers: several peers read the same stream or
+// channel, so each message names its sender and, optionally, its target.
+type busEnvelope struct {
+	Protocol      string          `json:"protocol"`
+	TransportID   string          `json:"transportId"`
+	From          string          `json:"from"`
+	To            string          `json:"to,omitempty"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+	SentAt        int64           `json:"sentAt,omitempty"`
+	Message       json.RawMessage `json:"message"`
+}
+
+func encodeBusEnvelope(message string, transportID string, from string, to string) (string, error) {
+	raw := json.RawMessage(strings.TrimSpace(message))
+	var header struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(busEnvelope{
+		Protocol:      busProtocol,
+		TransportID:   transportID,
+		From:          from,
+		To:            to,
+		CorrelationID: header.ID,
+		SentAt:        time.Now().UnixMilli(),
+		Message:       raw,
+	})
+	return string(data), err
+}
+
+// decodeBusEnvelope returns the message line for localPeer, or false for
+// payloads that are not envelopes, come from localPeer itself or target
+// another peer.
+func decodeBusEnvelope(payload string, localPeer string) (string, bool) {
+	var envelope busEnvelope
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+		return "", false
+	}
+	if envelope.Protocol != busProtocol || len(envelope.Message) == 0 || envelope.From == localPeer {
+		return "", false
+	}
+	if envelope.To != "" && envelope.To != localPeer {
+		return "", false
+	}
+	return string(envelope.Message) + "\n", true
+}
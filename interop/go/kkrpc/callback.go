@@ -0,0 +1,159 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	callbackType = reflect.TypeOf(Callback(nil))
+	anyType      = reflect.TypeOf((*any)(nil)).Elem()
+)
+
+func isFuncValue(value any) bool {
+	if value == nil {
+		return false
+	}
+	return reflect.TypeOf(value).Kind() == reflect.Func
+}
+
+func toCallback(fn any, onDecodeError func(error)) (Callback, error) {
+	if cb, ok := fn.(Callback); ok {
+		return cb, nil
+	}
+	if cb, ok := fn.(func(...any)); ok {
+		return Callback(cb), nil
+	}
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func {
+		return nil, fmt.Errorf("callback must be a func, got %T", fn)
+	}
+	if value.IsNil() {
+		return nil, errors.New("callback is nil")
+	}
+	fnType := value.Type()
+	return func(args ...any) {
+		in, err := decodeCallArgs(fnType, args)
+		if err != nil {
+			onDecodeError(err)
+			return
+		}
+		if fnType.IsVariadic() {
+			value.CallSlice(in)
+			return
+		}
+		value.Call(in)
+	}, nil
+}
+
+func decodeCallArgs(fnType reflect.Type, args []any) ([]reflect.Value, error) {
+	numIn := fnType.NumIn()
+	variadic := fnType.IsVariadic()
+	fixed := numIn
+	if variadic {
+		fixed--
+	}
+	in := make([]reflect.Value, 0, numIn)
+	for i := 0; i < fixed; i++ {
+		var arg any
+		if i < len(args) {
+			arg = args[i]
+		}
+		decoded, err := decodeValueAs(arg, fnType.In(i))
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		in = append(in, decoded)
+	}
+	if !variadic {
+		return in, nil
+	}
+	sliceType := fnType.In(fixed)
+	rest := reflect.MakeSlice(sliceType, 0, max(len(args)-fixed, 0))
+	for i := fixed; i < len(args); i++ {
+		decoded, err := decodeValueAs(args[i], sliceType.Elem())
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		rest = reflect.Append(rest, decoded)
+	}
+	return append(in, rest), nil
+}
+
+func decodeValueAs(value any, target reflect.Type) (reflect.Value, error) {
+	decoded, err := convertValueAs(value, target)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if err := validateEnums(decoded); err != nil {
+		return reflect.Value{}, err
+	}
+	return decoded, nil
+}
+
+func convertValueAs(value any, target reflect.Type) (reflect.Value, error) {
+	if value == nil {
+		return reflect.Zero(target), nil
+	}
+	if target == anyType {
+		return reflect.ValueOf(&value).Elem(), nil
+	}
+	source := reflect.ValueOf(value)
+	if source.Type().AssignableTo(target) {
+		return source, nil
+	}
+	if cb, ok := value.(Callback); ok && target.Kind() == reflect.Func {
+		return bindCallbackType(cb, target), nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	decoded := reflect.New(target)
+	if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("cannot decode %T into %s: %w", value, target, err)
+	}
+	return decoded.Elem(), nil
+}
+
+func bindCallbackType(cb Callback, fnType reflect.Type) reflect.Value {
+	return reflect.MakeFunc(fnType, func(in []reflect.Value) []reflect.Value {
+		args := make([]any, 0, len(in))
+		for i, value := range in {
+			if fnType.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < value.Len(); j++ {
+					args = append(args, value.Index(j).Interface())
+				}
+				continue
+			}
+			args = append(args, value.Interface())
+		}
+		cb(args...)
+		out := make([]reflect.Value, fnType.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(fnType.Out(i))
+		}
+		return out
+	})
+}
+
+func BindCallback(arg any, fnPtr any) error {
+	cb, ok := arg.(Callback)
+	if !ok {
+		return fmt.Errorf("argument is %T, not a callback", arg)
+	}
+	target := reflect.ValueOf(fnPtr)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("BindCallback target must be a pointer to a func, got %T", fnPtr)
+	}
+	if target.Elem().Type() == callbackType {
+		target.Elem().Set(reflect.ValueOf(cb))
+		return nil
+	}
+	target.Elem().Set(bindCallbackType(cb, target.Elem().Type()))
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Without reflection only untyped callbacks can be passed to calls.
+
+func isFuncValue(value any) bool {
+	switch value.(type) {
+	case Callback, func(...any):
+		return true
+	}
+	return false
+}
+
+func toCallback(fn any, onDecodeError func(error)) (Callback, error) {
+	switch typed := fn.(type) {
+	case Callback:
+		if typed == nil {
+			return nil, errors.New("callback is nil")
+		}
+		return typed, nil
+	case func(...any):
+		if typed == nil {
+			return nil, errors.New("callback is nil")
+		}
+		return Callback(typed), nil
+	}
+	return nil, fmt.Errorf("callback must be a kkrpc.Callback in a kkrpc_minimal build, got %T", fn)
+}
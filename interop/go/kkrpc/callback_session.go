@@ -0,0 +1,135 @@
+package kkrpc
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCallbackSessionCapacity is how many sessions NewCallbackSessions
+// keeps when given no capacity.
+const DefaultCallbackSessionCapacity = 1024
+
+// WithResumableCallbacks gives the client a stable session id, announced with
+// a "resume" message first on every connection: at start and, over a
+// ReconnectingTransport, after each reconnect. A peer built WithCallbackSessions
+// then routes callbacks it was handed on an earlier connection to the new one.
+// The ids of registered callbacks never change, so long-lived subscriptions
+// survive a blip. Peers that do not know the message ignore it.
+func WithResumableCallbacks() Option {
+	return func(o *options) {
+		o.resumeID = newSessionID()
+	}
+}
+
+// SessionID returns the id announced by a client built WithResumableCallbacks,
+// or "".
+func (c *Client) SessionID() string {
+	return c.options.resumeID
+}
+
+func resumePayload(sessionID string) map[string]any {
+	return map[string]any{"t": "resume", "sid": sessionID}
+}
+
+// CallbackSessions tracks, per resumable session, the connection its peer is
+// currently on. Share one between all the servers or channels a listener
+// creates. A session belongs to the subject of the Identity its first
+// connection had; connections with another identity cannot resume it. Sessions are evicted least recently resumed first once capacity
+// is reached; callbacks of an evicted session keep writing to its last
+// connection.
+type CallbackSessions struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type callbackSession struct {
+	id        string
+	owner     string
+	mu        sync.Mutex
+	transport Transport
+	options   *options
+}
+
+func NewCallbackSessions(capacity int) *CallbackSessions {
+	if capacity <= 0 {
+		capacity = DefaultCallbackSessionCapacity
+	}
+	return &CallbackSessions{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// WithCallbackSessions lets peers that announce a session id resume their
+// callbacks on this connection; see WithResumableCallbacks.
+func WithCallbackSessions(sessions *CallbackSessions) Option {
+	return func(o *options) {
+		o.cbSessions = sessions
+	}
+}
+
+func (s *CallbackSessions) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// attach points session id at transport and returns it, or nil if the
+// session belongs to another owner.
+func (s *CallbackSessions) attach(id, owner string, transport Transport, o *options) *callbackSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var session *callbackSession
+	if element, ok := s.entries[id]; ok {
+		session = element.Value.(*callbackSession)
+		if session.owner != owner {
+			return nil
+		}
+		s.order.MoveToFront(element)
+	} else {
+		session = &callbackSession{id: id, owner: owner}
+		s.entries[id] = s.order.PushFront(session)
+		if s.order.Len() > s.capacity {
+			oldest := s.order.Back()
+			s.order.Remove(oldest)
+			delete(s.entries, oldest.Value.(*callbackSession).id)
+		}
+	}
+	session.mu.Lock()
+	session.transport, session.options = transport, o
+	session.mu.Unlock()
+	return session
+}
+
+func (s *callbackSession) current() (Transport, *options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transport, s.options
+}
+
+// resume binds this connection to the session named in message.
+func (o *options) resume(transport Transport, message map[string]any) {
+	id, _ := message["sid"].(string)
+	if o.cbSessions == nil || id == "" {
+		return
+	}
+	var owner string
+	if identity := o.identity.Load(); identity != nil {
+		owner = identity.Subject
+	}
+	session := o.cbSessions.attach(id, owner, transport, o)
+	if session == nil {
+		o.logger.Printf("kkrpc: refused to resume callback session of another identity")
+		return
+	}
+	o.cbSession.Store(session)
+}
+
+// callbackTransport is where callbacks received on this connection are
+// invoked and released: the connection the session's peer is on now.
+func (s *Server) callbackTransport() (Transport, *options) {
+	session := s.options.cbSession.Load()
+	if session == nil {
+		return s.transport, s.options
+	}
+	return session.current()
+}
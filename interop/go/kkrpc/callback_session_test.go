@@ -0,0 +1,120 @@
+package kkrpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestResumableCallbacksSurviveReconnect(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	sessions := NewCallbackSessions(0)
+	var mu sync.Mutex
+	var subscribers []Callback
+	var ends []*PipeTransport
+	api := map[string]any{
+		"subscribe": func(args ...any) any {
+			mu.Lock()
+			subscribers = append(subscribers, args[0].(Callback))
+			mu.Unlock()
+			return nil
+		},
+	}
+	dial := func(context.Context) (Transport, error) {
+		local, remote := NewPipeTransportPair()
+		mu.Lock()
+		ends = append(ends, remote)
+		mu.Unlock()
+		NewServer(remote, api, WithCallbackSessions(sessions))
+		return local, nil
+	}
+	transport, err := DialReconnecting(context.Background(), ReconnectOptions{Dial: dial, MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	client := NewClient(transport, WithTimeout(2*time.Second), WithResumableCallbacks())
+	if client.SessionID() == "" {
+		t.Fatal("no session id")
+	}
+
+	events := make(chan any, 16)
+	if _, err := client.Call("subscribe", func(args ...any) { events <- args[0] }); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(value any) {
+		mu.Lock()
+		subscriber := subscribers[0]
+		mu.Unlock()
+		subscriber(value)
+	}
+	publish("before")
+	if got := <-events; got != "before" {
+		t.Fatalf("got %v", got)
+	}
+
+	mu.Lock()
+	_ = ends[0].Close()
+	mu.Unlock()
+	deadline := time.After(2 * time.Second)
+	for {
+		// Events published while the client is away are lost; keep
+		// publishing until the resumed session delivers one.
+		publish("after")
+		select {
+		case got := <-events:
+			if got != "after" {
+				t.Fatalf("got %v", got)
+			}
+			if transport.Generation() != 1 || sessions.Len() != 1 {
+				t.Fatalf("generation %d, %d sessions", transport.Generation(), sessions.Len())
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("callback was not resumed on the new connection")
+		}
+	}
+}
+
+func TestCallbackSessionsEvictLeastRecentlyResumed(t *testing.T) {
+	sessions := NewCallbackSessions(2)
+	for _, id := range []string{"a", "b", "a", "c"} {
+		sessions.attach(id, "", nil, nil)
+	}
+	if sessions.Len() != 2 {
+		t.Fatalf("%d sessions", sessions.Len())
+	}
+	if _, ok := sessions.entries["b"]; ok {
+		t.Fatal("b should have been evicted")
+	}
+}
+
+func TestCallbackSessionsBelongToTheirIdentity(t *testing.T) {
+	sessions := NewCallbackSessions(0)
+	connect := func(subject string) *options {
+		opts := []Option{WithCallbackSessions(sessions), WithLogger(nopLogger{})}
+		if subject != "" {
+			opts = append(opts, WithIdentity(Identity{Subject: subject}))
+		}
+		o := newOptions(opts)
+		local, _ := NewPipeTransportPair()
+		o.resume(local, resumePayload("session-1"))
+		return o
+	}
+	if connect("alice").cbSession.Load() == nil {
+		t.Fatal("new session not attached")
+	}
+	for _, subject := range []string{"mallory", ""} {
+		if connect(subject).cbSession.Load() != nil {
+			t.Fatalf("%q resumed alice's session", subject)
+		}
+	}
+	if connect("alice").cbSession.Load() == nil {
+		t.Fatal("the owner could not resume its session")
+	}
+}
@@ -0,0 +1,181 @@
+package kkrpc
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the values removed by a RedactionRule.
+const Redacted = "[REDACTED]"
+
+// RedactionRule removes secrets from the argument samples of session and
+// contract captures before they are stored, so captures can be attached to
+// bug reports. A rule applies to the calls matching Method and redacts the
+// arguments at Args, the values under Field and the strings matching Value.
+type RedactionRule struct {
+	// Method is a path.Match pattern such as "auth.*"; empty matches every
+	// method.
+	Method string
+	// Args are zero-based indexes of whole arguments to redact.
+	Args []int
+	// Field redacts the value of this object key at any depth, ignoring case,
+	// e.g. "password" or "token".
+	Field string
+	// Value redacts every string it matches, e.g. `^sk-[A-Za-z0-9]+$`.
+	Value *regexp.Regexp
+}
+
+func (rule RedactionRule) matches(method string) bool {
+	if rule.Method == "" {
+		return true
+	}
+	matched, _ := path.Match(rule.Method, method)
+	return matched
+}
+
+// redactSample applies rules to a sample decoded from JSON, in place.
+func redactSample(rules []RedactionRule, method string, sample []any) {
+	for _, rule := range rules {
+		if !rule.matches(method) {
+			continue
+		}
+		for _, index := range rule.Args {
+			if index >= 0 && index < len(sample) {
+				sample[index] = Redacted
+			}
+		}
+		if rule.Field != "" || rule.Value != nil {
+			for i, arg := range sample {
+				sample[i] = rule.redact(arg)
+			}
+		}
+	}
+}
+
+func (rule RedactionRule) redact(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, item := range typed {
+			if rule.Field != "" && strings.EqualFold(key, rule.Field) {
+				typed[key] = Redacted
+				continue
+			}
+			typed[key] = rule.redact(item)
+		}
+	case []any:
+		for i, item := range typed {
+			typed[i] = rule.redact(item)
+		}
+	case string:
+		if rule.Value != nil && rule.Value.MatchString(typed) {
+			return Redacted
+		}
+	}
+	return value
+}
+
+// captureMagic starts encrypted capture files; the rest is a GCM nonce and
+// the sealed JSON.
+const captureMagic = "kkrpc-capture-aes256-gcm\n"
+
+var ErrCaptureEncrypted = errors.New("kkrpc: capture is encrypted, a key is required")
+
+// NewCaptureKey returns a random 32-byte key for encrypted captures. Share it
+// hex-encoded, apart from the files, with whoever needs to read them.
+func NewCaptureKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseCaptureKey decodes a hex-encoded capture key.
+func ParseCaptureKey(text string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("kkrpc: capture key must be 64 hex digits")
+	}
+	return key, nil
+}
+
+// EncryptCapture seals data with AES-256-GCM under key.
+func EncryptCapture(key, data []byte) ([]byte, error) {
+	aead, err := captureCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(captureMagic)+aead.NonceSize(), len(captureMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, captureMagic)
+	nonce := out[len(captureMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, []byte(captureMagic)), nil
+}
+
+// DecryptCapture opens data sealed by EncryptCapture. Data that is not
+// encrypted is returned as is.
+func DecryptCapture(key, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(captureMagic)) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, ErrCaptureEncrypted
+	}
+	aead, err := captureCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed := data[len(captureMagic):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("kkrpc: capture is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(captureMagic))
+	if err != nil {
+		return nil, errors.New("kkrpc: capture key is wrong or the file is corrupt")
+	}
+	return plain, nil
+}
+
+func captureCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("kkrpc: capture key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeCapture writes data, encrypted and readable by the owner only when key
+// is set.
+func writeCapture(path string, data []byte, key []byte) error {
+	data = append(data, '\n')
+	if key == nil {
+		return os.WriteFile(path, data, 0o644)
+	}
+	sealed, err := EncryptCapture(key, data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0o600)
+}
+
+func readCapture(path string, key []byte) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptCapture(key, data)
+}
@@ -0,0 +1,80 @@
+package kkrpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedactionRulesScrubCapturedArgs(t *testing.T) {
+	recorder := NewSessionRecorder()
+	recorder.Redact(
+		RedactionRule{Field: "password"},
+		RedactionRule{Method: "auth.*", Args: []int{0}},
+		RedactionRule{Value: regexp.MustCompile(`^sk-[a-z0-9]+$`)},
+	)
+	recorder.record("auth.login", []any{"alice", map[string]any{"Password": "hunter2", "remember": true}})
+	recorder.record("users.find", []any{"alice", []any{"sk-abc123", "visible"}})
+
+	data, _ := json.Marshal(recorder.Session().Calls)
+	got := string(data)
+	for _, secret := range []string{"hunter2", "sk-abc123", `"alice",{`} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("capture leaks %q: %s", secret, got)
+		}
+	}
+	for _, kept := range []string{`"remember":true`, `"users.find","args":["alice"`, "visible"} {
+		if !strings.Contains(got, kept) {
+			t.Fatalf("capture lost %q: %s", kept, got)
+		}
+	}
+
+	contracts := NewContractRecorder()
+	contracts.Redact(RedactionRule{Field: "token"})
+	contracts.record("sync", []any{map[string]any{"token": "t-1"}}, nil, nil)
+	if sample := contracts.Contract().Entries[0].Sample; !valuesEqual(sample[0].(map[string]any)["token"], Redacted) {
+		t.Fatalf("contract sample %v", sample)
+	}
+}
+
+func TestEncryptedCaptureFiles(t *testing.T) {
+	recorder := NewSessionRecorder()
+	recorder.record("secrets.get", []any{"db-password"})
+	key, err := NewCaptureKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "session.kkcap")
+	if err := recorder.WriteEncryptedFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "db-password") || strings.Contains(string(raw), "secrets.get") {
+		t.Fatal("encrypted capture contains plaintext")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("mode %v, %v", info.Mode(), err)
+	}
+
+	if _, err := LoadSession(path); !errors.Is(err, ErrCaptureEncrypted) {
+		t.Fatalf("load without key: %v", err)
+	}
+	wrong, _ := NewCaptureKey()
+	if _, err := LoadSessionWithKey(path, wrong); err == nil {
+		t.Fatal("loaded with the wrong key")
+	}
+	session, err := LoadSessionWithKey(path, key)
+	if err != nil || len(session.Calls) != 1 || session.Calls[0].Method != "secrets.get" {
+		t.Fatalf("session %+v, %v", session, err)
+	}
+
+	parsed, err := ParseCaptureKey(strings.ToUpper(hex.EncodeToString(key)))
+	if err != nil || string(parsed) != string(key) {
+		t.Fatalf("parse key: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package kkrpc
+
+type Channel struct {
+	*Client
+	server *Server
+}
+
+func NewChannel(transport Transport, api map[string]any, opts ...Option) *Channel {
+	o := newOptions(opts)
+	client := newClient(transport, o)
+	server := newServer(transport, api, o)
+	server.dispatcher = client.dispatcher
+	channel := &Channel{Client: client, server: server}
+	startReading(transport, o, channel.handleMessage)
+	return channel
+}
+
+func (c *Channel) Server() *Server {
+	return c.server
+}
+
+func (c *Channel) handleMessage(message map[string]any) {
+	if messageType, _ := message["t"].(string); messageType == "q" || messageType == "sq" {
+		c.server.handleMessage(message)
+		return
+	}
+	c.Client.handleMessage(message)
+}
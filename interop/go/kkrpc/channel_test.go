@@ -0,0 +1,125 @@
+package kkrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestChannelCallsInBothDirections(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	a := NewChannel(left, map[string]any{
+		"name": func(args ...any) any { return "a" },
+	})
+	b := NewChannel(right, map[string]any{
+		"name": func(args ...any) any { return "b" },
+	})
+
+	fromA, err := a.Call("name")
+	if err != nil || fromA != "b" {
+		t.Fatalf("a -> b: %#v, %v", fromA, err)
+	}
+	fromB, err := b.Call("name")
+	if err != nil || fromB != "a" {
+		t.Fatalf("b -> a: %#v, %v", fromB, err)
+	}
+}
+
+func TestChannelDetectsMutualCallDeadlock(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	var a, b *Channel
+	a = NewChannel(left, map[string]any{
+		"ping": func(ctx context.Context, args ...any) any {
+			result, err := a.CallContext(ctx, "pong")
+			if err != nil {
+				return err
+			}
+			return result
+		},
+		"ack": func(args ...any) any { return "ack" },
+	}, WithMaxGoroutines(1))
+	b = NewChannel(right, map[string]any{
+		"pong": func(ctx context.Context, args ...any) any {
+			result, err := b.CallContext(ctx, "ack")
+			if err != nil {
+				return err
+			}
+			return result
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Call("ping")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !IsDeadlock(err) {
+			t.Fatalf("expected deadlock error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("mutual call hung instead of reporting a deadlock")
+	}
+}
+
+func TestReentrantChannelServesCallsWhileWaiting(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	var a, b *Channel
+	a = NewChannel(left, map[string]any{
+		"ping": func(ctx context.Context, args ...any) any {
+			result, err := a.CallContext(ctx, "pong")
+			if err != nil {
+				return err
+			}
+			return result
+		},
+		"ack": func(args ...any) any { return "ack" },
+	}, WithMaxGoroutines(1), WithReentrantCalls(true))
+	b = NewChannel(right, map[string]any{
+		"pong": func(ctx context.Context, args ...any) any {
+			result, err := b.CallContext(ctx, "ack")
+			if err != nil {
+				return err
+			}
+			return result
+		},
+	}, WithMaxGoroutines(1), WithReentrantCalls(true))
+
+	done := make(chan any, 1)
+	go func() {
+		result, err := b.Call("ping")
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- result
+	}()
+
+	select {
+	case result := <-done:
+		if result != "ack" {
+			t.Fatalf("unexpected result: %#v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("re-entrant call chain hung")
+	}
+}
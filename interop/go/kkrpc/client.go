@@ -1,9 +1,12 @@
 package kkrpc
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Callback func(args ...any)
@@ -14,54 +17,138 @@ type responsePayload struct {
 }
 
 type Client struct {
-	transport Transport
-	pending   map[string]chan responsePayload
-	callbacks map[string]Callback
-	mu        sync.Mutex
+	transport  Transport
+	options    *options
+	dispatcher *dispatcher
+	pending    map[string]chan responsePayload
+	callbacks  map[string]Callback
+	running    int
+	idle       chan struct{}
+	closing    bool
+	mu         sync.Mutex
 }
 
-func NewClient(transport Transport) *Client {
+func NewClient(transport Transport, opts ...Option) *Client {
+	client := newClient(transport, newOptions(opts))
+	startReading(transport, client.options, client.handleMessage)
+	return client
+}
+
+func newClient(transport Transport, o *options) *Client {
 	client := &Client{
-		transport: transport,
-		pending:   make(map[string]chan responsePayload),
-		callbacks: make(map[string]Callback),
+		transport:  transport,
+		options:    o,
+		dispatcher: newDispatcher(o.pool, o.maxGoroutines),
+		pending:    make(map[string]chan responsePayload),
+		callbacks:  make(map[string]Callback),
 	}
-	go client.readLoop()
+	o.failPending = client.failPending
 	return client
 }
 
+func (c *Client) MaxGoroutines() int {
+	return c.dispatcher.limit()
+}
+
+// PendingCalls returns the number of requests still awaiting a response.
+func (c *Client) PendingCalls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
 func (c *Client) Call(method string, args ...any) (any, error) {
-	return c.sendRequest("call", strings.Split(method, "."), args, nil)
+	return c.CallContext(context.Background(), method, args...)
+}
+
+func (c *Client) CallContext(ctx context.Context, method string, args ...any) (any, error) {
+	if outbox := c.options.outbox; outbox != nil && IsCritical(ctx) {
+		return outbox.call(ctx, c, method, args)
+	}
+	c.options.sessions.record(method, args)
+	result, err := c.sendRequest(ctx, "call", splitMethod(method), args, nil)
+	c.options.contracts.record(method, args, result, err)
+	return result, err
+}
+
+func (c *Client) Go(method string, args ...any) *Future {
+	return c.GoContext(context.Background(), method, args...)
+}
+
+// GoContext makes the call on a worker of the client's pool and returns its
+// Future at once; ctx bounds the call as it does for CallContext. When every
+// worker is busy it waits for one, so a burst of calls cannot start goroutines
+// without bound.
+func (c *Client) GoContext(ctx context.Context, method string, args ...any) *Future {
+	future := NewFuture()
+	if err := ctx.Err(); err != nil {
+		future.Reject(err)
+		return future
+	}
+	c.dispatcher.pool.Go(func() {
+		future.settle(c.CallContext(ctx, method, args...))
+	})
+	return future
+}
+
+func splitMethod(method string) []string {
+	return strings.Split(method, ".")
 }
 
 func (c *Client) Get(path []string) (any, error) {
-	return c.sendRequest("get", path, nil, nil)
+	return c.sendRequest(context.Background(), "get", path, nil, nil)
 }
 
 func (c *Client) Set(path []string, value any) (any, error) {
-	return c.sendRequest("set", path, nil, value)
+	return c.sendRequest(context.Background(), "set", path, nil, value)
 }
 
-func (c *Client) sendRequest(op string, path []string, args []any, value any) (any, error) {
-	requestID := GenerateUUID()
-	responseCh := make(chan responsePayload, 1)
-	c.mu.Lock()
-	c.pending[requestID] = responseCh
-	c.mu.Unlock()
+func (c *Client) sendRequest(ctx context.Context, op string, path []string, args []any, value any) (any, error) {
+	result, err := c.sendRequestOnce(ctx, op, path, args, value, nil)
+	if missing := missingBlobs(err); len(missing) > 0 && c.options.blobs != nil {
+		// One side evicted blobs the other expected it to hold; both forget
+		// them and the retry carries them in full.
+		c.options.blobs.forget(missing)
+		return c.sendRequestOnce(ctx, op, path, args, value, missing)
+	}
+	return result, err
+}
+
+func (c *Client) sendRequestOnce(ctx context.Context, op string, path []string, args []any, value any, missingBlobs []string) (any, error) {
+	if c.options.timeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.options.timeout)
+			defer cancel()
+		}
+	}
+
+	if !c.begin() {
+		return nil, fmt.Errorf("kkrpc: %s %s: %w", op, strings.Join(path, "."), ErrClosing)
+	}
+	defer c.end()
+
+	if err := c.options.flow.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("kkrpc: %s %s: %w", op, strings.Join(path, "."), err)
+	}
+	defer c.options.flow.release()
 
 	processedArgs := make([]any, 0, len(args))
 	for _, arg := range args {
-		if cb, ok := arg.(Callback); ok {
-			callbackID := GenerateUUID()
-			c.mu.Lock()
-			c.callbacks[callbackID] = cb
-			c.mu.Unlock()
-			processedArgs = append(processedArgs, map[string]any{ArgEnvelopeTag: "callback", "id": callbackID})
-			continue
+		processed, err := c.encodeArg(arg)
+		if err != nil {
+			return nil, err
 		}
-		processedArgs = append(processedArgs, arg)
+		processedArgs = append(processedArgs, processed)
 	}
 
+	requestID := GenerateUUID()
+	responseCh := make(chan responsePayload, 1)
+	c.mu.Lock()
+	c.pending[requestID] = responseCh
+	c.mu.Unlock()
+	c.options.yield(schedRegistered, requestID)
+
 	payload := map[string]any{
 		"t":  "q",
 		"id": requestID,
@@ -74,51 +161,159 @@ func (c *Client) sendRequest(op string, path []string, args []any, value any) (a
 	if op == "set" || value != nil {
 		payload["v"] = value
 	}
+	if meta := MetadataFromContext(ctx); len(meta) > 0 {
+		payload["meta"] = meta
+	}
+	if key := IdempotencyKeyFromContext(ctx); key != "" {
+		payload["idem"] = key
+	}
+	if len(missingBlobs) > 0 {
+		payload["bm"] = missingBlobs
+	}
+	c.options.encodeEnvelope(ctx, payload)
+	c.options.stampRequest(payload)
 
-	message, err := EncodeMessage(payload)
+	if err := c.writeRequest(ctx, payload, strings.Join(path, "."), args); err != nil {
+		c.forget(requestID)
+		return nil, err
+	}
+
+	if request := inflightFromContext(ctx); request != nil {
+		defer request.awaitResponse()()
+	}
+	select {
+	case response := <-responseCh:
+		return response.Result, response.Err
+	case <-ctx.Done():
+		c.options.yield(schedAbandoning, requestID)
+		c.forget(requestID)
+		return nil, fmt.Errorf("kkrpc: %s %s: %w", op, strings.Join(path, "."), ctx.Err())
+	}
+}
+
+func (c *Client) encodeArg(arg any) (any, error) {
+	switch typed := arg.(type) {
+	case map[string]any:
+		encoded := make(map[string]any, len(typed))
+		for key, value := range typed {
+			processed, err := c.encodeArg(value)
+			if err != nil {
+				return nil, err
+			}
+			encoded[key] = processed
+		}
+		return encoded, nil
+	case []any:
+		encoded := make([]any, 0, len(typed))
+		for _, value := range typed {
+			processed, err := c.encodeArg(value)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, processed)
+		}
+		return encoded, nil
+	}
+	if blob, ok := arg.(Blob); ok {
+		return c.options.encodeBlobs(blob), nil
+	}
+	if stream, ok := arg.(*Stream); ok {
+		return c.options.streams.attach(c.transport, c.options, stream), nil
+	}
+	if !isFuncValue(arg) {
+		return arg, nil
+	}
+	callbackID := GenerateUUID()
+	cb, err := toCallback(arg, func(err error) {
+		c.options.logger.Printf("kkrpc: callback %s: %v", callbackID, err)
+		if c.options.reportCbErrs {
+			c.sendCallbackError(callbackID, err)
+		}
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err := c.transport.Write(message); err != nil {
+	if err := c.options.resources.acquireHandle(); err != nil {
 		return nil, err
 	}
+	c.mu.Lock()
+	c.callbacks[callbackID] = cb
+	c.mu.Unlock()
+	return map[string]any{ArgEnvelopeTag: "callback", "id": callbackID}, nil
+}
+
+func (c *Client) forget(requestID string) {
+	c.mu.Lock()
+	delete(c.pending, requestID)
+	c.mu.Unlock()
+}
 
-	response := <-responseCh
-	return response.Result, response.Err
+// failPending fails every call awaiting a response with err.
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]chan responsePayload)
+	c.mu.Unlock()
+	c.options.yield(schedFailing, "")
+	for _, responseCh := range pending {
+		responseCh <- responsePayload{Err: err}
+	}
 }
 
 func (c *Client) Close() error {
+	c.options.streams.cancel()
 	return c.transport.Close()
 }
 
-func (c *Client) readLoop() {
-	for {
-		line, err := c.transport.Read()
-		if err != nil {
-			if errors.Is(err, ErrTransportClosed) {
-				return
-			}
-			return
-		}
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			continue
+func (c *Client) handleMessage(message map[string]any) {
+	messageType, _ := message["t"].(string)
+	switch messageType {
+	case "r":
+		c.handleResponse(message)
+	case "cb":
+		callbackID, _ := message["id"].(string)
+		c.mu.Lock()
+		callback := c.callbacks[callbackID]
+		if callback != nil {
+			c.join()
 		}
-		message, err := DecodeMessage(trimmed)
-		if err != nil {
-			continue
-		}
-		messageType, _ := message["t"].(string)
-		switch messageType {
-		case "r":
-			c.handleResponse(message)
-		case "cb":
-			c.handleCallback(message)
+		c.mu.Unlock()
+		if callback != nil {
+			c.dispatcher.run(func() {
+				defer c.end()
+				c.handleCallback(callbackID, callback, message)
+			})
 		}
+	case "cbr":
+		c.releaseCallbacks(message)
+	case "sr":
+		c.options.remoteStreams.handleResponse(message)
+	case "sq":
+		c.options.streams.handleRequest(c.transport, c.options, message)
+	case "protocol_error":
+		c.handleProtocolError(message)
+	case "q":
+		// Nothing serves requests sent to a bare client.
+		requestID, _ := message["id"].(string)
+		c.options.resources.release(requestID)
+	}
+}
+
+func (c *Client) handleProtocolError(message map[string]any) {
+	requestID, _ := message["id"].(string)
+	c.mu.Lock()
+	responseCh, ok := c.pending[requestID]
+	if ok {
+		delete(c.pending, requestID)
+	}
+	c.mu.Unlock()
+	if ok {
+		responseCh <- responsePayload{Err: protocolErrorFromMessage(message)}
 	}
 }
 
 func (c *Client) handleResponse(message map[string]any) {
+	c.options.observeResponse(message, time.Now())
 	requestID, _ := message["id"].(string)
 	c.mu.Lock()
 	responseCh, ok := c.pending[requestID]
@@ -129,22 +324,31 @@ func (c *Client) handleResponse(message map[string]any) {
 	if !ok {
 		return
 	}
+	c.options.yield(schedClaimed, requestID)
 
 	if errValue, exists := message["e"]; exists {
 		responseCh <- responsePayload{Result: nil, Err: decodeError(errValue)}
 		return
 	}
-	responseCh <- responsePayload{Result: message["v"], Err: nil}
-}
-
-func (c *Client) handleCallback(message map[string]any) {
-	callbackID, _ := message["id"].(string)
-	c.mu.Lock()
-	callback := c.callbacks[callbackID]
-	c.mu.Unlock()
-	if callback == nil {
+	value, err := c.options.resolveBlobs(message["v"])
+	if err != nil {
+		responseCh <- responsePayload{Result: nil, Err: err}
 		return
 	}
+	responseCh <- responsePayload{Result: c.decodeValue(value), Err: nil}
+}
+
+// handleCallback runs on the pool; the callback is looked up on the read loop
+// so a later "cbr" for the same id cannot overtake the invocation.
+func (c *Client) handleCallback(callbackID string, callback Callback, message map[string]any) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			c.options.logger.Printf("kkrpc: callback %s panicked: %v", callbackID, recovered)
+			if c.options.reportCbErrs {
+				c.sendCallbackError(callbackID, fmt.Errorf("callback panicked: %v", recovered))
+			}
+		}
+	}()
 
 	argsRaw, _ := message["a"].([]any)
 	if argsRaw == nil {
@@ -154,6 +358,17 @@ func (c *Client) handleCallback(message map[string]any) {
 	callback(decodeArgs(argsRaw)...)
 }
 
+func (c *Client) sendCallbackError(callbackID string, err error) {
+	payload := map[string]any{
+		"t":  "cbe",
+		"id": callbackID,
+		"e":  encodeError(err),
+	}
+	if writeErr := writePayload(c.transport, c.options, payload); writeErr != nil {
+		c.options.logger.Printf("kkrpc: report callback error: %v", writeErr)
+	}
+}
+
 func decodeArgs(args []any) []any {
 	decoded := make([]any, 0, len(args))
 	for _, arg := range args {
@@ -183,6 +398,22 @@ func (e *RpcError) Error() string {
 	return e.Name + ": " + e.Message
 }
 
+func encodeError(err error) map[string]any {
+	var rpcErr *RpcError
+	if errors.As(err, &rpcErr) && rpcErr.Name != "" {
+		encoded := map[string]any{"n": rpcErr.Name, "m": rpcErr.Message}
+		if data, ok := rpcErr.Data.(map[string]any); ok {
+			for key, value := range data {
+				if _, reserved := encoded[key]; !reserved {
+					encoded[key] = value
+				}
+			}
+		}
+		return encoded
+	}
+	return map[string]any{"n": "Error", "m": err.Error()}
+}
+
 func decodeError(value any) error {
 	if value == nil {
 		return errors.New("unknown error")
@@ -0,0 +1,92 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+var ErrNoBackends = errors.New("kkrpc: client pool has no backends")
+
+type affinityKey struct{}
+
+// ContextWithAffinity routes calls made with ctx through a ClientPool to the
+// same backend as every other call with key, e.g. a session or document id,
+// as stateful sidecars require.
+func ContextWithAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityKey{}, key)
+}
+
+func AffinityFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(affinityKey{}).(string)
+	return key
+}
+
+// PoolBackend is one backend of a ClientPool. Name identifies it for affinity
+// and must be unique and stable, e.g. a worker's address.
+type PoolBackend struct {
+	Name   string
+	Caller Caller
+}
+
+// ClientPool spreads calls over several backends serving the same API, such
+// as a Client per worker process. Calls without an affinity key go round
+// robin; keyed calls always reach the same backend.
+type ClientPool struct {
+	backends []PoolBackend
+	next     atomic.Uint64
+}
+
+func NewClientPool(backends ...PoolBackend) *ClientPool {
+	return &ClientPool{backends: append([]PoolBackend(nil), backends...)}
+}
+
+func (p *ClientPool) Len() int {
+	return len(p.backends)
+}
+
+// Backend returns the backend serving key. It uses rendezvous hashing on the
+// backend names, so a pool rebuilt with a backend more or less moves only the
+// keys of that backend.
+func (p *ClientPool) Backend(key string) PoolBackend {
+	var best PoolBackend
+	var bestScore uint64
+	for i, backend := range p.backends {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(backend.Name))
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(key))
+		if score := mix64(hash.Sum64()); i == 0 || score > bestScore {
+			best, bestScore = backend, score
+		}
+	}
+	return best
+}
+
+func (p *ClientPool) CallContext(ctx context.Context, method string, args ...any) (any, error) {
+	if len(p.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+	var backend PoolBackend
+	if key := AffinityFromContext(ctx); key != "" {
+		backend = p.Backend(key)
+	} else {
+		backend = p.backends[(p.next.Add(1)-1)%uint64(len(p.backends))]
+	}
+	return backend.Caller.CallContext(ctx, method, args...)
+}
+
+func (p *ClientPool) Call(method string, args ...any) (any, error) {
+	return p.CallContext(context.Background(), method, args...)
+}
+
+// mix64 is the splitmix64 finalizer; FNV alone spreads keys that differ only
+// in their last bytes poorly.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
@@ -0,0 +1,74 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type namedCaller string
+
+func (c namedCaller) CallContext(ctx context.Context, method string, args ...any) (any, error) {
+	return string(c), nil
+}
+
+func TestClientPoolRoutesByAffinity(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	backends := []PoolBackend{
+		{Name: "worker-a", Caller: namedCaller("a")},
+		{Name: "worker-b", Caller: namedCaller("b")},
+		{Name: "worker-c", Caller: namedCaller("c")},
+	}
+	pool := NewClientPool(backends...)
+
+	var unkeyed []any
+	for i := 0; i < 6; i++ {
+		result, _ := pool.Call("doc.open")
+		unkeyed = append(unkeyed, result)
+	}
+	if fmt.Sprint(unkeyed) != "[a b c a b c]" {
+		t.Fatalf("unkeyed calls should go round robin: %v", unkeyed)
+	}
+
+	ctx := ContextWithAffinity(context.Background(), "doc-42")
+	first, _ := pool.CallContext(ctx, "doc.open")
+	for i := 0; i < 5; i++ {
+		if again, _ := pool.CallContext(ctx, "doc.edit"); again != first {
+			t.Fatalf("doc-42 moved from %v to %v", first, again)
+		}
+	}
+
+	counts := map[string]int{}
+	assigned := map[string]string{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		name := pool.Backend(key).Name
+		counts[name]++
+		assigned[key] = name
+	}
+	for _, backend := range backends {
+		if counts[backend.Name] < 800 {
+			t.Fatalf("uneven spread: %v", counts)
+		}
+	}
+
+	// Dropping a backend only moves the keys it served.
+	smaller := NewClientPool(backends[0], backends[2])
+	for key, name := range assigned {
+		if moved := smaller.Backend(key).Name; name != "worker-b" && moved != name {
+			t.Fatalf("%s moved from %s to %s", key, name, moved)
+		}
+	}
+}
+
+func TestClientPoolWithoutBackends(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if _, err := NewClientPool().Call("anything"); !errors.Is(err, ErrNoBackends) {
+		t.Fatalf("expected ErrNoBackends, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func callbackIDFromRequest(t *testing.T, raw string) (string, string) {
+	t.Helper()
+	message, err := DecodeMessage(raw)
+	if err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	args, _ := message["a"].([]any)
+	for _, arg := range args {
+		envelope, ok := arg.(map[string]any)
+		if ok && envelope[ArgEnvelopeTag] == "callback" {
+			id, _ := envelope["id"].(string)
+			return message["id"].(string), id
+		}
+	}
+	t.Fatalf("request carried no callback: %s", raw)
+	return "", ""
+}
+
+func TestClientDecodesCallbackArgsIntoDeclaredTypes(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+	client := NewClient(transport)
+
+	type progress struct {
+		Done  int    `json:"done"`
+		Label string `json:"label"`
+	}
+	received := make(chan progress, 1)
+	callback := func(step progress, total int) {
+		step.Done = step.Done * 100 / total
+		received <- step
+	}
+
+	go func() { _, _ = client.Call("download", "file.bin", callback) }()
+
+	var requestID, callbackID string
+	select {
+	case raw := <-transport.out:
+		requestID, callbackID = callbackIDFromRequest(t, raw)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("request not sent")
+	}
+
+	invoke, err := EncodeMessage(map[string]any{
+		"t":  "cb",
+		"id": callbackID,
+		"a":  []any{map[string]any{"done": 5, "label": "file.bin"}, 10},
+	})
+	if err != nil {
+		t.Fatalf("encode callback: %v", err)
+	}
+	transport.in <- invoke
+
+	select {
+	case step := <-received:
+		if step.Done != 50 || step.Label != "file.bin" {
+			t.Fatalf("unexpected callback payload: %#v", step)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("callback not invoked")
+	}
+
+	response, _ := EncodeMessage(map[string]any{"t": "r", "id": requestID, "v": true})
+	transport.in <- response
+}
+
+func TestBindCallbackProducesTypedFunc(t *testing.T) {
+	var sent []any
+	cb := Callback(func(args ...any) { sent = args })
+
+	var notify func(name string, count int)
+	if err := BindCallback(cb, &notify); err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	notify("items", 3)
+	if len(sent) != 2 || sent[0] != "items" || sent[1] != 3 {
+		t.Fatalf("unexpected forwarded args: %#v", sent)
+	}
+}
+
+func TestNestedCallbacksRoundTrip(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientSide, serverSide := newConnectedTestTransports()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	api := map[string]any{
+		"upload": func(args ...any) any {
+			options, _ := args[0].(map[string]any)
+			hooks, _ := options["hooks"].([]any)
+			onProgress, _ := options["onProgress"].(Callback)
+			onDone, _ := hooks[0].(Callback)
+			if onProgress == nil || onDone == nil {
+				return "missing callbacks"
+			}
+			onProgress(0.5)
+			onDone("finished")
+			return "ok"
+		},
+	}
+	_ = NewServer(serverSide, api)
+	client := NewClient(clientSide)
+
+	progress := make(chan float64, 1)
+	done := make(chan string, 1)
+	result, err := client.Call("upload", map[string]any{
+		"name":       "file.bin",
+		"onProgress": func(value float64) { progress <- value },
+		"hooks":      []any{func(status string) { done <- status }},
+	})
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if result != "ok" {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	select {
+	case value := <-progress:
+		if value != 0.5 {
+			t.Fatalf("unexpected progress: %v", value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("progress callback not invoked")
+	}
+	select {
+	case status := <-done:
+		if status != "finished" {
+			t.Fatalf("unexpected status: %s", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nested array callback not invoked")
+	}
+}
+
+type recordingLogger struct {
+	lines chan string
+}
+
+func (l *recordingLogger) Printf(format string, args ...any) {
+	l.lines <- fmt.Sprintf(format, args...)
+}
+
+func TestClientRecoversCallbackPanics(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+	logger := &recordingLogger{lines: make(chan string, 4)}
+	client := NewClient(transport, WithLogger(logger), WithCallbackErrorReporting(true))
+
+	go func() { _, _ = client.Call("subscribe", func(args ...any) { panic("boom") }) }()
+
+	var callbackID string
+	select {
+	case raw := <-transport.out:
+		_, callbackID = callbackIDFromRequest(t, raw)
+	case <-time.After(2 * time.Second):
+		t.Fatalf("request not sent")
+	}
+
+	invoke, _ := EncodeMessage(map[string]any{"t": "cb", "id": callbackID, "a": []any{}})
+	transport.in <- invoke
+
+	select {
+	case line := <-logger.lines:
+		if !strings.Contains(line, "boom") {
+			t.Fatalf("unexpected log line: %s", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("panic was not logged")
+	}
+	select {
+	case raw := <-transport.out:
+		message, _ := DecodeMessage(raw)
+		errMap, _ := message["e"].(map[string]any)
+		if message["t"] != "cbe" || message["id"] != callbackID || !strings.Contains(toString(errMap["m"]), "boom") {
+			t.Fatalf("unexpected callback error message: %s", raw)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("callback error not reported to remote")
+	}
+}
+
+func TestServerLogsCallbackErrorsReportedByThePeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+	logger := &recordingLogger{lines: make(chan string, 1)}
+	_ = NewServer(transport, map[string]any{}, WithLogger(logger))
+
+	report, err := EncodeMessage(map[string]any{"t": "cbe", "id": "cb-1", "e": encodeError(errors.New("callback panicked: boom"))})
+	if err != nil {
+		t.Fatalf("encode report: %v", err)
+	}
+	transport.in <- report
+
+	select {
+	case line := <-logger.lines:
+		if !strings.Contains(line, "cb-1") || !strings.Contains(line, "boom") {
+			t.Fatalf("unexpected log line: %s", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("callback error was not logged")
+	}
+}
@@ -0,0 +1,180 @@
+package kkrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultClockSamples is how many recent round trips a ClockEstimator keeps.
+const DefaultClockSamples = 8
+
+// ClockSample is one timestamped round trip. Origin and Received are read
+// from the local clock, RemoteReceived and RemoteSent from the peer's.
+type ClockSample struct {
+	Origin         time.Time
+	RemoteReceived time.Time
+	RemoteSent     time.Time
+	Received       time.Time
+}
+
+// RTT is the round trip minus the time the peer spent handling the request.
+func (s ClockSample) RTT() time.Duration {
+	return s.Received.Sub(s.Origin) - s.RemoteSent.Sub(s.RemoteReceived)
+}
+
+// Offset is how far the peer's clock is ahead of the local one, assuming the
+// network delay is the same in both directions.
+func (s ClockSample) Offset() time.Duration {
+	return (s.RemoteReceived.Sub(s.Origin) + s.RemoteSent.Sub(s.Received)) / 2
+}
+
+// ClockEstimate is the peer clock offset and round trip time derived from
+// the sample with the lowest RTT, which carries the least queuing noise.
+type ClockEstimate struct {
+	Offset  time.Duration
+	RTT     time.Duration
+	Samples int
+}
+
+// ToLocal converts a timestamp read from the peer's clock to the local clock.
+func (e ClockEstimate) ToLocal(remote time.Time) time.Time {
+	return remote.Add(-e.Offset)
+}
+
+// Latency is the one-way delay of a message the peer stamped at remoteSent
+// and that arrived locally at received.
+func (e ClockEstimate) Latency(remoteSent, received time.Time) time.Duration {
+	return received.Sub(e.ToLocal(remoteSent))
+}
+
+// ClockEstimator tracks the offset between the local clock and a peer's from
+// the timestamps exchanged on calls; see WithClockEstimator.
+type ClockEstimator struct {
+	mu      sync.Mutex
+	samples []ClockSample
+	next    int
+	size    int
+}
+
+// NewClockEstimator keeps the last size samples, DefaultClockSamples if size
+// is not positive.
+func NewClockEstimator(size int) *ClockEstimator {
+	if size <= 0 {
+		size = DefaultClockSamples
+	}
+	return &ClockEstimator{size: size}
+}
+
+// Observe adds a round trip. Samples with a negative RTT, which only clock
+// steps during the call can produce, are dropped.
+func (c *ClockEstimator) Observe(sample ClockSample) {
+	if sample.RTT() < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) < c.size {
+		c.samples = append(c.samples, sample)
+		return
+	}
+	c.samples[c.next] = sample
+	c.next = (c.next + 1) % c.size
+}
+
+// Estimate reports the current estimate, or false before the first sample.
+func (c *ClockEstimator) Estimate() (ClockEstimate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) == 0 {
+		return ClockEstimate{}, false
+	}
+	best := c.samples[0]
+	for _, sample := range c.samples[1:] {
+		if sample.RTT() < best.RTT() {
+			best = sample
+		}
+	}
+	return ClockEstimate{Offset: best.Offset(), RTT: best.RTT(), Samples: len(c.samples)}, true
+}
+
+// WithClockEstimator stamps outgoing requests with the send time and feeds
+// the peer's receive and send times from each response into estimator.
+// Servers always echo timestamps on requests that carry them.
+func WithClockEstimator(estimator *ClockEstimator) Option {
+	return func(o *options) {
+		o.clock = estimator
+	}
+}
+
+// Timestamps travel as fractional Unix milliseconds, matching Date.now() and
+// performance.timeOrigin + performance.now() on the JavaScript side.
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+func fromUnixMillis(value any) (time.Time, bool) {
+	millis, ok := toFloat64(value)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(millis*float64(time.Millisecond))), true
+}
+
+func (o *options) stampRequest(payload map[string]any) {
+	if o.clock != nil {
+		payload["ts"] = map[string]any{"tx": unixMillis(time.Now())}
+	}
+}
+
+func (o *options) observeResponse(message map[string]any, received time.Time) {
+	stamps, _ := message["ts"].(map[string]any)
+	if o.clock == nil || stamps == nil {
+		return
+	}
+	origin, ok1 := fromUnixMillis(stamps["o"])
+	remoteReceived, ok2 := fromUnixMillis(stamps["rx"])
+	remoteSent, ok3 := fromUnixMillis(stamps["tx"])
+	if ok1 && ok2 && ok3 {
+		o.clock.Observe(ClockSample{Origin: origin, RemoteReceived: remoteReceived, RemoteSent: remoteSent, Received: received})
+	}
+}
+
+// requestStamps holds the origin and receive times of requests that carried
+// timestamps until their response is written.
+type requestStamps struct {
+	mu      sync.Mutex
+	pending map[string][2]float64
+}
+
+func (r *requestStamps) received(message map[string]any, at time.Time) {
+	stamps, _ := message["ts"].(map[string]any)
+	origin, ok := toFloat64(stamps["tx"])
+	requestID, _ := message["id"].(string)
+	if !ok || requestID == "" {
+		return
+	}
+	r.mu.Lock()
+	if r.pending == nil {
+		r.pending = make(map[string][2]float64)
+	}
+	r.pending[requestID] = [2]float64{origin, unixMillis(at)}
+	r.mu.Unlock()
+}
+
+func (r *requestStamps) take(requestID string) ([2]float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stamps, ok := r.pending[requestID]
+	delete(r.pending, requestID)
+	return stamps, ok
+}
+
+func (r *requestStamps) forget(requestID string) {
+	r.take(requestID)
+}
+
+func (r *requestStamps) stamp(requestID string, payload map[string]any) {
+	if stamps, ok := r.take(requestID); ok {
+		payload["ts"] = map[string]any{"o": stamps[0], "rx": stamps[1], "tx": unixMillis(time.Now())}
+	}
+}
@@ -0,0 +1,67 @@
+package kkrpc
+
+import (
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestClockEstimatorPrefersLowestRTT(t *testing.T) {
+	base := time.Unix(1_700_000_000, 0)
+	skew := 3 * time.Second
+	sample := func(at, up, handling, down time.Duration) ClockSample {
+		origin := base.Add(at)
+		return ClockSample{
+			Origin:         origin,
+			RemoteReceived: origin.Add(up + skew),
+			RemoteSent:     origin.Add(up + handling + skew),
+			Received:       origin.Add(up + handling + down),
+		}
+	}
+	estimator := NewClockEstimator(4)
+	if _, ok := estimator.Estimate(); ok {
+		t.Fatal("estimate before any sample")
+	}
+	estimator.Observe(sample(0, 40*time.Millisecond, 5*time.Millisecond, 10*time.Millisecond))
+	estimator.Observe(sample(time.Second, 5*time.Millisecond, 50*time.Millisecond, 5*time.Millisecond))
+	estimator.Observe(ClockSample{Origin: base, RemoteReceived: base, RemoteSent: base.Add(time.Second), Received: base})
+
+	estimate, ok := estimator.Estimate()
+	if !ok || estimate.Samples != 2 {
+		t.Fatalf("estimate %+v %v", estimate, ok)
+	}
+	if estimate.Offset != skew || estimate.RTT != 10*time.Millisecond {
+		t.Fatalf("offset %v rtt %v", estimate.Offset, estimate.RTT)
+	}
+	remoteSent := base.Add(skew)
+	if latency := estimate.Latency(remoteSent, base.Add(7*time.Millisecond)); latency != 7*time.Millisecond {
+		t.Fatalf("latency %v", latency)
+	}
+}
+
+func TestClientEstimatesClockFromResponses(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	estimator := NewClockEstimator(0)
+	client := NewClient(clientTransport, WithTimeout(time.Second), WithClockEstimator(estimator))
+	server := NewServer(serverTransport, map[string]any{
+		"echo": func(args ...any) any { return args[0] },
+	})
+	defer client.Close()
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Call("echo", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	estimate, ok := estimator.Estimate()
+	if !ok || estimate.Samples != 3 {
+		t.Fatalf("estimate %+v %v", estimate, ok)
+	}
+	if estimate.Offset.Abs() > 50*time.Millisecond || estimate.RTT < 0 {
+		t.Fatalf("same-process estimate %+v", estimate)
+	}
+}
@@ -0,0 +1,174 @@
+package kkrpc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type Codec interface {
+	Name() string
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte) (any, error)
+}
+
+// LimitedCodec is a Codec able to enforce a channel's DecodeLimits while it
+// decodes. Frames of other codecs are checked once decoded.
+type LimitedCodec interface {
+	Codec
+	UnmarshalLimited(data []byte, limits DecodeLimits) (any, error)
+}
+
+type codecRule struct {
+	pattern []string
+	codec   string
+}
+
+type codecSet struct {
+	mu        sync.Mutex
+	codecs    map[string]Codec
+	rules     []codecRule
+	peer      map[string]bool
+	responses map[string]string
+}
+
+func (s *codecSet) register(codec Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.codecs == nil {
+		s.codecs = make(map[string]Codec)
+	}
+	s.codecs[codec.Name()] = codec
+}
+
+func (s *codecSet) prefer(pattern string, codec Codec) {
+	s.register(codec)
+	s.mu.Lock()
+	s.rules = append(s.rules, codecRule{pattern: splitMethod(pattern), codec: codec.Name()})
+	s.mu.Unlock()
+}
+
+func (s *codecSet) names() []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]any, 0, len(s.codecs))
+	for name := range s.codecs {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *codecSet) handshake(ack bool) map[string]any {
+	return map[string]any{"t": "hs", "codecs": s.names(), "ack": ack}
+}
+
+func (s *codecSet) acceptHandshake(message map[string]any) (reply map[string]any) {
+	names, _ := message["codecs"].([]any)
+	s.mu.Lock()
+	s.peer = make(map[string]bool, len(names))
+	for _, name := range names {
+		if text, ok := name.(string); ok {
+			s.peer[text] = true
+		}
+	}
+	local := len(s.codecs)
+	s.mu.Unlock()
+	if ack, _ := message["ack"].(bool); ack || local == 0 {
+		return nil
+	}
+	return s.handshake(true)
+}
+
+func (s *codecSet) codecFor(payload map[string]any) Codec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.codecs) == 0 {
+		return nil
+	}
+	id, _ := payload["id"].(string)
+	switch payload["t"] {
+	case "q":
+		path := payloadPath(payload)
+		for _, rule := range s.rules {
+			if matchPattern(rule.pattern, path) && s.peer[rule.codec] {
+				return s.codecs[rule.codec]
+			}
+		}
+	case "r":
+		if name, ok := s.responses[id]; ok {
+			delete(s.responses, id)
+			return s.codecs[name]
+		}
+	}
+	return nil
+}
+
+func (s *codecSet) encode(payload map[string]any) (string, error) {
+	codec := s.codecFor(payload)
+	if codec == nil {
+		return EncodeMessage(payload)
+	}
+	data, err := codec.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return EncodeMessage(map[string]any{
+		"t": "enc",
+		"c": codec.Name(),
+		"d": base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+func (s *codecSet) decode(message map[string]any, limits DecodeLimits) (map[string]any, error) {
+	if message["t"] != "enc" {
+		return message, nil
+	}
+	name, _ := message["c"].(string)
+	s.mu.Lock()
+	codec := s.codecs[name]
+	s.mu.Unlock()
+	if codec == nil {
+		return nil, &ProtocolError{Code: "unsupported_codec", Message: fmt.Sprintf("frame uses unsupported codec %q", name)}
+	}
+	encoded, _ := message["d"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, &ProtocolError{Code: "invalid_frame", Message: err.Error()}
+	}
+	var value any
+	if limited, ok := codec.(LimitedCodec); ok {
+		value, err = limited.UnmarshalLimited(data, limits)
+	} else if value, err = codec.Unmarshal(data); err == nil {
+		err = limits.checkValue(value)
+	}
+	var protocolErr *ProtocolError
+	if errors.As(err, &protocolErr) {
+		return nil, protocolErr
+	}
+	if err != nil {
+		return nil, &ProtocolError{Code: "invalid_frame", Message: err.Error()}
+	}
+	inner, ok := value.(map[string]any)
+	if !ok {
+		return nil, &ProtocolError{Code: "invalid_frame", Message: name + " frame is not a message"}
+	}
+	if inner["t"] == "q" {
+		if id, ok := inner["id"].(string); ok {
+			s.mu.Lock()
+			if s.responses == nil {
+				s.responses = make(map[string]string)
+			}
+			s.responses[id] = name
+			s.mu.Unlock()
+		}
+	}
+	return inner, nil
+}
+
+func payloadPath(payload map[string]any) []string {
+	if path, ok := payload["p"].([]string); ok {
+		return path
+	}
+	return pathFromMessage(payload)
+}
@@ -0,0 +1,163 @@
+package kkrpc
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestMsgpackCodecRoundTrip(t *testing.T) {
+	value := map[string]any{
+		"t":     "q",
+		"small": float64(3),
+		"neg":   float64(-200),
+		"big":   float64(1 << 40),
+		"pi":    3.25,
+		"name":  strings.Repeat("x", 300),
+		"list":  []any{true, false, nil, "a"},
+		"bytes": []byte{1, 2, 3},
+	}
+	data, err := MsgpackCodec.Marshal(value)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	decoded, err := MsgpackCodec.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(value, decoded) {
+		t.Fatalf("round trip mismatch:\n%#v\n%#v", value, decoded)
+	}
+	if _, err := MsgpackCodec.Unmarshal(data[:len(data)-1]); err == nil {
+		t.Fatalf("expected truncated data to fail")
+	}
+}
+
+func TestNamespaceCodecIsNegotiatedPerPeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left := newServerTestTransport()
+	right := newServerTestTransport()
+	defer left.Close()
+	defer right.Close()
+
+	var mu sync.Mutex
+	var wire []string
+	relay := func(from, to *serverTestTransport) {
+		for {
+			select {
+			case line := <-from.out:
+				mu.Lock()
+				wire = append(wire, line)
+				mu.Unlock()
+				to.in <- line
+			case <-from.closed:
+				return
+			}
+		}
+	}
+	go relay(left, right)
+	go relay(right, left)
+
+	api := map[string]any{
+		"media":  map[string]any{"frame": func(args ...any) any { return args[0] }},
+		"config": func(args ...any) any { return "plain" },
+	}
+	_ = NewChannel(right, api, WithCodec(MsgpackCodec))
+	client := NewChannel(left, nil, WithNamespaceCodec("media.*", MsgpackCodec))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		client.options.codecs.mu.Lock()
+		negotiated := client.options.codecs.peer["msgpack"]
+		client.options.codecs.mu.Unlock()
+		if negotiated {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("codec handshake did not complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	frame, err := client.Call("media.frame", "pixels")
+	if err != nil || frame != "pixels" {
+		t.Fatalf("media.frame: %#v, %v", frame, err)
+	}
+	plain, err := client.Call("config")
+	if err != nil || plain != "plain" {
+		t.Fatalf("config: %#v, %v", plain, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var encoded, readable int
+	for _, line := range wire {
+		switch {
+		case strings.Contains(line, `"t":"enc"`):
+			encoded++
+		case strings.Contains(line, `"t":"hs"`):
+		default:
+			readable++
+			if strings.Contains(line, "pixels") {
+				t.Fatalf("media payload leaked as plain JSON: %s", line)
+			}
+		}
+	}
+	if encoded != 2 || readable != 2 {
+		t.Fatalf("expected 2 encoded and 2 plain frames, got %d and %d: %v", encoded, readable, wire)
+	}
+}
+
+func TestMsgpackRejectsDeepNesting(t *testing.T) {
+	data := append(bytesRepeat(0x91, 1000), 0xc0)
+	if _, err := MsgpackCodec.Unmarshal(data); err == nil {
+		t.Fatalf("expected deeply nested msgpack to be rejected")
+	}
+}
+
+func bytesRepeat(b byte, count int) []byte {
+	data := make([]byte, count)
+	for i := range data {
+		data[i] = b
+	}
+	return data
+}
+
+func TestChannelDecodeLimitsApplyToCodecFrames(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	transport := newServerTestTransport()
+	server := NewServer(transport, map[string]any{"echo": func(args ...any) any { return len(args) }},
+		WithCodec(MsgpackCodec), WithDecodeLimits(DecodeLimits{MaxDepth: 4, MaxElements: 16}))
+	defer server.Close()
+	send := func(args []any) map[string]any {
+		t.Helper()
+		data, err := MsgpackCodec.Marshal(map[string]any{"t": "q", "id": "1", "op": "call", "p": []any{"echo"}, "a": args})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, _ := EncodeMessage(map[string]any{"t": "enc", "c": "msgpack", "d": base64.StdEncoding.EncodeToString(data)})
+		transport.in <- frame
+		for {
+			// Skip the codec handshake.
+			if reply, _ := DecodeMessage(<-transport.out); reply["t"] != "hs" {
+				return reply
+			}
+		}
+	}
+
+	if reply := send(make([]any, 100)); reply["code"] != "too_many_elements" {
+		t.Fatalf("wide frame: %v", reply)
+	}
+	if reply := send([]any{[]any{[]any{[]any{"deep"}}}}); reply["code"] != "too_deep" {
+		t.Fatalf("deep frame: %v", reply)
+	}
+	if reply := send([]any{"ok"}); reply["t"] == "protocol_error" {
+		t.Fatalf("small frame rejected: %v", reply)
+	}
+}
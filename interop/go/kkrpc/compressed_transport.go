@@ -0,0 +1,218 @@
+package kkrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Compressor compresses whole messages for a CompressedTransport. Gzip is
+// built in; zstd, brotli and others plug in by implementing it, e.g. around
+// github.com/klauspost/compress/zstd.
+type Compressor interface {
+	// Name identifies the algorithm to the peer, e.g. "zstd".
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress must fail rather than return more than limit bytes.
+	Decompress(data []byte, limit int) ([]byte, error)
+}
+
+// GzipCompressor compresses with compress/gzip at Level; zero means
+// gzip.DefaultCompression.
+type GzipCompressor struct {
+	Level int
+}
+
+func (GzipCompressor) Name() string { return "gzip" }
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, errCompressedTooLarge
+	}
+	return out, nil
+}
+
+var errCompressedTooLarge = errors.New("kkrpc: compressed message inflates past the limit")
+
+type CompressedOptions struct {
+	// Compressors are offered to the peer in order of preference. Defaults to
+	// gzip alone.
+	Compressors []Compressor
+	// MinSize is the size below which messages are sent as they are.
+	// Defaults to 256 bytes.
+	MinSize int
+	// MaxSize bounds a received message once decompressed. Defaults to 64 MiB.
+	MaxSize int
+}
+
+// compressedFrame is the capability ("zc") or a compressed message ("zm").
+type compressedFrame struct {
+	T string   `json:"t"`
+	Z []string `json:"z,omitempty"`
+	A string   `json:"a,omitempty"`
+	D []byte   `json:"d,omitempty"`
+}
+
+// CompressedTransport compresses the messages it sends, for low-bandwidth
+// links between Go peers. It first sends a "zc" frame naming its
+// compressors; once the peer's arrives, messages of at least MinSize go out as
+// "zm" frames compressed with the first of ours the peer offers, unless that
+// does not make them smaller. Until then, and with a peer that does not wrap
+// its transport and so ignores "zc", messages are sent as they are. Lines
+// that are not "zm" frames pass through unchanged.
+type CompressedTransport struct {
+	inner Transport
+	opts  CompressedOptions
+	mu    sync.Mutex
+	use   Compressor
+}
+
+func NewCompressedTransport(inner Transport, opts CompressedOptions) *CompressedTransport {
+	if len(opts.Compressors) == 0 {
+		opts.Compressors = []Compressor{GzipCompressor{}}
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 256
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = maxWebSocketMessage
+	}
+	t := &CompressedTransport{inner: inner, opts: opts}
+	names := make([]string, len(opts.Compressors))
+	for i, compressor := range opts.Compressors {
+		names[i] = compressor.Name()
+	}
+	_ = t.writeFrame(compressedFrame{T: "zc", Z: names})
+	return t
+}
+
+// Compressor returns the compressor messages are sent with, or nil before the
+// peer has offered one in common.
+func (t *CompressedTransport) Compressor() Compressor {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.use
+}
+
+func (t *CompressedTransport) Write(message string) error {
+	compressor := t.Compressor()
+	if compressor == nil || len(message) < t.opts.MinSize {
+		return t.inner.Write(message)
+	}
+	data, err := compressor.Compress([]byte(message))
+	if err != nil {
+		return err
+	}
+	frame, err := json.Marshal(compressedFrame{T: "zm", A: compressor.Name(), D: data})
+	if err != nil {
+		return err
+	}
+	if len(frame)+1 >= len(message) {
+		return t.inner.Write(message)
+	}
+	return t.inner.Write(string(frame) + "\n")
+}
+
+func (t *CompressedTransport) Read() (string, error) {
+	for {
+		line, err := t.inner.Read()
+		if err != nil {
+			return "", err
+		}
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, `{"t":"z`) {
+			return line, nil
+		}
+		var frame compressedFrame
+		if err := json.Unmarshal([]byte(trimmed), &frame); err != nil {
+			return line, nil
+		}
+		switch frame.T {
+		case "zc":
+			t.negotiate(frame.Z)
+		case "zm":
+			message, err := t.decompress(frame)
+			if err != nil {
+				_ = t.inner.Close()
+			}
+			return message, err
+		default:
+			return line, nil
+		}
+	}
+}
+
+// negotiate picks the first of our compressors that the peer offers.
+func (t *CompressedTransport) negotiate(offered []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.use = nil
+	for _, compressor := range t.opts.Compressors {
+		for _, name := range offered {
+			if compressor.Name() == name {
+				t.use = compressor
+				return
+			}
+		}
+	}
+}
+
+// decompress fails rather than pass on a frame that cannot be trusted; Read
+// then closes the connection.
+func (t *CompressedTransport) decompress(frame compressedFrame) (string, error) {
+	for _, compressor := range t.opts.Compressors {
+		if compressor.Name() != frame.A {
+			continue
+		}
+		data, err := compressor.Decompress(frame.D, t.opts.MaxSize)
+		if err != nil {
+			return "", fmt.Errorf("kkrpc: %s message: %w", frame.A, err)
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("kkrpc: message compressed with %q, which was not offered", frame.A)
+}
+
+func (t *CompressedTransport) writeFrame(frame compressedFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return t.inner.Write(string(data) + "\n")
+}
+
+func (t *CompressedTransport) Close() error {
+	return t.inner.Close()
+}
@@ -0,0 +1,101 @@
+package kkrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// meteredTransport counts the bytes written through it.
+type meteredTransport struct {
+	Transport
+	written atomic.Int64
+}
+
+func (t *meteredTransport) Write(message string) error {
+	t.written.Add(int64(len(message)))
+	return t.Transport.Write(message)
+}
+
+// namedGzip stands in for a compressor only one peer has.
+type namedGzip struct {
+	GzipCompressor
+	name string
+}
+
+func (c namedGzip) Name() string { return c.name }
+
+func TestCompressedTransportCompressesLargeMessages(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	clientInner, serverInner := NewPipeTransportPair()
+	metered := &meteredTransport{Transport: clientInner}
+	clientTransport := NewCompressedTransport(metered, CompressedOptions{
+		Compressors: []Compressor{namedGzip{name: "zstd"}, GzipCompressor{Level: gzip.BestSpeed}},
+	})
+	serverTransport := NewCompressedTransport(serverInner, CompressedOptions{})
+	server := NewServer(serverTransport, map[string]any{"echo": func(args ...any) any { return args[0] }})
+	defer server.Close()
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	// The first call is sent before the server's capability has been read.
+	if result, err := client.Call("echo", "small"); err != nil || result != "small" {
+		t.Fatalf("%v %v", result, err)
+	}
+	if clientTransport.Compressor() == nil || clientTransport.Compressor().Name() != "gzip" {
+		t.Fatalf("negotiated %v", clientTransport.Compressor())
+	}
+	large := strings.Repeat("order 42 shipped; ", 4096)
+	before := metered.written.Load()
+	if result, err := client.Call("echo", large); err != nil || result != large {
+		t.Fatalf("large message: %v", err)
+	}
+	if sent := metered.written.Load() - before; sent > int64(len(large)/10) {
+		t.Fatalf("sent %d bytes for a %d byte argument", sent, len(large))
+	}
+}
+
+func TestCompressedTransportFallsBackForPlainPeers(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	clientInner, serverTransport := NewPipeTransportPair()
+	clientTransport := NewCompressedTransport(clientInner, CompressedOptions{})
+	// The plain peer drops the "zc" frame as an unknown message type.
+	server := NewServer(serverTransport, map[string]any{"echo": func(args ...any) any { return args[0] }})
+	defer server.Close()
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	large := strings.Repeat("x", 10000)
+	for i := 0; i < 2; i++ {
+		if result, err := client.Call("echo", large); err != nil || result != large {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if clientTransport.Compressor() != nil {
+		t.Fatal("compressing for a peer that cannot decompress")
+	}
+}
+
+func TestCompressedTransportBoundsInflatedSize(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	inner, peer := NewPipeTransportPair()
+	transport := NewCompressedTransport(inner, CompressedOptions{MaxSize: 1 << 10})
+	defer transport.Close()
+	bomb, err := GzipCompressor{}.Compress(bytes.Repeat([]byte{' '}, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := NewCompressedTransport(peer, CompressedOptions{})
+	_ = sender.writeFrame(compressedFrame{T: "zm", A: "gzip", D: bomb})
+	if _, err := transport.Read(); err == nil || !strings.Contains(err.Error(), "past the limit") {
+		t.Fatalf("read: %v", err)
+	}
+	if err := sender.Write("{}"); err == nil {
+		t.Fatal("connection still open")
+	}
+}
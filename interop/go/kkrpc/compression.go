@@ -0,0 +1,55 @@
+package kkrpc
+
+import "context"
+
+// CompressionHook decides whether the request for method, size bytes once
+// encoded, is compressed; e.g. only text arguments over 8 KiB, leaving
+// already-compressed binary and small calls alone.
+type CompressionHook func(method string, args []any, size int) bool
+
+// WithCompressionHook makes hook decide, per request, whether transports
+// that compress, like a WebSocket with permessage-deflate, compress it.
+// Without a hook they compress every message of at least 256 bytes. Responses
+// and callbacks keep that default.
+func WithCompressionHook(hook CompressionHook) Option {
+	return func(o *options) {
+		o.compression = hook
+	}
+}
+
+type compressionKey struct{}
+
+// ContextWithCompression forces calls made with ctx to be sent compressed or
+// not, overriding the CompressionHook and the size threshold.
+func ContextWithCompression(ctx context.Context, compress bool) context.Context {
+	return context.WithValue(ctx, compressionKey{}, compress)
+}
+
+// CompressionFromContext returns the choice set by ContextWithCompression.
+func CompressionFromContext(ctx context.Context) (compress bool, ok bool) {
+	compress, ok = ctx.Value(compressionKey{}).(bool)
+	return compress, ok
+}
+
+// compressionWriter is implemented by transports able to compress single
+// messages.
+type compressionWriter interface {
+	writeCompressed(message string, compress bool) error
+}
+
+func (c *Client) writeRequest(ctx context.Context, payload map[string]any, method string, args []any) error {
+	writer, ok := c.transport.(compressionWriter)
+	forced, isForced := CompressionFromContext(ctx)
+	if !ok || (!isForced && c.options.compression == nil) {
+		return writePayload(c.transport, c.options, payload)
+	}
+	message, err := c.options.encodePayload(payload)
+	if err != nil {
+		return err
+	}
+	compress := forced
+	if !isForced {
+		compress = c.options.compression(method, args, len(message))
+	}
+	return writer.writeCompressed(message, compress)
+}
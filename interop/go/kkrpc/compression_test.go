@@ -0,0 +1,102 @@
+package kkrpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// compressingTransport records the compression choice of every write and
+// never answers.
+type compressingTransport struct {
+	mu      sync.Mutex
+	choices map[string]bool
+	closed  chan struct{}
+}
+
+func (t *compressingTransport) Read() (string, error) {
+	<-t.closed
+	return "", ErrTransportClosed
+}
+
+func (t *compressingTransport) Write(message string) error {
+	return t.writeCompressed(message, len(message) >= deflateMinSize)
+}
+
+func (t *compressingTransport) writeCompressed(message string, compress bool) error {
+	payload, err := DecodeMessage(message)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.choices[strings.Join(payloadPath(payload), ".")] = compress
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *compressingTransport) Close() error {
+	close(t.closed)
+	return nil
+}
+
+func TestCompressionHookDecidesPerRequest(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := &compressingTransport{choices: map[string]bool{}, closed: make(chan struct{})}
+	defer transport.Close()
+	var seen []int
+	client := NewClient(transport, WithTimeout(20*time.Millisecond), WithCompressionHook(func(method string, args []any, size int) bool {
+		seen = append(seen, size)
+		text, _ := args[0].(string)
+		return len(text) > 8<<10
+	}))
+
+	_, _ = client.Call("docs.save", strings.Repeat("a", 10<<10))
+	_, _ = client.Call("docs.preview", strings.Repeat("a", 1<<10))
+	_, _ = client.CallContext(ContextWithCompression(context.Background(), true), "docs.ping", "x")
+	_, _ = client.CallContext(ContextWithCompression(context.Background(), false), "docs.upload", strings.Repeat("a", 10<<10))
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	want := map[string]bool{"docs.save": true, "docs.preview": false, "docs.ping": true, "docs.upload": false}
+	for method, compress := range want {
+		if got, ok := transport.choices[method]; !ok || got != compress {
+			t.Fatalf("%s: compress %v, want %v", method, got, compress)
+		}
+	}
+	if len(seen) != 2 || seen[0] <= 10<<10 {
+		t.Fatalf("hook should see the two unforced requests with their encoded size: %v", seen)
+	}
+}
+
+func TestCompressionHookOverWebSocket(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := ListenWebSocket("127.0.0.1:0", WithWebSocketCompression(true))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = listener.ServeAPI(map[string]any{
+			"echo": func(args ...any) any { return args[0] },
+		})
+	}()
+	transport, err := NewWebSocketTransport("ws://"+listener.Addr().String(), WithWebSocketCompression(true))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := NewClient(transport, WithTimeout(2*time.Second), WithCompressionHook(func(method string, args []any, size int) bool {
+		return size < deflateMinSize
+	}))
+	defer client.Close()
+	for _, payload := range []string{"tiny", strings.Repeat("large ", 1000)} {
+		if result, err := client.Call("echo", payload); err != nil || result != payload {
+			t.Fatalf("echo %d bytes: %v", len(payload), err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The environment variables read by LoadConfig.
+const (
+	EnvTimeout         = "KKRPC_TIMEOUT"
+	EnvLogLevel        = "KKRPC_LOG_LEVEL"
+	EnvMaxMessageBytes = "KKRPC_MAX_MSG_BYTES"
+	EnvEndpoint        = "KKRPC_ENDPOINT"
+)
+
+// Log levels for WithLogLevel. The library only logs problems, so "warn" and
+// "info" mean the same as LogLevelError.
+const (
+	LogLevelOff   = "off"
+	LogLevelError = "error"
+	LogLevelDebug = "debug"
+)
+
+var ErrNoEndpoint = errors.New("kkrpc: no endpoint configured")
+
+// Config holds the settings operators tune on a deployed binary without
+// rebuilding it. Zero fields leave the defaults alone.
+type Config struct {
+	// Timeout is the default call timeout. KKRPC_TIMEOUT takes a Go
+	// duration such as "30s", or milliseconds as the TypeScript side uses.
+	Timeout time.Duration
+	// LogLevel is one of the LogLevel constants.
+	LogLevel string
+	// MaxMessageBytes rejects larger incoming messages.
+	MaxMessageBytes int
+	// Endpoint is the peer's URL for Dial: ws://, wss://, http://, https://,
+	// tcp://host:port or unix:///path.
+	Endpoint string
+}
+
+// LoadConfig reads the KKRPC_* variables. It fails on a value it cannot
+// parse rather than silently running with the default.
+func LoadConfig() (Config, error) {
+	var config Config
+	if value, ok := os.LookupEnv(EnvTimeout); ok && value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil {
+			return Config{}, configError(EnvTimeout, value, err)
+		}
+		config.Timeout = timeout
+	}
+	if value, ok := os.LookupEnv(EnvLogLevel); ok && value != "" {
+		level, err := parseLogLevel(value)
+		if err != nil {
+			return Config{}, configError(EnvLogLevel, value, err)
+		}
+		config.LogLevel = level
+	}
+	if value, ok := os.LookupEnv(EnvMaxMessageBytes); ok && value != "" {
+		n, err := strconv.Atoi(value)
+		if err == nil && n <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			return Config{}, configError(EnvMaxMessageBytes, value, err)
+		}
+		config.MaxMessageBytes = n
+	}
+	if value, ok := os.LookupEnv(EnvEndpoint); ok && value != "" {
+		if _, err := url.Parse(value); err != nil {
+			return Config{}, configError(EnvEndpoint, value, err)
+		}
+		config.Endpoint = value
+	}
+	return config, nil
+}
+
+// Options turns the config into options. Put them before the program's own,
+// which then take precedence:
+//
+//	kkrpc.NewChannel(transport, api, append(config.Options(), opts...)...)
+func (c Config) Options() []Option {
+	var opts []Option
+	if c.Timeout > 0 {
+		opts = append(opts, WithTimeout(c.Timeout))
+	}
+	if c.LogLevel != "" {
+		opts = append(opts, WithLogLevel(c.LogLevel))
+	}
+	if c.MaxMessageBytes > 0 {
+		n := c.MaxMessageBytes
+		opts = append(opts, func(o *options) {
+			o.limits.MaxBytes = n
+		})
+	}
+	return opts
+}
+
+// Dial connects to Endpoint.
+func (c Config) Dial(ctx context.Context) (Transport, error) {
+	if c.Endpoint == "" {
+		return nil, ErrNoEndpoint
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch endpoint.Scheme {
+	case "ws", "wss":
+		return NewWebSocketTransport(c.Endpoint)
+	case "http", "https":
+		return NewHTTPClientTransport(c.Endpoint, HTTPClientOptions{}), nil
+	case "tcp", "unix":
+		address := endpoint.Host
+		if endpoint.Scheme == "unix" {
+			address = endpoint.Path
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, endpoint.Scheme, address)
+		if err != nil {
+			return nil, err
+		}
+		return NewConnTransport(conn), nil
+	default:
+		return nil, fmt.Errorf("kkrpc: unsupported endpoint scheme %q", endpoint.Scheme)
+	}
+}
+
+// WithLogLevel sets how much the channel logs. Unless a logger was given, it
+// logs to stderr. LogLevelDebug also logs every call and how long it took.
+func WithLogLevel(level string) Option {
+	return func(o *options) {
+		o.logLevel = level
+	}
+}
+
+func (o *options) applyLogLevel() {
+	switch o.logLevel {
+	case "":
+		return
+	case LogLevelOff:
+		o.logger = nopLogger{}
+		return
+	}
+	if _, unset := o.logger.(nopLogger); unset {
+		o.logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if o.logLevel == LogLevelDebug {
+		WithHook(logHook{logger: o.logger})(o)
+	}
+}
+
+// logHook logs every call for LogLevelDebug.
+type logHook struct {
+	logger Logger
+}
+
+func (h logHook) CallStarted(method string) {
+	h.logger.Printf("kkrpc: call %s", method)
+}
+
+func (h logHook) CallFinished(method string, duration time.Duration, err error) {
+	if err != nil {
+		h.logger.Printf("kkrpc: call %s failed after %s: %v", method, duration, err)
+		return
+	}
+	h.logger.Printf("kkrpc: call %s done in %s", method, duration)
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if ms, atoiErr := strconv.Atoi(value); atoiErr == nil {
+		timeout, err = time.Duration(ms)*time.Millisecond, nil
+	}
+	if err == nil && timeout <= 0 {
+		err = errors.New("must be positive")
+	}
+	return timeout, err
+}
+
+func parseLogLevel(value string) (string, error) {
+	switch level := strings.ToLower(value); level {
+	case LogLevelOff, LogLevelError, LogLevelDebug:
+		return level, nil
+	case "warn", "warning", "info":
+		return LogLevelError, nil
+	default:
+		return "", errors.New("want off, error or debug")
+	}
+}
+
+func configError(name, value string, err error) error {
+	return fmt.Errorf("kkrpc: %s=%q: %w", name, value, err)
+}
@@ -0,0 +1,97 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestLoadConfigAppliesEnvironmentAsDefaults(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	t.Setenv(EnvTimeout, "1500")
+	t.Setenv(EnvLogLevel, "DEBUG")
+	t.Setenv(EnvMaxMessageBytes, "4096")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Timeout != 1500*time.Millisecond || config.LogLevel != LogLevelDebug || config.MaxMessageBytes != 4096 {
+		t.Fatalf("config %+v", config)
+	}
+
+	o := newOptions(append(config.Options(), WithTimeout(time.Second)))
+	if o.timeout != time.Second {
+		t.Fatalf("timeout %v, the program's option should win", o.timeout)
+	}
+	if o.limits.MaxBytes != 4096 || o.limits.MaxDepth != DefaultDecodeLimits.MaxDepth {
+		t.Fatalf("limits %+v", o.limits)
+	}
+
+	logger := &recordingLogger{lines: make(chan string, 8)}
+	serverSide, clientSide := NewPipeTransportPair()
+	NewServer(serverSide, map[string]any{"ping": MustFunc(func() string { return "pong" })},
+		append(config.Options(), WithLogger(logger))...)
+	client := NewClient(clientSide, WithTimeout(2*time.Second))
+	defer client.Close()
+	if _, err := client.Call("ping"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-logger.lines:
+		if line != "kkrpc: call ping" {
+			t.Fatalf("logged %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("debug level did not log the call")
+	}
+}
+
+func TestLoadConfigRejectsBadValues(t *testing.T) {
+	t.Setenv(EnvTimeout, "soon")
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), EnvTimeout) {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func TestConfigDialsEndpoint(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			NewServer(NewConnTransport(conn), map[string]any{"ping": MustFunc(func() string { return "pong" })})
+		}
+	}()
+
+	t.Setenv(EnvEndpoint, "tcp://"+listener.Addr().String())
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := config.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, config.Options()...)
+	defer client.Close()
+	if result, err := client.Call("ping"); err != nil || result != "pong" {
+		t.Fatalf("ping = %v, %v", result, err)
+	}
+
+	if _, err := (Config{}).Dial(context.Background()); err != ErrNoEndpoint {
+		t.Fatalf("err %v", err)
+	}
+}
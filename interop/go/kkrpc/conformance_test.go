@@ -0,0 +1,155 @@
+package kkrpc
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type interopPeer struct {
+	name    string
+	command string
+	script  []string
+}
+
+// interopPeers lists every official implementation serving the reference API.
+// KKRPC_INTEROP_PEERS (comma separated) restricts the matrix; peers whose
+// runtime is not installed are skipped.
+var interopPeers = []interopPeer{
+	{name: "bun", command: "bun", script: []string{"node", "server.ts"}},
+	{name: "python", command: "python3", script: []string{"python", "reference_server.py"}},
+}
+
+func TestConformance(t *testing.T) {
+	selected := os.Getenv("KKRPC_INTEROP_PEERS")
+	for _, peer := range interopPeers {
+		peer := peer
+		t.Run(peer.name, func(t *testing.T) {
+			if selected != "" && !strings.Contains(","+selected+",", ","+peer.name+",") {
+				t.Skipf("%s not in KKRPC_INTEROP_PEERS", peer.name)
+			}
+			if _, err := exec.LookPath(peer.command); err != nil {
+				t.Skipf("%s not installed", peer.command)
+			}
+			runConformanceSuite(t, func(t *testing.T) *Client { return startInteropPeer(t, peer) })
+		})
+	}
+}
+
+func startInteropPeer(t *testing.T, peer interopPeer) *Client {
+	t.Helper()
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("cwd: %v", err)
+	}
+	script := filepath.Join(append([]string{root, "..", ".."}, peer.script...)...)
+	cmd := exec.Command(peer.command, script)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("stdout: %v", err)
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start %s: %v", peer.name, err)
+	}
+	client := NewClient(NewStdioTransport(stdout, stdin), WithTimeout(5*time.Second))
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = stdin.Close()
+		_ = cmd.Process.Kill()
+		_, _ = cmd.Process.Wait()
+	})
+	return client
+}
+
+func runConformanceSuite(t *testing.T, connect func(t *testing.T) *Client) {
+	t.Run("call", func(t *testing.T) {
+		client := connect(t)
+		result, err := client.Call("math.add", 4, 7)
+		if err != nil || !valuesEqual(11, result) {
+			t.Fatalf("math.add: %#v %v", result, err)
+		}
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		client := connect(t)
+		input := map[string]any{"name": "kkrpc", "count": 2, "nested": map[string]any{"ok": true}}
+		result, err := client.Call("echo", input)
+		if err != nil || !compareMaps(map[string]any{"name": "kkrpc", "count": 2}, result) {
+			t.Fatalf("echo: %#v %v", result, err)
+		}
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		client := connect(t)
+		received := make(chan string, 1)
+		result, err := client.Call("withCallback", "pong", Callback(func(args ...any) { received <- toString(args[0]) }))
+		if err != nil || result != "callback-sent" {
+			t.Fatalf("withCallback: %#v %v", result, err)
+		}
+		select {
+		case payload := <-received:
+			if payload != "callback:pong" {
+				t.Fatalf("unexpected callback payload: %s", payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback not received")
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		client := connect(t)
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				result, err := client.Call("math.add", i, i+1)
+				if err != nil || !valuesEqual(2*i+1, result) {
+					errs <- fmt.Errorf("call %d: %#v %v", i, result, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+	})
+
+	t.Run("properties", func(t *testing.T) {
+		client := connect(t)
+		if counter, err := client.Get([]string{"counter"}); err != nil || !valuesEqual(42, counter) {
+			t.Fatalf("get counter: %#v %v", counter, err)
+		}
+		if enabled, err := client.Get([]string{"settings", "notifications", "enabled"}); err != nil || enabled != true {
+			t.Fatalf("get enabled: %#v %v", enabled, err)
+		}
+		if _, err := client.Set([]string{"settings", "theme"}, "dark"); err != nil {
+			t.Fatalf("set theme: %v", err)
+		}
+		if theme, err := client.Get([]string{"settings", "theme"}); err != nil || theme != "dark" {
+			t.Fatalf("get theme: %#v %v", theme, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		client := connect(t)
+		if _, err := client.Call("missing.method"); err == nil {
+			t.Fatal("expected error for unknown method")
+		}
+		if result, err := client.Call("math.add", 1, 2); err != nil || !valuesEqual(3, result) {
+			t.Fatalf("peer unusable after error: %#v %v", result, err)
+		}
+	})
+}
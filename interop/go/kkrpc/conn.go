@@ -0,0 +1,32 @@
+package kkrpc
+
+import "net"
+
+// ConnTransport frames kkrpc messages as newline-delimited lines over a
+// net.Conn, the same framing as StdioTransport.
+type ConnTransport struct {
+	*StreamTransport
+	conn net.Conn
+}
+
+// NewConnTransport wraps an established connection: TCP or unix sockets, a
+// *tls.Conn, a conn from a proxy dialer or net.Pipe. Close closes conn.
+func NewConnTransport(conn net.Conn) *ConnTransport {
+	return &ConnTransport{StreamTransport: NewStreamTransport(conn), conn: conn}
+}
+
+// Read closes the connection once it cannot be read any more, so that a
+// server whose peer hung up releases the socket even if nothing else holds the
+// transport.
+func (t *ConnTransport) Read() (string, error) {
+	message, err := t.StreamTransport.Read()
+	if err != nil {
+		_ = t.conn.Close()
+	}
+	return message, err
+}
+
+// Conn returns the wrapped connection, e.g. to inspect a TLS peer.
+func (t *ConnTransport) Conn() net.Conn {
+	return t.conn
+}
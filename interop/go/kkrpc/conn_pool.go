@@ -0,0 +1,263 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoConnections = errors.New("kkrpc: connection pool has no live connections")
+
+// Balancing picks the connection of a ConnPool that carries a call.
+type Balancing int
+
+const (
+	// RoundRobin takes the live connections in turn.
+	RoundRobin Balancing = iota
+	// LeastPending takes the connection with the fewest calls awaiting a
+	// response, which steers work away from a slow server.
+	LeastPending
+)
+
+type ConnPoolOptions struct {
+	// Dial opens the connection for slot, 0 <= slot < Size. It is called
+	// again for a slot whose connection died.
+	Dial func(ctx context.Context, slot int) (Transport, error)
+	// Size is the number of connections kept open; it defaults to 1.
+	Size int
+	// Balancing defaults to RoundRobin.
+	Balancing Balancing
+	// Options configure the Client of every connection.
+	Options []Option
+	// RedialDelay is the wait after a failed dial. It doubles with every
+	// further failure of the slot, up to 30s, and defaults to 1s.
+	RedialDelay time.Duration
+}
+
+// ConnPool keeps Size connections to a farm of identical servers and spreads
+// calls over them. A connection whose transport fails a read or write is
+// evicted and its slot redialed in the background; calls go to the remaining
+// ones meanwhile. A call whose request could not be written is retried on
+// another connection, since the server never saw it. Calls already sent on a
+// connection that dies fail with their timeout.
+type ConnPool struct {
+	opts ConnPoolOptions
+
+	mu    sync.Mutex
+	conns []*poolConn // by slot; nil while the slot is redialing
+	next  atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewConnPool dials every slot, bounded by ctx, and fails only if none
+// connects; the other slots keep redialing until Close.
+func NewConnPool(ctx context.Context, opts ConnPoolOptions) (*ConnPool, error) {
+	if opts.Dial == nil {
+		return nil, errors.New("kkrpc: ConnPoolOptions.Dial is required")
+	}
+	if opts.Size <= 0 {
+		opts.Size = 1
+	}
+	if opts.RedialDelay <= 0 {
+		opts.RedialDelay = time.Second
+	}
+	poolCtx, cancel := context.WithCancel(context.Background())
+	p := &ConnPool{opts: opts, conns: make([]*poolConn, opts.Size), ctx: poolCtx, cancel: cancel}
+
+	errs := make([]error, opts.Size)
+	var dialing sync.WaitGroup
+	for slot := range p.conns {
+		dialing.Add(1)
+		go func(slot int) {
+			defer dialing.Done()
+			p.conns[slot], errs[slot] = p.dial(ctx, slot)
+		}(slot)
+	}
+	dialing.Wait()
+	if p.Len() == 0 {
+		cancel()
+		return nil, errs[0]
+	}
+	for slot, conn := range append([]*poolConn(nil), p.conns...) {
+		p.wg.Add(1)
+		go p.maintain(slot, conn)
+	}
+	return p, nil
+}
+
+// Len returns the number of live connections.
+func (p *ConnPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	live := 0
+	for _, conn := range p.conns {
+		if conn != nil {
+			live++
+		}
+	}
+	return live
+}
+
+func (p *ConnPool) Call(method string, args ...any) (any, error) {
+	return p.CallContext(context.Background(), method, args...)
+}
+
+func (p *ConnPool) CallContext(ctx context.Context, method string, args ...any) (any, error) {
+	tried := make(map[*poolConn]bool)
+	lastErr := ErrNoConnections
+	for {
+		conn := p.pick(tried)
+		if conn == nil {
+			return nil, lastErr
+		}
+		result, err := conn.client.CallContext(ctx, method, args...)
+		var writeErr *poolWriteError
+		if !errors.As(err, &writeErr) {
+			return result, err
+		}
+		tried[conn] = true
+		lastErr = writeErr.err
+	}
+}
+
+// Close stops redialing and closes every connection.
+func (p *ConnPool) Close() error {
+	p.cancel()
+	p.mu.Lock()
+	conns := append([]*poolConn(nil), p.conns...)
+	p.mu.Unlock()
+	for _, conn := range conns {
+		if conn != nil {
+			_ = conn.client.Close()
+		}
+	}
+	p.wg.Wait()
+	return nil
+}
+
+func (p *ConnPool) pick(tried map[*poolConn]bool) *poolConn {
+	p.mu.Lock()
+	live := make([]*poolConn, 0, len(p.conns))
+	for _, conn := range p.conns {
+		if conn != nil && !tried[conn] {
+			live = append(live, conn)
+		}
+	}
+	p.mu.Unlock()
+	if len(live) == 0 {
+		return nil
+	}
+	start := int((p.next.Add(1) - 1) % uint64(len(live)))
+	if p.opts.Balancing != LeastPending {
+		return live[start]
+	}
+	// Starting the scan at a rotating offset spreads ties.
+	best, bestPending := live[start], live[start].client.PendingCalls()
+	for i := 1; i < len(live); i++ {
+		conn := live[(start+i)%len(live)]
+		if pending := conn.client.PendingCalls(); pending < bestPending {
+			best, bestPending = conn, pending
+		}
+	}
+	return best
+}
+
+func (p *ConnPool) dial(ctx context.Context, slot int) (*poolConn, error) {
+	transport, err := p.opts.Dial(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+	conn := &poolConn{transport: transport, dead: make(chan struct{})}
+	conn.client = NewClient(conn, p.opts.Options...)
+	return conn, nil
+}
+
+// maintain evicts the slot's connection once it dies and dials a new one.
+func (p *ConnPool) maintain(slot int, conn *poolConn) {
+	defer p.wg.Done()
+	for {
+		if conn != nil {
+			select {
+			case <-conn.dead:
+			case <-p.ctx.Done():
+				return
+			}
+			p.mu.Lock()
+			p.conns[slot] = nil
+			p.mu.Unlock()
+			_ = conn.client.Close()
+		}
+		delay := p.opts.RedialDelay
+		for {
+			var err error
+			if conn, err = p.dial(p.ctx, slot); err == nil {
+				break
+			}
+			select {
+			case <-time.After(delay):
+			case <-p.ctx.Done():
+				return
+			}
+			delay = min(delay*2, 30*time.Second)
+		}
+		p.mu.Lock()
+		if p.ctx.Err() != nil {
+			p.mu.Unlock()
+			_ = conn.client.Close()
+			return
+		}
+		p.conns[slot] = conn
+		p.mu.Unlock()
+	}
+}
+
+// poolConn notices when its transport fails.
+type poolConn struct {
+	transport Transport
+	client    *Client
+	dead      chan struct{}
+	once      sync.Once
+}
+
+// poolWriteError marks a request that never left, so it can be retried.
+type poolWriteError struct {
+	err error
+}
+
+func (e *poolWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *poolWriteError) Unwrap() error {
+	return e.err
+}
+
+func (c *poolConn) Read() (string, error) {
+	message, err := c.transport.Read()
+	if err != nil {
+		c.kill()
+	}
+	return message, err
+}
+
+func (c *poolConn) Write(message string) error {
+	if err := c.transport.Write(message); err != nil {
+		c.kill()
+		return &poolWriteError{err: err}
+	}
+	return nil
+}
+
+func (c *poolConn) Close() error {
+	c.kill()
+	return c.transport.Close()
+}
+
+func (c *poolConn) kill() {
+	c.once.Do(func() { close(c.dead) })
+}
@@ -0,0 +1,186 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// testFarm serves one API per dialed connection and remembers the server end
+// of each so tests can kill it.
+type testFarm struct {
+	mu      sync.Mutex
+	servers []*PipeTransport
+	down    bool
+}
+
+func (f *testFarm) dial(ctx context.Context, slot int) (Transport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	serverSide, clientSide := NewPipeTransportPair()
+	id := len(f.servers)
+	NewServer(serverSide, map[string]any{
+		"whoami": MustFunc(func() int { return id }),
+		"slow": MustFunc(func(release string) int {
+			time.Sleep(200 * time.Millisecond)
+			return id
+		}),
+	})
+	f.servers = append(f.servers, serverSide)
+	return clientSide, nil
+}
+
+func (f *testFarm) kill(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = f.servers[i].Close()
+}
+
+func TestConnPoolRoundRobinAndRedial(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	farm := &testFarm{}
+	pool, err := NewConnPool(context.Background(), ConnPoolOptions{
+		Dial:        farm.dial,
+		Size:        2,
+		Options:     []Option{WithTimeout(2 * time.Second)},
+		RedialDelay: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	seen := map[any]bool{}
+	for i := 0; i < 4; i++ {
+		id, err := pool.Call("whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[id] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("calls reached %v", seen)
+	}
+
+	farm.kill(0)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		farm.mu.Lock()
+		redialed := len(farm.servers) == 3
+		farm.mu.Unlock()
+		if redialed && pool.Len() == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not redialed: %d live", pool.Len())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		if id, err := pool.Call("whoami"); err != nil || id == float64(0) {
+			t.Fatalf("whoami = %v, %v", id, err)
+		}
+	}
+}
+
+func TestConnPoolLeastPendingAvoidsBusyConnection(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	farm := &testFarm{}
+	pool, err := NewConnPool(context.Background(), ConnPoolOptions{
+		Dial:      farm.dial,
+		Size:      2,
+		Balancing: LeastPending,
+		Options:   []Option{WithTimeout(2 * time.Second)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	busy := make(chan any, 1)
+	go func() {
+		id, _ := pool.Call("slow", "")
+		busy <- id
+	}()
+	time.Sleep(50 * time.Millisecond)
+	var ids []any
+	for i := 0; i < 3; i++ {
+		id, err := pool.Call("whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	slowID := <-busy
+	for _, id := range ids {
+		if id == slowID {
+			t.Fatalf("call went to the busy connection %v", slowID)
+		}
+	}
+}
+
+// unwritableTransport accepts no writes, like a connection reset mid-call.
+type unwritableTransport struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (t *unwritableTransport) Read() (string, error) {
+	<-t.closed
+	return "", ErrTransportClosed
+}
+
+func (t *unwritableTransport) Write(string) error {
+	return errors.New("broken pipe")
+}
+
+func (t *unwritableTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+func TestConnPoolRetriesUnsentCallsElsewhere(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	farm := &testFarm{}
+	pool, err := NewConnPool(context.Background(), ConnPoolOptions{
+		Dial: func(ctx context.Context, slot int) (Transport, error) {
+			if slot == 0 {
+				return &unwritableTransport{closed: make(chan struct{})}, nil
+			}
+			return farm.dial(ctx, slot)
+		},
+		Size:        2,
+		Options:     []Option{WithTimeout(2 * time.Second)},
+		RedialDelay: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	for i := 0; i < 3; i++ {
+		if id, err := pool.Call("whoami"); err != nil || id != float64(0) {
+			t.Fatalf("whoami = %v, %v", id, err)
+		}
+	}
+}
+
+func TestConnPoolFailsWhenNothingConnects(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	farm := &testFarm{down: true}
+	if _, err := NewConnPool(context.Background(), ConnPoolOptions{Dial: farm.dial, Size: 2}); err == nil {
+		t.Fatal("expected the dial error")
+	}
+}
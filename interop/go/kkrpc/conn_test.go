@@ -0,0 +1,87 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestConnTransportOverPipe(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientConn, serverConn := net.Pipe()
+	server := NewServer(NewConnTransport(serverConn), map[string]any{
+		"math": map[string]any{"add": MustFunc(func(a, b int) int { return a + b })},
+		"each": MustFunc(func(items []string, visit func(string)) int {
+			for _, item := range items {
+				visit(item)
+			}
+			return len(items)
+		}),
+	})
+	defer server.Close()
+	client := NewClient(NewConnTransport(clientConn), WithTimeout(2*time.Second))
+	defer client.Close()
+
+	sum, err := client.Call("math.add", 2, 3)
+	if err != nil || !valuesEqual(sum, 5) {
+		t.Fatalf("math.add = %v, %v", sum, err)
+	}
+	visited := make(chan string, 2)
+	if _, err := client.Call("each", []string{"a", "b"}, func(args ...any) { visited <- args[0].(string) }); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-visited:
+			if got != want {
+				t.Fatalf("visited %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback not invoked")
+		}
+	}
+}
+
+func TestConnTransportReportsClosedConn(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := NewConnTransport(conn)
+	remote := NewConnTransport(<-accepted)
+
+	if err := local.Write(`{"t":"q"}`); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := remote.Read(); err != nil || line != `{"t":"q"}` {
+		t.Fatalf("read %q, %v", line, err)
+	}
+	_ = local.Close()
+	if _, err := remote.Read(); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("read after peer close: %v", err)
+	}
+	if err := local.Write("{}\n"); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+	_ = remote.Close()
+}
@@ -0,0 +1,67 @@
+package kkrpc
+
+import "context"
+
+type Metadata map[string]any
+
+type metadataKey struct{}
+
+func ContextWithMetadata(ctx context.Context, meta Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, meta)
+}
+
+func MetadataFromContext(ctx context.Context) Metadata {
+	meta, _ := ctx.Value(metadataKey{}).(Metadata)
+	return meta
+}
+
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	meta := MetadataFromContext(ctx).clone()
+	meta["requestId"] = requestID
+	return ContextWithMetadata(ctx, meta)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := MetadataFromContext(ctx)["requestId"].(string)
+	return requestID
+}
+
+func (m Metadata) clone() Metadata {
+	cloned := make(Metadata, len(m)+1)
+	for key, value := range m {
+		cloned[key] = value
+	}
+	return cloned
+}
+
+func metadataFromMessage(message map[string]any) Metadata {
+	raw, _ := message["meta"].(map[string]any)
+	meta := Metadata(raw).clone()
+	if _, ok := meta["requestId"].(string); !ok {
+		if requestID, ok := message["id"].(string); ok {
+			meta["requestId"] = requestID
+		}
+	}
+	return meta
+}
+
+type inflightKey struct{}
+
+type inflightRequest struct {
+	server *Server
+	id     string
+	slot   *dispatchSlot
+}
+
+func inflightFromContext(ctx context.Context) *inflightRequest {
+	request, _ := ctx.Value(inflightKey{}).(*inflightRequest)
+	return request
+}
+
+func (s *Server) requestContext(message map[string]any, slot *dispatchSlot) context.Context {
+	requestID, _ := message["id"].(string)
+	meta := metadataFromMessage(message)
+	meta[callChainKey] = append(callChainFromMetadata(meta), requestID)
+	ctx := s.withIdentity(ContextWithMetadata(context.Background(), meta))
+	return context.WithValue(ctx, inflightKey{}, &inflightRequest{server: s, id: requestID, slot: slot})
+}
@@ -0,0 +1,63 @@
+package kkrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestNestedCallsPropagateRequestID(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	upstream := newServerTestTransport()
+	downstream := newServerTestTransport()
+	defer upstream.Close()
+	defer downstream.Close()
+
+	backend := NewClient(downstream)
+	api := map[string]any{
+		"lookup": func(ctx context.Context, args ...any) any {
+			result, err := backend.CallContext(ctx, "db.find", args...)
+			if err != nil {
+				return err
+			}
+			return result
+		},
+	}
+	_ = NewServer(upstream, api)
+
+	request, _ := EncodeMessage(map[string]any{
+		"t":    "q",
+		"id":   "outer-request",
+		"op":   "call",
+		"p":    []any{"lookup"},
+		"a":    []any{"key"},
+		"meta": map[string]any{"traceparent": "00-abc-def-01"},
+	})
+	upstream.in <- request
+
+	select {
+	case raw := <-downstream.out:
+		message, _ := DecodeMessage(raw)
+		meta, _ := message["meta"].(map[string]any)
+		if meta["requestId"] != "outer-request" || meta["traceparent"] != "00-abc-def-01" {
+			t.Fatalf("metadata not propagated: %s", raw)
+		}
+		response, _ := EncodeMessage(map[string]any{"t": "r", "id": message["id"], "v": "found"})
+		downstream.in <- response
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nested call not sent")
+	}
+
+	select {
+	case raw := <-upstream.out:
+		message, _ := DecodeMessage(raw)
+		if message["v"] != "found" {
+			t.Fatalf("unexpected response: %s", raw)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("response not received")
+	}
+}
@@ -0,0 +1,305 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Shape describes the JSON structure of a value without its contents.
+type Shape struct {
+	Kind   string            `json:"kind"`
+	Elem   *Shape            `json:"elem,omitempty"`
+	Fields map[string]*Shape `json:"fields,omitempty"`
+}
+
+const (
+	ShapeAny      = "any"
+	ShapeNull     = "null"
+	ShapeBool     = "bool"
+	ShapeNumber   = "number"
+	ShapeString   = "string"
+	ShapeArray    = "array"
+	ShapeObject   = "object"
+	ShapeCallback = "callback"
+)
+
+func ShapeOf(value any) *Shape {
+	switch typed := value.(type) {
+	case nil:
+		return &Shape{Kind: ShapeNull}
+	case bool:
+		return &Shape{Kind: ShapeBool}
+	case string:
+		return &Shape{Kind: ShapeString}
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, json.Number:
+		return &Shape{Kind: ShapeNumber}
+	case Callback, *Handle:
+		return &Shape{Kind: ShapeCallback}
+	case []any:
+		shape := &Shape{Kind: ShapeArray}
+		for _, item := range typed {
+			shape.Elem = mergeShapes(shape.Elem, ShapeOf(item))
+		}
+		return shape
+	case map[string]any:
+		shape := &Shape{Kind: ShapeObject, Fields: make(map[string]*Shape, len(typed))}
+		for key, item := range typed {
+			shape.Fields[key] = ShapeOf(item)
+		}
+		return shape
+	}
+	if isFuncValue(value) {
+		return &Shape{Kind: ShapeCallback}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return &Shape{Kind: ShapeAny}
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return &Shape{Kind: ShapeAny}
+	}
+	return ShapeOf(generic)
+}
+
+func mergeShapes(a, b *Shape) *Shape {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.Kind != b.Kind:
+		return &Shape{Kind: ShapeAny}
+	case a.Kind == ShapeArray:
+		return &Shape{Kind: ShapeArray, Elem: mergeShapes(a.Elem, b.Elem)}
+	case a.Kind == ShapeObject:
+		merged := &Shape{Kind: ShapeObject, Fields: make(map[string]*Shape)}
+		for key, field := range a.Fields {
+			if other, ok := b.Fields[key]; ok {
+				merged.Fields[key] = mergeShapes(field, other)
+			}
+		}
+		return merged
+	}
+	return a
+}
+
+// SatisfiedBy reports whether actual still provides everything the recorded
+// shape promised. Extra object fields are allowed; missing or retyped ones are not.
+func (s *Shape) SatisfiedBy(actual *Shape) error {
+	if s == nil || s.Kind == ShapeAny {
+		return nil
+	}
+	if actual == nil || actual.Kind != s.Kind {
+		got := "nothing"
+		if actual != nil {
+			got = actual.Kind
+		}
+		return fmt.Errorf("expected %s, got %s", s.Kind, got)
+	}
+	switch s.Kind {
+	case ShapeArray:
+		if s.Elem == nil || actual.Elem == nil {
+			return nil
+		}
+		if err := s.Elem.SatisfiedBy(actual.Elem); err != nil {
+			return fmt.Errorf("[]: %w", err)
+		}
+	case ShapeObject:
+		keys := make([]string, 0, len(s.Fields))
+		for key := range s.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := s.Fields[key].SatisfiedBy(actual.Fields[key]); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+type ContractEntry struct {
+	Method string   `json:"method"`
+	Args   []*Shape `json:"args"`
+	Result *Shape   `json:"result,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Sample []any    `json:"sample,omitempty"`
+}
+
+type Contract struct {
+	Version int             `json:"version"`
+	Entries []ContractEntry `json:"entries"`
+}
+
+const contractVersion = 1
+
+// ContractRecorder collects the calls a client makes so they can be replayed
+// against a newer server with CheckContract.
+type ContractRecorder struct {
+	mu      sync.Mutex
+	entries map[string]ContractEntry
+	rules   []RedactionRule
+}
+
+func NewContractRecorder() *ContractRecorder {
+	return &ContractRecorder{entries: make(map[string]ContractEntry)}
+}
+
+func WithContractRecorder(recorder *ContractRecorder) Option {
+	return func(o *options) {
+		o.contracts = recorder
+	}
+}
+
+// Redact adds rules removing secrets from the samples captured from now on.
+func (r *ContractRecorder) Redact(rules ...RedactionRule) {
+	r.mu.Lock()
+	r.rules = append(r.rules, rules...)
+	r.mu.Unlock()
+}
+
+func (r *ContractRecorder) record(method string, args []any, result any, err error) {
+	if r == nil {
+		return
+	}
+	entry := ContractEntry{Method: method, Args: make([]*Shape, 0, len(args))}
+	replayable := true
+	for _, arg := range args {
+		shape := ShapeOf(arg)
+		if shape.Kind == ShapeCallback {
+			replayable = false
+		}
+		entry.Args = append(entry.Args, shape)
+	}
+	if err != nil {
+		var rpcErr *RpcError
+		if !errors.As(err, &rpcErr) {
+			return
+		}
+		entry.Error = rpcErr.Name
+	} else {
+		entry.Result = ShapeOf(result)
+	}
+	if replayable {
+		entry.Sample = jsonSample(args)
+	}
+	key, _ := json.Marshal([]any{entry.Method, entry.Args, entry.Result, entry.Error})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.entries[string(key)]; !exists {
+		redactSample(r.rules, method, entry.Sample)
+		r.entries[string(key)] = entry
+	}
+}
+
+func jsonSample(args []any) []any {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	var sample []any
+	if err := json.Unmarshal(data, &sample); err != nil {
+		return nil
+	}
+	return sample
+}
+
+func (r *ContractRecorder) Contract() Contract {
+	r.mu.Lock()
+	keys := make([]string, 0, len(r.entries))
+	for key := range r.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	contract := Contract{Version: contractVersion, Entries: make([]ContractEntry, 0, len(keys))}
+	for _, key := range keys {
+		contract.Entries = append(contract.Entries, r.entries[key])
+	}
+	r.mu.Unlock()
+	return contract
+}
+
+func (r *ContractRecorder) WriteFile(path string) error {
+	return r.WriteEncryptedFile(path, nil)
+}
+
+// WriteEncryptedFile writes the contract sealed with key, from
+// NewCaptureKey, or in the clear if key is nil.
+func (r *ContractRecorder) WriteEncryptedFile(path string, key []byte) error {
+	data, err := json.MarshalIndent(r.Contract(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeCapture(path, data, key)
+}
+
+func LoadContract(path string) (Contract, error) {
+	return LoadContractWithKey(path, nil)
+}
+
+// LoadContractWithKey loads a contract written by WriteEncryptedFile, or one
+// in the clear.
+func LoadContractWithKey(path string, key []byte) (Contract, error) {
+	var contract Contract
+	data, err := readCapture(path, key)
+	if err != nil {
+		return contract, err
+	}
+	if err := json.Unmarshal(data, &contract); err != nil {
+		return contract, fmt.Errorf("kkrpc: parse contract %s: %w", path, err)
+	}
+	if contract.Version != contractVersion {
+		return contract, fmt.Errorf("kkrpc: unsupported contract version %d", contract.Version)
+	}
+	return contract, nil
+}
+
+type ContractViolation struct {
+	Entry  ContractEntry
+	Reason string
+}
+
+func (v ContractViolation) Error() string {
+	return v.Entry.Method + ": " + v.Reason
+}
+
+// CheckContract replays every recorded sample against caller and returns the
+// entries whose outcome no longer matches. Entries recorded with callback
+// arguments carry no sample and are skipped.
+func CheckContract(ctx context.Context, caller Caller, contract Contract) []ContractViolation {
+	var violations []ContractViolation
+	for _, entry := range contract.Entries {
+		if entry.Sample == nil && len(entry.Args) > 0 {
+			continue
+		}
+		result, err := caller.CallContext(ctx, entry.Method, entry.Sample...)
+		if reason := entry.mismatch(result, err); reason != "" {
+			violations = append(violations, ContractViolation{Entry: entry, Reason: reason})
+		}
+	}
+	return violations
+}
+
+func (e ContractEntry) mismatch(result any, err error) string {
+	if e.Error != "" {
+		var rpcErr *RpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Name != e.Error {
+			return fmt.Sprintf("expected %s error, got %v", e.Error, err)
+		}
+		return ""
+	}
+	if err != nil {
+		return "call failed: " + err.Error()
+	}
+	if shapeErr := e.Result.SatisfiedBy(ShapeOf(result)); shapeErr != nil {
+		return "result: " + shapeErr.Error()
+	}
+	return ""
+}
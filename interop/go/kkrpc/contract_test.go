@@ -0,0 +1,78 @@
+package kkrpc
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestContractRecordAndCheck(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	recorder := NewContractRecorder()
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport, WithContractRecorder(recorder))
+	server := NewServer(serverTransport, map[string]any{
+		"user": map[string]any{
+			"get": func(args ...any) any {
+				return map[string]any{"id": args[0], "name": "Ada", "tags": []any{"admin"}}
+			},
+		},
+		"fail": func(args ...any) any { return &RpcError{Name: "NotFound", Message: "missing"} },
+	})
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := client.Call("user.get", 7); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = client.Call("fail")
+	path := filepath.Join(t.TempDir(), "contract.json")
+	if err := recorder.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	contract, err := LoadContract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contract.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", contract.Entries)
+	}
+	if violations := CheckContract(context.Background(), client, contract); len(violations) != 0 {
+		t.Fatalf("unchanged server violates contract: %v", violations)
+	}
+
+	refactoredTransport, refactoredServerTransport := newConnectedTestTransports()
+	refactored := NewClient(refactoredTransport)
+	newServer := NewServer(refactoredServerTransport, map[string]any{
+		"user": map[string]any{
+			"get": func(args ...any) any {
+				return map[string]any{"id": args[0], "name": 1, "extra": true, "tags": []any{}}
+			},
+		},
+		"fail": func(args ...any) any { return &RpcError{Name: "NotFound", Message: "missing"} },
+	})
+	defer refactored.Close()
+	defer newServer.Close()
+
+	violations := CheckContract(context.Background(), refactored, contract)
+	if len(violations) != 1 || !strings.Contains(violations[0].Error(), "name: expected string, got number") {
+		t.Fatalf("unexpected violations: %v", violations)
+	}
+}
+
+func TestShapeSatisfiedByAllowsExtraFields(t *testing.T) {
+	recorded := ShapeOf(map[string]any{"a": 1.0, "list": []any{"x"}})
+	if err := recorded.SatisfiedBy(ShapeOf(map[string]any{"a": 2.0, "b": "new", "list": []any{}})); err != nil {
+		t.Fatalf("extra fields must be accepted: %v", err)
+	}
+	if err := recorded.SatisfiedBy(ShapeOf(map[string]any{"list": []any{"x"}})); err == nil {
+		t.Fatal("missing field must be rejected")
+	}
+	if err := ShapeOf([]any{1.0, "x"}).SatisfiedBy(ShapeOf([]any{true})); err != nil {
+		t.Fatalf("mixed arrays record as any: %v", err)
+	}
+}
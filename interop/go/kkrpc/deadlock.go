@@ -0,0 +1,54 @@
+package kkrpc
+
+import "errors"
+
+const callChainKey = "callChain"
+
+var ErrDeadlock = &RpcError{Name: "DeadlockError", Message: "call would deadlock: every dispatch slot is waiting on this call chain"}
+
+func callChainFromMetadata(meta Metadata) []any {
+	switch chain := meta[callChainKey].(type) {
+	case []any:
+		return append([]any(nil), chain...)
+	case []string:
+		converted := make([]any, 0, len(chain))
+		for _, id := range chain {
+			converted = append(converted, id)
+		}
+		return converted
+	default:
+		return nil
+	}
+}
+
+func (s *Server) wouldDeadlock(message map[string]any) bool {
+	limit := s.dispatcher.budgetLimit()
+	if limit == 0 || s.blocked.Load() < int64(limit) {
+		return false
+	}
+	meta, _ := message["meta"].(map[string]any)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range callChainFromMetadata(meta) {
+		if text, ok := id.(string); ok {
+			if _, waiting := s.active[text]; waiting {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func IsDeadlock(err error) bool {
+	var rpcErr *RpcError
+	return errors.As(err, &rpcErr) && rpcErr.Name == ErrDeadlock.Name
+}
+
+func (r *inflightRequest) awaitResponse() func() {
+	if r.server.options.reentrant && r.slot != nil {
+		r.slot.release()
+		return r.slot.acquire
+	}
+	r.server.blocked.Add(1)
+	return func() { r.server.blocked.Add(-1) }
+}
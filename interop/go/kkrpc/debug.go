@@ -0,0 +1,81 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// DebugTarget is what ServeDebug exposes about a running peer. Every field is
+// optional; for a Channel set Client to channel.Client and Server to
+// channel.Server().
+type DebugTarget struct {
+	Metrics *Metrics
+	Client  *Client
+	Server  *Server
+}
+
+// DebugSnapshot is the result of debug.snapshot. Pending counts the calls the
+// target has sent that are still awaiting a response.
+type DebugSnapshot struct {
+	Time    time.Time
+	Methods []MethodMetrics
+	Pending int
+}
+
+// DebugAPI returns the read-only methods ServeDebug mounts: debug.snapshot
+// and debug.schema. Mount it elsewhere to expose them on another transport.
+func DebugAPI(target DebugTarget) map[string]any {
+	return map[string]any{
+		"debug": map[string]any{
+			"snapshot": InvokerFunc(func(context.Context, []any) (any, error) {
+				snapshot := DebugSnapshot{Time: time.Now()}
+				if target.Metrics != nil {
+					snapshot.Methods = target.Metrics.Snapshot()
+				}
+				if target.Client != nil {
+					snapshot.Pending = target.Client.PendingCalls()
+				}
+				return snapshot, nil
+			}),
+			"schema": InvokerFunc(func(context.Context, []any) (any, error) {
+				if target.Server == nil {
+					return Schema{Version: schemaVersion}, nil
+				}
+				return target.Server.Introspect(), nil
+			}),
+		},
+	}
+}
+
+// ServeDebug serves DebugAPI over newline-delimited kkrpc on every
+// connection accepted from listener, usually a unix socket, for tools such as
+// kkrpc-top. It returns once the listener is closed.
+func ServeDebug(listener net.Listener, target DebugTarget) error {
+	api := DebugAPI(target)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		NewServer(NewConnTransport(debugConn{conn}), api)
+	}
+}
+
+// debugConn closes the connection once reading from it fails, which is when
+// the server's read loop stops.
+type debugConn struct {
+	net.Conn
+}
+
+func (c debugConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		_ = c.Conn.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestServeDebugReportsMetricsAndPendingCalls(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	defer left.Close()
+	metrics := NewMetrics()
+	release := make(chan struct{})
+	server := NewServer(right, map[string]any{
+		"echo": MustFunc(func(s string) string { return s }),
+		"slow": MustFunc(func() { <-release }),
+	}, WithHook(metrics))
+	client := NewClient(left, WithTimeout(2*time.Second))
+	if _, err := client.Call("echo", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	slow := client.Go("slow")
+	defer func() {
+		close(release)
+		_, _ = slow.Wait(context.Background())
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go ServeDebug(listener, DebugTarget{Metrics: metrics, Client: client, Server: server})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	debug := NewClient(NewConnTransport(conn), WithTimeout(2*time.Second))
+
+	var snapshot DebugSnapshot
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if err := debug.CallTuple(context.Background(), "debug.snapshot", nil, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Pending == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if snapshot.Pending != 1 {
+		t.Fatalf("expected one pending call, got %d", snapshot.Pending)
+	}
+	byMethod := map[string]MethodMetrics{}
+	for _, m := range snapshot.Methods {
+		byMethod[m.Method] = m
+	}
+	if byMethod["echo"].Calls != 1 || byMethod["slow"].InFlight != 1 {
+		t.Fatalf("unexpected metrics: %+v", snapshot.Methods)
+	}
+	if byMethod["echo"].LatencyQuantile(0.5) <= 0 {
+		t.Fatalf("latency not recorded: %+v", byMethod["echo"].Latencies)
+	}
+
+	var schema Schema
+	if err := debug.CallTuple(context.Background(), "debug.schema", nil, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Methods) != 2 || schema.Methods[0].Name != "echo" {
+		t.Fatalf("unexpected schema: %+v", schema)
+	}
+}
@@ -0,0 +1,114 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrClosing fails calls made, or received by a server, once CloseGracefully
+// has begun.
+var ErrClosing = errors.New("kkrpc: closing")
+
+// ErrPeerClosed fails calls still pending when the peer said goodbye before
+// closing the connection. It matches ErrTransportClosed.
+var ErrPeerClosed = fmt.Errorf("%w: peer closed the connection", ErrTransportClosed)
+
+// CloseGracefully stops new calls, which fail with ErrClosing, and waits for
+// those in flight and for callbacks the peer runs here. It then says goodbye to
+// the peer and closes the transport. If ctx ends first, the transport is closed
+// all the same and ctx's error returned.
+func (c *Client) CloseGracefully(ctx context.Context) error {
+	err := c.drain(ctx)
+	return closeAfterGoodbye(c.transport, c.options, c.Close, err)
+}
+
+// CloseGracefully answers calls received from now on with ErrClosing and
+// waits for those running, as Drain does. It then says goodbye to the peer and
+// closes the transport. If ctx ends first, the transport is closed all the
+// same and ctx's error returned.
+func (s *Server) CloseGracefully(ctx context.Context) error {
+	s.stopAccepting()
+	err := s.Drain(ctx)
+	return closeAfterGoodbye(s.transport, s.options, s.Close, err)
+}
+
+// CloseGracefully drains both directions, as Client.CloseGracefully and
+// Server.CloseGracefully do, before saying goodbye and closing.
+func (c *Channel) CloseGracefully(ctx context.Context) error {
+	c.server.stopAccepting()
+	err := c.Client.drain(ctx)
+	if serverErr := c.server.Drain(ctx); err == nil {
+		err = serverErr
+	}
+	return closeAfterGoodbye(c.transport, c.options, c.Close, err)
+}
+
+func closeAfterGoodbye(transport Transport, o *options, close func() error, err error) error {
+	if writeErr := writePayload(transport, o, map[string]any{"t": "bye"}); writeErr != nil {
+		o.logger.Printf("kkrpc: say goodbye: %v", writeErr)
+	}
+	if closeErr := close(); err == nil && !errors.Is(closeErr, ErrTransportClosed) {
+		err = closeErr
+	}
+	return err
+}
+
+// begin counts a call about to be sent, unless the client is closing.
+func (c *Client) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	c.join()
+	return true
+}
+
+// join counts work finishing what is already in flight, such as a callback
+// the peer runs here, even while closing. The caller holds c.mu.
+func (c *Client) join() {
+	if c.running == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.running++
+}
+
+func (c *Client) end() {
+	c.mu.Lock()
+	c.running--
+	if c.running == 0 {
+		close(c.idle)
+	}
+	c.mu.Unlock()
+}
+
+// drain refuses new calls and waits for the running ones or for ctx.
+func (c *Client) drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.closing = true
+	if c.running == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	idle := c.idle
+	c.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) stopAccepting() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
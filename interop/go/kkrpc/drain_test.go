@@ -0,0 +1,93 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// gatedAPI serves "wait", which blocks until release is closed.
+func gatedAPI(started chan<- struct{}, release <-chan struct{}) map[string]any {
+	return map[string]any{
+		"wait": MustFunc(func() string {
+			started <- struct{}{}
+			<-release
+			return "done"
+		}),
+		"ping": MustFunc(func() string { return "pong" }),
+	}
+}
+
+func TestClientCloseGracefullyWaitsForCalls(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	clientTransport, serverTransport := NewPipeTransportPair()
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	server := NewChannel(serverTransport, gatedAPI(started, release))
+	defer server.Close()
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+
+	pending := callAsync(context.Background(), client, "wait")
+	<-started
+	closed := make(chan error, 1)
+	go func() { closed <- client.CloseGracefully(context.Background()) }()
+	for {
+		_, err := client.Call("ping")
+		if errors.Is(err, ErrClosing) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("closed with a call in flight: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if result := <-pending; result.err != nil || result.value != "done" {
+		t.Fatalf("in-flight call: %v %v", result.value, result.err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Call("ping"); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("call to a closed peer: %v", err)
+	}
+}
+
+func TestServerCloseGracefullyRefusesNewCalls(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	clientTransport, serverTransport := NewPipeTransportPair()
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	server := NewServer(serverTransport, gatedAPI(started, release))
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	pending := callAsync(context.Background(), client, "wait")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- server.CloseGracefully(ctx) }()
+	for !server.isClosing() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := client.Call("ping"); err == nil || !strings.Contains(err.Error(), ErrClosing.Error()) {
+		t.Fatalf("call while closing: %v", err)
+	}
+
+	// The stuck call outlives the deadline and learns the peer has gone.
+	if err := <-closed; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close: %v", err)
+	}
+	if result := <-pending; !errors.Is(result.err, ErrPeerClosed) {
+		t.Fatalf("pending call: %v", result.err)
+	}
+}
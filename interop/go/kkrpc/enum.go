@@ -0,0 +1,163 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type EnumValue interface {
+	~string | ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+type enumInfo struct {
+	name    string
+	values  []any
+	allowed map[any]struct{}
+}
+
+var enums = struct {
+	sync.RWMutex
+	types map[reflect.Type]*enumInfo
+}{types: make(map[reflect.Type]*enumInfo)}
+
+// RegisterEnum declares the valid values of a typed string or integer enum.
+// Func parameters and typed callbacks that receive the type, directly or inside
+// structs, slices and maps, reject any other value with an *EnumError. The zero
+// value means "unset" and is always accepted.
+func RegisterEnum[T EnumValue](values ...T) {
+	enumType := reflect.TypeOf((*T)(nil)).Elem()
+	info := &enumInfo{name: enumType.Name(), allowed: make(map[any]struct{}, len(values))}
+	for _, value := range values {
+		info.values = append(info.values, value)
+		info.allowed[any(value)] = struct{}{}
+	}
+	enums.Lock()
+	enums.types[enumType] = info
+	enums.Unlock()
+}
+
+type EnumError struct {
+	Type    string
+	Value   any
+	Allowed []any
+}
+
+func (e *EnumError) Error() string {
+	allowed := make([]string, 0, len(e.Allowed))
+	for _, value := range e.Allowed {
+		allowed = append(allowed, enumLiteral(value))
+	}
+	return fmt.Sprintf("invalid %s value %s (allowed: %s)", e.Type, enumLiteral(e.Value), strings.Join(allowed, ", "))
+}
+
+func (e *EnumError) rpcError(prefix string) *RpcError {
+	return &RpcError{
+		Name:    "EnumError",
+		Message: prefix + e.Error(),
+		Data:    map[string]any{"enum": e.Type, "value": e.Value, "allowed": e.Allowed},
+	}
+}
+
+func enumLiteral(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// validateArg checks the enums in a parameter decoded without reflection.
+func validateArg(value any) error {
+	return validateEnums(reflect.ValueOf(value))
+}
+
+func validateEnums(value reflect.Value) error {
+	enums.RLock()
+	empty := len(enums.types) == 0
+	enums.RUnlock()
+	if empty {
+		return nil
+	}
+	return walkEnums(value, 0)
+}
+
+func walkEnums(value reflect.Value, depth int) error {
+	if !value.IsValid() || depth > DefaultDecodeLimits.MaxDepth {
+		return nil
+	}
+	enums.RLock()
+	info := enums.types[value.Type()]
+	enums.RUnlock()
+	if info != nil {
+		if _, ok := info.allowed[value.Interface()]; !ok && !value.IsZero() {
+			return &EnumError{Type: info.name, Value: value.Interface(), Allowed: info.values}
+		}
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return walkEnums(value.Elem(), depth+1)
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !value.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := walkEnums(value.Field(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := walkEnums(value.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			if err := walkEnums(iter.Key(), depth+1); err != nil {
+				return err
+			}
+			if err := walkEnums(iter.Value(), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GenerateTypeScriptEnums writes a TypeScript literal union for every
+// registered enum, e.g. `export type Color = "red" | "green"`.
+func GenerateTypeScriptEnums(w io.Writer) error {
+	enums.RLock()
+	infos := make([]*enumInfo, 0, len(enums.types))
+	for _, info := range enums.types {
+		infos = append(infos, info)
+	}
+	enums.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+
+	for _, info := range infos {
+		literals := make([]string, 0, len(info.values))
+		for _, value := range info.values {
+			literals = append(literals, enumLiteral(value))
+		}
+		if len(literals) == 0 {
+			literals = append(literals, "never")
+		}
+		if _, err := fmt.Fprintf(w, "export type %s = %s\n", info.name, strings.Join(literals, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testColor string
+
+type testPriority int
+
+const (
+	colorRed   testColor = "red"
+	colorGreen testColor = "green"
+
+	priorityLow  testPriority = 1
+	priorityHigh testPriority = 2
+)
+
+type paintJob struct {
+	Colors   []testColor  `json:"colors"`
+	Priority testPriority `json:"priority"`
+}
+
+func init() {
+	RegisterEnum(colorRed, colorGreen)
+	RegisterEnum(priorityLow, priorityHigh)
+}
+
+func TestEnumValidationOnDecode(t *testing.T) {
+	paint, err := NewFunc(func(color testColor) string { return string(color) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := paint.Invoke(context.Background(), []any{"red"}); err != nil || result != "red" {
+		t.Fatalf("valid value rejected: %v %v", result, err)
+	}
+	_, err = paint.Invoke(context.Background(), []any{"purple"})
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Name != "EnumError" {
+		t.Fatalf("expected EnumError, got %v", err)
+	}
+	if !strings.Contains(rpcErr.Message, `invalid testColor value "purple" (allowed: "red", "green")`) {
+		t.Fatalf("unexpected message: %s", rpcErr.Message)
+	}
+	encoded := encodeError(err)
+	if encoded["enum"] != "testColor" || encoded["value"] != testColor("purple") {
+		t.Fatalf("structured fields not encoded: %v", encoded)
+	}
+
+	schedule := MustFunc(func(job paintJob) int { return len(job.Colors) })
+	if _, err := schedule.Invoke(context.Background(), []any{map[string]any{"colors": []any{"red"}, "priority": 2.0}}); err != nil {
+		t.Fatalf("valid nested enums rejected: %v", err)
+	}
+	_, err = schedule.Invoke(context.Background(), []any{map[string]any{"colors": []any{"red", "blue"}, "priority": 2.0}})
+	if !errors.As(err, &rpcErr) || rpcErr.Name != "EnumError" {
+		t.Fatalf("expected nested EnumError, got %v", err)
+	}
+	_, err = schedule.Invoke(context.Background(), []any{map[string]any{"colors": []any{}, "priority": 7.0}})
+	if !errors.As(err, &rpcErr) || !strings.Contains(rpcErr.Message, "testPriority value 7") {
+		t.Fatalf("expected priority EnumError, got %v", err)
+	}
+}
+
+func TestGenerateTypeScriptEnums(t *testing.T) {
+	var out strings.Builder
+	if err := GenerateTypeScriptEnums(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`export type testColor = "red" | "green"`,
+		`export type testPriority = 1 | 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package kkrpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const envelopeFieldPrefix = "x-"
+
+// EnvelopeField carries custom data alongside requests in a top-level "x-"
+// prefixed field. Encode runs on the calling side with the call's context and
+// reports whether the field should be attached; Decode runs on the serving side
+// and returns the context the handler observes.
+type EnvelopeField struct {
+	Name   string
+	Encode func(ctx context.Context) (any, bool)
+	Decode func(ctx context.Context, value any) (context.Context, error)
+}
+
+func WithEnvelopeField(field EnvelopeField) Option {
+	return func(o *options) {
+		if !strings.HasPrefix(field.Name, envelopeFieldPrefix) {
+			panic("kkrpc: envelope field " + field.Name + " must be prefixed with " + envelopeFieldPrefix)
+		}
+		o.envelope = append(o.envelope, field)
+	}
+}
+
+func (o *options) encodeEnvelope(ctx context.Context, payload map[string]any) {
+	for _, field := range o.envelope {
+		if field.Encode == nil {
+			continue
+		}
+		if value, ok := field.Encode(ctx); ok {
+			payload[field.Name] = value
+		}
+	}
+}
+
+func (o *options) decodeEnvelope(ctx context.Context, message map[string]any) (context.Context, error) {
+	for _, field := range o.envelope {
+		value, ok := message[field.Name]
+		if !ok || field.Decode == nil {
+			continue
+		}
+		decoded, err := field.Decode(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("envelope field %s: %w", field.Name, err)
+		}
+		ctx = decoded
+	}
+	return ctx, nil
+}
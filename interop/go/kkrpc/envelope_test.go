@@ -0,0 +1,77 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type tenantKey struct{}
+
+func tenantField() EnvelopeField {
+	return EnvelopeField{
+		Name: "x-tenant",
+		Encode: func(ctx context.Context) (any, bool) {
+			tenant, ok := ctx.Value(tenantKey{}).(string)
+			return tenant, ok
+		},
+		Decode: func(ctx context.Context, value any) (context.Context, error) {
+			tenant, ok := value.(string)
+			if !ok || tenant == "" {
+				return nil, errors.New("tenant must be a non-empty string")
+			}
+			return context.WithValue(ctx, tenantKey{}, tenant), nil
+		},
+	}
+}
+
+func TestEnvelopeFieldsTravelWithRequests(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport, WithEnvelopeField(tenantField()))
+	server := NewServer(serverTransport, map[string]any{
+		"whoami": func(ctx context.Context, args ...any) any {
+			tenant, _ := ctx.Value(tenantKey{}).(string)
+			return tenant
+		},
+	}, WithEnvelopeField(tenantField()))
+	defer client.Close()
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	result, err := client.CallContext(ctx, "whoami")
+	if err != nil || result != "acme" {
+		t.Fatalf("expected acme, got %v %v", result, err)
+	}
+	if result, err := client.Call("whoami"); err != nil || result != "" {
+		t.Fatalf("field must be omitted when Encode declines: %v %v", result, err)
+	}
+}
+
+func TestEnvelopeFieldDecodeErrorFailsRequest(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	server := NewServer(transport, map[string]any{
+		"whoami": func(args ...any) any { return "unreachable" },
+	}, WithEnvelopeField(tenantField()))
+	defer server.Close()
+
+	transport.in <- `{"t":"q","id":"1","op":"call","p":["whoami"],"x-tenant":42}`
+	response, _ := DecodeMessage(<-transport.out)
+	if _, failed := response["e"]; !failed {
+		t.Fatalf("expected error response, got %v", response)
+	}
+}
+
+func TestEnvelopeFieldRequiresPrefix(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for unprefixed field")
+		}
+	}()
+	newOptions([]Option{WithEnvelopeField(EnvelopeField{Name: "tenant"})})
+}
@@ -0,0 +1,119 @@
+package kkrpc
+
+import "fmt"
+
+type UnknownMessagePolicy int
+
+const (
+	IgnoreUnknownMessages UnknownMessagePolicy = iota
+	LogUnknownMessages
+	RejectUnknownMessages
+)
+
+// MessageHandler receives messages of an extension type registered with
+// WithMessageHandler. It runs on the read loop and must not block.
+type MessageHandler func(message map[string]any)
+
+var coreMessageTypes = map[string]struct{}{
+	"q":              {},
+	"r":              {},
+	"cb":             {},
+	"cbe":            {},
+	"cbr":            {},
+	"hs":             {},
+	"enc":            {},
+	"credit":         {},
+	"resume":         {},
+	"ping":           {},
+	"pong":           {},
+	"bye":            {},
+	"sq":             {},
+	"sr":             {},
+	"protocol_error": {},
+}
+
+func IsCoreMessageType(messageType string) bool {
+	_, ok := coreMessageTypes[messageType]
+	return ok
+}
+
+func WithUnknownMessagePolicy(policy UnknownMessagePolicy) Option {
+	return func(o *options) {
+		o.unknownPolicy = policy
+	}
+}
+
+func WithMessageHandler(messageType string, handler MessageHandler) Option {
+	return func(o *options) {
+		if IsCoreMessageType(messageType) {
+			panic("kkrpc: cannot override core message type " + messageType)
+		}
+		if o.extensions == nil {
+			o.extensions = make(map[string]MessageHandler)
+		}
+		o.extensions[messageType] = handler
+	}
+}
+
+// OutboundHook runs on a message just before it is encoded and sent. It may
+// add or change top-level fields, e.g. "x-" fields carrying tracing baggage;
+// an error stops the message and fails whatever sent it. Hooks can run
+// concurrently and again when a message is resent.
+type OutboundHook func(message map[string]any) error
+
+type outboundHook struct {
+	messageType string
+	hook        OutboundHook
+}
+
+// WithOutboundHook runs hook on every sent message of messageType, or on every
+// message when messageType is empty. Hooks run in the order they are
+// registered, before the request is signed.
+func WithOutboundHook(messageType string, hook OutboundHook) Option {
+	return func(o *options) {
+		o.outbound = append(o.outbound, outboundHook{messageType, hook})
+	}
+}
+
+func (o *options) runOutboundHooks(payload map[string]any) error {
+	messageType, _ := payload["t"].(string)
+	for _, registered := range o.outbound {
+		if registered.messageType != "" && registered.messageType != messageType {
+			continue
+		}
+		if err := registered.hook(payload); err != nil {
+			return fmt.Errorf("kkrpc: outbound %q message: %w", messageType, err)
+		}
+	}
+	return nil
+}
+
+// handleExtension reports whether the message was consumed because its type is
+// not part of the core protocol.
+func (o *options) handleExtension(transport Transport, message map[string]any) bool {
+	messageType, _ := message["t"].(string)
+	if IsCoreMessageType(messageType) {
+		return false
+	}
+	if handler, ok := o.extensions[messageType]; ok {
+		handler(message)
+		return true
+	}
+	switch o.unknownPolicy {
+	case LogUnknownMessages:
+		o.logger.Printf("kkrpc: unknown message type %q", messageType)
+	case RejectUnknownMessages:
+		payload := map[string]any{
+			"t":    "protocol_error",
+			"code": "unknown_type",
+			"m":    "unsupported message type " + messageType,
+		}
+		if requestID, ok := message["id"].(string); ok {
+			payload["id"] = requestID
+		}
+		if err := writePayload(transport, o, payload); err != nil {
+			o.logger.Printf("kkrpc: send protocol error: %v", err)
+		}
+	}
+	return true
+}
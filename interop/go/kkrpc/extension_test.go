@@ -0,0 +1,105 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestMessageHandlerReceivesExtensionTypes(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	received := make(chan map[string]any, 1)
+	server := NewServer(transport, map[string]any{
+		"ping": func(args ...any) any { return "pong" },
+	}, WithMessageHandler("x-stats", func(message map[string]any) { received <- message }))
+	defer server.Close()
+
+	transport.in <- `{"t":"x-stats","v":3}`
+	select {
+	case message := <-received:
+		if message["v"] != float64(3) {
+			t.Fatalf("unexpected message: %v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("extension handler not invoked")
+	}
+
+	transport.in <- `{"t":"q","id":"1","op":"call","p":["ping"]}`
+	response, _ := DecodeMessage(<-transport.out)
+	if response["v"] != "pong" {
+		t.Fatalf("core messages must still be served: %v", response)
+	}
+}
+
+func TestUnknownMessagePolicy(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	server := NewServer(transport, map[string]any{}, WithUnknownMessagePolicy(RejectUnknownMessages))
+	defer server.Close()
+
+	transport.in <- `{"t":"stream","id":"s-1"}`
+	reply, _ := DecodeMessage(<-transport.out)
+	if reply["t"] != "protocol_error" || reply["code"] != "unknown_type" || reply["id"] != "s-1" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+
+	ignoring := newServerTestTransport()
+	quiet := NewServer(ignoring, map[string]any{
+		"ping": func(args ...any) any { return "pong" },
+	})
+	defer quiet.Close()
+	ignoring.in <- `{"t":"cancel","id":"c-1"}`
+	ignoring.in <- `{"t":"q","id":"2","op":"call","p":["ping"]}`
+	response, _ := DecodeMessage(<-ignoring.out)
+	if response["id"] != "2" {
+		t.Fatalf("unknown message must be dropped silently, got %v", response)
+	}
+}
+
+func TestOutboundHooksAmendRequestsBeforeSigning(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	key := []byte("broker-secret")
+	clientTransport, serverTransport := NewPipeTransportPair()
+	defer serverTransport.Close()
+	baggage := make(chan any, 1)
+	NewServer(serverTransport, map[string]any{"ping": func(args ...any) any { return "pong" }},
+		WithRequestSigning(key, 0),
+		WithEnvelopeField(EnvelopeField{Name: "x-baggage", Decode: func(ctx context.Context, value any) (context.Context, error) {
+			baggage <- value
+			return ctx, nil
+		}}))
+
+	errBlocked := errors.New("blocked")
+	client := NewClient(clientTransport, WithTimeout(time.Second), WithRequestSigning(key, 0),
+		WithOutboundHook("q", func(message map[string]any) error {
+			message["x-baggage"] = "tenant=acme"
+			return nil
+		}),
+		WithOutboundHook("", func(message map[string]any) error {
+			if path, _ := message["p"].([]string); len(path) > 0 && path[0] == "drop" {
+				return errBlocked
+			}
+			return nil
+		}),
+		WithOutboundHook("cb", func(map[string]any) error {
+			t.Error("hook ran for another message type")
+			return nil
+		}))
+	defer client.Close()
+
+	if result, err := client.Call("ping"); err != nil || result != "pong" {
+		t.Fatalf("hooked call: %v %v", result, err)
+	}
+	if value := <-baggage; value != "tenant=acme" {
+		t.Fatalf("baggage %v", value)
+	}
+	if _, err := client.Call("drop"); !errors.Is(err, errBlocked) {
+		t.Fatalf("vetoed call: %v", err)
+	}
+}
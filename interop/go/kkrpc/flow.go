@@ -0,0 +1,83 @@
+package kkrpc
+
+import (
+	"context"
+	"sync"
+)
+
+// WithFlowControl advertises to the peer that at most window requests may be
+// outstanding on this connection at once. Independently of this option, every
+// client holds its requests back while the peer's advertised window is full,
+// so a fast producer cannot flood a single-threaded peer such as a JS worker.
+// Until the peer's advertisement arrives, requests are not limited.
+func WithFlowControl(window int) Option {
+	return func(o *options) {
+		o.creditWindow = window
+	}
+}
+
+func creditPayload(window int) map[string]any {
+	return map[string]any{"t": "credit", "n": window}
+}
+
+// flowControl tracks the requests outstanding against the window the peer
+// advertised. A response returns the credit its request took.
+type flowControl struct {
+	mu       sync.Mutex
+	window   int
+	inflight int
+	changed  chan struct{}
+}
+
+func newFlowControl() *flowControl {
+	return &flowControl{changed: make(chan struct{})}
+}
+
+func (f *flowControl) acquire(ctx context.Context) error {
+	for {
+		f.mu.Lock()
+		if f.window <= 0 || f.inflight < f.window {
+			f.inflight++
+			f.mu.Unlock()
+			return nil
+		}
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (f *flowControl) release() {
+	f.mu.Lock()
+	f.inflight--
+	f.notify()
+	f.mu.Unlock()
+}
+
+func (f *flowControl) setWindow(message map[string]any) {
+	window, ok := toFloat64(message["n"])
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	f.window = int(window)
+	f.notify()
+	f.mu.Unlock()
+}
+
+func (f *flowControl) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// FlowWindow reports the window the peer advertised, 0 when unlimited, and
+// how many requests currently hold credit.
+func (c *Client) FlowWindow() (window int, inflight int) {
+	c.options.flow.mu.Lock()
+	defer c.options.flow.mu.Unlock()
+	return c.options.flow.window, c.options.flow.inflight
+}
@@ -0,0 +1,59 @@
+package kkrpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestFlowControlRespectsPeerWindow(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	var running, peak atomic.Int64
+	server := NewServer(serverTransport, map[string]any{
+		"work": func(args ...any) any {
+			now := running.Add(1)
+			for {
+				seen := peak.Load()
+				if now <= seen || peak.CompareAndSwap(seen, now) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			return true
+		},
+	}, WithFlowControl(2))
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for window, _ := client.FlowWindow(); window != 2; window, _ = client.FlowWindow() {
+		if time.Now().After(deadline) {
+			t.Fatal("window never advertised")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Call("work"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak.Load() != 2 {
+		t.Fatalf("peak concurrency %d, want 2", peak.Load())
+	}
+	if _, inflight := client.FlowWindow(); inflight != 0 {
+		t.Fatalf("credits leaked: %d in flight", inflight)
+	}
+}
@@ -0,0 +1,67 @@
+package kkrpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type Caller interface {
+	CallContext(ctx context.Context, method string, args ...any) (any, error)
+}
+
+type forwardRule struct {
+	pattern []string
+	target  Caller
+}
+
+func (s *Server) Forward(pattern string, target Caller) error {
+	if target == nil {
+		return fmt.Errorf("kkrpc: nil forward target for %q", pattern)
+	}
+	parts := splitMethod(pattern)
+	for _, part := range parts {
+		if part == "" || (strings.Contains(part, "*") && part != "*") {
+			return fmt.Errorf("kkrpc: invalid forward pattern %q", pattern)
+		}
+	}
+	s.mu.Lock()
+	s.forwards = append(s.forwards, forwardRule{pattern: parts, target: target})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Server) forwardFor(path []string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rule := range s.forwards {
+		if matchPattern(rule.pattern, path) {
+			target := rule.target
+			method := strings.Join(path, ".")
+			return func(ctx context.Context, args ...any) any {
+				result, err := target.CallContext(ctx, method, args...)
+				if err != nil {
+					return err
+				}
+				return result
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func matchPattern(pattern []string, path []string) bool {
+	for i, part := range pattern {
+		last := i == len(pattern)-1
+		if last && part == "*" {
+			return len(path) > i
+		}
+		if i >= len(path) {
+			return false
+		}
+		if part != "*" && part != path[i] {
+			return false
+		}
+	}
+	return len(path) == len(pattern)
+}
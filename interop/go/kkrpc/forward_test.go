@@ -0,0 +1,61 @@
+package kkrpc
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		method  string
+		match   bool
+	}{
+		{"db.*", "db.query", true},
+		{"db.*", "db.users.find", true},
+		{"db.*", "db", false},
+		{"db.*", "cache.get", false},
+		{"*.health", "db.health", true},
+		{"*.health", "db.users.health", false},
+		{"media.thumbnail", "media.thumbnail", true},
+		{"media.thumbnail", "media.thumbnails", false},
+		{"*", "anything.at.all", true},
+	}
+	for _, tc := range cases {
+		if got := matchPattern(splitMethod(tc.pattern), splitMethod(tc.method)); got != tc.match {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tc.pattern, tc.method, got, tc.match)
+		}
+	}
+}
+
+func TestServerForwardsMatchingCallsToBackend(t *testing.T) {
+	frontLeft, frontRight := newConnectedTestTransports()
+	backLeft, backRight := newConnectedTestTransports()
+	defer frontLeft.Close()
+	defer frontRight.Close()
+	defer backLeft.Close()
+	defer backRight.Close()
+
+	_ = NewServer(backRight, map[string]any{
+		"db": map[string]any{
+			"query": func(args ...any) any { return "backend:" + toString(args[0]) },
+		},
+	})
+	gateway := NewServer(frontRight, map[string]any{
+		"ping": func(args ...any) any { return "pong" },
+	})
+	backend := NewClient(backLeft)
+	if err := gateway.Forward("db.*", backend); err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+	if err := gateway.Forward("db.x*", backend); err == nil {
+		t.Fatalf("expected partial wildcard to be rejected")
+	}
+	client := NewClient(frontLeft)
+
+	result, err := client.Call("db.query", "select 1")
+	if err != nil || result != "backend:select 1" {
+		t.Fatalf("db.query: %#v, %v", result, err)
+	}
+	local, err := client.Call("ping")
+	if err != nil || local != "pong" {
+		t.Fatalf("ping: %#v, %v", local, err)
+	}
+}
@@ -0,0 +1,129 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+type Func struct {
+	fn          reflect.Value
+	params      []reflect.Type
+	variadic    reflect.Type
+	withContext bool
+	results     int
+	withError   bool
+}
+
+func NewFunc(fn any) (*Func, error) {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return nil, fmt.Errorf("kkrpc: expected a func, got %T", fn)
+	}
+	fnType := value.Type()
+	f := &Func{fn: value}
+	first := 0
+	if fnType.NumIn() > 0 && fnType.In(0) == contextType {
+		f.withContext = true
+		first = 1
+	}
+	last := fnType.NumIn()
+	if fnType.IsVariadic() {
+		last--
+		f.variadic = fnType.In(last).Elem()
+	}
+	for i := first; i < last; i++ {
+		f.params = append(f.params, fnType.In(i))
+	}
+	f.results = fnType.NumOut()
+	if f.results > 0 && fnType.Out(f.results-1) == errorType {
+		f.withError = true
+		f.results--
+	}
+	return f, nil
+}
+
+func MustFunc(fn any) *Func {
+	f, err := NewFunc(fn)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *Func) NumParams() int {
+	return len(f.params)
+}
+
+func (f *Func) Variadic() bool {
+	return f.variadic != nil
+}
+
+func (f *Func) Invoke(ctx context.Context, args []any) (any, error) {
+	if f.variadic == nil {
+		if err := checkArity(args, len(f.params)); err != nil {
+			return nil, err
+		}
+	}
+	in := make([]reflect.Value, 0, len(f.params)+1)
+	if f.withContext {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	for i, paramType := range f.params {
+		var arg any
+		if i < len(args) {
+			arg = args[i]
+		}
+		decoded, err := decodeValueAs(arg, paramType)
+		if err != nil {
+			return nil, argumentError(i, err)
+		}
+		in = append(in, decoded)
+	}
+	if f.variadic != nil {
+		for i := len(f.params); i < len(args); i++ {
+			decoded, err := decodeValueAs(args[i], f.variadic)
+			if err != nil {
+				return nil, argumentError(i, err)
+			}
+			in = append(in, decoded)
+		}
+	}
+	return f.valueFromResults(f.fn.Call(in))
+}
+
+func (f *Func) valueFromResults(out []reflect.Value) (any, error) {
+	if f.withError {
+		if errValue := out[len(out)-1]; !errValue.IsNil() {
+			return nil, errValue.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return out[0].Interface(), nil
+	default:
+		values := make([]any, 0, len(out))
+		for _, value := range out {
+			values = append(values, value.Interface())
+		}
+		return values, nil
+	}
+}
+
+func (s *Server) RegisterFunc(path string, fn any) error {
+	f, err := NewFunc(fn)
+	if err != nil {
+		return err
+	}
+	return s.register(path, f)
+}
@@ -0,0 +1,83 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestRegisterFuncDecodesTypedArguments(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	server := NewServer(right, nil)
+	if err := server.RegisterFunc("geo.shift", func(ctx context.Context, p point, dx int) (point, error) {
+		if dx < 0 {
+			return point{}, errors.New("negative shift")
+		}
+		return point{X: p.X + dx, Y: p.Y}, nil
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := server.RegisterFunc("geo.sum", func(values ...float64) float64 {
+		total := 0.0
+		for _, value := range values {
+			total += value
+		}
+		return total
+	}); err != nil {
+		t.Fatalf("register variadic: %v", err)
+	}
+	client := NewClient(left)
+
+	shifted, err := client.Call("geo.shift", map[string]any{"x": 1, "y": 2}, 3)
+	if err != nil {
+		t.Fatalf("geo.shift: %v", err)
+	}
+	if !compareMaps(map[string]any{"x": 4, "y": 2}, shifted) {
+		t.Fatalf("unexpected shift result: %#v", shifted)
+	}
+
+	if _, err := client.Call("geo.shift", map[string]any{"x": 1}, -1); err == nil || err.Error() != "Error: negative shift" {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+
+	var rpcErr *RpcError
+	if _, err := client.Call("geo.shift", "not a point", 1); !errors.As(err, &rpcErr) || rpcErr.Name != "TypeError" {
+		t.Fatalf("expected TypeError for bad argument, got %v", err)
+	}
+
+	if _, err := client.Call("geo.shift", map[string]any{"x": 1}, 1, 2); !errors.As(err, &rpcErr) || rpcErr.Name != "TypeError" {
+		t.Fatalf("expected TypeError for an extra argument, got %v", err)
+	}
+
+	sum, err := client.Call("geo.sum", 1, 2, 3.5)
+	if err != nil || sum != 6.5 {
+		t.Fatalf("geo.sum: %#v, %v", sum, err)
+	}
+}
+
+func TestRegisterFuncRejectsNonFunctions(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+	server := NewServer(transport, map[string]any{"value": 1})
+	if err := server.RegisterFunc("value.nested", func() {}); err == nil {
+		t.Fatalf("expected registration under a non-namespace to fail")
+	}
+	if err := server.RegisterFunc("notAFunc", 42); err == nil {
+		t.Fatalf("expected non-func registration to fail")
+	}
+}
@@ -0,0 +1,169 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Func0 to Func3 are Invokers for functions with a fixed number of typed
+// parameters. They decode arguments with type assertions and fall back to a
+// JSON round trip only for values of another shape, such as a struct arriving
+// as a map, so they need no reflection and work in a kkrpc_minimal build:
+//
+//	"add": kkrpc.Func2[int, int, int](func(ctx context.Context, a, b int) (int, error) {
+//		return a + b, nil
+//	}),
+//
+// Missing trailing arguments decode to the zero value; extra ones fail the call
+// with a TypeError.
+type Func0[R any] func(ctx context.Context) (R, error)
+
+type Func1[A, R any] func(ctx context.Context, a A) (R, error)
+
+type Func2[A, B, R any] func(ctx context.Context, a A, b B) (R, error)
+
+type Func3[A, B, C, R any] func(ctx context.Context, a A, b B, c C) (R, error)
+
+func (f Func0[R]) Invoke(ctx context.Context, args []any) (any, error) {
+	if err := checkArity(args, 0); err != nil {
+		return nil, err
+	}
+	return f(ctx)
+}
+
+func (f Func1[A, R]) Invoke(ctx context.Context, args []any) (any, error) {
+	if err := checkArity(args, 1); err != nil {
+		return nil, err
+	}
+	a, err := decodeTypedArg[A](args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f(ctx, a)
+}
+
+func (f Func2[A, B, R]) Invoke(ctx context.Context, args []any) (any, error) {
+	if err := checkArity(args, 2); err != nil {
+		return nil, err
+	}
+	a, err := decodeTypedArg[A](args, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decodeTypedArg[B](args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return f(ctx, a, b)
+}
+
+func (f Func3[A, B, C, R]) Invoke(ctx context.Context, args []any) (any, error) {
+	if err := checkArity(args, 3); err != nil {
+		return nil, err
+	}
+	a, err := decodeTypedArg[A](args, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decodeTypedArg[B](args, 1)
+	if err != nil {
+		return nil, err
+	}
+	c, err := decodeTypedArg[C](args, 2)
+	if err != nil {
+		return nil, err
+	}
+	return f(ctx, a, b, c)
+}
+
+func (Func0[R]) NumParams() int          { return 0 }
+func (Func1[A, R]) NumParams() int       { return 1 }
+func (Func2[A, B, R]) NumParams() int    { return 2 }
+func (Func3[A, B, C, R]) NumParams() int { return 3 }
+
+func (Func0[R]) Variadic() bool          { return false }
+func (Func1[A, R]) Variadic() bool       { return false }
+func (Func2[A, B, R]) Variadic() bool    { return false }
+func (Func3[A, B, C, R]) Variadic() bool { return false }
+
+// checkArity rejects calls passing more arguments than a non-variadic function
+// declares.
+func checkArity(args []any, params int) error {
+	if len(args) > params {
+		return &RpcError{Name: "TypeError", Message: fmt.Sprintf("expected at most %d arguments, got %d", params, len(args))}
+	}
+	return nil
+}
+
+func decodeTypedArg[T any](args []any, index int) (T, error) {
+	var decoded T
+	if index >= len(args) || args[index] == nil {
+		return decoded, nil
+	}
+	arg := args[index]
+	if typed, ok := arg.(T); ok {
+		decoded = typed
+	} else if number, ok := arg.(float64); !ok || !convertNumber(number, &decoded) {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return decoded, argumentError(index, err)
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return decoded, argumentError(index, fmt.Errorf("cannot decode %T into %T: %w", arg, decoded, err))
+		}
+	}
+	if err := validateArg(decoded); err != nil {
+		return decoded, argumentError(index, err)
+	}
+	return decoded, nil
+}
+
+// convertNumber stores a JSON number into the common numeric parameter types,
+// reporting false for other targets and for values the target cannot hold.
+func convertNumber(number float64, target any) bool {
+	integral := number == math.Trunc(number)
+	switch typed := target.(type) {
+	case *int:
+		if integral && number >= math.MinInt && number < math.MaxInt {
+			*typed = int(number)
+			return true
+		}
+	case *int64:
+		if integral && number >= math.MinInt64 && number < math.MaxInt64 {
+			*typed = int64(number)
+			return true
+		}
+	case *int32:
+		if integral && number >= math.MinInt32 && number <= math.MaxInt32 {
+			*typed = int32(number)
+			return true
+		}
+	case *uint:
+		if integral && number >= 0 && number < math.MaxUint {
+			*typed = uint(number)
+			return true
+		}
+	case *uint64:
+		if integral && number >= 0 && number < math.MaxUint64 {
+			*typed = uint64(number)
+			return true
+		}
+	case *float32:
+		*typed = float32(number)
+		return true
+	}
+	return false
+}
+
+func argumentError(index int, err error) *RpcError {
+	prefix := fmt.Sprintf("argument %d: ", index)
+	// EnumError carries its own fields; it only exists in the full build.
+	var detailed interface{ rpcError(prefix string) *RpcError }
+	if errors.As(err, &detailed) {
+		return detailed.rpcError(prefix)
+	}
+	return &RpcError{Name: "TypeError", Message: prefix + err.Error()}
+}
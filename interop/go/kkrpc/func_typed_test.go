@@ -0,0 +1,73 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestTypedFuncsDecodeArguments(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	server := NewServer(right, map[string]any{
+		"version": Func0[string](func(ctx context.Context) (string, error) { return "1.0", nil }),
+		"add": Func2[int, int, int](func(ctx context.Context, a, b int) (int, error) {
+			return a + b, nil
+		}),
+		"shift": Func2[point, int, point](func(ctx context.Context, p point, dx int) (point, error) {
+			if dx < 0 {
+				return point{}, errors.New("negative shift")
+			}
+			return point{X: p.X + dx, Y: p.Y}, nil
+		}),
+	})
+	client := NewClient(left)
+
+	if version, err := client.Call("version"); err != nil || version != "1.0" {
+		t.Fatalf("version: %#v, %v", version, err)
+	}
+	if sum, err := client.Call("add", 2, 3); err != nil || sum != float64(5) {
+		t.Fatalf("add: %#v, %v", sum, err)
+	}
+	if sum, err := client.Call("add", 2); err != nil || sum != float64(2) {
+		t.Fatalf("add with a missing argument: %#v, %v", sum, err)
+	}
+	shifted, err := client.Call("shift", map[string]any{"x": 1, "y": 2}, 3)
+	if err != nil || !compareMaps(map[string]any{"x": 4, "y": 2}, shifted) {
+		t.Fatalf("shift: %#v, %v", shifted, err)
+	}
+	if _, err := client.Call("shift", map[string]any{"x": 1}, -1); err == nil || err.Error() != "Error: negative shift" {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+
+	var rpcErr *RpcError
+	for name, args := range map[string][]any{
+		"fraction":       {1.5, 2},
+		"wrong shape":    {"two", 2},
+		"extra argument": {1, 2, 3},
+	} {
+		if _, err := client.Call("add", args...); !errors.As(err, &rpcErr) || rpcErr.Name != "TypeError" {
+			t.Fatalf("%s: expected TypeError, got %v", name, err)
+		}
+	}
+	if _, err := client.Call("version", 1); !errors.As(err, &rpcErr) || rpcErr.Name != "TypeError" {
+		t.Fatalf("expected TypeError for an argument to a nullary func, got %v", err)
+	}
+
+	methods := server.Introspect().Methods
+	for _, method := range methods {
+		if method.Name == "add" && (method.Params != 2 || method.Variadic) {
+			t.Fatalf("add introspected as %+v", method)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package kkrpc
+
+import (
+	"context"
+	"sync"
+)
+
+type Future struct {
+	done      chan struct{}
+	mu        sync.Mutex
+	value     any
+	err       error
+	settled   bool
+	listeners []func(any, error)
+}
+
+func NewFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Async runs fn on a worker of DefaultPool and returns the Future it settles.
+func Async(fn func() (any, error)) *Future {
+	future := NewFuture()
+	DefaultPool.Go(func() {
+		future.settle(fn())
+	})
+	return future
+}
+
+func (f *Future) Resolve(value any) {
+	f.settle(value, nil)
+}
+
+func (f *Future) Reject(err error) {
+	f.settle(nil, err)
+}
+
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *Future) Wait(ctx context.Context) (any, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *Future) then(listener func(any, error)) {
+	f.mu.Lock()
+	if !f.settled {
+		f.listeners = append(f.listeners, listener)
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Unlock()
+	listener(f.value, f.err)
+}
+
+func (f *Future) settle(value any, err error) {
+	f.mu.Lock()
+	if f.settled {
+		f.mu.Unlock()
+		return
+	}
+	f.value = value
+	f.err = err
+	f.settled = true
+	listeners := f.listeners
+	f.listeners = nil
+	close(f.done)
+	f.mu.Unlock()
+	for _, listener := range listeners {
+		listener(value, err)
+	}
+}
@@ -0,0 +1,131 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestServerResolvesFutureResults(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+
+	api := map[string]any{
+		"slow": func(args ...any) any {
+			return Async(func() (any, error) {
+				time.Sleep(20 * time.Millisecond)
+				return "late", nil
+			})
+		},
+		"failLater": func(args ...any) any {
+			future := NewFuture()
+			time.AfterFunc(10*time.Millisecond, func() { future.Reject(errors.New("nope")) })
+			return future
+		},
+	}
+	_ = NewServer(transport, api)
+
+	for _, method := range []string{"slow", "failLater"} {
+		request, _ := EncodeMessage(map[string]any{"t": "q", "id": method, "op": "call", "p": []any{method}})
+		transport.in <- request
+		select {
+		case raw := <-transport.out:
+			message, _ := DecodeMessage(raw)
+			switch method {
+			case "slow":
+				if message["v"] != "late" {
+					t.Fatalf("unexpected slow result: %s", raw)
+				}
+			case "failLater":
+				errMap, _ := message["e"].(map[string]any)
+				if errMap["m"] != "nope" {
+					t.Fatalf("unexpected failLater result: %s", raw)
+				}
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s response not received", method)
+		}
+	}
+}
+
+func TestClientCallTimesOut(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+	client := NewClient(transport, WithTimeout(20*time.Millisecond))
+	go func() { <-transport.out }()
+
+	_, err := client.Call("never")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	client.mu.Lock()
+	pending := len(client.pending)
+	client.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("expected timed out request to be forgotten, %d pending", pending)
+	}
+}
+
+func TestClientGoRunsOnPoolAndHonorsContext(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	release := make(chan struct{})
+	hang := make(chan struct{})
+	defer close(hang)
+	NewServer(right, map[string]any{
+		"wait": func(args ...any) any {
+			<-release
+			return "done"
+		},
+		"hang": func(args ...any) any {
+			<-hang
+			return nil
+		},
+	})
+	pool := NewPool(1)
+	client := NewClient(left, WithPool(pool))
+
+	first := client.Go("wait")
+	// The only worker is busy with the first call; a second call waits for it
+	// instead of starting another goroutine.
+	queued := make(chan *Future, 1)
+	go func() { queued <- client.Go("wait") }()
+	time.Sleep(20 * time.Millisecond)
+	if pool.Workers() != 1 {
+		t.Fatalf("calls run on %d workers", pool.Workers())
+	}
+	select {
+	case <-queued:
+		t.Fatal("second call started without a free worker")
+	default:
+	}
+	close(release)
+	second := <-queued
+	for _, future := range []*Future{first, second} {
+		if value, err := future.Wait(context.Background()); err != nil || value != "done" {
+			t.Fatalf("call: %#v, %v", value, err)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.GoContext(cancelled, "wait").Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled before the call: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.GoContext(ctx, "hang").Wait(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("deadline during the call: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package kkrpc
+
+import (
+	"context"
+	"sync"
+)
+
+// CallGroup issues many calls under one context, like errgroup: Wait returns
+// the results in the order the calls were added, and the first failure
+// cancels the calls still running or waiting for a slot.
+type CallGroup struct {
+	caller  Caller
+	ctx     context.Context
+	cancel  context.CancelFunc
+	slots   chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results []any
+	errOnce sync.Once
+	err     error
+}
+
+// NewCallGroup returns a group calling through caller. With limit > 0 at most
+// limit calls are in flight; Go blocks until a slot frees up.
+func NewCallGroup(ctx context.Context, caller Caller, limit int) *CallGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	group := &CallGroup{caller: caller, ctx: ctx, cancel: cancel}
+	if limit > 0 {
+		group.slots = make(chan struct{}, limit)
+	}
+	return group
+}
+
+// Context is cancelled once a call fails or Wait returns.
+func (g *CallGroup) Context() context.Context {
+	return g.ctx
+}
+
+// Go adds a call and returns the index of its result. Calls added after a
+// failure are not sent.
+func (g *CallGroup) Go(method string, args ...any) int {
+	g.mu.Lock()
+	index := len(g.results)
+	g.results = append(g.results, nil)
+	g.mu.Unlock()
+	if g.slots != nil {
+		select {
+		case g.slots <- struct{}{}:
+		case <-g.ctx.Done():
+			g.fail(g.ctx.Err())
+			return index
+		}
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.slots != nil {
+			defer func() { <-g.slots }()
+		}
+		if err := g.ctx.Err(); err != nil {
+			g.fail(err)
+			return
+		}
+		result, err := g.caller.CallContext(g.ctx, method, args...)
+		if err != nil {
+			g.fail(err)
+			return
+		}
+		g.mu.Lock()
+		g.results[index] = result
+		g.mu.Unlock()
+	}()
+	return index
+}
+
+// Wait blocks until every call has finished and returns their results with
+// the first error. Results of failed or skipped calls are nil.
+func (g *CallGroup) Wait() ([]any, error) {
+	g.wg.Wait()
+	g.cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.results, g.err
+}
+
+func (g *CallGroup) fail(err error) {
+	g.errOnce.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
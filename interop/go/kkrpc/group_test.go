@@ -0,0 +1,107 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// fakeCaller answers "double" after a delay and fails "fail".
+type fakeCaller struct {
+	inflight atomic.Int32
+	peak     atomic.Int32
+	calls    atomic.Int32
+}
+
+func (f *fakeCaller) CallContext(ctx context.Context, method string, args ...any) (any, error) {
+	f.calls.Add(1)
+	current := f.inflight.Add(1)
+	defer f.inflight.Add(-1)
+	for {
+		peak := f.peak.Load()
+		if current <= peak || f.peak.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	if method == "fail" {
+		return nil, errors.New("boom")
+	}
+	select {
+	case <-time.After(5 * time.Millisecond):
+		return args[0].(int) * 2, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestCallGroupKeepsOrderAndLimit(t *testing.T) {
+	caller := &fakeCaller{}
+	group := NewCallGroup(context.Background(), caller, 3)
+	for i := 0; i < 10; i++ {
+		if index := group.Go("double", i); index != i {
+			t.Fatalf("index %d for call %d", index, i)
+		}
+	}
+	results, err := group.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result != i*2 {
+			t.Fatalf("result %d: %v", i, result)
+		}
+	}
+	if peak := caller.peak.Load(); peak > 3 {
+		t.Fatalf("%d calls in flight", peak)
+	}
+	if group.Context().Err() == nil {
+		t.Fatal("context still live after Wait")
+	}
+}
+
+func TestCallGroupCancelsOnFirstError(t *testing.T) {
+	caller := &fakeCaller{}
+	group := NewCallGroup(context.Background(), caller, 1)
+	group.Go("fail")
+	for i := 0; i < 5; i++ {
+		group.Go("double", i)
+	}
+	results, err := group.Wait()
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("error %v", err)
+	}
+	if len(results) != 6 || caller.calls.Load() != 1 {
+		t.Fatalf("%d results, %d calls sent", len(results), caller.calls.Load())
+	}
+}
+
+func TestCallGroupOverClient(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	server := NewServer(serverTransport, map[string]any{
+		"square": MustFunc(func(n int) int { return n * n }),
+	})
+	defer server.Close()
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	group := NewCallGroup(context.Background(), client, 4)
+	for i := 1; i <= 8; i++ {
+		group.Go("square", i)
+	}
+	results, err := group.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valuesEqual(64, results[7]) || !valuesEqual(1, results[0]) {
+		t.Fatalf("results %v", results)
+	}
+}
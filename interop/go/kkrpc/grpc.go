@@ -0,0 +1,355 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// GRPCTunnelPath is the method path of the Tunnel service in tunnel.proto:
+// a bidirectional stream of Frame messages, each carrying one kkrpc message.
+const GRPCTunnelPath = "/kkrpc.tunnel.v1.Tunnel/Connect"
+
+// maxGRPCFrame bounds a single gRPC message, like gRPC's default 4 MiB
+// receive limit raised to fit large kkrpc payloads.
+const maxGRPCFrame = 64 << 20
+
+// GRPCError is a non-OK status ending a tunnel.
+type GRPCError struct {
+	Code    string
+	Message string
+}
+
+func (e *GRPCError) Error() string {
+	return fmt.Sprintf("kkrpc: grpc status %s: %s", e.Code, e.Message)
+}
+
+// encodeGRPCFrame returns message as a length-prefixed gRPC message holding
+// the protobuf encoding of Frame{message}: field 1, wire type 2.
+func encodeGRPCFrame(message string) []byte {
+	payload := binary.AppendUvarint([]byte{0x0a}, uint64(len(message)))
+	payload = append(payload, message...)
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// readGRPCFrame reads one length-prefixed message and decodes its Frame,
+// skipping fields it does not know.
+func readGRPCFrame(reader *bufio.Reader) (string, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(reader, prefix[:]); err != nil {
+		return "", err
+	}
+	if prefix[0] != 0 {
+		return "", errors.New("kkrpc: compressed grpc messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCFrame {
+		return "", fmt.Errorf("kkrpc: grpc message of %d bytes exceeds the limit", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return "", err
+	}
+	var message string
+	for len(payload) > 0 {
+		key, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return "", errors.New("kkrpc: malformed grpc frame")
+		}
+		payload = payload[n:]
+		var value []byte
+		switch key & 7 {
+		case 0:
+			if _, n = binary.Uvarint(payload); n <= 0 {
+				return "", errors.New("kkrpc: malformed grpc frame")
+			}
+			payload = payload[n:]
+			continue
+		case 1, 5:
+			width := 8
+			if key&7 == 5 {
+				width = 4
+			}
+			if len(payload) < width {
+				return "", errors.New("kkrpc: malformed grpc frame")
+			}
+			payload = payload[width:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(payload)
+			if n <= 0 || uint64(len(payload)-n) < length {
+				return "", errors.New("kkrpc: malformed grpc frame")
+			}
+			value = payload[n : n+int(length)]
+			payload = payload[n+int(length):]
+		default:
+			return "", errors.New("kkrpc: malformed grpc frame")
+		}
+		if key == 0x0a {
+			message = string(value)
+		}
+	}
+	return message, nil
+}
+
+// GRPCServerTransport is one tunnel opened through GRPCHandler.
+type GRPCServerTransport struct {
+	inbound  chan string
+	outbound chan string
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (t *GRPCServerTransport) Read() (string, error) {
+	select {
+	case message := <-t.inbound:
+		return message, nil
+	case <-t.closed:
+		return "", ErrTransportClosed
+	}
+}
+
+func (t *GRPCServerTransport) Write(message string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.outbound <- strings.TrimRight(message, "\n"):
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+// Close ends the tunnel with an OK status.
+func (t *GRPCServerTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+// GRPCHandler serves the Tunnel service of tunnel.proto, so gRPC clients, and
+// DialGRPC, can reach kkrpc through gRPC-only meshes. Mount it on an HTTP/2
+// server at GRPCTunnelPath; net/http speaks HTTP/2 over TLS by itself, and
+// since Go 1.24 cleartext HTTP/2 via http.Server.Protocols. accept runs on its
+// own goroutine for each tunnel.
+func GRPCHandler(accept func(*GRPCServerTransport)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.ProtoMajor != 2 {
+			http.Error(w, "gRPC requires POST over HTTP/2", http.StatusBadRequest)
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/grpc") {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		transport := &GRPCServerTransport{
+			inbound:  make(chan string, 64),
+			outbound: make(chan string, 64),
+			closed:   make(chan struct{}),
+		}
+		defer transport.Close()
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		go accept(transport)
+
+		received := make(chan error, 1)
+		go func() {
+			reader := bufio.NewReader(r.Body)
+			for {
+				message, err := readGRPCFrame(reader)
+				if err != nil {
+					received <- err
+					return
+				}
+				select {
+				case transport.inbound <- message:
+				case <-transport.closed:
+					received <- nil
+					return
+				}
+			}
+		}()
+
+		status, statusMessage := "0", ""
+		for {
+			select {
+			case message := <-transport.outbound:
+				if _, err := w.Write(encodeGRPCFrame(message)); err != nil {
+					return
+				}
+				flusher.Flush()
+				continue
+			case err := <-received:
+				// A client half-close ends the tunnel normally.
+				if err != nil && !errors.Is(err, io.EOF) {
+					status, statusMessage = "13", err.Error()
+				}
+			case <-transport.closed:
+			case <-r.Context().Done():
+				return
+			}
+			break
+		}
+		w.Header().Set("Grpc-Status", status)
+		w.Header().Set("Grpc-Message", statusMessage)
+	})
+}
+
+type GRPCOptions struct {
+	// Headers are sent with the call, e.g. authorization metadata.
+	Headers http.Header
+	// Client performs the call. It must speak HTTP/2: http.DefaultClient does
+	// for https:// URLs; for cleartext h2c set Protocols on its transport
+	// (Go 1.24+). Leave its Timeout unset, it would cut the tunnel.
+	Client *http.Client
+}
+
+// GRPCClientTransport is the client end of a Tunnel stream.
+type GRPCClientTransport struct {
+	writer   *io.PipeWriter
+	writeMu  sync.Mutex
+	messages chan string
+	done     chan struct{}
+	err      error
+	cancel   context.CancelFunc
+	once     sync.Once
+}
+
+// DialGRPC starts a Tunnel call at baseURL, e.g. "https://mesh.internal:8443".
+// It returns without waiting for the server's headers, which gRPC servers may
+// hold back until their first message.
+func DialGRPC(ctx context.Context, baseURL string, opts GRPCOptions) (*GRPCClientTransport, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	callCtx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	request, err := http.NewRequestWithContext(callCtx, http.MethodPost, strings.TrimRight(baseURL, "/")+GRPCTunnelPath, reader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for name, values := range opts.Headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("Te", "trailers")
+	t := &GRPCClientTransport{
+		writer:   writer,
+		messages: make(chan string, 64),
+		done:     make(chan struct{}),
+		cancel:   cancel,
+	}
+	// ctx bounds the wait for the response headers; the tunnel then lives
+	// until Close.
+	stop := context.AfterFunc(ctx, cancel)
+	go func() {
+		response, err := client.Do(request)
+		stop()
+		if err != nil {
+			t.finish(err)
+			return
+		}
+		t.receive(response)
+	}()
+	return t, nil
+}
+
+func (t *GRPCClientTransport) receive(response *http.Response) {
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.finish(fmt.Errorf("kkrpc: grpc tunnel: HTTP error %d", response.StatusCode))
+		return
+	}
+	if response.ProtoMajor != 2 {
+		t.finish(errors.New("kkrpc: grpc tunnel: server did not answer over HTTP/2"))
+		return
+	}
+	reader := bufio.NewReader(response.Body)
+	for {
+		message, err := readGRPCFrame(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = grpcStatus(response)
+			}
+			t.finish(err)
+			return
+		}
+		select {
+		case t.messages <- message:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// grpcStatus reads the status from the trailers, or from the headers of a
+// trailers-only response.
+func grpcStatus(response *http.Response) error {
+	status, message := response.Trailer.Get("Grpc-Status"), response.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = response.Header.Get("Grpc-Status"), response.Header.Get("Grpc-Message")
+	}
+	if status == "" || status == "0" {
+		return ErrTransportClosed
+	}
+	return &GRPCError{Code: status, Message: message}
+}
+
+func (t *GRPCClientTransport) finish(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+		t.writer.CloseWithError(ErrTransportClosed)
+		t.cancel()
+	})
+}
+
+func (t *GRPCClientTransport) Read() (string, error) {
+	select {
+	case message := <-t.messages:
+		return message, nil
+	case <-t.done:
+		return "", t.err
+	}
+}
+
+func (t *GRPCClientTransport) Write(message string) error {
+	select {
+	case <-t.done:
+		if t.err != nil {
+			return t.err
+		}
+		return ErrTransportClosed
+	default:
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err := t.writer.Write(encodeGRPCFrame(strings.TrimRight(message, "\n")))
+	return err
+}
+
+// Close half-closes the stream and cancels the call.
+func (t *GRPCClientTransport) Close() error {
+	t.finish(ErrTransportClosed)
+	return nil
+}
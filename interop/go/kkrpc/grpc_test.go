@@ -0,0 +1,68 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestGRPCFrameMatchesProtobuf(t *testing.T) {
+	frame := encodeGRPCFrame(`{"t":"q"}`)
+	want := append([]byte{0, 0, 0, 0, 11, 0x0a, 9}, `{"t":"q"}`...)
+	if !bytes.Equal(frame, want) {
+		t.Fatalf("frame % x", frame)
+	}
+	// Unknown fields from newer Frame definitions are skipped.
+	extended := []byte{0, 0, 0, 0, 10, 0x10, 0x01, 0x0a, 2, 'h', 'i', 0x1a, 2, 'x', 'y'}
+	message, err := readGRPCFrame(bufio.NewReader(bytes.NewReader(extended)))
+	if err != nil || message != "hi" {
+		t.Fatalf("message %q %v", message, err)
+	}
+}
+
+func TestGRPCTunnelRoundTrip(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	accepted := make(chan *GRPCServerTransport, 1)
+	server := httptest.NewUnstartedServer(GRPCHandler(func(transport *GRPCServerTransport) {
+		accepted <- transport
+		NewServer(transport, map[string]any{
+			"greet": MustFunc(func(name string, reply func(string)) string {
+				reply("hello " + name)
+				return "done"
+			}),
+		})
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	transport, err := DialGRPC(context.Background(), server.URL, GRPCOptions{Client: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	replies := make(chan string, 1)
+	result, err := client.Call("greet", "grpc", func(args ...any) { replies <- args[0].(string) })
+	if err != nil || result != "done" {
+		t.Fatalf("call %v %v", result, err)
+	}
+	if reply := <-replies; reply != "hello grpc" {
+		t.Fatalf("callback %q", reply)
+	}
+
+	// Closing the server end finishes the stream with an OK status.
+	(<-accepted).Close()
+	if _, err := transport.Read(); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("read after close: %v", err)
+	}
+	client.Close()
+}
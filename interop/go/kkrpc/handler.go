@@ -0,0 +1,105 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type HandlerFunc func(ctx context.Context, method string, args json.RawMessage) (any, error)
+
+// Invoker is a method that converts its own arguments, as generated code does.
+// *Func is the Invoker made by reflection; Invokers are all a build with the
+// kkrpc_minimal tag can serve besides HandlerFunc and func(...any) any.
+type Invoker interface {
+	Invoke(ctx context.Context, args []any) (any, error)
+}
+
+// InvokerFunc adapts a function to Invoker.
+type InvokerFunc func(ctx context.Context, args []any) (any, error)
+
+func (f InvokerFunc) Invoke(ctx context.Context, args []any) (any, error) {
+	return f(ctx, args)
+}
+
+func (s *Server) Handle(path string, handler HandlerFunc) error {
+	if handler == nil {
+		return fmt.Errorf("kkrpc: nil handler for %s", path)
+	}
+	return s.register(path, handler)
+}
+
+func (s *Server) invokeRaw(ctx context.Context, handler HandlerFunc, path []string, args []any) (any, error) {
+	unwrapped := make([]any, 0, len(args))
+	for _, arg := range args {
+		if envelope, ok := arg.(map[string]any); ok && envelope[ArgEnvelopeTag] == "value" {
+			arg = envelope["v"]
+		}
+		unwrapped = append(unwrapped, arg)
+	}
+	raw, err := json.Marshal(unwrapped)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, strings.Join(path, "."), raw)
+}
+
+func CallbackFromContext(ctx context.Context, marker any) (Callback, error) {
+	request := inflightFromContext(ctx)
+	if request == nil {
+		return nil, fmt.Errorf("kkrpc: context does not belong to a request")
+	}
+	var callbackID string
+	switch typed := marker.(type) {
+	case string:
+		callbackID = typed
+	case map[string]any:
+		if typed[ArgEnvelopeTag] != "callback" {
+			return nil, fmt.Errorf("kkrpc: value is not a callback marker")
+		}
+		callbackID, _ = typed["id"].(string)
+	case json.RawMessage:
+		var envelope map[string]any
+		if err := json.Unmarshal(typed, &envelope); err != nil {
+			return nil, err
+		}
+		return CallbackFromContext(ctx, envelope)
+	default:
+		return nil, fmt.Errorf("kkrpc: unsupported callback marker %T", marker)
+	}
+	if callbackID == "" {
+		return nil, fmt.Errorf("kkrpc: callback marker has no id")
+	}
+	return request.server.callbackProxy(callbackID), nil
+}
+
+func (s *Server) register(path string, handler any) error {
+	parts := splitMethod(path)
+	if path == "" || len(parts) == 0 {
+		return errors.New("kkrpc: empty registration path")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.api == nil {
+		s.api = make(map[string]any)
+	}
+	node := s.api
+	for i, part := range parts[:len(parts)-1] {
+		next, exists := node[part]
+		if !exists {
+			child := make(map[string]any)
+			node[part] = child
+			node = child
+			continue
+		}
+		child, ok := next.(map[string]any)
+		if !ok {
+			return fmt.Errorf("kkrpc: cannot register %s: %s is not a namespace", path, strings.Join(parts[:i+1], "."))
+		}
+		node = child
+	}
+	node[parts[len(parts)-1]] = handler
+	return nil
+}
@@ -0,0 +1,140 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestHandlerFuncReceivesRawArgsForNamespace(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	server := NewServer(right, nil)
+	if err := server.Handle("scripts", func(ctx context.Context, method string, args json.RawMessage) (any, error) {
+		var decoded []json.RawMessage
+		if err := json.Unmarshal(args, &decoded); err != nil {
+			return nil, err
+		}
+		if len(decoded) > 1 {
+			notify, err := CallbackFromContext(ctx, decoded[1])
+			if err != nil {
+				return nil, err
+			}
+			notify("ran " + method)
+		}
+		return method + ":" + string(decoded[0]), nil
+	}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	client := NewClient(left)
+
+	notified := make(chan string, 1)
+	result, err := client.Call("scripts.greet.run", map[string]any{"name": "kk"}, func(message string) { notified <- message })
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if result != `scripts.greet.run:{"name":"kk"}` {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	select {
+	case message := <-notified:
+		if message != "ran scripts.greet.run" {
+			t.Fatalf("unexpected notification: %s", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("callback not invoked")
+	}
+}
+
+func TestResolverSuppliesMissingMethods(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	var server *Server
+	loads := 0
+	resolver := func(ctx context.Context, path []string) (any, error) {
+		if len(path) != 2 || path[0] != "plugins" {
+			return nil, nil
+		}
+		loads++
+		name := path[1]
+		handler := MustFunc(func() string { return "loaded " + name })
+		if err := server.register("plugins."+name, handler); err != nil {
+			return nil, err
+		}
+		return handler, nil
+	}
+	server = NewServer(right, map[string]any{}, WithResolver(resolver))
+	client := NewClient(left)
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Call("plugins.zip")
+		if err != nil || result != "loaded zip" {
+			t.Fatalf("plugins.zip: %#v, %v", result, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected the resolver to run once, ran %d times", loads)
+	}
+	if _, err := client.Call("other.method"); err == nil || err.Error() != "Error: path not found" {
+		t.Fatalf("expected path not found, got %v", err)
+	}
+}
+
+func TestInvokerConvertsItsOwnArguments(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	server := NewServer(right, map[string]any{
+		"add": InvokerFunc(func(ctx context.Context, args []any) (any, error) {
+			a, okA := args[0].(float64)
+			b, okB := args[1].(float64)
+			if !okA || !okB {
+				return nil, &RpcError{Name: "TypeError", Message: "expected numbers"}
+			}
+			return a + b, nil
+		}),
+	})
+	client := NewClient(left)
+
+	if sum, err := client.Call("add", 2, 3); err != nil || sum != float64(5) {
+		t.Fatalf("add = %v, %v", sum, err)
+	}
+	if _, err := client.Call("add", "2", 3); err == nil {
+		t.Fatal("expected a TypeError")
+	}
+	if methods := server.Introspect().Methods; len(methods) != 1 || methods[0].Params != -1 {
+		t.Fatalf("schema %+v", methods)
+	}
+}
+
+func TestMinimalBuildCompiles(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if testing.Short() {
+		t.Skip("builds the package again")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	if out, err := exec.Command(goTool, "build", "-tags", "kkrpc_minimal", ".").CombinedOutput(); err != nil {
+		t.Fatalf("kkrpc_minimal build: %v\n%s", err, out)
+	}
+}
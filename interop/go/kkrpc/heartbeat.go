@@ -0,0 +1,125 @@
+package kkrpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPeerUnresponsive fails the calls pending on a connection whose peer
+// stopped answering heartbeats.
+var ErrPeerUnresponsive = errors.New("kkrpc: peer stopped answering heartbeats")
+
+// WithHeartbeat pings the peer whenever the connection was quiet for interval
+// and gives it up once nothing at all arrived for timeout, three intervals
+// when zero. The connection is then closed, or redialed if it is a
+// ReconnectingTransport, and the calls pending on it fail at once with
+// ErrPeerUnresponsive instead of waiting for their timeouts.
+//
+// Over a WebSocket the pings are ping frames, which every WebSocket peer
+// answers. Elsewhere they are {"t":"ping"} messages; every kkrpc Go peer
+// answers them with {"t":"pong"}, with or without this option.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout <= 0 {
+			timeout = 3 * interval
+		}
+		o.heartbeatInterval = interval
+		o.heartbeatTimeout = timeout
+	}
+}
+
+// pinger is a transport with a ping of its own that its peer answers.
+type pinger interface {
+	ping() error
+}
+
+// errNoPing reports that a wrapping transport's current connection has no
+// ping of its own.
+var errNoPing = errors.New("kkrpc: transport has no ping")
+
+func pingPayload() map[string]any {
+	return map[string]any{"t": "ping"}
+}
+
+func pongPayload() map[string]any {
+	return map[string]any{"t": "pong"}
+}
+
+// heartbeat watches one connection; its methods do nothing on nil, when the
+// option is off.
+type heartbeat struct {
+	interval time.Duration
+	timeout  time.Duration
+	last     atomic.Int64
+	pinging  atomic.Bool
+	done     chan struct{}
+}
+
+func startHeartbeat(transport Transport, o *options) *heartbeat {
+	if o.heartbeatInterval <= 0 {
+		return nil
+	}
+	h := &heartbeat{interval: o.heartbeatInterval, timeout: o.heartbeatTimeout, done: make(chan struct{})}
+	h.seen()
+	go h.run(transport, o)
+	return h
+}
+
+// seen records that something arrived from the peer.
+func (h *heartbeat) seen() {
+	if h != nil {
+		h.last.Store(time.Now().UnixNano())
+	}
+}
+
+func (h *heartbeat) stop() {
+	if h != nil {
+		close(h.done)
+	}
+}
+
+func (h *heartbeat) run(transport Transport, o *options) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.done:
+			return
+		}
+		quiet := time.Since(time.Unix(0, h.last.Load()))
+		if quiet >= h.timeout {
+			o.logger.Printf("kkrpc: nothing from peer for %v, dropping connection", quiet.Round(time.Millisecond))
+			if reconnecting, ok := transport.(*ReconnectingTransport); ok {
+				reconnecting.drop(ErrPeerUnresponsive)
+			} else {
+				_ = transport.Close()
+			}
+			if o.failPending != nil {
+				o.failPending(ErrPeerUnresponsive)
+			}
+			h.seen()
+			continue
+		}
+		// The ping is sent aside: a write to a half-open connection may block
+		// and must not hold up the timeout.
+		if quiet >= h.interval && h.pinging.CompareAndSwap(false, true) {
+			go func() {
+				defer h.pinging.Store(false)
+				if err := sendPing(transport, o); err != nil {
+					o.logger.Printf("kkrpc: send heartbeat: %v", err)
+				}
+			}()
+		}
+	}
+}
+
+func sendPing(transport Transport, o *options) error {
+	if p, ok := transport.(pinger); ok {
+		if err := p.ping(); !errors.Is(err, errNoPing) {
+			return err
+		}
+	}
+	return writePayload(transport, o, pingPayload())
+}
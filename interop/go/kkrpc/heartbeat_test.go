@@ -0,0 +1,88 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestHeartbeatFailsPendingCallsOfDeadPeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	// Nothing serves the other end, so pings go unanswered, as with a peer
+	// that hung or a connection that went half-open.
+	clientTransport, _ := NewPipeTransportPair()
+	client := NewClient(clientTransport, WithTimeout(time.Minute), WithHeartbeat(10*time.Millisecond, 50*time.Millisecond))
+
+	started := time.Now()
+	_, err := client.Call("slow")
+	if !errors.Is(err, ErrPeerUnresponsive) {
+		t.Fatalf("expected ErrPeerUnresponsive, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("dead peer detected after %v", elapsed)
+	}
+	if client.PendingCalls() != 0 {
+		t.Fatalf("%d calls still pending", client.PendingCalls())
+	}
+	if err := clientTransport.Write("{}\n"); err == nil {
+		t.Fatal("transport still open")
+	}
+}
+
+func TestHeartbeatKeepsIdleConnectionToLivePeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := NewPipeTransportPair()
+	NewServer(serverTransport, map[string]any{"ping": MustFunc(func() string { return "ok" })})
+	client := NewClient(clientTransport, WithHeartbeat(10*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+	defer serverTransport.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if result, err := client.Call("ping"); err != nil || result != "ok" {
+		t.Fatalf("call after idle period: %v %v", result, err)
+	}
+}
+
+func TestHeartbeatUsesWebSocketPingFrames(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	// The peer only reads, so it answers ping frames but not ping messages.
+	received := make(chan string, 16)
+	server := httptest.NewServer(WebSocketHandler(func(transport *WebSocketTransport) {
+		for {
+			message, err := transport.Read()
+			if err != nil {
+				return
+			}
+			received <- message
+		}
+	}))
+	defer server.Close()
+	transport, err := NewWebSocketTransport("ws" + strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	NewClient(transport, WithHeartbeat(10*time.Millisecond, 50*time.Millisecond))
+
+	time.Sleep(200 * time.Millisecond)
+	if err := transport.Write(`{"t":"x"}`); err != nil {
+		t.Fatalf("connection dropped: %v", err)
+	}
+	select {
+	case message := <-received:
+		if message != `{"t":"x"}` {
+			t.Fatalf("peer received %q", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer received nothing")
+	}
+}
@@ -0,0 +1,162 @@
+package kkrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxHTTPResponse caps the body read for one response.
+const maxHTTPResponse = 64 << 20
+
+type HTTPClientOptions struct {
+	// Headers are added to every POST, e.g. Authorization.
+	Headers http.Header
+	// Client performs the requests; defaults to http.DefaultClient. Set its
+	// Timeout to bound each exchange.
+	Client *http.Client
+}
+
+// HTTPClientTransport talks to a unary kkrpc HTTP endpoint such as the one
+// created by createHttpHandler in the TypeScript package: every request is
+// POSTed as JSON and the response message is read from the HTTP response.
+// HTTP has no channel back to the client, so callback arguments are rejected
+// and only requests can be sent.
+type HTTPClientTransport struct {
+	url       string
+	client    *http.Client
+	headers   http.Header
+	responses chan string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewHTTPClientTransport(url string, opts HTTPClientOptions) *HTTPClientTransport {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HTTPClientTransport{
+		url:       url,
+		client:    client,
+		headers:   opts.Headers,
+		responses: make(chan string, 64),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+type httpRequestHeader struct {
+	T  string `json:"t"`
+	ID string `json:"id"`
+	A  []any  `json:"a"`
+}
+
+func (t *HTTPClientTransport) Write(message string) error {
+	var header httpRequestHeader
+	if err := json.Unmarshal([]byte(message), &header); err != nil {
+		return err
+	}
+	if header.T != "q" {
+		return errors.New("kkrpc: HTTP transport only supports client request messages")
+	}
+	if containsCallbackEnvelope(header.A, 0) {
+		return errors.New("kkrpc: HTTP transport does not support callback arguments")
+	}
+	if t.ctx.Err() != nil {
+		return ErrTransportClosed
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		reply, err := t.post(message)
+		if err != nil {
+			reply, _ = EncodeMessage(map[string]any{"t": "r", "id": header.ID, "e": encodeError(err)})
+		}
+		select {
+		case t.responses <- reply:
+		case <-t.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+func (t *HTTPClientTransport) post(message string) (string, error) {
+	request, err := http.NewRequestWithContext(t.ctx, http.MethodPost, t.url, strings.NewReader(message))
+	if err != nil {
+		return "", err
+	}
+	for key, values := range t.headers {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := t.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxHTTPResponse))
+	if err != nil {
+		return "", err
+	}
+	// Error statuses such as 504 still carry a response message.
+	var reply struct {
+		T  string `json:"t"`
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(body, &reply) == nil && reply.T == "r" && reply.ID != "" {
+		return string(bytes.TrimSpace(body)) + "\n", nil
+	}
+	if response.StatusCode >= 300 {
+		return "", fmt.Errorf("HTTP error %d", response.StatusCode)
+	}
+	return "", errors.New("invalid RPC response")
+}
+
+func (t *HTTPClientTransport) Read() (string, error) {
+	select {
+	case reply := <-t.responses:
+		return reply, nil
+	case <-t.ctx.Done():
+		return "", ErrTransportClosed
+	}
+}
+
+// Close aborts requests still in flight.
+func (t *HTTPClientTransport) Close() error {
+	t.cancel()
+	t.wg.Wait()
+	return nil
+}
+
+func containsCallbackEnvelope(value any, depth int) bool {
+	if depth > DefaultDecodeLimits.MaxDepth {
+		return false
+	}
+	switch typed := value.(type) {
+	case []any:
+		for _, item := range typed {
+			if containsCallbackEnvelope(item, depth+1) {
+				return true
+			}
+		}
+	case map[string]any:
+		if typed[ArgEnvelopeTag] == "callback" {
+			return true
+		}
+		for _, item := range typed {
+			if containsCallbackEnvelope(item, depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
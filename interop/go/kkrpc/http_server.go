@@ -0,0 +1,182 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxHTTPRequest caps the body read for one request.
+const maxHTTPRequest = 64 << 20
+
+// NewHTTPHandler serves api over unary HTTP, compatible with the TypeScript
+// httpClientTransport and HTTPClientTransport: each POSTed request message is
+// answered in the HTTP response body. All requests share one Server, so
+// options such as goroutine budgets and idempotency keys span requests.
+// WithTimeout bounds each request and answers 504 with RPCTimeoutError.
+func NewHTTPHandler(api map[string]any, opts ...Option) http.Handler {
+	transport := &httpHandlerTransport{waiting: make(map[string]chan string), closed: make(chan struct{})}
+	o := newOptions(opts)
+	return &httpHandler{server: newServer(transport, api, o), transport: transport, timeout: o.timeout}
+}
+
+type httpHandler struct {
+	server    *Server
+	transport *httpHandlerTransport
+	timeout   time.Duration
+}
+
+var httpOperations = map[string]bool{"call": true, "get": true, "set": true, "new": true}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPRequest))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	message, err := h.server.options.decodeMessage(string(body))
+	if err != nil || !isHTTPRequestMessage(message) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	requestID := message["id"].(string)
+	if containsCallbackEnvelope(message["a"], 0) || containsCallbackEnvelope(message["v"], 0) {
+		writeHTTPReply(w, http.StatusOK, map[string]any{
+			"t":  "r",
+			"id": requestID,
+			"e":  encodeError(errors.New("HTTP transport does not support callback arguments")),
+		})
+		return
+	}
+
+	reply, ok := h.transport.expect(requestID)
+	if !ok {
+		http.Error(w, "Duplicate request id", http.StatusConflict)
+		return
+	}
+	defer h.transport.forget(requestID)
+	h.server.options.resources.received(message, len(body))
+	h.server.handleMessage(message)
+
+	var timeout <-chan time.Time
+	if h.timeout > 0 {
+		timer := time.NewTimer(h.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case response := <-reply:
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, response)
+	case <-timeout:
+		writeHTTPReply(w, http.StatusGatewayTimeout, map[string]any{
+			"t":  "r",
+			"id": requestID,
+			"e": map[string]any{
+				"n": "RPCTimeoutError",
+				"m": fmt.Sprintf("RPC request %s timed out after %dms", requestID, h.timeout.Milliseconds()),
+			},
+		})
+	case <-r.Context().Done():
+	}
+}
+
+func isHTTPRequestMessage(message map[string]any) bool {
+	if message["t"] != "q" {
+		return false
+	}
+	if _, ok := message["id"].(string); !ok {
+		return false
+	}
+	if op, _ := message["op"].(string); !httpOperations[op] {
+		return false
+	}
+	path, ok := message["p"].([]any)
+	if !ok {
+		return false
+	}
+	for _, segment := range path {
+		if _, ok := segment.(string); !ok {
+			return false
+		}
+	}
+	if args, exists := message["a"]; exists {
+		if _, ok := args.([]any); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func writeHTTPReply(w http.ResponseWriter, status int, payload map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(payload)
+}
+
+// httpHandlerTransport routes the shared server's responses to the HTTP
+// request waiting for them. Requests are fed to the server directly, so Read
+// only blocks until Close.
+type httpHandlerTransport struct {
+	mu      sync.Mutex
+	waiting map[string]chan string
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (t *httpHandlerTransport) expect(requestID string) (chan string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.waiting[requestID]; exists {
+		return nil, false
+	}
+	reply := make(chan string, 1)
+	t.waiting[requestID] = reply
+	return reply, true
+}
+
+func (t *httpHandlerTransport) forget(requestID string) {
+	t.mu.Lock()
+	delete(t.waiting, requestID)
+	t.mu.Unlock()
+}
+
+func (t *httpHandlerTransport) Write(message string) error {
+	var header struct {
+		T  string `json:"t"`
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(message), &header); err != nil {
+		return err
+	}
+	if header.T != "r" {
+		return errors.New("kkrpc: HTTP handler only supports response messages")
+	}
+	t.mu.Lock()
+	reply, ok := t.waiting[header.ID]
+	delete(t.waiting, header.ID)
+	t.mu.Unlock()
+	if ok {
+		reply <- message
+	}
+	return nil
+}
+
+func (t *httpHandlerTransport) Read() (string, error) {
+	<-t.closed
+	return "", ErrTransportClosed
+}
+
+func (t *httpHandlerTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
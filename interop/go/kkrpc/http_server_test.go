@@ -0,0 +1,71 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestHTTPHandlerServesHTTPClientTransport(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	handler := NewHTTPHandler(map[string]any{
+		"math": map[string]any{
+			"add": MustFunc(func(a, b float64) float64 { return a + b }),
+		},
+		"version": "1.0",
+		"slow": func(args ...any) any {
+			time.Sleep(time.Second)
+			return "late"
+		},
+	}, WithTimeout(100*time.Millisecond))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := NewClient(NewHTTPClientTransport(server.URL, HTTPClientOptions{}), WithTimeout(2*time.Second))
+	defer client.Close()
+
+	if result, err := client.Call("math.add", 2, 3); err != nil || !valuesEqual(5, result) {
+		t.Fatalf("math.add: %#v %v", result, err)
+	}
+	if result, err := client.Get([]string{"version"}); err != nil || result != "1.0" {
+		t.Fatalf("get: %#v %v", result, err)
+	}
+	var rpcErr *RpcError
+	if _, err := client.Call("slow"); !errors.As(err, &rpcErr) || rpcErr.Name != "RPCTimeoutError" {
+		t.Fatalf("slow: %v", err)
+	}
+	if _, err := client.Call("missing"); err == nil {
+		t.Fatal("missing method succeeded")
+	}
+}
+
+func TestHTTPHandlerRejectsInvalidRequests(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	handler := NewHTTPHandler(map[string]any{"noop": func(args ...any) any { return nil }})
+	cases := []struct {
+		method string
+		body   string
+		status int
+		want   string
+	}{
+		{http.MethodGet, "", http.StatusMethodNotAllowed, "Method not allowed"},
+		{http.MethodPost, "not json", http.StatusBadRequest, "Bad request"},
+		{http.MethodPost, `{"t":"q","id":"1","op":"drop","p":["noop"]}`, http.StatusBadRequest, "Bad request"},
+		{http.MethodPost, `{"t":"q","id":"1","op":"call","p":["noop"],"a":[{"__kkrpc_next_arg__":"callback","id":"cb"}]}`, http.StatusOK, "does not support callback"},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(tc.method, "/rpc", strings.NewReader(tc.body)))
+		if recorder.Code != tc.status || !strings.Contains(recorder.Body.String(), tc.want) {
+			t.Errorf("%s %q: %d %q", tc.method, tc.body, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// tsHTTPHandler mimics createHttpHandler from the TypeScript package.
+func tsHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		T  string    `json:"t"`
+		ID string    `json:"id"`
+		P  []string  `json:"p"`
+		A  []float64 `json:"a"`
+	}
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&request) != nil || request.T != "q" {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch strings.Join(request.P, ".") {
+	case "math.add":
+		_ = json.NewEncoder(w).Encode(map[string]any{"t": "r", "id": request.ID, "v": request.A[0] + request.A[1]})
+	case "slow":
+		w.WriteHeader(http.StatusGatewayTimeout)
+		_ = json.NewEncoder(w).Encode(map[string]any{"t": "r", "id": request.ID, "e": map[string]any{"n": "RPCTimeoutError", "m": "timed out"}})
+	default:
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}
+}
+
+func TestHTTPClientTransport(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	server := httptest.NewServer(http.HandlerFunc(tsHTTPHandler))
+	defer server.Close()
+	client := NewClient(NewHTTPClientTransport(server.URL, HTTPClientOptions{}), WithTimeout(2*time.Second))
+	defer client.Close()
+
+	result, err := client.Call("math.add", 2, 3)
+	if err != nil || !valuesEqual(5, result) {
+		t.Fatalf("math.add: %#v %v", result, err)
+	}
+	var rpcErr *RpcError
+	if _, err := client.Call("slow"); err == nil || !errors.As(err, &rpcErr) || rpcErr.Name != "RPCTimeoutError" {
+		t.Fatalf("slow: %v", err)
+	}
+	if _, err := client.Call("broken"); err == nil || !strings.Contains(err.Error(), "HTTP error 500") {
+		t.Fatalf("broken: %v", err)
+	}
+	if _, err := client.Call("math.add", Callback(func(...any) {})); err == nil || !strings.Contains(err.Error(), "callback") {
+		t.Fatalf("callback: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package kkrpc
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultIdempotencyCapacity is how many responses the default store keeps.
+const DefaultIdempotencyCapacity = 1024
+
+// ErrIdempotencyKeyReused fails a keyed request whose key was already used for
+// a different method or different arguments.
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different request")
+
+// IdempotentResponse is the recorded outcome of a keyed request: either the
+// encoded result or the encoded error, exactly as it was sent. Request
+// identifies what was answered, the op, method and a hash of the arguments,
+// so that a key reused for something else is refused rather than replayed.
+type IdempotentResponse struct {
+	Value   any            `json:"v,omitempty"`
+	Error   map[string]any `json:"e,omitempty"`
+	Request string         `json:"r,omitempty"`
+}
+
+// IdempotencyStore keeps responses of keyed requests. Keys are scoped to the
+// subject of the caller's Identity, so peers sharing a store only replay their
+// own responses. Implementations must be safe for concurrent use; a shared
+// store (Redis, SQL) makes keys survive restarts and span server replicas.
+type IdempotencyStore interface {
+	Get(key string) (IdempotentResponse, bool)
+	Put(key string, response IdempotentResponse)
+}
+
+type idempotencyKey struct{}
+
+// ContextWithIdempotencyKey marks calls made with ctx as idempotent: a server
+// that already answered key replays the recorded response instead of running
+// the handler again. Use one key per logical operation and reuse it on retry.
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// WithIdempotencyStore replaces the server's in-memory LRU store.
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(o *options) {
+		o.idempotency = store
+	}
+}
+
+// LRUIdempotencyStore is the default IdempotencyStore, evicting the least
+// recently used key once capacity is reached.
+type LRUIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key      string
+	response IdempotentResponse
+}
+
+func NewLRUIdempotencyStore(capacity int) *LRUIdempotencyStore {
+	if capacity <= 0 {
+		capacity = DefaultIdempotencyCapacity
+	}
+	return &LRUIdempotencyStore{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (s *LRUIdempotencyStore) Get(key string) (IdempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return IdempotentResponse{}, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*lruEntry).response, true
+}
+
+func (s *LRUIdempotencyStore) Put(key string, response IdempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*lruEntry).response = response
+		s.order.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&lruEntry{key: key, response: response})
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (s *LRUIdempotencyStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// idempotencyTracker links in-flight request ids to their keys so the
+// response can be recorded, and parks duplicates that arrive while the first
+// request with the same key is still running.
+type idempotencyTracker struct {
+	mu       sync.Mutex
+	store    IdempotencyStore
+	requests map[string]string
+	running  map[string]*keyedRun
+}
+
+// keyedRun is the request running under a key and the duplicates parked
+// behind it.
+type keyedRun struct {
+	request string
+	waiting []string
+}
+
+func newIdempotencyTracker(store IdempotencyStore) *idempotencyTracker {
+	if store == nil {
+		store = NewLRUIdempotencyStore(DefaultIdempotencyCapacity)
+	}
+	return &idempotencyTracker{store: store, requests: make(map[string]string), running: make(map[string]*keyedRun)}
+}
+
+// begin reports whether requestID, described by request, should run.
+// Otherwise the request is either answered from the store (response non-nil),
+// parked behind the running one, or refused because key was used for another
+// request.
+func (t *idempotencyTracker) begin(key, request, requestID string) (run bool, response *IdempotentResponse, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cached, ok := t.store.Get(key); ok {
+		if cached.Request != request {
+			return false, nil, ErrIdempotencyKeyReused
+		}
+		return false, &cached, nil
+	}
+	if running, ok := t.running[key]; ok {
+		if running.request != request {
+			return false, nil, ErrIdempotencyKeyReused
+		}
+		running.waiting = append(running.waiting, requestID)
+		return false, nil, nil
+	}
+	t.running[key] = &keyedRun{request: request}
+	t.requests[requestID] = key
+	return true, nil, nil
+}
+
+// complete records the response of requestID if it was keyed and returns the
+// parked duplicates that should receive the same response.
+func (t *idempotencyTracker) complete(requestID string, response IdempotentResponse) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, ok := t.requests[requestID]
+	if !ok {
+		return nil
+	}
+	delete(t.requests, requestID)
+	running := t.running[key]
+	delete(t.running, key)
+	response.Request = running.request
+	t.store.Put(key, response)
+	return running.waiting
+}
+
+// idempotentRequest describes what a keyed request asks for: its op, its
+// method and a hash of its arguments.
+func idempotentRequest(message map[string]any) string {
+	op, _ := message["op"].(string)
+	args := message["a"]
+	if op == "set" {
+		args = message["v"]
+	}
+	encoded, _ := json.Marshal(args)
+	sum := sha256.Sum256(encoded)
+	return op + " " + strings.Join(pathFromMessage(message), ".") + " " + hex.EncodeToString(sum[:])
+}
+
+// scopedIdempotencyKey prefixes key with the caller's subject, length first so
+// that no subject and key pair can spell another.
+func scopedIdempotencyKey(ctx context.Context, key string) string {
+	identity, _ := IdentityFromContext(ctx)
+	return fmt.Sprintf("%d:%s:%s", len(identity.Subject), identity.Subject, key)
+}
+
+func (s *Server) beginIdempotent(ctx context.Context, message map[string]any, requestID string) bool {
+	key, _ := message["idem"].(string)
+	if key == "" {
+		return true
+	}
+	run, cached, err := s.idempotency.begin(scopedIdempotencyKey(ctx, key), idempotentRequest(message), requestID)
+	if err != nil {
+		s.sendError(requestID, err)
+	} else if cached != nil {
+		s.replay(requestID, *cached)
+	}
+	return run
+}
+
+func (s *Server) completeIdempotent(requestID string, payload map[string]any) {
+	response := IdempotentResponse{Value: payload["v"]}
+	response.Error, _ = payload["e"].(map[string]any)
+	for _, waiting := range s.idempotency.complete(requestID, response) {
+		s.replay(waiting, response)
+	}
+}
+
+func (s *Server) replay(requestID string, response IdempotentResponse) {
+	payload := map[string]any{"t": "r", "id": requestID}
+	if response.Error != nil {
+		payload["e"] = response.Error
+	} else {
+		payload["v"] = response.Value
+	}
+	s.stamps.stamp(requestID, payload)
+	if err := writePayload(s.transport, s.options, payload); err != nil {
+		s.options.logger.Printf("kkrpc: replay response %s: %v", requestID, err)
+	}
+}
@@ -0,0 +1,130 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	var charges atomic.Int64
+	release := make(chan struct{})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	server := NewServer(serverTransport, map[string]any{
+		"charge": func(args ...any) any {
+			<-release
+			return charges.Add(1)
+		},
+		"fail": func(args ...any) any {
+			charges.Add(1)
+			return errors.New("declined")
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	ctx := ContextWithIdempotencyKey(context.Background(), "order-1")
+	var wg sync.WaitGroup
+	results := make([]any, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := client.CallContext(ctx, "charge")
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = result
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if again, err := client.CallContext(ctx, "charge"); err != nil || !valuesEqual(1, again) {
+		t.Fatalf("retry: %#v %v", again, err)
+	}
+	for _, result := range results {
+		if !valuesEqual(1, result) {
+			t.Fatalf("duplicate got %#v", result)
+		}
+	}
+	if charges.Load() != 1 {
+		t.Fatalf("handler ran %d times", charges.Load())
+	}
+
+	failCtx := ContextWithIdempotencyKey(context.Background(), "order-2")
+	for i := 0; i < 2; i++ {
+		if _, err := client.CallContext(failCtx, "fail"); err == nil || !strings.Contains(err.Error(), "declined") {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	if charges.Load() != 2 {
+		t.Fatalf("failing handler re-ran: %d", charges.Load())
+	}
+}
+
+func TestIdempotencyKeyIsBoundToRequestAndCaller(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	store := NewLRUIdempotencyStore(0)
+	var charges atomic.Int64
+	api := map[string]any{
+		"charge": func(args ...any) any { return charges.Add(1) },
+		"refund": func(args ...any) any { return "refunded" },
+	}
+	connect := func(subject string) *Client {
+		clientTransport, serverTransport := NewPipeTransportPair()
+		server := NewServer(serverTransport, api, WithIdempotencyStore(store), WithIdentity(Identity{Subject: subject}))
+		client := NewClient(clientTransport, WithTimeout(2*time.Second))
+		t.Cleanup(func() {
+			_ = client.Close()
+			_ = server.Close()
+		})
+		return client
+	}
+	alice, bob := connect("alice"), connect("bob")
+
+	ctx := ContextWithIdempotencyKey(context.Background(), "order-1")
+	if result, err := alice.CallContext(ctx, "charge", 10); err != nil || !valuesEqual(1, result) {
+		t.Fatalf("charge: %#v %v", result, err)
+	}
+	if _, err := alice.CallContext(ctx, "refund", 10); err == nil || !strings.Contains(err.Error(), ErrIdempotencyKeyReused.Error()) {
+		t.Fatalf("key reused for another method: %v", err)
+	}
+	if _, err := alice.CallContext(ctx, "charge", 20); err == nil || !strings.Contains(err.Error(), ErrIdempotencyKeyReused.Error()) {
+		t.Fatalf("key reused with other args: %v", err)
+	}
+	// Another caller's key of the same name is its own.
+	if result, err := bob.CallContext(ctx, "charge", 10); err != nil || !valuesEqual(2, result) {
+		t.Fatalf("bob replayed alice's response: %#v %v", result, err)
+	}
+	if result, err := alice.CallContext(ctx, "charge", 10); err != nil || !valuesEqual(1, result) {
+		t.Fatalf("retry: %#v %v", result, err)
+	}
+}
+
+func TestLRUIdempotencyStoreEvictsOldest(t *testing.T) {
+	store := NewLRUIdempotencyStore(2)
+	store.Put("a", IdempotentResponse{Value: 1})
+	store.Put("b", IdempotentResponse{Value: 2})
+	store.Get("a")
+	store.Put("c", IdempotentResponse{Value: 3})
+	if _, ok := store.Get("b"); ok {
+		t.Fatal("b should have been evicted")
+	}
+	if response, ok := store.Get("a"); !ok || response.Value != 1 {
+		t.Fatalf("a: %#v %v", response, ok)
+	}
+	if store.Len() != 2 {
+		t.Fatalf("len %d", store.Len())
+	}
+}
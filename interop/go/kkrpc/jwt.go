@@ -0,0 +1,120 @@
+package kkrpc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("kkrpc: invalid token")
+
+// ParseJWT verifies a compact JWT and returns the identity it asserts, with
+// "sub" as the subject and every claim. key selects the algorithms accepted:
+// a []byte secret for HS256/384/512, an *rsa.PublicKey for RS256/384/512 or an
+// *ecdsa.PublicKey for ES256/384/512, so a token cannot pick a weaker one.
+// Tokens past "exp" or before "nbf" are refused.
+func ParseJWT(token string, key any) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: not three segments", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return Identity{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	if err := verifyJWT(header.Alg, parts[0]+"."+parts[1], signature, key); err != nil {
+		return Identity{}, err
+	}
+	var claims map[string]any
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return Identity{}, err
+	}
+	now := time.Now().Unix()
+	if exp, ok := claims["exp"].(float64); ok && now >= int64(exp) {
+		return Identity{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return Identity{}, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	subject, _ := claims["sub"].(string)
+	return Identity{Subject: subject, Claims: claims}, nil
+}
+
+func decodeJWTSegment(segment string, target any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+func verifyJWT(alg, signed string, signature []byte, key any) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, alg)
+	}
+	var newHash func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		newHash, cryptoHash = sha256.New, crypto.SHA256
+	case "384":
+		newHash, cryptoHash = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, cryptoHash = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, alg)
+	}
+	valid := false
+	switch typed := key.(type) {
+	case []byte:
+		if alg[:2] != "HS" {
+			break
+		}
+		mac := hmac.New(newHash, typed)
+		mac.Write([]byte(signed))
+		valid = hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			break
+		}
+		digest := newHash()
+		digest.Write([]byte(signed))
+		valid = rsa.VerifyPKCS1v15(typed, cryptoHash, digest.Sum(nil), signature) == nil
+	case *ecdsa.PublicKey:
+		size := (typed.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			break
+		}
+		digest := newHash()
+		digest.Write([]byte(signed))
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(typed, digest.Sum(nil), r, s)
+	default:
+		return fmt.Errorf("kkrpc: unsupported jwt key %T", key)
+	}
+	if !valid {
+		return fmt.Errorf("%w: bad signature for alg %q", ErrInvalidToken, alg)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package kkrpctest
+
+import "errors"
+
+func openFDs() (map[int]string, error) {
+	return nil, errors.New("descriptors cannot be listed on this system")
+}
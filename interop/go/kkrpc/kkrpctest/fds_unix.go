@@ -0,0 +1,33 @@
+//go:build unix
+
+package kkrpctest
+
+import (
+	"os"
+	"strconv"
+)
+
+// openFDs maps the descriptors open in the process to what they refer to,
+// where the system says.
+func openFDs() (map[int]string, error) {
+	dir, err := os.Open("/dev/fd")
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	fds := make(map[int]string, len(names))
+	for _, name := range names {
+		fd, err := strconv.Atoi(name)
+		// The listing's own descriptor is not the caller's.
+		if err != nil || uintptr(fd) == dir.Fd() {
+			continue
+		}
+		target, _ := os.Readlink("/dev/fd/" + name)
+		fds[fd] = target
+	}
+	return fds, nil
+}
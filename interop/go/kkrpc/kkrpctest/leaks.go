@@ -0,0 +1,224 @@
+// Package kkrpctest checks that code built on kkrpc cleans up after itself:
+// that closing its channels and transports stops their goroutines and
+// releases their file descriptors.
+//
+//	func TestPlugin(t *testing.T) {
+//		kkrpctest.AssertNoGoroutineLeaks(t)
+//		kkrpctest.AssertNoFDLeaks(t)
+//		transport, err := kkrpc.StartProcess(ctx, kkrpc.ProcessOptions{Path: pluginPath})
+//		// ...
+//		defer transport.Close()
+//	}
+//
+// Both compare against the start of the test, so they do not work with
+// t.Parallel.
+package kkrpctest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LeakTimeout is how long the assertions wait for goroutines to exit and
+// descriptors to close after a test, since closing a transport only starts
+// its teardown.
+var LeakTimeout = 2 * time.Second
+
+// permanentFrames start the stacks of goroutines that outlive a test by
+// design: a kkrpc pool worker waiting for work, which exits once idle for a
+// while, and the signal loop that the first signal.Notify starts.
+var permanentFrames = []string{
+	"kkrpc-interop/kkrpc.(*Pool).worker(",
+	"os/signal.signal_recv(",
+}
+
+// AssertNoGoroutineLeaks fails t if goroutines started during the test, or
+// whose stack changed during it, are still running LeakTimeout after it,
+// listing their stacks. Comparing stacks catches pool workers that existed
+// before the test and were left stuck in one of its handlers. Idle pool
+// workers, the signal loop and goroutines whose stack mentions one of
+// ignore, e.g. "net/http.(*persistConn)", are allowed.
+// Call it first, so that it checks after the test's own cleanups.
+func AssertNoGoroutineLeaks(t testing.TB, ignore ...string) {
+	t.Helper()
+	// Goroutines parked at the start are pinned to where they were parked;
+	// those on their way somewhere are only known by id.
+	baseline := make(map[int]string)
+	for _, g := range goroutines(true) {
+		baseline[g.id] = anywhere
+		if g.parked() {
+			baseline[g.id] = g.location()
+		}
+	}
+	t.Cleanup(func() {
+		// The cleanup runs on the test's goroutine, whose stack has moved on.
+		self := goroutines(false)[0].id
+		var leaked []goroutine
+		waitFor(func() bool {
+			leaked = leaked[:0]
+			for _, g := range goroutines(true) {
+				if g.id != self && !g.unchanged(baseline) && !g.permanent() && !g.mentions(ignore) {
+					leaked = append(leaked, g)
+				}
+			}
+			return len(leaked) == 0
+		})
+		if len(leaked) == 0 {
+			return
+		}
+		stacks := make([]string, len(leaked))
+		for i, g := range leaked {
+			stacks[i] = g.stack
+		}
+		t.Errorf("kkrpctest: %d goroutines leaked:\n\n%s", len(leaked), strings.Join(stacks, "\n\n"))
+	})
+}
+
+// AssertNoFDLeaks fails t if the process holds more file descriptors
+// LeakTimeout after the test than before it, listing the new ones. It only
+// logs where descriptors cannot be listed, as on Windows.
+// Call it first, so that it checks after the test's own cleanups.
+func AssertNoFDLeaks(t testing.TB) {
+	t.Helper()
+	// The runtime keeps the descriptors of its network poller once opened;
+	// open them now so that they are not blamed on the test.
+	if r, w, err := os.Pipe(); err == nil {
+		r.Close()
+		w.Close()
+	}
+	baseline, err := openFDs()
+	if err != nil {
+		t.Logf("kkrpctest: cannot check for descriptor leaks: %v", err)
+		return
+	}
+	t.Cleanup(func() {
+		var current map[int]string
+		waitFor(func() bool {
+			current, err = openFDs()
+			return err != nil || len(current) <= len(baseline)
+		})
+		if err != nil {
+			t.Errorf("kkrpctest: list descriptors: %v", err)
+			return
+		}
+		if len(current) <= len(baseline) {
+			return
+		}
+		var added []string
+		for fd, target := range current {
+			if _, ok := baseline[fd]; !ok {
+				added = append(added, fmt.Sprintf("%d %s", fd, target))
+			}
+		}
+		sort.Strings(added)
+		t.Errorf("kkrpctest: %d file descriptors leaked; new since the test started:\n%s",
+			len(current)-len(baseline), strings.Join(added, "\n"))
+	})
+}
+
+func waitFor(done func() bool) {
+	deadline := time.Now().Add(LeakTimeout)
+	for !done() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type goroutine struct {
+	id    int
+	stack string
+}
+
+// frames is the stack without its header, whose wait time changes while the
+// goroutine stays put.
+func (g goroutine) frames() string {
+	_, frames, _ := strings.Cut(g.stack, "\n")
+	return frames
+}
+
+// location is where g is: its frames without the argument values, some of
+// which are only guesses that change while the goroutine stays put.
+func (g goroutine) location() string {
+	lines := strings.Split(g.frames(), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "\t") {
+			if open := strings.LastIndex(line, "("); open > 0 {
+				lines[i] = line[:open]
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// anywhere is the baseline location of a goroutine that was not parked.
+const anywhere = ""
+
+// unchanged reports whether g was there at the start of the test, parked
+// where it is now if it was parked then.
+func (g goroutine) unchanged(baseline map[int]string) bool {
+	location, ok := baseline[g.id]
+	return ok && (location == anywhere || location == g.location())
+}
+
+// parked reports whether g is waiting rather than running or about to.
+func (g goroutine) parked() bool {
+	// The header reads "goroutine 42 [chan receive, 2 minutes]:".
+	_, state, _ := strings.Cut(g.stack, "[")
+	state, _, _ = strings.Cut(state, "]")
+	state, _, _ = strings.Cut(state, ",")
+	return state != "running" && state != "runnable"
+}
+
+// permanent reports whether g is parked where a permanent goroutine idles;
+// a pool worker running a handler has the handler's frame on top instead.
+func (g goroutine) permanent() bool {
+	frames := g.frames()
+	for _, frame := range permanentFrames {
+		if strings.HasPrefix(frames, frame) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g goroutine) mentions(ignore []string) bool {
+	for _, fragment := range ignore {
+		if strings.Contains(g.stack, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutines lists the stacks of all goroutines, or only the calling one.
+func goroutines(all bool) []goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, all)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var list []goroutine
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		// Each stack starts "goroutine 42 [chan receive]:".
+		fields := strings.Fields(string(stack))
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		list = append(list, goroutine{id: id, stack: strings.TrimSpace(string(stack))})
+	}
+	return list
+}
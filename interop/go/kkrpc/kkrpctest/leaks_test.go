@@ -0,0 +1,131 @@
+package kkrpctest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// recorder runs an assertion's cleanup on demand and keeps its failures.
+type recorder struct {
+	testing.TB
+	cleanups []func()
+	failures []string
+}
+
+func (r *recorder) Helper()                         {}
+func (r *recorder) Logf(format string, args ...any) {}
+func (r *recorder) Cleanup(cleanup func())          { r.cleanups = append(r.cleanups, cleanup) }
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) finish() []string {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+	return r.failures
+}
+
+func shortLeakTimeout(t *testing.T) {
+	previous := LeakTimeout
+	LeakTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { LeakTimeout = previous })
+}
+
+func leakyWorker(stop chan struct{}) {
+	<-stop
+}
+
+func TestAssertNoGoroutineLeaks(t *testing.T) {
+	shortLeakTimeout(t)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	r := &recorder{TB: t}
+	AssertNoGoroutineLeaks(r)
+	go leakyWorker(stop)
+	failures := r.finish()
+	if len(failures) != 1 || !strings.Contains(failures[0], "leakyWorker") {
+		t.Fatalf("leak not reported: %q", failures)
+	}
+
+	r = &recorder{TB: t}
+	AssertNoGoroutineLeaks(r, "leakyWorker")
+	go leakyWorker(stop)
+	if failures := r.finish(); len(failures) != 0 {
+		t.Fatalf("ignored goroutine reported: %q", failures)
+	}
+
+	r = &recorder{TB: t}
+	AssertNoGoroutineLeaks(r)
+	done := make(chan struct{})
+	go func() { close(done) }()
+	<-done
+	if failures := r.finish(); len(failures) != 0 {
+		t.Fatalf("finished goroutine reported: %q", failures)
+	}
+}
+
+func stuckHandler(started, stop chan struct{}) {
+	close(started)
+	<-stop
+}
+
+func TestAssertNoGoroutineLeaksFlagsStuckPoolWorkers(t *testing.T) {
+	shortLeakTimeout(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	pool := kkrpc.NewPool(1)
+	// Start the only worker before the baseline, as an earlier test would.
+	ran := make(chan struct{})
+	pool.Go(func() { close(ran) })
+	<-ran
+
+	r := &recorder{TB: t}
+	AssertNoGoroutineLeaks(r)
+	started := make(chan struct{})
+	pool.Go(func() { stuckHandler(started, stop) })
+	<-started
+	failures := r.finish()
+	if len(failures) != 1 || !strings.Contains(failures[0], "stuckHandler") {
+		t.Fatalf("stuck worker not reported: %q", failures)
+	}
+}
+
+func TestAssertNoFDLeaks(t *testing.T) {
+	if _, err := openFDs(); err != nil {
+		t.Skip(err)
+	}
+	shortLeakTimeout(t)
+	path := filepath.Join(t.TempDir(), "leaked")
+
+	r := &recorder{TB: t}
+	AssertNoFDLeaks(r)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	failures := r.finish()
+	if len(failures) != 1 || (runtime.GOOS == "linux" && !strings.Contains(failures[0], path)) {
+		t.Fatalf("leak not reported: %q", failures)
+	}
+
+	r = &recorder{TB: t}
+	AssertNoFDLeaks(r)
+	other, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if failures := r.finish(); len(failures) != 0 {
+		t.Fatalf("closed descriptor reported: %q", failures)
+	}
+}
@@ -0,0 +1,204 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type DecodeLimits struct {
+	MaxBytes    int
+	MaxDepth    int
+	MaxElements int
+}
+
+var DefaultDecodeLimits = DecodeLimits{MaxDepth: 256}
+
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol error (" + e.Code + "): " + e.Message
+}
+
+func protocolErrorPayload(err error, raw string) map[string]any {
+	protocolErr, ok := err.(*ProtocolError)
+	if !ok {
+		protocolErr = &ProtocolError{Code: "invalid_message", Message: err.Error()}
+	}
+	payload := map[string]any{
+		"t":    "protocol_error",
+		"code": protocolErr.Code,
+		"m":    protocolErr.Message,
+	}
+	if id := frameHeader(raw).id; id != "" {
+		payload["id"] = id
+	}
+	return payload
+}
+
+// frameFields holds the top-level "t" and "id" of a frame.
+type frameFields struct {
+	t, id string
+}
+
+// limitErrorCodes are the ProtocolError codes of frames rejected by
+// DecodeLimits.
+var limitErrorCodes = map[string]bool{"too_large": true, "too_deep": true, "too_many_elements": true}
+
+// frameHeader reads the top-level "t" and "id" strings of a frame without
+// decoding it, so a frame too large, too deep or cut short still names the
+// request it belonged to. Fields of the same names nested inside arguments,
+// like callback envelopes, are skipped.
+func frameHeader(raw string) frameFields {
+	var h frameFields
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return h
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return h
+		}
+		value, err := decoder.Token()
+		if err != nil {
+			return h
+		}
+		if delim, ok := value.(json.Delim); ok {
+			if skipNested(decoder, delim) != nil {
+				return h
+			}
+			continue
+		}
+		text, _ := value.(string)
+		switch key {
+		case "t":
+			h.t = text
+		case "id":
+			if len(text) <= 128 {
+				h.id = text
+			}
+		}
+		if h.t != "" && h.id != "" {
+			return h
+		}
+	}
+	return h
+}
+
+// skipNested consumes the tokens of the object or array opened by delim.
+func skipNested(decoder *json.Decoder, delim json.Delim) error {
+	if delim != '{' && delim != '[' {
+		return errors.New("unexpected delimiter")
+	}
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+func protocolErrorFromMessage(message map[string]any) *ProtocolError {
+	code, _ := message["code"].(string)
+	text, _ := message["m"].(string)
+	return &ProtocolError{Code: code, Message: text}
+}
+
+func (l DecodeLimits) check(raw string) error {
+	if l.MaxBytes > 0 && len(raw) > l.MaxBytes {
+		return &ProtocolError{Code: "too_large", Message: fmt.Sprintf("message is %d bytes, limit is %d", len(raw), l.MaxBytes)}
+	}
+	if l.MaxDepth <= 0 && l.MaxElements <= 0 {
+		return nil
+	}
+	depth, elements := 0, 0
+	inString, escaped := false, false
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			elements++
+			if l.MaxDepth > 0 && depth > l.MaxDepth {
+				return &ProtocolError{Code: "too_deep", Message: fmt.Sprintf("nesting exceeds depth %d", l.MaxDepth)}
+			}
+		case '}', ']':
+			depth--
+		case ',':
+			elements++
+		}
+		if l.MaxElements > 0 && elements > l.MaxElements {
+			return &ProtocolError{Code: "too_many_elements", Message: fmt.Sprintf("message has more than %d elements", l.MaxElements)}
+		}
+	}
+	return nil
+}
+
+func DecodeMessageWithLimits(raw string, limits DecodeLimits) (map[string]any, error) {
+	if err := limits.check(raw); err != nil {
+		return nil, err
+	}
+	return decodeJSONMessage(raw)
+}
+
+// checkValue applies MaxDepth and MaxElements to a value decoded by a codec
+// that cannot enforce them itself.
+func (l DecodeLimits) checkValue(value any) error {
+	if l.MaxDepth <= 0 && l.MaxElements <= 0 {
+		return nil
+	}
+	elements := 0
+	var walk func(value any, depth int) error
+	walk = func(value any, depth int) error {
+		var children []any
+		switch typed := value.(type) {
+		case []any:
+			children = typed
+		case map[string]any:
+			for _, child := range typed {
+				children = append(children, child)
+			}
+		default:
+			return nil
+		}
+		if l.MaxDepth > 0 && depth > l.MaxDepth {
+			return &ProtocolError{Code: "too_deep", Message: fmt.Sprintf("nesting exceeds depth %d", l.MaxDepth)}
+		}
+		elements += 1 + len(children)
+		if l.MaxElements > 0 && elements > l.MaxElements {
+			return &ProtocolError{Code: "too_many_elements", Message: fmt.Sprintf("message has more than %d elements", l.MaxElements)}
+		}
+		for _, child := range children {
+			if err := walk(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(value, 1)
+}
@@ -0,0 +1,370 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// longPollTimeout is how long a poll waits for messages before answering
+	// 204, kept below common proxy idle timeouts.
+	longPollTimeout = 25 * time.Second
+	// longPollSessionTTL closes sessions whose client stopped polling.
+	longPollSessionTTL = 2 * longPollTimeout
+	// longPollBatch caps how many messages one poll returns.
+	longPollBatch = 256
+)
+
+// LongPollServerTransport is one peer connected through LongPollHandler.
+// Messages written while no poll is waiting are queued and returned together
+// by the next poll.
+type LongPollServerTransport struct {
+	session  string
+	inbound  chan string
+	outbound chan string
+	polled   chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+	onClose  func()
+}
+
+func (t *LongPollServerTransport) Session() string {
+	return t.session
+}
+
+func (t *LongPollServerTransport) Read() (string, error) {
+	select {
+	case message := <-t.inbound:
+		return message, nil
+	case <-t.closed:
+		return "", ErrTransportClosed
+	}
+}
+
+func (t *LongPollServerTransport) Write(message string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.outbound <- strings.TrimRight(message, "\n"):
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+func (t *LongPollServerTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		t.onClose()
+	})
+	return nil
+}
+
+// expire closes the session once the client has not polled for ttl.
+func (t *LongPollServerTransport) expire(ttl time.Duration) {
+	timer := time.NewTimer(ttl)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.polled:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(ttl)
+		case <-timer.C:
+			_ = t.Close()
+			return
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (t *LongPollServerTransport) touch() {
+	select {
+	case t.polled <- struct{}{}:
+	default:
+	}
+}
+
+// poll waits up to timeout for a message, then drains whatever else is queued.
+func (t *LongPollServerTransport) poll(ctx context.Context, timeout time.Duration) ([]string, error) {
+	t.touch()
+	defer t.touch()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var batch []string
+	select {
+	case message := <-t.outbound:
+		batch = append(batch, message)
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.closed:
+		return nil, ErrTransportClosed
+	}
+	for len(batch) < longPollBatch {
+		select {
+		case message := <-t.outbound:
+			batch = append(batch, message)
+		default:
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+type longPollHandler struct {
+	accept   func(*LongPollServerTransport)
+	timeout  time.Duration
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[string]*LongPollServerTransport
+}
+
+// LongPollHandler serves kkrpc where neither WebSockets nor event streams get
+// through. A GET without a session opens one and answers its id. A GET with
+// ?session=<id> waits for messages and answers all queued ones, one per line,
+// or 204 after a quiet poll. POSTs with ?session=<id> carry the client's
+// messages, one per line, and DELETE ends the session. Sessions not polled
+// for a while are closed. accept runs on its own goroutine for each session.
+func LongPollHandler(accept func(*LongPollServerTransport)) http.Handler {
+	return &longPollHandler{
+		accept:   accept,
+		timeout:  longPollTimeout,
+		ttl:      longPollSessionTTL,
+		sessions: make(map[string]*LongPollServerTransport),
+	}
+}
+
+func (h *longPollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if r.Method == http.MethodGet && session == "" {
+		h.open(w)
+		return
+	}
+	h.mu.Lock()
+	transport := h.sessions[session]
+	h.mu.Unlock()
+	if transport == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		batch, err := transport.poll(r.Context(), h.timeout)
+		if errors.Is(err, ErrTransportClosed) {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		if len(batch) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = io.WriteString(w, strings.Join(batch, "\n")+"\n")
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPRequest))
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			select {
+			case transport.inbound <- line:
+			case <-transport.closed:
+				http.Error(w, "session closed", http.StatusGone)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodDelete:
+		_ = transport.Close()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *longPollHandler) open(w http.ResponseWriter) {
+	transport := &LongPollServerTransport{
+		session:  newSessionID(),
+		inbound:  make(chan string, 64),
+		outbound: make(chan string, 1024),
+		polled:   make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	transport.onClose = func() {
+		h.mu.Lock()
+		delete(h.sessions, transport.session)
+		h.mu.Unlock()
+	}
+	h.mu.Lock()
+	h.sessions[transport.session] = transport
+	h.mu.Unlock()
+	go transport.expire(h.ttl)
+	go h.accept(transport)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = io.WriteString(w, transport.session)
+}
+
+type LongPollOptions struct {
+	// Headers are added to every request.
+	Headers http.Header
+	// Client performs the requests; defaults to http.DefaultClient. Its
+	// Timeout must exceed the server's poll timeout of 25 seconds.
+	Client *http.Client
+}
+
+// LongPollTransport connects to a LongPollHandler. It keeps one poll in
+// flight at a time and POSTs every message it writes.
+type LongPollTransport struct {
+	url     string
+	client  *http.Client
+	headers http.Header
+	events  chan string
+	done    chan struct{}
+	err     error
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// DialLongPoll opens a session at url and starts polling it.
+func DialLongPoll(ctx context.Context, rawURL string, opts LongPollOptions) (*LongPollTransport, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	t := &LongPollTransport{client: client, headers: opts.Headers}
+	body, status, err := t.do(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || body == "" {
+		return nil, fmt.Errorf("HTTP error %d", status)
+	}
+	sessionURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	query := sessionURL.Query()
+	query.Set("session", strings.TrimSpace(body))
+	sessionURL.RawQuery = query.Encode()
+
+	t.url = sessionURL.String()
+	t.events = make(chan string, 64)
+	t.done = make(chan struct{})
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	go t.pollLoop()
+	return t, nil
+}
+
+func (t *LongPollTransport) do(ctx context.Context, method string, target string, body io.Reader) (string, int, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return "", 0, err
+	}
+	for key, values := range t.headers {
+		request.Header[key] = values
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "text/plain")
+	}
+	response, err := t.client.Do(request)
+	if err != nil {
+		return "", 0, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxHTTPResponse))
+	return string(data), response.StatusCode, err
+}
+
+func (t *LongPollTransport) pollLoop() {
+	defer close(t.done)
+	for {
+		body, status, err := t.do(t.ctx, http.MethodGet, t.url, nil)
+		if err != nil {
+			t.err = err
+			return
+		}
+		switch status {
+		case http.StatusOK:
+		case http.StatusNoContent:
+			continue
+		case http.StatusNotFound, http.StatusGone:
+			t.err = ErrTransportClosed
+			return
+		default:
+			t.err = fmt.Errorf("HTTP error %d", status)
+			return
+		}
+		for _, line := range strings.Split(body, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			select {
+			case t.events <- line:
+			case <-t.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (t *LongPollTransport) Read() (string, error) {
+	select {
+	case message := <-t.events:
+		return message, nil
+	case <-t.done:
+		if t.ctx.Err() != nil {
+			return "", ErrTransportClosed
+		}
+		return "", t.err
+	}
+}
+
+func (t *LongPollTransport) Write(message string) error {
+	if t.ctx.Err() != nil {
+		return ErrTransportClosed
+	}
+	_, status, err := t.do(t.ctx, http.MethodPost, t.url, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	if status != http.StatusAccepted {
+		return fmt.Errorf("HTTP error %d", status)
+	}
+	return nil
+}
+
+// Close stops polling and ends the session on the server.
+func (t *LongPollTransport) Close() error {
+	if t.ctx.Err() != nil {
+		return nil
+	}
+	t.cancel()
+	<-t.done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := t.do(ctx, http.MethodDelete, t.url, nil)
+	return err
+}
@@ -0,0 +1,60 @@
+package kkrpc
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestLongPollTransportRoundTripWithCallbacks(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	handler := LongPollHandler(func(transport *LongPollServerTransport) {
+		NewChannel(transport, map[string]any{
+			"greet": func(args ...any) any { return "hello " + toString(args[0]) },
+			"ticks": func(args ...any) any {
+				onTick := args[1].(Callback)
+				for i := 0; i < int(args[0].(float64)); i++ {
+					onTick(i)
+				}
+				return true
+			},
+		})
+	}).(*longPollHandler)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	transport, err := DialLongPoll(context.Background(), server.URL+"/poll", LongPollOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ Transport = transport
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	if result, err := client.Call("greet", "poll"); err != nil || result != "hello poll" {
+		t.Fatalf("greet: %#v %v", result, err)
+	}
+	ticks := make(chan any, 5)
+	if _, err := client.Call("ticks", 5, func(args ...any) { ticks <- args[0] }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(time.Second):
+			t.Fatalf("tick %d not delivered", i)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if len(handler.sessions) != 0 {
+		t.Fatalf("%d sessions left open", len(handler.sessions))
+	}
+}
@@ -0,0 +1,331 @@
+package kkrpc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Hook interface {
+	CallStarted(method string)
+	CallFinished(method string, duration time.Duration, err error)
+}
+
+// SizeHook is implemented by hooks that also want the encoded size of every
+// request and response, attributed to the method called. It sees both
+// directions, so it works on clients as well as servers.
+type SizeHook interface {
+	RequestSize(method string, bytes int)
+	ResponseSize(method string, bytes int)
+}
+
+// UnknownMethod is the method that calls which never reached a method, because
+// the path was not found or the call was refused, are reported under, so that
+// callers cannot grow per-method metrics without bound.
+const UnknownMethod = "(unknown)"
+
+type hookSet []Hook
+
+// servedCall reports one call a server handles. The method is only known once
+// the call resolved; a call that never does is reported as UnknownMethod when
+// it finishes.
+type servedCall struct {
+	hooks    hookSet
+	o        *options
+	id       string
+	started  time.Time
+	mu       sync.Mutex
+	method   string
+	finished bool
+}
+
+func (o *options) serveCall(id string) *servedCall {
+	return &servedCall{hooks: o.hooks, o: o, id: id, started: time.Now()}
+}
+
+// resolved names the method; the hooks see the call start now.
+func (c *servedCall) resolved(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.method != "" {
+		return
+	}
+	c.method = method
+	for _, hook := range c.hooks {
+		hook.CallStarted(method)
+	}
+	c.o.sizes.resolve(c.id, method)
+	c.o.serialization.resolve(c.id, method)
+}
+
+func (c *servedCall) finish(err error) {
+	c.resolved(UnknownMethod)
+	c.mu.Lock()
+	finished := c.finished
+	c.finished = true
+	c.mu.Unlock()
+	if finished {
+		return
+	}
+	elapsed := time.Since(c.started)
+	for _, hook := range c.hooks {
+		hook.CallFinished(c.method, elapsed, err)
+	}
+}
+
+type servedCallKey struct{}
+
+// resolvedCall tells the hooks of the call served with ctx which method it
+// reached.
+func resolvedCall(ctx context.Context, path []string) {
+	if call, ok := ctx.Value(servedCallKey{}).(*servedCall); ok {
+		call.resolved(strings.Join(path, "."))
+	}
+}
+
+// sizeTracker pairs responses with the method of their request so sizes can
+// be attributed to it. Requests this side serves are reported when answered,
+// under the method they resolved to. Like the serialization sampler it
+// remembers at most maxSampledRequests unanswered requests.
+type sizeTracker struct {
+	hooks    []SizeHook
+	mu       sync.Mutex
+	requests pendingRequests[*trackedRequest]
+}
+
+type trackedRequest struct {
+	method string
+	// inbound requests are served here; size is theirs.
+	inbound bool
+	size    int
+}
+
+func (t *sizeTracker) observe(payload map[string]any, size int, outbound bool) {
+	if t == nil {
+		return
+	}
+	id, _ := payload["id"].(string)
+	switch payload["t"] {
+	case "q":
+		request := &trackedRequest{inbound: !outbound, size: size}
+		if outbound {
+			request.method = strings.Join(payloadPath(payload), ".")
+		}
+		t.mu.Lock()
+		t.requests.add(id, request)
+		t.mu.Unlock()
+		if outbound {
+			for _, hook := range t.hooks {
+				hook.RequestSize(request.method, size)
+			}
+		}
+	case "r":
+		t.mu.Lock()
+		request, ok := t.requests.take(id)
+		t.mu.Unlock()
+		if !ok || request.inbound != outbound {
+			return
+		}
+		method := request.method
+		if method == "" {
+			method = UnknownMethod
+		}
+		for _, hook := range t.hooks {
+			if request.inbound {
+				hook.RequestSize(method, request.size)
+			}
+			hook.ResponseSize(method, size)
+		}
+	}
+}
+
+// resolve names the method of a request this side serves.
+func (t *sizeTracker) resolve(id, method string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if request, ok := t.requests.get(id); ok && request.inbound {
+		request.method = method
+	}
+	t.mu.Unlock()
+}
+
+// SizeBuckets are the upper bounds, in bytes, of the SizeHistogram buckets.
+var SizeBuckets = [...]int{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// SizeHistogram counts payloads by encoded size. Counts[i] holds those up to
+// SizeBuckets[i] bytes that did not fit a smaller bucket; the last count holds
+// the larger ones.
+type SizeHistogram struct {
+	Counts     [len(SizeBuckets) + 1]uint64
+	TotalBytes uint64
+	MaxBytes   int
+}
+
+func (h *SizeHistogram) observe(size int) {
+	bucket := sort.SearchInts(SizeBuckets[:], size)
+	h.Counts[bucket]++
+	h.TotalBytes += uint64(size)
+	h.MaxBytes = max(h.MaxBytes, size)
+}
+
+func (h SizeHistogram) Count() uint64 {
+	var count uint64
+	for _, n := range h.Counts {
+		count += n
+	}
+	return count
+}
+
+func (h SizeHistogram) MeanBytes() int {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return int(h.TotalBytes / count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile,
+// or MaxBytes when that is the overflow bucket.
+func (h SizeHistogram) Quantile(q float64) int {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(count-1)) + 1
+	var seen uint64
+	for i, n := range h.Counts[:len(SizeBuckets)] {
+		seen += n
+		if seen >= rank {
+			return min(SizeBuckets[i], h.MaxBytes)
+		}
+	}
+	return h.MaxBytes
+}
+
+// LatencyBuckets are the upper bounds of the LatencyHistogram buckets.
+var LatencyBuckets = [...]time.Duration{
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// LatencyHistogram counts call durations the way SizeHistogram counts sizes.
+type LatencyHistogram struct {
+	Counts [len(LatencyBuckets) + 1]uint64
+}
+
+func (h *LatencyHistogram) observe(duration time.Duration) {
+	bucket := sort.Search(len(LatencyBuckets), func(i int) bool { return LatencyBuckets[i] >= duration })
+	h.Counts[bucket]++
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile,
+// capped at limit, which is MethodMetrics.MaxDuration for the overflow bucket.
+func (h LatencyHistogram) Quantile(q float64, limit time.Duration) time.Duration {
+	var count uint64
+	for _, n := range h.Counts {
+		count += n
+	}
+	if count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(count-1)) + 1
+	var seen uint64
+	for i, n := range h.Counts[:len(LatencyBuckets)] {
+		seen += n
+		if seen >= rank {
+			return min(LatencyBuckets[i], limit)
+		}
+	}
+	return limit
+}
+
+type MethodMetrics struct {
+	Method        string
+	Calls         uint64
+	Errors        uint64
+	InFlight      int64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	Latencies     LatencyHistogram
+	RequestSizes  SizeHistogram
+	ResponseSizes SizeHistogram
+}
+
+func (m MethodMetrics) MeanDuration() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return m.TotalDuration / time.Duration(m.Calls)
+}
+
+// LatencyQuantile estimates the q-th quantile of the call durations, e.g.
+// 0.99 for p99, to the precision of LatencyBuckets.
+func (m MethodMetrics) LatencyQuantile(q float64) time.Duration {
+	return m.Latencies.Quantile(q, m.MaxDuration)
+}
+
+type Metrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{methods: make(map[string]*MethodMetrics)}
+}
+
+func (m *Metrics) CallStarted(method string) {
+	m.mu.Lock()
+	m.method(method).InFlight++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) CallFinished(method string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.method(method)
+	entry.InFlight--
+	entry.Calls++
+	if err != nil {
+		entry.Errors++
+	}
+	entry.TotalDuration += duration
+	entry.MaxDuration = max(entry.MaxDuration, duration)
+	entry.Latencies.observe(duration)
+}
+
+func (m *Metrics) RequestSize(method string, bytes int) {
+	m.mu.Lock()
+	m.method(method).RequestSizes.observe(bytes)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) ResponseSize(method string, bytes int) {
+	m.mu.Lock()
+	m.method(method).ResponseSizes.observe(bytes)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) Snapshot() []MethodMetrics {
+	m.mu.Lock()
+	snapshot := make([]MethodMetrics, 0, len(m.methods))
+	for _, entry := range m.methods {
+		snapshot = append(snapshot, *entry)
+	}
+	m.mu.Unlock()
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Method < snapshot[j].Method })
+	return snapshot
+}
+
+func (m *Metrics) method(method string) *MethodMetrics {
+	entry, ok := m.methods[method]
+	if !ok {
+		entry = &MethodMetrics{Method: method}
+		m.methods[method] = entry
+	}
+	return entry
+}
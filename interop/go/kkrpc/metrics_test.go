@@ -0,0 +1,174 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestMetricsHookCountsCallsPerMethod(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+
+	metrics := NewMetrics()
+	api := map[string]any{
+		"ok":   func(args ...any) any { return "fine" },
+		"fail": func(args ...any) any { return errors.New("broken") },
+	}
+	_ = NewServer(transport, api, WithHook(metrics))
+
+	for i, method := range []string{"ok", "ok", "fail", "missing", "also.missing"} {
+		request, _ := EncodeMessage(map[string]any{"t": "q", "id": toString(i), "op": "call", "p": []any{method}})
+		transport.in <- request
+		select {
+		case <-transport.out:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("response %d not received", i)
+		}
+	}
+
+	byMethod := map[string]MethodMetrics{}
+	for _, entry := range metrics.Snapshot() {
+		byMethod[entry.Method] = entry
+	}
+	if byMethod["ok"].Calls != 2 || byMethod["ok"].Errors != 0 {
+		t.Fatalf("unexpected ok metrics: %+v", byMethod["ok"])
+	}
+	if byMethod["fail"].Calls != 1 || byMethod["fail"].Errors != 1 {
+		t.Fatalf("unexpected fail metrics: %+v", byMethod["fail"])
+	}
+	// Unknown names share one entry, so callers cannot add entries at will.
+	unknown := byMethod[UnknownMethod]
+	if unknown.Calls != 2 || unknown.Errors != 2 || unknown.RequestSizes.Count() != 2 || unknown.ResponseSizes.Count() != 2 {
+		t.Fatalf("unexpected unknown metrics: %+v", unknown)
+	}
+	if len(byMethod) != 3 {
+		t.Fatalf("expected ok, fail and %s, got %v", UnknownMethod, byMethod)
+	}
+	if byMethod["ok"].InFlight != 0 {
+		t.Fatalf("expected no calls in flight, got %d", byMethod["ok"].InFlight)
+	}
+}
+
+func TestSerializationStatsAttributeResponsesToMethods(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	serverStats := NewSerializationStats()
+	clientStats := NewSerializationStats()
+	_ = NewServer(right, map[string]any{
+		"blob": func(args ...any) any { return strings.Repeat("b", 1000) },
+	}, WithSerializationStats(serverStats, 1))
+	client := NewClient(left, WithSerializationStats(clientStats, 1))
+
+	if _, err := client.Call("blob"); err != nil {
+		t.Fatalf("blob: %v", err)
+	}
+
+	server := serverStats.Snapshot()
+	if len(server) != 1 || server[0].Method != "blob" || server[0].Decoded != 1 || server[0].Encoded != 1 {
+		t.Fatalf("unexpected server stats: %+v", server)
+	}
+	if server[0].MaxEncodedBytes < 1000 {
+		t.Fatalf("response size not recorded: %+v", server[0])
+	}
+	clientSide := clientStats.Snapshot()
+	if len(clientSide) != 1 || clientSide[0].Encoded != 1 || clientSide[0].Decoded != 1 || clientSide[0].MaxDecodedBytes < 1000 {
+		t.Fatalf("unexpected client stats: %+v", clientSide)
+	}
+}
+
+func TestMetricsRecordPayloadSizesPerMethod(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := newConnectedTestTransports()
+	defer left.Close()
+	defer right.Close()
+
+	metrics := NewMetrics()
+	_ = NewServer(right, map[string]any{
+		"blob": MustFunc(func(n int) string { return strings.Repeat("b", n) }),
+	}, WithHook(metrics))
+	client := NewClient(left, WithTimeout(2*time.Second))
+	for _, n := range []int{10, 2000, 100000} {
+		if _, err := client.Call("blob", n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blob := metrics.Snapshot()[0]
+	if blob.Method != "blob" || blob.RequestSizes.Count() != 3 || blob.ResponseSizes.Count() != 3 {
+		t.Fatalf("unexpected size metrics: %+v", blob)
+	}
+	if blob.RequestSizes.Counts[0] != 3 {
+		t.Fatalf("requests should all be small: %v", blob.RequestSizes.Counts)
+	}
+	responses := blob.ResponseSizes
+	if responses.Counts[0] != 1 || responses.Counts[2] != 1 || responses.Counts[5] != 1 || responses.MaxBytes < 100000 {
+		t.Fatalf("unexpected response histogram: %+v", responses)
+	}
+	if p50 := responses.Quantile(0.5); p50 != 4<<10 {
+		t.Fatalf("p50 %d", p50)
+	}
+	if p100 := responses.Quantile(1); p100 != responses.MaxBytes {
+		t.Fatalf("p100 %d, max %d", p100, responses.MaxBytes)
+	}
+}
+
+func TestLatencyQuantilesUseBucketBounds(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	metrics := NewMetrics()
+	for _, d := range []time.Duration{300 * time.Microsecond, 3 * time.Millisecond, 4 * time.Millisecond, 40 * time.Millisecond, 30 * time.Second} {
+		metrics.CallStarted("work")
+		metrics.CallFinished("work", d, nil)
+	}
+	work := metrics.Snapshot()[0]
+	if got := work.LatencyQuantile(0); got != time.Millisecond {
+		t.Fatalf("p0 %v", got)
+	}
+	if got := work.LatencyQuantile(0.5); got != 5*time.Millisecond {
+		t.Fatalf("p50 %v", got)
+	}
+	if got := work.LatencyQuantile(0.75); got != 50*time.Millisecond {
+		t.Fatalf("p75 %v", got)
+	}
+	if got := work.LatencyQuantile(1); got != 30*time.Second {
+		t.Fatalf("p100 %v", got)
+	}
+}
+
+func TestUnansweredRequestsDoNotStopSampling(t *testing.T) {
+	pending := newPendingRequests[string]()
+	for i := 0; i < maxSampledRequests; i++ {
+		pending.add(toString(i), "lost")
+	}
+	pending.add("fresh", "method")
+	if _, ok := pending.get("fresh"); ok {
+		t.Fatal("remembered a request past the limit")
+	}
+
+	// Requests never answered age out, and sampling resumes.
+	for id, entry := range pending.entries {
+		entry.added = entry.added.Add(-sampledRequestTTL)
+		pending.entries[id] = entry
+	}
+	pending.lastSweep = time.Time{}
+	pending.add("fresh", "method")
+	if method, ok := pending.take("fresh"); !ok || method != "method" {
+		t.Fatalf("sampling did not resume: %q %v", method, ok)
+	}
+	if len(pending.entries) != 0 {
+		t.Fatalf("%d stale requests kept", len(pending.entries))
+	}
+}
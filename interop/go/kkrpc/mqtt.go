@@ -0,0 +1,463 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMQTTKeepAlive = 30 * time.Second
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttPubrec      = 5
+	mqttPubrel      = 6
+	mqttPubcomp     = 7
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+	mqttMaxRemain   = 268435455
+	mqttSubackError = 0x80
+)
+
+type MQTTOptions struct {
+	// URL is mqtt://[user:password@]host[:port], or mqtts:// for TLS; tcp://
+	// and ssl:// are accepted as aliases. The port defaults to 1883 or 8883.
+	URL string
+	// ClientID defaults to "kkrpc-<uuid>".
+	ClientID string
+	// Username and Password override the URL's credentials.
+	Username string
+	Password string
+	// InboundTopic is subscribed to and OutboundTopic published to. Both are
+	// required and must differ, e.g. "agents/<id>/rpc/in" and ".../out".
+	InboundTopic  string
+	OutboundTopic string
+	// QoS (0, 1 or 2) applies to the subscription and to every publish. With
+	// QoS > 0 Write returns once the broker has acknowledged the message.
+	QoS byte
+	// PersistentSession keeps the subscription and queued QoS > 0 messages
+	// on the broker while the agent is offline; ClientID must then be stable.
+	PersistentSession bool
+	// KeepAlive defaults to 30 seconds.
+	KeepAlive time.Duration
+	// TLSConfig is used for mqtts:// URLs.
+	TLSConfig *tls.Config
+}
+
+// MQTTTransport carries messages over an MQTT 3.1.1 broker, one message per
+// PUBLISH with the plain JSON line as payload, so Node controllers can use
+// any MQTT client.
+type MQTTTransport struct {
+	opts     MQTTOptions
+	conn     net.Conn
+	writeMu  sync.Mutex
+	mu       sync.Mutex
+	nextID   uint16
+	acks     map[uint16]chan byte
+	received map[uint16]bool
+	messages chan string
+	done     chan struct{}
+	err      error
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// DialMQTT connects and returns once the inbound topic is subscribed.
+func DialMQTT(ctx context.Context, opts MQTTOptions) (*MQTTTransport, error) {
+	if opts.InboundTopic == "" || opts.OutboundTopic == "" {
+		return nil, errors.New("kkrpc: MQTTOptions.InboundTopic and OutboundTopic are required")
+	}
+	if opts.InboundTopic == opts.OutboundTopic {
+		return nil, errors.New("kkrpc: MQTT inbound and outbound topics must differ")
+	}
+	if opts.QoS > 2 {
+		return nil, fmt.Errorf("kkrpc: invalid MQTT QoS %d", opts.QoS)
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "kkrpc-" + GenerateUUID()
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = defaultMQTTKeepAlive
+	}
+	conn, err := dialMQTT(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	t := &MQTTTransport{
+		opts:     opts,
+		conn:     conn,
+		acks:     make(map[uint16]chan byte),
+		received: make(map[uint16]bool),
+		messages: make(chan string, 64),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	reader := bufio.NewReader(conn)
+	if err := t.connect(ctx, reader); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go t.listen(reader)
+	if err := t.subscribe(ctx); err != nil {
+		t.Close()
+		return nil, err
+	}
+	go t.keepAlive()
+	return t, nil
+}
+
+func dialMQTT(ctx context.Context, opts *MQTTOptions) (net.Conn, error) {
+	parsed, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Username == "" && parsed.User != nil {
+		opts.Username = parsed.User.Username()
+		opts.Password, _ = parsed.User.Password()
+	}
+	secure := false
+	switch parsed.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl":
+		secure = true
+	default:
+		return nil, fmt.Errorf("kkrpc: unsupported MQTT URL scheme %q", parsed.Scheme)
+	}
+	host := parsed.Host
+	if parsed.Port() == "" {
+		port := "1883"
+		if secure {
+			port = "8883"
+		}
+		host = net.JoinHostPort(parsed.Hostname(), port)
+	}
+	if secure {
+		config := opts.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: parsed.Hostname()}
+		}
+		dialer := tls.Dialer{Config: config}
+		return dialer.DialContext(ctx, "tcp", host)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", host)
+}
+
+func (t *MQTTTransport) connect(ctx context.Context, reader *bufio.Reader) error {
+	var flags byte
+	if !t.opts.PersistentSession {
+		flags |= 0x02
+	}
+	payload := mqttString(t.opts.ClientID)
+	if t.opts.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(t.opts.Username)...)
+		if t.opts.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(t.opts.Password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(t.opts.KeepAlive/time.Second))
+	if err := t.writePacket(mqttConnect<<4, append(body, payload...)); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		t.conn.SetReadDeadline(deadline)
+		defer t.conn.SetReadDeadline(time.Time{})
+	}
+	header, body, err := readMQTTPacket(reader)
+	if err != nil {
+		return err
+	}
+	if header>>4 != mqttConnack || len(body) != 2 {
+		return fmt.Errorf("kkrpc: expected MQTT CONNACK, got packet type %d", header>>4)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("kkrpc: MQTT connection refused with code %d", body[1])
+	}
+	return nil
+}
+
+func (t *MQTTTransport) subscribe(ctx context.Context) error {
+	id, ack := t.track()
+	defer t.untrack(id)
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = append(append(body, mqttString(t.opts.InboundTopic)...), t.opts.QoS)
+	if err := t.writePacket(mqttSubscribe<<4|0x02, body); err != nil {
+		return err
+	}
+	select {
+	case code := <-ack:
+		if code == mqttSubackError {
+			return fmt.Errorf("kkrpc: MQTT subscription to %q refused", t.opts.InboundTopic)
+		}
+		return nil
+	case <-t.done:
+		return t.failure()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MQTTTransport) keepAlive() {
+	ticker := time.NewTicker(t.opts.KeepAlive * 3 / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if t.writePacket(mqttPingreq<<4, nil) != nil {
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *MQTTTransport) listen(reader *bufio.Reader) {
+	defer close(t.done)
+	for {
+		header, body, err := readMQTTPacket(reader)
+		if err != nil {
+			t.err = err
+			return
+		}
+		if err := t.handle(header, body); err != nil {
+			t.err = err
+			return
+		}
+	}
+}
+
+func (t *MQTTTransport) handle(header byte, body []byte) error {
+	switch header >> 4 {
+	case mqttPublish:
+		return t.handlePublish(header, body)
+	case mqttPuback, mqttPubcomp, mqttSuback:
+		if len(body) < 2 {
+			return errors.New("kkrpc: short MQTT acknowledgement")
+		}
+		var code byte
+		if header>>4 == mqttSuback && len(body) > 2 {
+			code = body[2]
+		}
+		t.acknowledge(binary.BigEndian.Uint16(body), code)
+	case mqttPubrec:
+		// Second step of an outbound QoS 2 publish; the waiter stays
+		// registered until PUBCOMP.
+		if len(body) < 2 {
+			return errors.New("kkrpc: short MQTT PUBREC")
+		}
+		return t.writePacket(mqttPubrel<<4|0x02, body[:2])
+	case mqttPubrel:
+		if len(body) < 2 {
+			return errors.New("kkrpc: short MQTT PUBREL")
+		}
+		t.mu.Lock()
+		delete(t.received, binary.BigEndian.Uint16(body))
+		t.mu.Unlock()
+		return t.writePacket(mqttPubcomp<<4, body[:2])
+	}
+	return nil
+}
+
+func (t *MQTTTransport) handlePublish(header byte, body []byte) error {
+	qos := (header >> 1) & 0x03
+	if len(body) < 2 {
+		return errors.New("kkrpc: short MQTT PUBLISH")
+	}
+	offset := 2 + int(binary.BigEndian.Uint16(body))
+	if qos > 0 {
+		offset += 2
+	}
+	if offset > len(body) {
+		return errors.New("kkrpc: short MQTT PUBLISH")
+	}
+	payload := string(body[offset:])
+	switch qos {
+	case 1:
+		if err := t.writePacket(mqttPuback<<4, body[offset-2:offset]); err != nil {
+			return err
+		}
+	case 2:
+		// Deliver once and drop redeliveries of the same id until PUBREL.
+		id := binary.BigEndian.Uint16(body[offset-2:])
+		t.mu.Lock()
+		duplicate := t.received[id]
+		t.received[id] = true
+		t.mu.Unlock()
+		if err := t.writePacket(mqttPubrec<<4, body[offset-2:offset]); err != nil {
+			return err
+		}
+		if duplicate {
+			return nil
+		}
+	}
+	select {
+	case t.messages <- payload:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+func (t *MQTTTransport) track() (uint16, chan byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for {
+		t.nextID++
+		if t.nextID == 0 {
+			t.nextID = 1
+		}
+		if _, used := t.acks[t.nextID]; !used {
+			break
+		}
+	}
+	ack := make(chan byte, 1)
+	t.acks[t.nextID] = ack
+	return t.nextID, ack
+}
+
+func (t *MQTTTransport) untrack(id uint16) {
+	t.mu.Lock()
+	delete(t.acks, id)
+	t.mu.Unlock()
+}
+
+func (t *MQTTTransport) acknowledge(id uint16, code byte) {
+	t.mu.Lock()
+	ack := t.acks[id]
+	t.mu.Unlock()
+	if ack != nil {
+		select {
+		case ack <- code:
+		default:
+		}
+	}
+}
+
+func (t *MQTTTransport) failure() error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+		return t.err
+	}
+}
+
+func (t *MQTTTransport) Read() (string, error) {
+	select {
+	case message := <-t.messages:
+		return message, nil
+	case <-t.done:
+		return "", t.failure()
+	}
+}
+
+func (t *MQTTTransport) Write(message string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	body := mqttString(t.opts.OutboundTopic)
+	payload := []byte(strings.TrimSuffix(message, "\n"))
+	if t.opts.QoS == 0 {
+		return t.writePacket(mqttPublish<<4, append(body, payload...))
+	}
+	id, ack := t.track()
+	defer t.untrack(id)
+	body = binary.BigEndian.AppendUint16(body, id)
+	if err := t.writePacket(mqttPublish<<4|t.opts.QoS<<1, append(body, payload...)); err != nil {
+		return err
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-t.done:
+		return t.failure()
+	}
+}
+
+func (t *MQTTTransport) writePacket(header byte, body []byte) error {
+	if len(body) > mqttMaxRemain {
+		return fmt.Errorf("kkrpc: MQTT packet of %d bytes is too large", len(body))
+	}
+	packet := append([]byte{header}, mqttRemainingLength(len(body))...)
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err := t.conn.Write(append(packet, body...))
+	return err
+}
+
+func (t *MQTTTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		_ = t.writePacket(mqttDisconnect<<4, nil)
+		t.conn.Close()
+	})
+	return nil
+}
+
+func mqttString(value string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(value))), value...)
+}
+
+func mqttRemainingLength(length int) []byte {
+	var encoded []byte
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+func readMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("kkrpc: malformed MQTT remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,169 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// fakeBroker routes PUBLISH packets to exact-topic subscribers and speaks the
+// QoS 1 and 2 handshakes with both sides.
+type fakeBroker struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	subscribers map[string][]*brokerSession
+	published   map[byte]int
+}
+
+type brokerSession struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	qos     byte
+	nextID  uint16
+}
+
+func (s *brokerSession) write(header byte, body []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, _ = s.conn.Write(append(append([]byte{header}, mqttRemainingLength(len(body))...), body...))
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &fakeBroker{listener: listener, subscribers: make(map[string][]*brokerSession), published: make(map[byte]int)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return broker
+}
+
+func (b *fakeBroker) URL() string {
+	return "mqtt://" + b.listener.Addr().String()
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	session := &brokerSession{conn: conn}
+	reader := bufio.NewReader(conn)
+	for {
+		header, body, err := readMQTTPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case mqttConnect:
+			session.write(mqttConnack<<4, []byte{0, 0})
+		case mqttSubscribe:
+			topicLength := int(binary.BigEndian.Uint16(body[2:]))
+			topic := string(body[4 : 4+topicLength])
+			session.qos = body[4+topicLength]
+			b.mu.Lock()
+			b.subscribers[topic] = append(b.subscribers[topic], session)
+			b.mu.Unlock()
+			session.write(mqttSuback<<4, []byte{body[0], body[1], session.qos})
+		case mqttPublish:
+			qos := (header >> 1) & 0x03
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+topicLength])
+			offset := 2 + topicLength
+			if qos > 0 {
+				offset += 2
+			}
+			switch qos {
+			case 1:
+				session.write(mqttPuback<<4, body[offset-2:offset])
+			case 2:
+				session.write(mqttPubrec<<4, body[offset-2:offset])
+			}
+			b.mu.Lock()
+			b.published[qos]++
+			subscribers := append([]*brokerSession(nil), b.subscribers[topic]...)
+			b.mu.Unlock()
+			for _, subscriber := range subscribers {
+				subscriber.deliver(topic, body[offset:])
+			}
+		case mqttPubrel:
+			session.write(mqttPubcomp<<4, body[:2])
+		case mqttPubrec:
+			session.write(mqttPubrel<<4|0x02, body[:2])
+		case mqttPingreq:
+			session.write(mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func (s *brokerSession) deliver(topic string, payload []byte) {
+	body := mqttString(topic)
+	if s.qos > 0 {
+		s.writeMu.Lock()
+		s.nextID++
+		id := s.nextID
+		s.writeMu.Unlock()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	s.write(mqttPublish<<4|s.qos<<1, append(body, payload...))
+}
+
+func TestMQTTTransportQoSLevels(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	for _, qos := range []byte{0, 1, 2} {
+		broker := newFakeBroker(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		agent, err := DialMQTT(ctx, MQTTOptions{URL: broker.URL(), InboundTopic: "agents/a1/in", OutboundTopic: "agents/a1/out", QoS: qos, KeepAlive: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		controller, err := DialMQTT(ctx, MQTTOptions{URL: broker.URL(), InboundTopic: "agents/a1/out", OutboundTopic: "agents/a1/in", QoS: qos})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := NewServer(agent, map[string]any{
+			"echo": func(args ...any) any { return args[0] },
+		})
+		client := NewClient(controller, WithTimeout(2*time.Second))
+		for i := 0; i < 3; i++ {
+			result, err := client.Call("echo", i)
+			if err != nil || !valuesEqual(i, result) {
+				t.Fatalf("qos %d call %d: %#v %v", qos, i, result, err)
+			}
+		}
+		// Let a keepalive ping go out on the agent connection.
+		time.Sleep(60 * time.Millisecond)
+		client.Close()
+		server.Close()
+		broker.mu.Lock()
+		published := broker.published[qos]
+		broker.mu.Unlock()
+		if published != 6 {
+			t.Fatalf("qos %d: broker saw %d publishes", qos, published)
+		}
+	}
+}
+
+func TestDialMQTTRejectsSharedTopic(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if _, err := DialMQTT(context.Background(), MQTTOptions{URL: "mqtt://localhost", InboundTopic: "rpc", OutboundTopic: "rpc"}); err == nil {
+		t.Fatal("shared topic accepted")
+	}
+}
@@ -0,0 +1,356 @@
+package kkrpc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type msgpackCodec struct{}
+
+var MsgpackCodec Codec = msgpackCodec{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(value any) ([]byte, error) {
+	return appendMsgpack(nil, value)
+}
+
+func (c msgpackCodec) Unmarshal(data []byte) (any, error) {
+	return c.UnmarshalLimited(data, DefaultDecodeLimits)
+}
+
+// UnmarshalLimited enforces MaxDepth and MaxElements while decoding. Nesting
+// stays bounded by DefaultDecodeLimits when MaxDepth is zero, as the decoder
+// recurses.
+func (msgpackCodec) UnmarshalLimited(data []byte, limits DecodeLimits) (any, error) {
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultDecodeLimits.MaxDepth
+	}
+	decoder := &msgpackDecoder{data: data, limits: limits}
+	value, err := decoder.value()
+	if err != nil {
+		return nil, err
+	}
+	if decoder.pos != len(data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return value, nil
+}
+
+func appendMsgpack(buf []byte, value any) ([]byte, error) {
+	switch typed := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if typed {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case string:
+		return appendMsgpackString(buf, typed), nil
+	case []byte:
+		return appendMsgpackBinary(buf, typed), nil
+	case float64:
+		return appendMsgpackFloat(buf, typed), nil
+	case float32:
+		return appendMsgpackFloat(buf, float64(typed)), nil
+	case int:
+		return appendMsgpackInt(buf, int64(typed)), nil
+	case int64:
+		return appendMsgpackInt(buf, typed), nil
+	case int32:
+		return appendMsgpackInt(buf, int64(typed)), nil
+	case uint32:
+		return appendMsgpackInt(buf, int64(typed)), nil
+	case json.Number:
+		if number, err := typed.Int64(); err == nil {
+			return appendMsgpackInt(buf, number), nil
+		}
+		number, err := typed.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendMsgpackFloat(buf, number), nil
+	case []any:
+		buf = appendMsgpackLength(buf, len(typed), 0x90, 0xdc, 0xdd)
+		for _, item := range typed {
+			var err error
+			if buf, err = appendMsgpack(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf = appendMsgpackLength(buf, len(typed), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			buf = appendMsgpackString(buf, key)
+			var err error
+			if buf, err = appendMsgpack(buf, typed[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	if isFuncValue(value) {
+		return nil, fmt.Errorf("msgpack: cannot encode %T", value)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(buf, generic)
+}
+
+func appendMsgpackLength(buf []byte, length int, fix byte, code16 byte, code32 byte) []byte {
+	switch {
+	case length < 16:
+		return append(buf, fix|byte(length))
+	case length <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(length))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, code32), uint32(length))
+	}
+}
+
+func appendMsgpackString(buf []byte, value string) []byte {
+	length := len(value)
+	switch {
+	case length < 32:
+		buf = append(buf, 0xa0|byte(length))
+	case length <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(length))
+	case length <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(length))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(length))
+	}
+	return append(buf, value...)
+}
+
+func appendMsgpackBinary(buf []byte, value []byte) []byte {
+	length := len(value)
+	switch {
+	case length <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(length))
+	case length <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(length))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(length))
+	}
+	return append(buf, value...)
+}
+
+func appendMsgpackFloat(buf []byte, value float64) []byte {
+	if value == math.Trunc(value) && value >= math.MinInt64 && value <= math.MaxInt64 && !math.IsInf(value, 0) {
+		return appendMsgpackInt(buf, int64(value))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(value))
+}
+
+func appendMsgpackInt(buf []byte, value int64) []byte {
+	switch {
+	case value >= 0 && value <= 0x7f:
+		return append(buf, byte(value))
+	case value < 0 && value >= -32:
+		return append(buf, byte(value))
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		return append(buf, 0xd0, byte(value))
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(value))
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(value))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(value))
+	}
+}
+
+type msgpackDecoder struct {
+	data     []byte
+	pos      int
+	depth    int
+	elements int
+	limits   DecodeLimits
+}
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+func (d *msgpackDecoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errMsgpackShort
+	}
+	chunk := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return chunk, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	chunk, err := d.take(n)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, b := range chunk {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+func (d *msgpackDecoder) value() (any, error) {
+	head, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+	code := head[0]
+	switch {
+	case code <= 0x7f:
+		return float64(code), nil
+	case code >= 0xe0:
+		return float64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.mapValue(int(code & 0x0f))
+	case code&0xf0 == 0x90:
+		return d.arrayValue(int(code & 0x0f))
+	case code&0xe0 == 0xa0:
+		return d.stringValue(int(code & 0x1f))
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := d.uint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := d.take(int(length))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), chunk...), nil
+	case 0xca:
+		bits, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.uint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := d.uint(1 << (code - 0xcc))
+		return float64(value), err
+	case 0xd0:
+		value, err := d.uint(1)
+		return float64(int8(value)), err
+	case 0xd1:
+		value, err := d.uint(2)
+		return float64(int16(value)), err
+	case 0xd2:
+		value, err := d.uint(4)
+		return float64(int32(value)), err
+	case 0xd3:
+		value, err := d.uint(8)
+		return float64(int64(value)), err
+	case 0xd9, 0xda, 0xdb:
+		length, err := d.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.stringValue(int(length))
+	case 0xdc, 0xdd:
+		length, err := d.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(int(length))
+	case 0xde, 0xdf:
+		length, err := d.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(length))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type code 0x%02x", code)
+}
+
+func (d *msgpackDecoder) stringValue(length int) (any, error) {
+	chunk, err := d.take(length)
+	if err != nil {
+		return nil, err
+	}
+	return string(chunk), nil
+}
+
+// enter opens an array or map of length elements.
+func (d *msgpackDecoder) enter(length int) error {
+	d.depth++
+	if limit := d.limits.MaxDepth; limit > 0 && d.depth > limit {
+		return &ProtocolError{Code: "too_deep", Message: fmt.Sprintf("msgpack nesting exceeds depth %d", limit)}
+	}
+	d.elements += 1 + length
+	if limit := d.limits.MaxElements; limit > 0 && d.elements > limit {
+		return &ProtocolError{Code: "too_many_elements", Message: fmt.Sprintf("msgpack message has more than %d elements", limit)}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) arrayValue(length int) (any, error) {
+	if length > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	if err := d.enter(length); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+	values := make([]any, 0, length)
+	for i := 0; i < length; i++ {
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (d *msgpackDecoder) mapValue(length int) (any, error) {
+	if length > len(d.data)-d.pos {
+		return nil, errMsgpackShort
+	}
+	if err := d.enter(length); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+	values := make(map[string]any, length)
+	for i := 0; i < length; i++ {
+		key, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		text, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: unsupported map key %T", key)
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		values[text] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,201 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// muxField is the top-level message field naming the logical channel.
+const muxField = "ch"
+
+type MuxOptions struct {
+	// OnChannel is called with every channel the peer opens, before its
+	// first message is read, e.g. to serve an API on it. Without it, messages
+	// on channels not opened locally are dropped.
+	OnChannel func(channel *MuxChannel)
+}
+
+// Mux carries several logical channels over one transport, such as a single
+// WebSocket hosting isolated APIs. Every message is tagged with a top-level
+// "ch" field naming its channel, and each channel is a Transport of its own:
+// put a Client, Server or Channel on it, with its own API, options and
+// pending requests. Messages without the field belong to the channel with the
+// empty id, so a peer that does not multiplex talks to that one.
+//
+// Each channel buffers what it has not read yet without bound, so a slow
+// channel never holds up the others.
+type Mux struct {
+	inner Transport
+	opts  MuxOptions
+
+	mu       sync.Mutex
+	channels map[string]*MuxChannel
+	err      error
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewMux(inner Transport, opts MuxOptions) *Mux {
+	m := &Mux{inner: inner, opts: opts, channels: make(map[string]*MuxChannel), done: make(chan struct{})}
+	go m.route()
+	return m
+}
+
+// Channel returns the channel with id, opening it if needed.
+func (m *Mux) Channel(id string) *MuxChannel {
+	channel, _ := m.open(id)
+	return channel
+}
+
+// Channels returns the ids of the open channels, sorted.
+func (m *Mux) Channels() []string {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.channels))
+	for id := range m.channels {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// Close closes every channel and the underlying transport.
+func (m *Mux) Close() error {
+	m.stop(ErrTransportClosed)
+	return m.inner.Close()
+}
+
+func (m *Mux) open(id string) (*MuxChannel, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if channel, ok := m.channels[id]; ok {
+		return channel, false
+	}
+	channel := &MuxChannel{mux: m, id: id, queue: newPipeQueue(), closed: make(chan struct{})}
+	if id != "" {
+		tag, _ := json.Marshal(id)
+		channel.tag = `{"` + muxField + `":` + string(tag)
+	}
+	m.channels[id] = channel
+	return channel, true
+}
+
+func (m *Mux) route() {
+	for {
+		message, err := m.inner.Read()
+		if err != nil {
+			m.stop(err)
+			return
+		}
+		id := muxChannelID(message)
+		m.mu.Lock()
+		channel := m.channels[id]
+		m.mu.Unlock()
+		if channel == nil {
+			if m.opts.OnChannel == nil {
+				continue
+			}
+			var opened bool
+			if channel, opened = m.open(id); opened {
+				m.opts.OnChannel(channel)
+			}
+		}
+		channel.queue.push(message)
+	}
+}
+
+func (m *Mux) stop(err error) {
+	m.once.Do(func() {
+		m.mu.Lock()
+		m.err = err
+		m.mu.Unlock()
+		close(m.done)
+	})
+}
+
+// muxChannelID finds the channel field, which Mux writes first.
+func muxChannelID(message string) string {
+	prefix := `{"` + muxField + `":"`
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(message), prefix); ok {
+		if end := strings.IndexAny(rest, `"\`); end >= 0 && rest[end] == '"' {
+			return rest[:end]
+		}
+	}
+	if !strings.Contains(message, `"`+muxField+`"`) {
+		return ""
+	}
+	var envelope struct {
+		Channel string `json:"ch"`
+	}
+	_ = json.Unmarshal([]byte(message), &envelope)
+	return envelope.Channel
+}
+
+// MuxChannel is one logical channel of a Mux.
+type MuxChannel struct {
+	mux    *Mux
+	id     string
+	tag    string
+	queue  *pipeQueue
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *MuxChannel) ID() string {
+	return c.id
+}
+
+func (c *MuxChannel) Read() (string, error) {
+	for {
+		if message, ok := c.queue.pop(); ok {
+			return message, nil
+		}
+		select {
+		case <-c.queue.ready:
+		case <-c.closed:
+			return "", ErrTransportClosed
+		case <-c.mux.done:
+			if message, ok := c.queue.pop(); ok {
+				return message, nil
+			}
+			return "", c.mux.err
+		}
+	}
+}
+
+func (c *MuxChannel) Write(message string) error {
+	select {
+	case <-c.closed:
+		return ErrTransportClosed
+	default:
+	}
+	if c.tag != "" {
+		message = strings.TrimSpace(message)
+		if !strings.HasPrefix(message, "{") {
+			return errors.New("kkrpc: mux: message is not a JSON object")
+		}
+		if body := strings.TrimSpace(message[1:]); body == "}" {
+			message = c.tag + "}"
+		} else {
+			message = c.tag + "," + body
+		}
+	}
+	return c.mux.inner.Write(message)
+}
+
+// Close closes this channel only; the peer's side of it stays open. Messages
+// that arrive for it later open it again if OnChannel is set.
+func (c *MuxChannel) Close() error {
+	c.once.Do(func() {
+		c.mux.mu.Lock()
+		if c.mux.channels[c.id] == c {
+			delete(c.mux.channels, c.id)
+		}
+		c.mux.mu.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestMuxIsolatesChannelsOverOneTransport(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	apis := map[string]map[string]any{
+		"math": {"add": MustFunc(func(a, b float64) float64 { return a + b })},
+		"text": {"upper": MustFunc(strings.ToUpper)},
+	}
+	left, right := NewPipeTransportPair()
+	NewMux(right, MuxOptions{OnChannel: func(channel *MuxChannel) {
+		NewServer(channel, apis[channel.ID()])
+	}})
+	mux := NewMux(left, MuxOptions{})
+	defer mux.Close()
+	math := NewClient(mux.Channel("math"), WithTimeout(2*time.Second))
+	text := NewClient(mux.Channel("text"), WithTimeout(2*time.Second))
+
+	if sum, err := math.Call("add", 2, 3); err != nil || sum != float64(5) {
+		t.Fatalf("add = %v, %v", sum, err)
+	}
+	if upper, err := text.Call("upper", "kkrpc"); err != nil || upper != "KKRPC" {
+		t.Fatalf("upper = %v, %v", upper, err)
+	}
+	if _, err := math.Call("upper", "kkrpc"); err == nil {
+		t.Fatal("the math channel reached the text API")
+	}
+	if ids := mux.Channels(); len(ids) != 2 || ids[0] != "math" || ids[1] != "text" {
+		t.Fatalf("channels %v", ids)
+	}
+}
+
+func TestMuxDefaultChannelTalksToPlainPeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	NewServer(right, map[string]any{"ping": MustFunc(func() string { return "pong" })})
+	mux := NewMux(left, MuxOptions{})
+	defer mux.Close()
+	client := NewClient(mux.Channel(""), WithTimeout(2*time.Second))
+	if result, err := client.Call("ping"); err != nil || result != "pong" {
+		t.Fatalf("ping = %v, %v", result, err)
+	}
+}
+
+func TestMuxChannelID(t *testing.T) {
+	for message, id := range map[string]string{
+		`{"ch":"math","t":"q"}`:      "math",
+		`{"t":"q","ch":"a\"b"}`:      `a"b`,
+		`{"t":"q","p":"ch"}`:         "",
+		`{"t":"q","a":[{"ch":"x"}]}`: "",
+	} {
+		if got := muxChannelID(message); got != id {
+			t.Errorf("muxChannelID(%s) = %q, want %q", message, got, id)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package kkrpc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type Option func(*options)
+
+type options struct {
+	pool              *Pool
+	maxGoroutines     int
+	timeout           time.Duration
+	logger            Logger
+	logLevel          string
+	reportCbErrs      bool
+	hooks             hookSet
+	sizes             *sizeTracker
+	reentrant         bool
+	resolver          Resolver
+	codecs            *codecSet
+	compression       CompressionHook
+	serialization     *serializationSampler
+	pooledDecode      bool
+	limits            DecodeLimits
+	onProtoErr        func(*ProtocolError)
+	unknownPolicy     UnknownMessagePolicy
+	extensions        map[string]MessageHandler
+	outbound          []outboundHook
+	peerClosed        atomic.Bool
+	envelope          []EnvelopeField
+	idempotency       IdempotencyStore
+	contracts         *ContractRecorder
+	sessions          *SessionRecorder
+	resumeID          string
+	cbSessions        *CallbackSessions
+	cbSession         atomic.Pointer[callbackSession]
+	outbox            *Outbox
+	clock             *ClockEstimator
+	creditWindow      int
+	flow              *flowControl
+	streams           streamSet
+	remoteStreams     remoteStreamSet
+	blobs             *blobLink
+	resources         *resourceMeter
+	authorizer        Authorizer
+	identity          atomic.Pointer[Identity]
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	failPending       func(error)
+	signer            *requestSigner
+	replay            ReplayStore
+	disconnected      func()
+	sched             func(schedPoint, string)
+	readLoopPolicy    ReadLoopPolicy
+	onReadLoopErr     func(*ReadLoopError)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{logger: nopLogger{}, codecs: &codecSet{}, limits: DefaultDecodeLimits, flow: newFlowControl()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.applyLogLevel()
+	if o.blobs != nil {
+		o.blobs.owner = o.resources
+	}
+	return o
+}
+
+func WithPool(pool *Pool) Option {
+	return func(o *options) {
+		o.pool = pool
+	}
+}
+
+func WithMaxGoroutines(n int) Option {
+	return func(o *options) {
+		o.maxGoroutines = n
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		o.logger = logger
+	}
+}
+
+func WithCallbackErrorReporting(enabled bool) Option {
+	return func(o *options) {
+		o.reportCbErrs = enabled
+	}
+}
+
+func WithHook(hook Hook) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, hook)
+		if sizeHook, ok := hook.(SizeHook); ok {
+			if o.sizes == nil {
+				o.sizes = &sizeTracker{requests: newPendingRequests[*trackedRequest]()}
+			}
+			o.sizes.hooks = append(o.sizes.hooks, sizeHook)
+		}
+	}
+}
+
+func WithReentrantCalls(enabled bool) Option {
+	return func(o *options) {
+		o.reentrant = enabled
+	}
+}
+
+func WithResolver(resolver Resolver) Option {
+	return func(o *options) {
+		o.resolver = resolver
+	}
+}
+
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codecs.register(codec)
+	}
+}
+
+func WithNamespaceCodec(pattern string, codec Codec) Option {
+	return func(o *options) {
+		o.codecs.prefer(pattern, codec)
+	}
+}
+
+func WithSerializationStats(stats *SerializationStats, sampleRate float64) Option {
+	return func(o *options) {
+		if stats == nil || sampleRate <= 0 {
+			o.serialization = nil
+			return
+		}
+		o.serialization = &serializationSampler{
+			stats:    stats,
+			rate:     min(sampleRate, 1),
+			requests: newPendingRequests[*sampledRequest](),
+		}
+	}
+}
+
+// WithPooledDecoding decodes this channel's incoming lines through reusable
+// buffers. The buffers come from one process-wide pool shared by every channel
+// that enables it, so a burst on one channel warms the pool for the others.
+func WithPooledDecoding(enabled bool) Option {
+	return func(o *options) {
+		o.pooledDecode = enabled
+	}
+}
+
+func WithDecodeLimits(limits DecodeLimits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+func WithProtocolErrorHandler(handler func(*ProtocolError)) Option {
+	return func(o *options) {
+		o.onProtoErr = handler
+	}
+}
@@ -0,0 +1,287 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OutboxEntry is a critical call that has not been delivered yet.
+type OutboxEntry struct {
+	ID      string    `json:"id"`
+	Method  string    `json:"method"`
+	Args    []any     `json:"args,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// maxOutboxRecordBytes bounds a single persisted call when reloading.
+const maxOutboxRecordBytes = 16 << 20
+
+type outboxRecord struct {
+	Op string `json:"op"`
+	OutboxEntry
+}
+
+// Outbox persists critical calls in an append-only file until a peer answers
+// them, so user actions survive a sidecar that is briefly down or a restart of
+// the calling process. Each entry is delivered with its id as idempotency key,
+// making redelivery after an ambiguous failure (a timeout) safe on servers
+// that keep idempotency records.
+type Outbox struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]*OutboxEntry
+	order   []string
+	done    int
+}
+
+type criticalKey struct{}
+
+// ContextWithCritical flags calls made with ctx as critical: a client with an
+// outbox records them before sending and keeps them until they are answered.
+func ContextWithCritical(ctx context.Context) context.Context {
+	return context.WithValue(ctx, criticalKey{}, true)
+}
+
+// IsCritical reports whether ctx was flagged with ContextWithCritical.
+func IsCritical(ctx context.Context) bool {
+	critical, _ := ctx.Value(criticalKey{}).(bool)
+	return critical
+}
+
+// WithOutbox stores critical calls in outbox; see ContextWithCritical.
+func WithOutbox(outbox *Outbox) Option {
+	return func(o *options) {
+		o.outbox = outbox
+	}
+}
+
+// OpenOutbox loads the outbox at path, creating it if needed, and compacts
+// entries that were already delivered.
+func OpenOutbox(path string) (*Outbox, error) {
+	outbox := &Outbox{path: path, entries: make(map[string]*OutboxEntry)}
+	if err := outbox.load(); err != nil {
+		return nil, err
+	}
+	if err := outbox.compact(); err != nil {
+		return nil, err
+	}
+	return outbox, nil
+}
+
+func (o *Outbox) load() error {
+	file, err := os.Open(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxOutboxRecordBytes)
+	for scanner.Scan() {
+		var record outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final line from a crash mid-write; everything before it is intact.
+			break
+		}
+		switch record.Op {
+		case "add":
+			entry := record.OutboxEntry
+			o.entries[entry.ID] = &entry
+			o.order = append(o.order, entry.ID)
+		case "done":
+			delete(o.entries, record.ID)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with only pending entries. The current file stays
+// in use until the rewritten one has replaced it, so a failed compaction
+// leaves the outbox working.
+func (o *Outbox) compact() error {
+	order := o.order[:0]
+	for _, id := range o.order {
+		if _, ok := o.entries[id]; ok {
+			order = append(order, id)
+		}
+	}
+	o.order = order
+
+	temp := o.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := o.writeEntries(file); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, o.path); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(o.path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file = file
+	o.done = 0
+	return nil
+}
+
+func (o *Outbox) writeEntries(file *os.File) error {
+	writer := bufio.NewWriter(file)
+	for _, id := range o.order {
+		if err := writeOutboxRecord(writer, outboxRecord{Op: "add", OutboxEntry: *o.entries[id]}); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func writeOutboxRecord(writer *bufio.Writer, record outboxRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	return writer.WriteByte('\n')
+}
+
+func (o *Outbox) append(record outboxRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := o.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+func (o *Outbox) add(method string, args []any) (*OutboxEntry, error) {
+	entry := &OutboxEntry{ID: GenerateUUID(), Method: method, Args: args, Created: time.Now().UTC()}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil, ErrTransportClosed
+	}
+	if err := o.append(outboxRecord{Op: "add", OutboxEntry: *entry}); err != nil {
+		return nil, fmt.Errorf("kkrpc: outbox %s: %w", method, err)
+	}
+	o.entries[entry.ID] = entry
+	o.order = append(o.order, entry.ID)
+	return entry, nil
+}
+
+// complete records that id was delivered. A failed compaction afterwards is
+// only logged, since the call itself went through; it is retried once as
+// many entries have completed again.
+func (o *Outbox) complete(id string, logger Logger) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.entries[id]; !ok || o.file == nil {
+		return nil
+	}
+	delete(o.entries, id)
+	if err := o.append(outboxRecord{Op: "done", OutboxEntry: OutboxEntry{ID: id}}); err != nil {
+		return err
+	}
+	o.done++
+	if o.done > 2*len(o.entries)+64 {
+		if err := o.compact(); err != nil {
+			logger.Printf("kkrpc: compact outbox %s: %v", o.path, err)
+			o.done = 0
+		}
+	}
+	return nil
+}
+
+// Pending returns the undelivered entries, oldest first.
+func (o *Outbox) Pending() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := make([]OutboxEntry, 0, len(o.entries))
+	for _, id := range o.order {
+		if entry, ok := o.entries[id]; ok {
+			pending = append(pending, *entry)
+		}
+	}
+	return pending
+}
+
+// Flush redelivers pending entries in order through caller and stops at the
+// first one the peer does not answer. Call it after (re)connecting.
+func (o *Outbox) Flush(ctx context.Context, caller Caller) (int, error) {
+	delivered := 0
+	for _, entry := range o.Pending() {
+		if _, err := o.deliver(ctx, caller, entry); !answered(err) {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (o *Outbox) call(ctx context.Context, caller Caller, method string, args []any) (any, error) {
+	entry, err := o.add(method, args)
+	if err != nil {
+		return nil, err
+	}
+	return o.deliver(ctx, caller, *entry)
+}
+
+func (o *Outbox) deliver(ctx context.Context, caller Caller, entry OutboxEntry) (any, error) {
+	// Clear the flag so a client with this outbox does not record the entry again.
+	ctx = context.WithValue(ctx, criticalKey{}, false)
+	result, err := caller.CallContext(ContextWithIdempotencyKey(ctx, entry.ID), entry.Method, entry.Args...)
+	if answered(err) {
+		var logger Logger = nopLogger{}
+		if client, ok := caller.(*Client); ok {
+			logger = client.options.logger
+		}
+		if completeErr := o.complete(entry.ID, logger); completeErr != nil {
+			return result, completeErr
+		}
+	}
+	return result, err
+}
+
+// answered reports whether the peer produced a response, as opposed to the
+// call failing in transit.
+func answered(err error) bool {
+	var rpcErr *RpcError
+	return err == nil || errors.As(err, &rpcErr)
+}
+
+// Close releases the file; pending entries stay on disk for the next OpenOutbox.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
@@ -0,0 +1,121 @@
+package kkrpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestOutboxSurvivesRestartAndFlushes(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	outbox, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downTransport, _ := newConnectedTestTransports()
+	down := NewClient(downTransport, WithTimeout(50*time.Millisecond), WithOutbox(outbox))
+	ctx := ContextWithCritical(context.Background())
+	if _, err := down.CallContext(ctx, "notes.save", "draft"); err == nil {
+		t.Fatal("expected timeout with no server")
+	}
+	down.Close()
+	if err := outbox.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox, err = OpenOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+	pending := outbox.Pending()
+	if len(pending) != 1 || pending[0].Method != "notes.save" {
+		t.Fatalf("pending after reopen: %#v", pending)
+	}
+
+	clientTransport, serverTransport := newConnectedTestTransports()
+	var saved []any
+	client := NewClient(clientTransport, WithTimeout(time.Second), WithOutbox(outbox))
+	server := NewServer(serverTransport, map[string]any{
+		"notes": map[string]any{
+			"save": func(args ...any) any {
+				saved = append(saved, args[0])
+				return true
+			},
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	delivered, err := outbox.Flush(context.Background(), client)
+	if err != nil || delivered != 1 {
+		t.Fatalf("flush: %d %v", delivered, err)
+	}
+	if result, err := client.CallContext(ctx, "notes.save", "final"); err != nil || result != true {
+		t.Fatalf("critical call: %#v %v", result, err)
+	}
+	if len(saved) != 2 || saved[0] != "draft" || saved[1] != "final" {
+		t.Fatalf("saved %#v", saved)
+	}
+	if pending := outbox.Pending(); len(pending) != 0 {
+		t.Fatalf("pending after flush: %#v", pending)
+	}
+}
+
+func TestOutboxSurvivesFailedCompaction(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	outbox, err := OpenOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory in the way of the temporary file fails every compaction.
+	if err := os.Mkdir(path+".tmp", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	outbox.done = 1 << 20
+
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport, WithTimeout(time.Second), WithOutbox(outbox))
+	server := NewServer(serverTransport, map[string]any{
+		"save": func(args ...any) any { return args[0] },
+	})
+	ctx := ContextWithCritical(context.Background())
+	for _, note := range []string{"first", "second"} {
+		if result, err := client.CallContext(ctx, "save", note); err != nil || result != note {
+			t.Fatalf("delivered call failed after a failed compaction: %#v %v", result, err)
+		}
+	}
+	_ = client.Close()
+	_ = server.Close()
+
+	// The outbox still records calls that are not delivered.
+	downTransport, _ := newConnectedTestTransports()
+	down := NewClient(downTransport, WithTimeout(50*time.Millisecond), WithOutbox(outbox))
+	if _, err := down.CallContext(ctx, "save", "draft"); err == nil {
+		t.Fatal("expected timeout with no server")
+	}
+	_ = down.Close()
+	if err := outbox.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	outbox, err = OpenOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outbox.Close()
+	if pending := outbox.Pending(); len(pending) != 1 || pending[0].Args[0] != "draft" {
+		t.Fatalf("pending after reopen: %#v", pending)
+	}
+}
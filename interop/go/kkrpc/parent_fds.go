@@ -0,0 +1,62 @@
+package kkrpc
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvFDs names the descriptors that carry kkrpc in a child started with
+// ProcessOptions.ExtraFDs, the one it reads first: "3,4".
+const EnvFDs = "KKRPC_FDS"
+
+var ErrNoParentFDs = errors.New("kkrpc: no descriptors passed by the parent")
+
+// ParentFDs returns the descriptors the parent passed to carry kkrpc, named by
+// KKRPC_FDS, or ErrNoParentFDs if it passed none. It unsets the variable, so
+// the process's own children don't claim them too.
+func ParentFDs() (in *os.File, out *os.File, err error) {
+	value, ok := os.LookupEnv(EnvFDs)
+	if !ok || value == "" {
+		return nil, nil, ErrNoParentFDs
+	}
+	_ = os.Unsetenv(EnvFDs)
+	first, second, _ := strings.Cut(value, ",")
+	inFD, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, nil, configError(EnvFDs, value, err)
+	}
+	outFD, err := strconv.Atoi(second)
+	if err != nil {
+		return nil, nil, configError(EnvFDs, value, err)
+	}
+	if inFD < 0 || outFD < 0 {
+		return nil, nil, configError(EnvFDs, value, errors.New("invalid descriptor"))
+	}
+	return os.NewFile(uintptr(inFD), "kkrpc-in"), os.NewFile(uintptr(outFD), "kkrpc-out"), nil
+}
+
+// ParentTransport runs kkrpc over the descriptors from ParentFDs, leaving
+// stdin and stdout to the program. ServeStdio uses them by itself.
+func ParentTransport() (*StreamTransport, error) {
+	in, out, err := ParentFDs()
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamTransport(fdPair{in, out}), nil
+}
+
+// fdPair reads from one descriptor and writes to the other; Close closes both.
+type fdPair struct {
+	in  *os.File
+	out *os.File
+}
+
+func (p fdPair) Read(b []byte) (int, error)  { return p.in.Read(b) }
+func (p fdPair) Write(b []byte) (int, error) { return p.out.Write(b) }
+
+func (p fdPair) Close() error {
+	_ = p.in.Close()
+	return p.out.Close()
+}
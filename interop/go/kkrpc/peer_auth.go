@@ -0,0 +1,221 @@
+package kkrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// peerAuthTimeout bounds AuthenticatePeer when ServeStdio or StartProcess
+// runs it.
+const peerAuthTimeout = 10 * time.Second
+
+const (
+	peerAuthLabel    = "kkrpc-peer-auth-v2 "
+	peerSessionLabel = "kkrpc-peer-session-v2 "
+)
+
+var ErrPeerAuthentication = errors.New("kkrpc: peer failed to prove the shared key")
+
+// PeerRole is the side a peer takes in AuthenticatePeer; the two peers of a
+// connection must take different ones.
+type PeerRole string
+
+const (
+	// PeerClient is the side that starts or dials the other, as StartProcess
+	// does.
+	PeerClient PeerRole = "client"
+	// PeerServer is the side that is started or dialed, as ServeStdio is.
+	PeerServer PeerRole = "server"
+)
+
+func (r PeerRole) other() PeerRole {
+	if r == PeerClient {
+		return PeerServer
+	}
+	return PeerClient
+}
+
+// AuthenticatePeer proves to the peer at the other end of transport that this
+// side holds key and makes the peer prove the same, before any kkrpc message
+// is exchanged. Both peers call it with the same key and opposite roles, then
+// build their Client, Server or Channel on the returned transport, which
+// authenticates every later frame; on error, close transport instead. ctx
+// bounds the exchange and closes transport when done first.
+//
+// Each side sends a random nonce, {"t":"auth","n":<base64>}, then
+// {"t":"auth","p":<base64>}, the HMAC-SHA256 under key of the label
+// "kkrpc-peer-auth-v2 <role>\x00" followed by its own nonce and the peer's,
+// where role is its own, "client" or "server". A proof is thus only valid
+// coming from the other role, and cannot be reflected between two handshakes.
+//
+// Each later frame is sent as "<mac> <frame>", where mac is the base64
+// HMAC-SHA256 of the frame's sequence number in its direction, from 0, as 8
+// big-endian bytes, followed by the frame. The key for frames a side sends is
+// the HMAC-SHA256 under key of "kkrpc-peer-session-v2 <role>\x00", its own
+// nonce and the peer's. Lines without a valid mac, such as stray prints or
+// frames injected, replayed or reordered on the way, are dropped. Frames are
+// authenticated, not encrypted.
+func AuthenticatePeer(ctx context.Context, transport Transport, key []byte, role PeerRole) (Transport, error) {
+	session, err := authenticatePeer(ctx, transport, key, role)
+	if err != nil {
+		return nil, err
+	}
+	return &peerTransport{Transport: transport, session: session}, nil
+}
+
+func authenticatePeer(ctx context.Context, transport Transport, key []byte, role PeerRole) (*peerSession, error) {
+	if len(key) == 0 {
+		return nil, errors.New("kkrpc: AuthenticatePeer needs a key")
+	}
+	if role != PeerClient && role != PeerServer {
+		return nil, fmt.Errorf("kkrpc: AuthenticatePeer needs PeerClient or PeerServer, not %q", role)
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	peerNonce, err := exchangePeerAuth(ctx, transport, "n", nonce)
+	if err != nil {
+		return nil, err
+	}
+	// A peer echoing our nonce back could replay our own proof.
+	if len(peerNonce) != len(nonce) || hmac.Equal(peerNonce, nonce) {
+		return nil, ErrPeerAuthentication
+	}
+	proof, err := exchangePeerAuth(ctx, transport, "p", peerKey(key, peerAuthLabel, role, nonce, peerNonce))
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(proof, peerKey(key, peerAuthLabel, role.other(), peerNonce, nonce)) {
+		return nil, ErrPeerAuthentication
+	}
+	return &peerSession{
+		sendKey: peerKey(key, peerSessionLabel, role, nonce, peerNonce),
+		recvKey: peerKey(key, peerSessionLabel, role.other(), peerNonce, nonce),
+	}, nil
+}
+
+// peerKey is the HMAC under key of label, the role of the side from speaks
+// for, and the nonces from and to.
+func peerKey(key []byte, label string, role PeerRole, from, to []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write([]byte(role))
+	mac.Write([]byte{0})
+	mac.Write(from)
+	mac.Write(to)
+	return mac.Sum(nil)
+}
+
+// peerSession authenticates the frames exchanged after AuthenticatePeer.
+type peerSession struct {
+	sendKey []byte
+	recvKey []byte
+	// mu keeps frames in the order of their sequence numbers.
+	mu      sync.Mutex
+	sendSeq uint64
+	// recvSeq is only used by the one goroutine reading the transport.
+	recvSeq uint64
+}
+
+func (s *peerSession) write(write func(string) error, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frame := strings.TrimRight(message, "\r\n")
+	if err := write(frameMAC(s.sendKey, s.sendSeq, frame) + " " + frame + "\n"); err != nil {
+		return err
+	}
+	s.sendSeq++
+	return nil
+}
+
+func (s *peerSession) read(read func() (string, error)) (string, error) {
+	for {
+		line, err := read()
+		if err != nil {
+			return "", err
+		}
+		mac, frame, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if ok && hmac.Equal([]byte(mac), []byte(frameMAC(s.recvKey, s.recvSeq, frame))) {
+			s.recvSeq++
+			return frame, nil
+		}
+	}
+}
+
+func frameMAC(key []byte, seq uint64, frame string) string {
+	mac := hmac.New(sha256.New, key)
+	var prefix [8]byte
+	binary.BigEndian.PutUint64(prefix[:], seq)
+	mac.Write(prefix[:])
+	mac.Write([]byte(frame))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// peerTransport is the transport AuthenticatePeer returns.
+type peerTransport struct {
+	Transport
+	session *peerSession
+}
+
+func (t *peerTransport) Read() (string, error) {
+	return t.session.read(t.Transport.Read)
+}
+
+func (t *peerTransport) Write(message string) error {
+	return t.session.write(t.Transport.Write, message)
+}
+
+type peerAuthRead struct {
+	line string
+	err  error
+}
+
+// exchangePeerAuth sends value under field while reading the peer's, so
+// neither side waits on the other over an unbuffered transport.
+func exchangePeerAuth(ctx context.Context, transport Transport, field string, value []byte) ([]byte, error) {
+	out, _ := json.Marshal(map[string]any{"t": "auth", field: base64.StdEncoding.EncodeToString(value)})
+	written := make(chan error, 1)
+	go func() { written <- transport.Write(string(out) + "\n") }()
+	read := make(chan peerAuthRead, 1)
+	go func() {
+		line, err := transport.Read()
+		read <- peerAuthRead{line, err}
+	}()
+
+	var line string
+	for pending := 2; pending > 0; pending-- {
+		var err error
+		select {
+		case result := <-read:
+			line, err = result.line, result.err
+		case err = <-written:
+		case <-ctx.Done():
+			_ = transport.Close()
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kkrpc: peer authentication: %w", err)
+		}
+	}
+	var message map[string]any
+	if err := json.Unmarshal([]byte(line), &message); err != nil || message["t"] != "auth" {
+		return nil, ErrPeerAuthentication
+	}
+	encoded, _ := message[field].(string)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) == 0 {
+		return nil, ErrPeerAuthentication
+	}
+	return decoded, nil
+}
@@ -0,0 +1,215 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type authenticated struct {
+	transport Transport
+	err       error
+}
+
+func authenticateBoth(t *testing.T, a, b Transport, keyA, keyB []byte) (authenticated, authenticated) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resultB := make(chan authenticated, 1)
+	go func() {
+		transport, err := AuthenticatePeer(ctx, b, keyB, PeerServer)
+		resultB <- authenticated{transport, err}
+	}()
+	transport, err := AuthenticatePeer(ctx, a, keyA, PeerClient)
+	return authenticated{transport, err}, <-resultB
+}
+
+func TestAuthenticatePeerWithSharedKey(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := NewPipeTransportPair()
+	key := []byte("correct horse battery staple")
+	clientSide, serverSide := authenticateBoth(t, clientTransport, serverTransport, key, key)
+	if clientSide.err != nil || serverSide.err != nil {
+		t.Fatalf("%v %v", clientSide.err, serverSide.err)
+	}
+	forged := make(chan struct{}, 1)
+	server := NewServer(serverSide.transport, map[string]any{
+		"ping":   func(args ...any) any { return "pong" },
+		"forged": func(args ...any) any { forged <- struct{}{}; return nil },
+	})
+	defer server.Close()
+	// Someone else writing to the stream cannot get a request served.
+	if err := clientTransport.Write(`{"t":"q","id":"x","op":"call","p":["forged"],"a":[]}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(clientSide.transport, WithTimeout(2*time.Second))
+	defer client.Close()
+	if result, err := client.Call("ping"); err != nil || result != "pong" {
+		t.Fatalf("%#v %v", result, err)
+	}
+	select {
+	case <-forged:
+		t.Fatal("unauthenticated frame was served")
+	default:
+	}
+
+	a, b := NewPipeTransportPair()
+	defer a.Close()
+	sideA, sideB := authenticateBoth(t, a, b, key, []byte("wrong"))
+	if !errors.Is(sideA.err, ErrPeerAuthentication) || !errors.Is(sideB.err, ErrPeerAuthentication) {
+		t.Fatalf("mismatched keys: %v %v", sideA.err, sideB.err)
+	}
+}
+
+func TestAuthenticatePeerRefusesUnauthenticatedPeers(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	a, b := NewPipeTransportPair()
+	defer a.Close()
+	// A plain client sends its request straight away.
+	go func() { _ = b.Write(`{"t":"q","id":"1","op":"call","p":["ping"],"a":[]}`) }()
+	if _, err := AuthenticatePeer(context.Background(), a, []byte("k"), PeerServer); !errors.Is(err, ErrPeerAuthentication) {
+		t.Fatalf("expected ErrPeerAuthentication, got %v", err)
+	}
+
+	// A peer without the key reflects our own messages back.
+	c, d := NewPipeTransportPair()
+	defer c.Close()
+	go func() {
+		for {
+			message, err := d.Read()
+			if err != nil || d.Write(message) != nil {
+				return
+			}
+		}
+	}()
+	if _, err := AuthenticatePeer(context.Background(), c, []byte("k"), PeerClient); !errors.Is(err, ErrPeerAuthentication) {
+		t.Fatalf("reflection: expected ErrPeerAuthentication, got %v", err)
+	}
+
+	silent, _ := NewPipeTransportPair()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := AuthenticatePeer(ctx, silent, []byte("k"), PeerClient); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("silent peer: %v", err)
+	}
+}
+
+func TestAuthenticatePeerRejectsReflectedProofs(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	// Someone without the key relaying between two handshakes that take the
+	// same role hands each the other's nonce and then its proof. Before roles
+	// were part of the proof, each accepted the other's.
+	key := []byte("k")
+	first, relay1 := NewPipeTransportPair()
+	second, relay2 := NewPipeTransportPair()
+	defer relay1.Close()
+	defer relay2.Close()
+	pipe := func(from, to Transport) {
+		for {
+			message, err := from.Read()
+			if err != nil || to.Write(message) != nil {
+				return
+			}
+		}
+	}
+	go pipe(relay1, relay2)
+	go pipe(relay2, relay1)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	results := make(chan error, 2)
+	for _, transport := range []Transport{first, second} {
+		go func(transport Transport) {
+			_, err := AuthenticatePeer(ctx, transport, key, PeerClient)
+			results <- err
+		}(transport)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; !errors.Is(err, ErrPeerAuthentication) {
+			t.Fatalf("reflected proof: expected ErrPeerAuthentication, got %v", err)
+		}
+	}
+	if _, err := AuthenticatePeer(ctx, first, key, "peer"); err == nil {
+		t.Fatal("unknown role accepted")
+	}
+}
+
+func TestPeerSessionDropsReplayedAndReflectedFrames(t *testing.T) {
+	sender := &peerSession{sendKey: []byte("a to b"), recvKey: []byte("b to a")}
+	receiver := &peerSession{sendKey: []byte("b to a"), recvKey: []byte("a to b")}
+	var wire []string
+	record := func(line string) error { wire = append(wire, line); return nil }
+	for _, frame := range []string{`{"t":"q","id":"1"}`, `{"t":"q","id":"2"}`} {
+		if err := sender.write(record, frame+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first frame arrives twice, then a frame of the receiver's own.
+	var ownFrame string
+	_ = receiver.write(func(line string) error { ownFrame = line; return nil }, `{"t":"r","id":"1"}`)
+	lines := []string{wire[0], wire[0], ownFrame, "stray print", wire[1]}
+	read := func() (string, error) {
+		if len(lines) == 0 {
+			return "", ErrTransportClosed
+		}
+		line := lines[0]
+		lines = lines[1:]
+		return line, nil
+	}
+	for _, want := range []string{`{"t":"q","id":"1"}`, `{"t":"q","id":"2"}`} {
+		if frame, err := receiver.read(read); err != nil || frame != want {
+			t.Fatalf("read %q, %v; want %q", frame, err, want)
+		}
+	}
+	if frame, err := receiver.read(read); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("read %q, %v after the last frame", frame, err)
+	}
+}
+
+func TestServeStdioAuthenticatesPeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	key := []byte("helper key")
+	for _, clientKey := range [][]byte{key, []byte("guess")} {
+		clientIn, serverOut := io.Pipe()
+		serverIn, clientOut := io.Pipe()
+		served := make(chan error, 1)
+		go func() {
+			served <- ServeStdio(context.Background(), map[string]any{
+				"ping": func(args ...any) any { return "pong" },
+			}, StdioServerOptions{Stdin: serverIn, Stdout: serverOut, SharedKey: key})
+		}()
+
+		transport, err := AuthenticatePeer(context.Background(), NewStdioTransport(clientIn, clientOut), clientKey, PeerClient)
+		if string(clientKey) != string(key) {
+			if !errors.Is(err, ErrPeerAuthentication) {
+				t.Fatalf("client with the wrong key: %v", err)
+			}
+			if err := <-served; !errors.Is(err, ErrPeerAuthentication) {
+				t.Fatalf("server should refuse the peer: %v", err)
+			}
+			_ = clientOut.Close()
+			_ = serverOut.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := NewClient(transport, WithTimeout(2*time.Second))
+		if result, err := client.Call("ping"); err != nil || result != "pong" {
+			t.Fatalf("%#v %v", result, err)
+		}
+		_ = clientOut.Close()
+		if err := <-served; err != nil {
+			t.Fatal(err)
+		}
+		_ = client.Close()
+		_ = serverOut.Close()
+	}
+}
@@ -0,0 +1,93 @@
+package kkrpc
+
+import "sync"
+
+// PipeTransport is one end of an in-memory connection made by
+// NewPipeTransportPair.
+type PipeTransport struct {
+	in   *pipeQueue
+	out  *pipeQueue
+	done chan struct{}
+	once *sync.Once
+}
+
+// NewPipeTransportPair returns two connected in-process transports: what one
+// writes the other reads, in order. It wires a client to a server in unit
+// tests, callbacks and property access included, without spawning a process
+// or opening a socket. Writes never block; closing either end closes both.
+func NewPipeTransportPair() (*PipeTransport, *PipeTransport) {
+	done := make(chan struct{})
+	once := &sync.Once{}
+	ab, ba := newPipeQueue(), newPipeQueue()
+	return &PipeTransport{in: ba, out: ab, done: done, once: once},
+		&PipeTransport{in: ab, out: ba, done: done, once: once}
+}
+
+func (p *PipeTransport) Read() (string, error) {
+	for {
+		if message, ok := p.in.pop(); ok {
+			return message, nil
+		}
+		select {
+		case <-p.in.ready:
+		case <-p.done:
+			return "", ErrTransportClosed
+		}
+	}
+}
+
+func (p *PipeTransport) Write(message string) error {
+	select {
+	case <-p.done:
+		return ErrTransportClosed
+	default:
+	}
+	p.out.push(message)
+	return nil
+}
+
+func (p *PipeTransport) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// pipeQueue is an unbounded FIFO, so a peer writing from its read loop can
+// never deadlock against one doing the same.
+type pipeQueue struct {
+	mu       sync.Mutex
+	messages []string
+	ready    chan struct{}
+}
+
+func newPipeQueue() *pipeQueue {
+	return &pipeQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *pipeQueue) push(message string) {
+	q.mu.Lock()
+	q.messages = append(q.messages, message)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *pipeQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.messages) == 0 {
+		return "", false
+	}
+	message := q.messages[0]
+	q.messages[0] = ""
+	q.messages = q.messages[1:]
+	if len(q.messages) > 0 {
+		// Pass the signal on in case another reader is waiting.
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+	return message, true
+}
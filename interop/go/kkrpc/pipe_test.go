@@ -0,0 +1,75 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestPipeTransportPairWiresClientAndServer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	settings := map[string]any{"theme": "light"}
+	server := NewServer(right, map[string]any{
+		"settings": settings,
+		"each": MustFunc(func(items []string, visit func(string)) int {
+			for _, item := range items {
+				visit(item)
+			}
+			return len(items)
+		}),
+	})
+	defer server.Close()
+	client := NewClient(left, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	visited := make(chan string, 3)
+	count, err := client.Call("each", []string{"a", "b", "c"}, func(args ...any) { visited <- args[0].(string) })
+	if err != nil || !valuesEqual(3, count) {
+		t.Fatalf("call %v %v", count, err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-visited:
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback not received")
+		}
+	}
+	if _, err := client.Set([]string{"settings", "theme"}, "dark"); err != nil {
+		t.Fatal(err)
+	}
+	if theme, err := client.Get([]string{"settings", "theme"}); err != nil || theme != "dark" {
+		t.Fatalf("get %v %v", theme, err)
+	}
+}
+
+func TestPipeTransportCloseEndsBothSides(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	for i := 0; i < 100; i++ {
+		if err := left.Write("m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if message, err := right.Read(); err != nil || message != "m" {
+		t.Fatalf("read %q %v", message, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := left.Read()
+		done <- err
+	}()
+	right.Close()
+	if err := <-done; !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("blocked read: %v", err)
+	}
+	if err := left.Write("late"); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package kkrpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPoolSize    = 1024
+	poolWorkerIdleTime = 30 * time.Second
+)
+
+var DefaultPool = NewPool(defaultPoolSize)
+
+type Pool struct {
+	tasks  chan func()
+	slots  chan struct{}
+	active atomic.Int64
+}
+
+func NewPool(size int) *Pool {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+	return &Pool{
+		tasks: make(chan func()),
+		slots: make(chan struct{}, size),
+	}
+}
+
+func (p *Pool) Go(fn func()) {
+	select {
+	case p.tasks <- fn:
+		return
+	default:
+	}
+	select {
+	case p.tasks <- fn:
+	case p.slots <- struct{}{}:
+		go p.worker(fn)
+	}
+}
+
+func (p *Pool) Size() int {
+	return cap(p.slots)
+}
+
+func (p *Pool) Workers() int {
+	return len(p.slots)
+}
+
+func (p *Pool) Active() int {
+	return int(p.active.Load())
+}
+
+func (p *Pool) worker(fn func()) {
+	defer func() { <-p.slots }()
+	idle := time.NewTimer(poolWorkerIdleTime)
+	defer idle.Stop()
+	for {
+		p.run(fn)
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(poolWorkerIdleTime)
+		select {
+		case fn = <-p.tasks:
+		case <-idle.C:
+			return
+		}
+	}
+}
+
+func (p *Pool) run(fn func()) {
+	p.active.Add(1)
+	defer p.active.Add(-1)
+	fn()
+}
+
+type dispatcher struct {
+	pool   *Pool
+	budget chan struct{}
+}
+
+func newDispatcher(pool *Pool, maxGoroutines int) *dispatcher {
+	if pool == nil {
+		pool = DefaultPool
+	}
+	d := &dispatcher{pool: pool}
+	if maxGoroutines > 0 {
+		d.budget = make(chan struct{}, maxGoroutines)
+	}
+	return d
+}
+
+func (d *dispatcher) run(fn func()) {
+	d.runWithSlot(func(*dispatchSlot) { fn() })
+}
+
+func (d *dispatcher) runWithSlot(fn func(*dispatchSlot)) {
+	slot := &dispatchSlot{dispatcher: d}
+	slot.acquire()
+	d.pool.Go(func() {
+		defer slot.finish()
+		fn(slot)
+	})
+}
+
+type dispatchSlot struct {
+	dispatcher *dispatcher
+	mu         sync.Mutex
+	held       bool
+	finished   bool
+}
+
+func (s *dispatchSlot) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held || s.finished {
+		return
+	}
+	if s.dispatcher.budget != nil {
+		s.dispatcher.budget <- struct{}{}
+	}
+	s.held = true
+}
+
+func (s *dispatchSlot) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *dispatchSlot) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+	s.finished = true
+}
+
+func (s *dispatchSlot) releaseLocked() {
+	if !s.held {
+		return
+	}
+	if s.dispatcher.budget != nil {
+		<-s.dispatcher.budget
+	}
+	s.held = false
+}
+
+func (d *dispatcher) limit() int {
+	if d.budget == nil {
+		return d.pool.Size()
+	}
+	return min(cap(d.budget), d.pool.Size())
+}
+
+func (d *dispatcher) budgetLimit() int {
+	return cap(d.budget)
+}
@@ -0,0 +1,92 @@
+package kkrpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestPoolBoundsConcurrentWork(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	pool := NewPool(2)
+	var running, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		pool.Go(func() {
+			defer wg.Done()
+			current := running.Add(1)
+			for {
+				previous := peak.Load()
+				if current <= previous || peak.CompareAndSwap(previous, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	wg.Wait()
+
+	if peak.Load() > 2 {
+		t.Fatalf("expected at most 2 concurrent tasks, got %d", peak.Load())
+	}
+	if pool.Workers() > pool.Size() {
+		t.Fatalf("pool spawned %d workers for size %d", pool.Workers(), pool.Size())
+	}
+}
+
+func TestServerRespectsMaxGoroutines(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+
+	release := make(chan struct{})
+	var running, peak atomic.Int64
+	api := map[string]any{
+		"block": func(args ...any) any {
+			current := running.Add(1)
+			if current > peak.Load() {
+				peak.Store(current)
+			}
+			<-release
+			running.Add(-1)
+			return nil
+		},
+	}
+	server := NewServer(transport, api, WithPool(NewPool(8)), WithMaxGoroutines(1))
+	if server.MaxGoroutines() != 1 {
+		t.Fatalf("expected channel budget of 1, got %d", server.MaxGoroutines())
+	}
+
+	for i := 0; i < 3; i++ {
+		request, err := EncodeMessage(map[string]any{
+			"t":  "q",
+			"id": GenerateUUID(),
+			"op": "call",
+			"p":  []any{"block"},
+		})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		go func() { transport.in <- request }()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-transport.out:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("response %d not received", i)
+		}
+	}
+	if peak.Load() != 1 {
+		t.Fatalf("expected handlers to run one at a time, peak was %d", peak.Load())
+	}
+}
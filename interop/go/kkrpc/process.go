@@ -0,0 +1,303 @@
+package kkrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProcessStderr bounds the stderr kept to explain a failed child.
+const maxProcessStderr = 4 << 10
+
+type ProcessOptions struct {
+	// Path is the program to run, looked up on PATH if it has no separator,
+	// e.g. "bun", "node", "python3" or a binary.
+	Path string
+	Args []string
+	Dir  string
+	// Env is the child's environment; nil inherits this process's.
+	Env []string
+	// Stderr also receives the child's stderr, e.g. os.Stderr or a log
+	// writer. Its tail is kept either way to explain a crash.
+	Stderr io.Writer
+	// StopTimeout is how long Close waits for the child to exit after its
+	// stdin is closed before killing it; it defaults to 2s.
+	StopTimeout time.Duration
+	// OnExit, when set, is called once the child has exited, with the error
+	// Err reports.
+	OnExit func(pid int, err error)
+	// SharedKey, when set, makes StartProcess and the child prove to each
+	// other that they hold the same key, e.g. before trusting a setuid
+	// helper; see AuthenticatePeer. The child passes it to ServeStdio.
+	SharedKey []byte
+	// ExtraFDs runs kkrpc over two extra descriptors instead of the child's
+	// stdin and stdout: it reads from fd 3 and writes to fd 4, as EnvFDs
+	// tells it, so stray prints cannot corrupt the stream. ServeStdio and
+	// ParentTransport pick them up. Not supported on Windows.
+	ExtraFDs bool
+	// Stdout receives the child's stdout when ExtraFDs is set; nil discards
+	// it.
+	Stdout io.Writer
+}
+
+// ProcessTransport runs kkrpc over the stdin and stdout of a child process,
+// the way the TS examples spawn Bun or Node servers, or over the extra
+// descriptors of ProcessOptions.ExtraFDs.
+type ProcessTransport struct {
+	*StreamTransport
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	stdout      *os.File
+	stderr      *tailBuffer
+	stopTimeout time.Duration
+	exited      chan struct{}
+	err         error
+	closed      chan struct{}
+	once        sync.Once
+	// session authenticates frames once SharedKey was proven.
+	session *peerSession
+}
+
+// StartProcess spawns the child. ctx only bounds the start, authentication
+// included; the child lives until Close or until it exits.
+func StartProcess(ctx context.Context, opts ProcessOptions) (*ProcessTransport, error) {
+	if opts.Path == "" {
+		return nil, errors.New("kkrpc: ProcessOptions.Path is required")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(opts.Path, opts.Args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	// Unlike StdoutPipe, a pipe of our own is not closed by Wait, so the last
+	// messages can still be read after the child has exited.
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	var stdin io.WriteCloser
+	var childEnds []*os.File
+	if opts.ExtraFDs {
+		childIn, stdinWriter, err := os.Pipe()
+		if err != nil {
+			_ = stdout.Close()
+			_ = stdoutWriter.Close()
+			return nil, err
+		}
+		stdin = stdinWriter
+		childEnds = []*os.File{childIn, stdoutWriter}
+		cmd.ExtraFiles = childEnds
+		cmd.Stdout = opts.Stdout
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, EnvFDs+"=3,4")
+	} else {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			_ = stdout.Close()
+			_ = stdoutWriter.Close()
+			return nil, err
+		}
+		childEnds = []*os.File{stdoutWriter}
+		cmd.Stdout = stdoutWriter
+	}
+	stderr := &tailBuffer{limit: maxProcessStderr}
+	cmd.Stderr = stderr
+	if opts.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, opts.Stderr)
+	}
+	err = cmd.Start()
+	for _, end := range childEnds {
+		_ = end.Close()
+	}
+	if err != nil {
+		_ = stdout.Close()
+		if opts.ExtraFDs {
+			_ = stdin.Close()
+		}
+		return nil, err
+	}
+	t := &ProcessTransport{
+		StreamTransport: NewStreamTransport(processStream{stdout, stdin}),
+		cmd:             cmd,
+		stdin:           stdin,
+		stdout:          stdout,
+		stderr:          stderr,
+		stopTimeout:     opts.StopTimeout,
+		exited:          make(chan struct{}),
+		closed:          make(chan struct{}),
+	}
+	if t.stopTimeout <= 0 {
+		t.stopTimeout = 2 * time.Second
+	}
+	go func() {
+		t.err = cmd.Wait()
+		close(t.exited)
+		if opts.OnExit != nil {
+			opts.OnExit(cmd.Process.Pid, t.Err())
+		}
+	}()
+	if len(opts.SharedKey) > 0 {
+		authCtx, cancel := context.WithTimeout(ctx, peerAuthTimeout)
+		defer cancel()
+		session, err := authenticatePeer(authCtx, t, opts.SharedKey, PeerClient)
+		if err != nil {
+			_ = t.Close()
+			return nil, err
+		}
+		t.session = session
+	}
+	return t, nil
+}
+
+// Pid returns the child's process id.
+func (t *ProcessTransport) Pid() int {
+	return t.cmd.Process.Pid
+}
+
+// Exited is closed once the child has exited.
+func (t *ProcessTransport) Exited() <-chan struct{} {
+	return t.exited
+}
+
+// Read reports why the child exited once its stdout ends, with the tail of
+// its stderr, unless it exited cleanly or Close ended it.
+func (t *ProcessTransport) Read() (string, error) {
+	var message string
+	var err error
+	if t.session != nil {
+		message, err = t.session.read(t.StreamTransport.Read)
+	} else {
+		message, err = t.StreamTransport.Read()
+	}
+	if errors.Is(err, ErrTransportClosed) {
+		select {
+		case <-t.exited:
+		case <-time.After(time.Second):
+		}
+		if exitErr := t.Err(); exitErr != nil {
+			return "", exitErr
+		}
+	}
+	return message, err
+}
+
+func (t *ProcessTransport) Write(message string) error {
+	if t.session != nil {
+		return t.session.write(t.StreamTransport.Write, message)
+	}
+	return t.StreamTransport.Write(message)
+}
+
+// Err returns why the child failed, or nil while it runs, after a clean exit
+// and after Close.
+func (t *ProcessTransport) Err() error {
+	select {
+	case <-t.exited:
+	default:
+		return nil
+	}
+	select {
+	case <-t.closed:
+		return nil
+	default:
+	}
+	if t.err == nil {
+		return nil
+	}
+	name := t.cmd.Path
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if detail := strings.TrimSpace(t.stderr.String()); detail != "" {
+		return fmt.Errorf("kkrpc: %s: %w: %s", name, t.err, detail)
+	}
+	return fmt.Errorf("kkrpc: %s: %w", name, t.err)
+}
+
+// Close closes the child's stdin, which ends a well-behaved kkrpc process,
+// and kills it if it is still running after StopTimeout.
+func (t *ProcessTransport) Close() error {
+	var err error
+	t.once.Do(func() {
+		close(t.closed)
+		_ = t.stdin.Close()
+		select {
+		case <-t.exited:
+		case <-time.After(t.stopTimeout):
+			_ = t.cmd.Process.Kill()
+			<-t.exited
+		}
+		err = t.stdout.Close()
+	})
+	return err
+}
+
+// SuperviseProcess keeps a child running: when it exits it is started again,
+// with backoff, and the same transport carries on with the new process, so a
+// Client or Channel on it survives crashes. restart configures the backoff,
+// MaxAttempts and Logger; its Dial is replaced. Register OnResync hooks on
+// the result to restore the child's state after a restart.
+func SuperviseProcess(ctx context.Context, opts ProcessOptions, restart ReconnectOptions) (*ReconnectingTransport, error) {
+	crashDelay := time.Duration(0)
+	var started time.Time
+	restart.Dial = func(ctx context.Context) (Transport, error) {
+		// A child that dies right after starting would otherwise be
+		// restarted in a tight loop: back off while it keeps crashing.
+		if !started.IsZero() {
+			if time.Since(started) < time.Second {
+				crashDelay = min(max(crashDelay*2, restart.MinBackoff, 100*time.Millisecond), max(restart.MaxBackoff, 10*time.Second))
+			} else {
+				crashDelay = 0
+			}
+			timer := time.NewTimer(crashDelay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		started = time.Now()
+		return StartProcess(ctx, opts)
+	}
+	return DialReconnecting(ctx, restart)
+}
+
+// processStream joins a child's stdout and stdin into one stream.
+type processStream struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	data  []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(bytes.ToValidUTF8(b.data, nil))
+}
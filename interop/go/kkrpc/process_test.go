@@ -0,0 +1,213 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// TestProcessHelperProcess is the child of the tests below: it serves kkrpc
+// on stdin and stdout until stdin closes, or crashes on request. In "fds"
+// mode it serves on the descriptors its parent passed and prints on stdout.
+func TestProcessHelperProcess(t *testing.T) {
+	switch os.Getenv("KKRPC_PROCESS_HELPER") {
+	case "1":
+	case "fds":
+		RunStdioServer(map[string]any{
+			"print": MustFunc(func(line string) string {
+				fmt.Println(line)
+				return "printed"
+			}),
+		})
+	default:
+		t.Skip("helper process")
+	}
+	done := make(chan struct{})
+	stdin := &eofSignal{Reader: os.Stdin, done: done}
+	var transport Transport = NewStdioTransport(stdin, os.Stdout)
+	if key := os.Getenv("KKRPC_PROCESS_HELPER_KEY"); key != "" {
+		authenticated, err := AuthenticatePeer(context.Background(), transport, []byte(key), PeerServer)
+		if err != nil {
+			os.Exit(4)
+		}
+		transport = authenticated
+	}
+	NewServer(transport, map[string]any{
+		"pid": MustFunc(func() int { return os.Getpid() }),
+		"crash": MustFunc(func() {
+			fmt.Fprintln(os.Stderr, "panic: worker out of memory")
+			os.Exit(3)
+		}),
+	})
+	<-done
+	os.Exit(0)
+}
+
+func helperProcess() ProcessOptions {
+	return ProcessOptions{
+		Path: os.Args[0],
+		Args: []string{"-test.run=^TestProcessHelperProcess$"},
+		Env:  append(os.Environ(), "KKRPC_PROCESS_HELPER=1"),
+	}
+}
+
+func TestProcessTransportServesAndStops(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	opts := helperProcess()
+	exits := make(chan error, 1)
+	opts.OnExit = func(pid int, err error) { exits <- err }
+	transport, err := StartProcess(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, WithTimeout(5*time.Second))
+	pid, err := client.Call("pid")
+	if err != nil || pid != float64(transport.Pid()) {
+		t.Fatalf("pid = %v, %v; want %d", pid, err, transport.Pid())
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-exits:
+		if err != nil {
+			t.Fatalf("clean shutdown reported %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnExit was not called")
+	}
+}
+
+func TestProcessTransportReportsCrash(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport, err := StartProcess(context.Background(), helperProcess())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	client := NewClient(transport)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := client.CallContext(ctx, "crash"); err == nil {
+		t.Fatal("crash returned")
+	}
+	select {
+	case <-transport.Exited():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exited not closed after the crash")
+	}
+	err = transport.Err()
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "worker out of memory") {
+		t.Fatalf("expected the crash, got %v", err)
+	}
+}
+
+func TestSuperviseProcessRestartsChild(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport, err := SuperviseProcess(context.Background(), helperProcess(), ReconnectOptions{MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	var resyncs atomic.Int32
+	transport.OnResync(func(context.Context) error {
+		resyncs.Add(1)
+		return nil
+	})
+	first, err := client.Call("pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, _ = client.CallContext(ctx, "crash")
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		second, err := client.Call("pid")
+		if err == nil && second != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child was not restarted: pid %v, %v", second, err)
+		}
+	}
+	if transport.Generation() != 1 || resyncs.Load() != 1 {
+		t.Fatalf("generation %d, resyncs %d", transport.Generation(), resyncs.Load())
+	}
+}
+
+func TestStartProcessAuthenticatesChild(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	for _, key := range []string{"helper key", "guess"} {
+		opts := helperProcess()
+		opts.Env = append(opts.Env, "KKRPC_PROCESS_HELPER_KEY=helper key")
+		opts.SharedKey = []byte(key)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		transport, err := StartProcess(ctx, opts)
+		cancel()
+		if key != "helper key" {
+			if !errors.Is(err, ErrPeerAuthentication) {
+				t.Fatalf("wrong key: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := NewClient(transport, WithTimeout(5*time.Second))
+		if pid, err := client.Call("pid"); err != nil || pid != float64(transport.Pid()) {
+			t.Fatalf("pid = %v, %v", pid, err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestProcessTransportOverExtraFDs(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if runtime.GOOS == "windows" {
+		t.Skip("no extra descriptors on Windows")
+	}
+	stdout := &tailBuffer{limit: 1 << 10}
+	transport, err := StartProcess(context.Background(), ProcessOptions{
+		Path:     os.Args[0],
+		Args:     []string{"-test.run=^TestProcessHelperProcess$"},
+		Env:      append(os.Environ(), "KKRPC_PROCESS_HELPER=fds"),
+		ExtraFDs: true,
+		Stdout:   stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, WithTimeout(5*time.Second))
+	for _, line := range []string{"not json", `{"t":"r","id":"forged"}`} {
+		if result, err := client.Call("print", line); err != nil || result != "printed" {
+			t.Fatalf("print %q: %v %v", line, result, err)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if code := transport.cmd.ProcessState.ExitCode(); code != 0 {
+		t.Fatalf("child exited with %d", code)
+	}
+	if got := stdout.String(); got != "not json\n{\"t\":\"r\",\"id\":\"forged\"}\n" {
+		t.Fatalf("child stdout = %q", got)
+	}
+}
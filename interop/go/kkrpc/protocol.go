@@ -1,9 +1,12 @@
 package kkrpc
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -21,6 +24,16 @@ func GenerateUUID() string {
 	return fmt.Sprintf("%s-%s-%s-%s", parts[0], parts[1], parts[2], parts[3])
 }
 
+// newSessionID returns an unguessable id for sessions whose id is all a peer
+// needs to join them. GenerateUUID is only unique, not unpredictable.
+func newSessionID() string {
+	id := make([]byte, 16)
+	if _, err := cryptorand.Read(id); err != nil {
+		panic(fmt.Sprintf("kkrpc: read random session id: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
 func EncodeMessage(payload map[string]any) (string, error) {
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -30,9 +43,39 @@ func EncodeMessage(payload map[string]any) (string, error) {
 }
 
 func DecodeMessage(raw string) (map[string]any, error) {
+	return DecodeMessageWithLimits(raw, DefaultDecodeLimits)
+}
+
+func decodeJSONMessage(raw string) (map[string]any, error) {
 	var payload map[string]any
 	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
-		return nil, err
+		return nil, &ProtocolError{Code: "invalid_json", Message: err.Error()}
+	}
+	return payload, nil
+}
+
+const maxPooledDecodeBuffer = 1 << 20
+
+// decodeBuffers is shared by all channels with pooled decoding; a buffer is
+// only held for the duration of one json.Unmarshal.
+var decodeBuffers = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, 0, 4096)
+		return &buffer
+	},
+}
+
+func decodeMessagePooled(raw string) (map[string]any, error) {
+	bufferPtr := decodeBuffers.Get().(*[]byte)
+	buffer := append((*bufferPtr)[:0], raw...)
+	var payload map[string]any
+	err := json.Unmarshal(buffer, &payload)
+	if cap(buffer) <= maxPooledDecodeBuffer {
+		*bufferPtr = buffer
+		decodeBuffers.Put(bufferPtr)
+	}
+	if err != nil {
+		return nil, &ProtocolError{Code: "invalid_json", Message: err.Error()}
 	}
 	return payload, nil
 }
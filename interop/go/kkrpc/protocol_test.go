@@ -0,0 +1,199 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+var benchmarkMessage = func() string {
+	message, err := EncodeMessage(map[string]any{
+		"t":  "q",
+		"id": "bench-request",
+		"op": "call",
+		"p":  []any{"media", "upload"},
+		"a": []any{
+			map[string]any{"name": "frame.png", "tags": []any{"a", "b", "c"}},
+			strings.Repeat("payload", 512),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return strings.TrimSpace(message)
+}()
+
+func TestPooledDecodingMatchesDecodeMessage(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	expected, err := DecodeMessage(benchmarkMessage)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		actual, err := decodeMessagePooled(benchmarkMessage)
+		if err != nil {
+			t.Fatalf("pooled decode: %v", err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("pooled decode differs:\n%#v\n%#v", expected, actual)
+		}
+	}
+	if _, err := decodeMessagePooled("{not json"); err == nil {
+		t.Fatalf("expected invalid JSON to fail")
+	}
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkMessage)))
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeMessage(benchmarkMessage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessagePooled(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkMessage)))
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeMessagePooled(benchmarkMessage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessagePooledParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkMessage)))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := decodeMessagePooled(benchmarkMessage); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDecodeLimitsRejectNestingBombs(t *testing.T) {
+	bomb := `{"t":"q","a":` + strings.Repeat("[", 300) + strings.Repeat("]", 300) + `}`
+	_, err := DecodeMessage(bomb)
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) || protocolErr.Code != "too_deep" {
+		t.Fatalf("expected too_deep protocol error, got %v", err)
+	}
+
+	quoted := `{"t":"q","v":"` + strings.Repeat("[", 300) + `"}`
+	if _, err := DecodeMessage(quoted); err != nil {
+		t.Fatalf("brackets inside strings must not count: %v", err)
+	}
+
+	limits := DecodeLimits{MaxBytes: 64, MaxElements: 5}
+	if _, err := DecodeMessageWithLimits(`{"a":[1,2,3,4,5,6]}`, limits); !errors.As(err, &protocolErr) || protocolErr.Code != "too_many_elements" {
+		t.Fatalf("expected too_many_elements, got %v", err)
+	}
+	if _, err := DecodeMessageWithLimits(`{"v":"`+strings.Repeat("x", 100)+`"}`, limits); !errors.As(err, &protocolErr) || protocolErr.Code != "too_large" {
+		t.Fatalf("expected too_large, got %v", err)
+	}
+	if _, err := DecodeMessageWithLimits(`{"a":[1,2]}`, limits); err != nil {
+		t.Fatalf("small message rejected: %v", err)
+	}
+}
+
+func TestRejectedFramesAreReportedToPeer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	server := NewServer(transport, map[string]any{})
+	defer server.Close()
+
+	transport.in <- `{"t":"q","id":"broken-1",`
+	reply, err := DecodeMessage(<-transport.out)
+	if err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	if reply["t"] != "protocol_error" || reply["code"] != "invalid_json" || reply["id"] != "broken-1" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+}
+
+func TestNonProtocolLinesAreIgnored(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	server := NewServer(transport, map[string]any{"ping": func(args ...any) any { return "pong" }})
+	defer server.Close()
+
+	transport.in <- "listening on 8080"
+	transport.in <- `{"level":"info","msg":"ready"}`
+	transport.in <- `{"t":"q","id":"1","op":"call","p":["ping"]}`
+	reply, _ := DecodeMessage(<-transport.out)
+	if reply["t"] != "r" || reply["id"] != "1" {
+		t.Fatalf("log lines were answered: %v", reply)
+	}
+}
+
+func TestFrameHeaderReadsTopLevelFields(t *testing.T) {
+	raw := `{"a":[{"__kkrpc_next_arg__":"callback","id":"cb-1"}],"id":"req-1","op":"call","t":"q"}`
+	if h := frameHeader(raw); h.id != "req-1" || h.t != "q" {
+		t.Fatalf("header %+v", h)
+	}
+	if h := frameHeader(`{"t":"q","a":[{"id":"nested"}`); h.id != "" || h.t != "q" {
+		t.Fatalf("truncated frame header %+v", h)
+	}
+}
+
+func TestSessionIDsAreRandom(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newSessionID()
+		if raw, err := base64.RawURLEncoding.DecodeString(id); err != nil || len(raw) != 16 {
+			t.Fatalf("session id %q: %d bytes, %v", id, len(raw), err)
+		}
+		if seen[id] {
+			t.Fatalf("session id %q repeated", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestProtocolErrorFailsPendingCall(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	reported := make(chan *ProtocolError, 1)
+	client := NewClient(clientTransport, WithProtocolErrorHandler(func(err *ProtocolError) { reported <- err }))
+	server := NewServer(serverTransport, map[string]any{
+		"echo": func(args ...any) any { return args[0] },
+	}, WithDecodeLimits(DecodeLimits{MaxBytes: 256}))
+	defer client.Close()
+	defer server.Close()
+
+	_, err := client.Call("echo", strings.Repeat("x", 512))
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) || protocolErr.Code != "too_large" {
+		t.Fatalf("expected too_large protocol error, got %v", err)
+	}
+	if got := <-reported; got.Code != "too_large" {
+		t.Fatalf("handler saw %v", got)
+	}
+	if result, err := client.Call("echo", "ok"); err != nil || result != "ok" {
+		t.Fatalf("follow-up call: %v %v", result, err)
+	}
+
+	// A callback argument carries an "id" of its own, sorted before the
+	// request's.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = client.CallContext(ctx, "echo", strings.Repeat("x", 512), func(...any) {})
+	if !errors.As(err, &protocolErr) || protocolErr.Code != "too_large" {
+		t.Fatalf("call with a callback: %v", err)
+	}
+}
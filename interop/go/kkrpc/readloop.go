@@ -0,0 +1,93 @@
+package kkrpc
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadLoopPolicy decides what happens when handling a message panics on a
+// connection's read loop, e.g. in a codec or a MessageHandler.
+type ReadLoopPolicy int
+
+const (
+	// FailOnReadLoopPanic closes the transport and fails every pending call,
+	// so the channel fails loudly rather than going deaf.
+	FailOnReadLoopPanic ReadLoopPolicy = iota
+	// RestartReadLoop drops the connection of a ReconnectingTransport and
+	// keeps reading once it has reconnected and resynced. Other transports,
+	// and loops that keep panicking, fail as with FailOnReadLoopPanic.
+	RestartReadLoop
+)
+
+// A read loop restarts at most maxReadLoopRestarts times per
+// readLoopRestartWindow; after that it fails.
+const (
+	maxReadLoopRestarts   = 5
+	readLoopRestartWindow = time.Minute
+)
+
+// ReadLoopError reports a panic on a read loop. Calls that fail because of it
+// get an error matching both ErrTransportClosed and *ReadLoopError.
+type ReadLoopError struct {
+	// Panic is the recovered value and Stack where it was raised.
+	Panic any
+	Stack []byte
+	// Restarted is set when the loop carries on under RestartReadLoop.
+	Restarted bool
+}
+
+func (e *ReadLoopError) Error() string {
+	return fmt.Sprintf("kkrpc: read loop panicked: %v", e.Panic)
+}
+
+// WithReadLoopPolicy sets what a panic on the read loop does; the default is
+// FailOnReadLoopPanic.
+func WithReadLoopPolicy(policy ReadLoopPolicy) Option {
+	return func(o *options) {
+		o.readLoopPolicy = policy
+	}
+}
+
+// WithReadLoopErrorHandler is called on the read loop for every panic it
+// recovers, after it is logged and before the channel fails or the loop
+// restarts.
+func WithReadLoopErrorHandler(handler func(*ReadLoopError)) Option {
+	return func(o *options) {
+		o.onReadLoopErr = handler
+	}
+}
+
+// readLoopRestarts limits how often a read loop restarts.
+type readLoopRestarts []time.Time
+
+func (r *readLoopRestarts) allow(now time.Time) bool {
+	recent := (*r)[:0]
+	for _, at := range *r {
+		if now.Sub(at) < readLoopRestartWindow {
+			recent = append(recent, at)
+		}
+	}
+	*r = recent
+	if len(recent) >= maxReadLoopRestarts {
+		return false
+	}
+	*r = append(recent, now)
+	return true
+}
+
+// superviseReadLoop handles a panic recovered from the read loop and reports
+// whether the loop should carry on.
+func superviseReadLoop(transport Transport, o *options, loopErr *ReadLoopError, restarts *readLoopRestarts) bool {
+	reconnecting, resumable := transport.(*ReconnectingTransport)
+	loopErr.Restarted = resumable && o.readLoopPolicy == RestartReadLoop && restarts.allow(time.Now())
+	o.logger.Printf("%v\n%s", loopErr, loopErr.Stack)
+	if o.onReadLoopErr != nil {
+		o.onReadLoopErr(loopErr)
+	}
+	if loopErr.Restarted {
+		reconnecting.drop(loopErr)
+		return true
+	}
+	_ = transport.Close()
+	return false
+}
@@ -0,0 +1,99 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// poisonHandler panics on the read loop for every "x-poison" message.
+var poisonHandler = WithMessageHandler("x-poison", func(map[string]any) { panic("bad frame") })
+
+func TestReadLoopPanicFailsChannel(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	clientTransport, serverTransport := NewPipeTransportPair()
+	defer serverTransport.Close()
+	events := make(chan *ReadLoopError, 1)
+	logger := &recordingLogger{lines: make(chan string, 4)}
+	client := NewClient(clientTransport, poisonHandler, WithLogger(logger),
+		WithReadLoopErrorHandler(func(err *ReadLoopError) { events <- err }),
+		// Not resumable, so it fails anyway.
+		WithReadLoopPolicy(RestartReadLoop))
+
+	done := callAsync(context.Background(), client, "slow")
+	time.Sleep(20 * time.Millisecond)
+	_ = serverTransport.Write(`{"t":"x-poison"}`)
+
+	result := <-done
+	var loopErr *ReadLoopError
+	if !errors.Is(result.err, ErrTransportClosed) || !errors.As(result.err, &loopErr) || loopErr.Panic != "bad frame" {
+		t.Fatalf("pending call: %v", result.err)
+	}
+	if event := <-events; event != loopErr || event.Restarted || len(event.Stack) == 0 {
+		t.Fatalf("event %+v", event)
+	}
+	if line := <-logger.lines; !strings.HasPrefix(line, "kkrpc: read loop panicked: bad frame\n") {
+		t.Fatalf("logged %q", line)
+	}
+	if err := clientTransport.Write("{}"); err == nil {
+		t.Fatal("transport still open")
+	}
+}
+
+func TestReadLoopRestartsReconnectingTransport(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	dial := func(context.Context) (Transport, error) {
+		local, remote := NewPipeTransportPair()
+		NewServer(remote, map[string]any{
+			"echo": func(args ...any) any { return args[0] },
+			"poison": MustFunc(func() string {
+				_ = remote.Write(`{"t":"x-poison"}`)
+				return "sent"
+			}),
+		})
+		return local, nil
+	}
+	transport, err := DialReconnecting(context.Background(), ReconnectOptions{Dial: dial, MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	events := make(chan *ReadLoopError, maxReadLoopRestarts+1)
+	client := NewClient(transport, poisonHandler, WithTimeout(2*time.Second), WithLogger(nopLogger{}),
+		WithReadLoopPolicy(RestartReadLoop),
+		WithReadLoopErrorHandler(func(err *ReadLoopError) { events <- err }))
+
+	// The response to "poison" is lost with the dropped connection.
+	poison := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, _ = client.CallContext(ctx, "poison")
+	}
+	for i := 1; i <= maxReadLoopRestarts; i++ {
+		poison()
+		if event := <-events; !event.Restarted {
+			t.Fatalf("restart %d: loop failed", i)
+		}
+		if result, err := client.Call("echo", "alive"); err != nil || result != "alive" {
+			t.Fatalf("after restart %d: %v %v", i, result, err)
+		}
+		if transport.Generation() != uint64(i) {
+			t.Fatalf("generation %d after restart %d", transport.Generation(), i)
+		}
+	}
+
+	// A loop that keeps panicking fails in the end.
+	poison()
+	if event := <-events; event.Restarted {
+		t.Fatal("restarted past the limit")
+	}
+	if _, err := client.Call("echo", "dead"); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("call on the failed channel: %v", err)
+	}
+}
@@ -0,0 +1,462 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ResyncFunc restores session state on a fresh connection: it re-exposes an
+// API, re-subscribes topics or re-registers persistent callbacks.
+type ResyncFunc func(ctx context.Context) error
+
+type ReconnectOptions struct {
+	// Dial opens a new connection to the peer; it is required.
+	Dial func(ctx context.Context) (Transport, error)
+	// MinBackoff and MaxBackoff bound the exponential delay between failed
+	// dials. They default to 100ms and 10s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Jitter spreads every delay by up to this fraction either way, so that
+	// clients which lost the same server do not redial it in lockstep. It
+	// defaults to 0.2; a negative value disables it.
+	Jitter float64
+	// MaxAttempts gives up after this many consecutive failed dials, closing
+	// the transport; zero retries until Close.
+	MaxAttempts int
+	// ResyncTimeout bounds one run of the resync hooks; it defaults to 30s.
+	ResyncTimeout time.Duration
+	// Logger receives dial failures and resync hook errors.
+	Logger Logger
+	// OnStateChange, when set, is called on every transition with the new
+	// state and, for StateReconnecting and StateClosed, the error that caused it.
+	OnStateChange func(state ConnState, err error)
+	// SendQueue, when positive, holds up to this many messages written while
+	// reconnecting instead of making the writers wait. They are sent in order
+	// on the new connection, after the greetings and before anything else,
+	// resync hooks included. Calls still time out meanwhile.
+	SendQueue int
+	// QueueOverflow is what a write does when the queue is full.
+	QueueOverflow QueueOverflow
+	// OnQueueDrop, when set, is called with every message DropOldest discards
+	// and every queued message left unsent by Close.
+	OnQueueDrop func(message string)
+}
+
+// ErrSendQueueFull is returned by writes to a full send queue under
+// RejectWhenFull.
+var ErrSendQueueFull = errors.New("kkrpc: send queue full")
+
+// QueueOverflow is what a write does when ReconnectOptions.SendQueue is full.
+type QueueOverflow int
+
+const (
+	// RejectWhenFull fails the write with ErrSendQueueFull.
+	RejectWhenFull QueueOverflow = iota
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest
+	// BlockWhenFull waits for the connection, as writes do without a queue.
+	BlockWhenFull
+)
+
+// ConnState is where a ReconnectingTransport stands.
+type ConnState int
+
+const (
+	// StateConnected carries traffic over a live connection.
+	StateConnected ConnState = iota
+	// StateReconnecting lost its connection and is redialing; reads wait, and
+	// writes wait or are queued.
+	StateReconnecting
+	// StateClosed was closed or gave up; reads and writes fail.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// ReconnectingTransport redials the peer whenever its connection fails, so a
+// Client or Channel built on it outlives peer restarts. Reads and writes wait
+// while it reconnects, unless SendQueue lets writes queue up. Calls in flight
+// when the connection drops are lost and fail by timeout; the peer's state is
+// lost too, which is what hooks registered with OnResync are for.
+type ReconnectingTransport struct {
+	opts ReconnectOptions
+
+	mu           sync.Mutex
+	state        ConnState
+	current      Transport
+	generation   uint64
+	ready        chan struct{}
+	hooks        []ResyncFunc
+	greetings    []map[string]any
+	resyncCancel context.CancelFunc
+	err          error
+	queue        []string
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// DialReconnecting makes the first connection, returning its error if that
+// fails, and keeps reconnecting afterwards.
+func DialReconnecting(ctx context.Context, opts ReconnectOptions) (*ReconnectingTransport, error) {
+	if opts.Dial == nil {
+		return nil, errors.New("kkrpc: ReconnectOptions.Dial is required")
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 10 * time.Second
+	}
+	if opts.Jitter == 0 {
+		opts.Jitter = 0.2
+	}
+	if opts.ResyncTimeout <= 0 {
+		opts.ResyncTimeout = 30 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	inner, err := opts.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ready := make(chan struct{})
+	close(ready)
+	return &ReconnectingTransport{
+		opts:    opts,
+		current: inner,
+		ready:   ready,
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+// OnResync registers a hook run after every reconnect, once the new
+// connection carries traffic. Hooks run in registration order on their own
+// goroutine, so they can make calls over this transport; an error is logged
+// and does not stop the hooks after it. A reconnect cancels the context of
+// hooks still running for the previous one.
+func (t *ReconnectingTransport) OnResync(hook ResyncFunc) {
+	t.mu.Lock()
+	t.hooks = append(t.hooks, hook)
+	t.mu.Unlock()
+}
+
+// greet registers a message written first on every new connection, before
+// any traffic, as the announcement of WithResumableCallbacks.
+func (t *ReconnectingTransport) greet(payload map[string]any) {
+	t.mu.Lock()
+	t.greetings = append(t.greetings, payload)
+	t.mu.Unlock()
+}
+
+// State reports whether the transport is connected, reconnecting or closed.
+func (t *ReconnectingTransport) State() ConnState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+func (t *ReconnectingTransport) notify(state ConnState, err error) {
+	if t.opts.OnStateChange != nil {
+		t.opts.OnStateChange(state, err)
+	}
+}
+
+// Generation counts the reconnects so far.
+func (t *ReconnectingTransport) Generation() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generation
+}
+
+func (t *ReconnectingTransport) Read() (string, error) {
+	for {
+		inner, generation, err := t.wait()
+		if err != nil {
+			return "", err
+		}
+		message, err := inner.Read()
+		if err == nil {
+			return message, nil
+		}
+		t.fail(generation, err)
+	}
+}
+
+func (t *ReconnectingTransport) Write(message string) error {
+	for {
+		if queued, err := t.enqueue(message); queued {
+			return err
+		}
+		inner, generation, err := t.wait()
+		if err != nil {
+			return err
+		}
+		err = inner.Write(message)
+		if err == nil {
+			return nil
+		}
+		t.fail(generation, err)
+	}
+}
+
+// enqueue queues message if the transport is reconnecting and has a send
+// queue, reporting whether the write is settled, with the error it gets.
+func (t *ReconnectingTransport) enqueue(message string) (bool, error) {
+	if t.opts.SendQueue <= 0 {
+		return false, nil
+	}
+	t.mu.Lock()
+	if t.err != nil || t.state != StateReconnecting {
+		t.mu.Unlock()
+		return false, nil
+	}
+	var dropped string
+	if len(t.queue) >= t.opts.SendQueue {
+		switch t.opts.QueueOverflow {
+		case DropOldest:
+			dropped = t.queue[0]
+			t.queue = t.queue[1:]
+		case BlockWhenFull:
+			t.mu.Unlock()
+			return false, nil
+		default:
+			t.mu.Unlock()
+			return true, ErrSendQueueFull
+		}
+	}
+	t.queue = append(t.queue, message)
+	t.mu.Unlock()
+	if dropped != "" && t.opts.OnQueueDrop != nil {
+		t.opts.OnQueueDrop(dropped)
+	}
+	return true, nil
+}
+
+// Queued reports how many messages wait in the send queue.
+func (t *ReconnectingTransport) Queued() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queue)
+}
+
+// flush sends the queued messages on inner until the queue stays empty and
+// returns with t.mu held, so no write can slip in before inner is installed.
+// If inner fails, the messages it did not take are queued again.
+func (t *ReconnectingTransport) flush(inner Transport) error {
+	for {
+		t.mu.Lock()
+		if len(t.queue) == 0 || t.err != nil {
+			return nil
+		}
+		batch := t.queue
+		t.queue = nil
+		t.mu.Unlock()
+		for i, message := range batch {
+			if err := inner.Write(message); err != nil {
+				t.mu.Lock()
+				t.queue = append(batch[i:], t.queue...)
+				return err
+			}
+		}
+	}
+}
+
+// ping uses the current connection's own ping, if it has one.
+func (t *ReconnectingTransport) ping() error {
+	inner, _, err := t.wait()
+	if err != nil {
+		return err
+	}
+	if p, ok := inner.(pinger); ok {
+		return p.ping()
+	}
+	return errNoPing
+}
+
+// drop gives up the current connection as if it had failed with err.
+func (t *ReconnectingTransport) drop(err error) {
+	t.mu.Lock()
+	generation := t.generation
+	t.mu.Unlock()
+	t.fail(generation, err)
+}
+
+func (t *ReconnectingTransport) Close() error {
+	t.finish(ErrTransportClosed)
+	return nil
+}
+
+// wait returns the live connection, blocking while a reconnect is under way.
+func (t *ReconnectingTransport) wait() (Transport, uint64, error) {
+	for {
+		t.mu.Lock()
+		inner, generation, ready, err := t.current, t.generation, t.ready, t.err
+		t.mu.Unlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		select {
+		case <-ready:
+			if inner != nil {
+				return inner, generation, nil
+			}
+		case <-t.closed:
+		}
+	}
+}
+
+// fail starts a reconnect unless the failed connection was already replaced.
+func (t *ReconnectingTransport) fail(generation uint64, err error) {
+	t.mu.Lock()
+	if t.err != nil || generation != t.generation || t.current == nil {
+		t.mu.Unlock()
+		return
+	}
+	old := t.current
+	t.current = nil
+	t.state = StateReconnecting
+	t.ready = make(chan struct{})
+	if t.resyncCancel != nil {
+		t.resyncCancel()
+		t.resyncCancel = nil
+	}
+	t.mu.Unlock()
+	_ = old.Close()
+	t.opts.Logger.Printf("kkrpc: connection lost, reconnecting: %v", err)
+	t.notify(StateReconnecting, err)
+	go t.redial()
+}
+
+func (t *ReconnectingTransport) redial() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	backoff := t.opts.MinBackoff
+	for attempt := 1; ; attempt++ {
+		inner, err := t.opts.Dial(ctx)
+		if err == nil {
+			if err = t.install(inner); err == nil {
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		t.opts.Logger.Printf("kkrpc: reconnect attempt %d failed: %v", attempt, err)
+		if t.opts.MaxAttempts > 0 && attempt >= t.opts.MaxAttempts {
+			t.finish(fmt.Errorf("kkrpc: gave up reconnecting after %d attempts: %w", attempt, err))
+			return
+		}
+		timer := time.NewTimer(t.jitter(backoff))
+		select {
+		case <-timer.C:
+		case <-t.closed:
+			timer.Stop()
+			return
+		}
+		backoff = min(backoff*2, t.opts.MaxBackoff)
+	}
+}
+
+func (t *ReconnectingTransport) jitter(delay time.Duration) time.Duration {
+	if t.opts.Jitter <= 0 {
+		return delay
+	}
+	spread := float64(delay) * min(t.opts.Jitter, 1)
+	return delay + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// install makes inner the live connection, unless sending it the queued
+// messages fails.
+func (t *ReconnectingTransport) install(inner Transport) error {
+	t.mu.Lock()
+	greetings := append([]map[string]any(nil), t.greetings...)
+	t.mu.Unlock()
+	for _, greeting := range greetings {
+		message, err := EncodeMessage(greeting)
+		if err == nil {
+			err = inner.Write(message)
+		}
+		if err != nil {
+			t.opts.Logger.Printf("kkrpc: greet new connection: %v", err)
+		}
+	}
+	if err := t.flush(inner); err != nil {
+		t.mu.Unlock()
+		_ = inner.Close()
+		return fmt.Errorf("flush send queue: %w", err)
+	}
+	if t.err != nil {
+		t.mu.Unlock()
+		_ = inner.Close()
+		return nil
+	}
+	t.current = inner
+	t.state = StateConnected
+	t.generation++
+	close(t.ready)
+	hooks := append([]ResyncFunc(nil), t.hooks...)
+	ctx, cancel := context.WithTimeout(context.Background(), t.opts.ResyncTimeout)
+	t.resyncCancel = cancel
+	t.mu.Unlock()
+	t.notify(StateConnected, nil)
+	go func() {
+		defer cancel()
+		for _, hook := range hooks {
+			if err := hook(ctx); err != nil {
+				t.opts.Logger.Printf("kkrpc: resync hook failed: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *ReconnectingTransport) finish(err error) {
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.err = err
+		t.state = StateClosed
+		inner := t.current
+		if t.resyncCancel != nil {
+			t.resyncCancel()
+		}
+		unsent := t.queue
+		t.queue = nil
+		t.mu.Unlock()
+		close(t.closed)
+		if inner != nil {
+			_ = inner.Close()
+		}
+		if t.opts.OnQueueDrop != nil {
+			for _, message := range unsent {
+				t.opts.OnQueueDrop(message)
+			}
+		}
+		if errors.Is(err, ErrTransportClosed) {
+			err = nil
+		}
+		t.notify(StateClosed, err)
+	})
+}
@@ -0,0 +1,295 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// restartingPeer serves api on a fresh Server for every dial, like a sidecar
+// that loses its state when it restarts.
+type restartingPeer struct {
+	mu     sync.Mutex
+	ends   []*PipeTransport
+	refuse bool
+	topics []string
+	lines  []string
+}
+
+// peerEnd records the lines the peer reads, in the order they arrived.
+type peerEnd struct {
+	Transport
+	peer *restartingPeer
+}
+
+func (e peerEnd) Read() (string, error) {
+	line, err := e.Transport.Read()
+	if err == nil {
+		e.peer.mu.Lock()
+		e.peer.lines = append(e.peer.lines, line)
+		e.peer.mu.Unlock()
+	}
+	return line, err
+}
+
+func (p *restartingPeer) dial(context.Context) (Transport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refuse {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := NewPipeTransportPair()
+	p.ends = append(p.ends, remote)
+	p.topics = nil
+	p.lines = nil
+	NewServer(peerEnd{remote, p}, map[string]any{
+		"subscribe": MustFunc(func(topic string) {
+			p.mu.Lock()
+			p.topics = append(p.topics, topic)
+			p.mu.Unlock()
+		}),
+		"topics": MustFunc(func() []string {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			return append([]string(nil), p.topics...)
+		}),
+	})
+	return local, nil
+}
+
+func (p *restartingPeer) restart() {
+	p.mu.Lock()
+	end := p.ends[len(p.ends)-1]
+	p.mu.Unlock()
+	_ = end.Close()
+}
+
+func TestReconnectingTransportRunsResyncHooks(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	peer := &restartingPeer{}
+	transport, err := DialReconnecting(context.Background(), ReconnectOptions{Dial: peer.dial, MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	subscribe := func(ctx context.Context) error {
+		_, err := client.CallContext(ctx, "subscribe", "orders")
+		return err
+	}
+	resynced := make(chan struct{}, 1)
+	transport.OnResync(subscribe)
+	transport.OnResync(func(context.Context) error {
+		resynced <- struct{}{}
+		return nil
+	})
+	if err := subscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	peer.restart()
+	select {
+	case <-resynced:
+	case <-time.After(2 * time.Second):
+		t.Fatal("resync hooks did not run")
+	}
+	if transport.Generation() != 1 {
+		t.Fatalf("generation %d", transport.Generation())
+	}
+	topics, err := client.Call("topics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, ok := topics.([]any); !ok || len(list) != 1 || list[0] != "orders" {
+		t.Fatalf("topics after resync: %v", topics)
+	}
+}
+
+func TestReconnectingTransportGivesUp(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	peer := &restartingPeer{}
+	transport, err := DialReconnecting(context.Background(), ReconnectOptions{Dial: peer.dial, MinBackoff: time.Millisecond, MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.mu.Lock()
+	peer.refuse = true
+	peer.mu.Unlock()
+	peer.restart()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := transport.Read()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || errors.Is(err, ErrTransportClosed) {
+			t.Fatalf("expected the dial error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not give up")
+	}
+	if err := transport.Write("{}\n"); err == nil {
+		t.Fatal("write succeeded after giving up")
+	}
+}
+
+func TestReconnectingTransportReportsStateChanges(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	peer := &restartingPeer{}
+	states := make(chan ConnState, 8)
+	transport, err := DialReconnecting(context.Background(), ReconnectOptions{
+		Dial:       peer.dial,
+		MinBackoff: time.Millisecond,
+		OnStateChange: func(state ConnState, err error) {
+			if (state == StateReconnecting) != (err != nil) {
+				t.Errorf("state %v with error %v", state, err)
+			}
+			states <- state
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewClient(transport)
+	if transport.State() != StateConnected {
+		t.Fatalf("initial state %v", transport.State())
+	}
+
+	peer.restart()
+	for _, want := range []ConnState{StateReconnecting, StateConnected} {
+		select {
+		case state := <-states:
+			if state != want {
+				t.Fatalf("state %v, want %v", state, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no transition to %v", want)
+		}
+	}
+	_ = transport.Close()
+	if state := <-states; state != StateClosed || transport.State() != StateClosed {
+		t.Fatalf("state %v after Close", state)
+	}
+}
+
+func TestReconnectingTransportJitter(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := &ReconnectingTransport{opts: ReconnectOptions{Jitter: 0.5}}
+	for i := 0; i < 100; i++ {
+		if delay := transport.jitter(time.Second); delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("jittered delay %v", delay)
+		}
+	}
+	transport.opts.Jitter = -1
+	if delay := transport.jitter(time.Second); delay != time.Second {
+		t.Fatalf("delay %v with jitter disabled", delay)
+	}
+}
+
+func TestReconnectingTransportQueuesWritesInOrder(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	peer := &restartingPeer{}
+	transport, err := DialReconnecting(context.Background(), ReconnectOptions{
+		Dial: peer.dial, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, SendQueue: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	client := NewClient(transport, WithTimeout(2*time.Second), WithLogger(nopLogger{}))
+
+	peer.mu.Lock()
+	peer.refuse = true
+	peer.mu.Unlock()
+	peer.restart()
+	for transport.State() != StateReconnecting {
+		time.Sleep(time.Millisecond)
+	}
+	topics := []string{"orders", "invoices", "refunds"}
+	var results []<-chan callResult
+	for i, topic := range topics {
+		results = append(results, callAsync(context.Background(), client, "subscribe", topic))
+		for transport.Queued() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	peer.mu.Lock()
+	peer.refuse = false
+	peer.mu.Unlock()
+	for i, done := range results {
+		if result := <-done; result.err != nil {
+			t.Fatalf("queued call %d: %v", i, result.err)
+		}
+	}
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if len(peer.lines) != len(topics) {
+		t.Fatalf("peer read %q", peer.lines)
+	}
+	for i, topic := range topics {
+		if !strings.Contains(peer.lines[i], topic) {
+			t.Fatalf("line %d is %q, want %s", i, peer.lines[i], topic)
+		}
+	}
+}
+
+func TestReconnectingTransportQueueOverflow(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	for _, overflow := range []QueueOverflow{RejectWhenFull, DropOldest} {
+		peer := &restartingPeer{}
+		var dropped []string
+		transport, err := DialReconnecting(context.Background(), ReconnectOptions{
+			Dial: peer.dial, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond,
+			SendQueue: 2, QueueOverflow: overflow,
+			OnQueueDrop: func(message string) { dropped = append(dropped, message) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The client's read loop notices the dropped connection.
+		NewClient(transport, WithLogger(nopLogger{}))
+		peer.mu.Lock()
+		peer.refuse = true
+		peer.mu.Unlock()
+		peer.restart()
+		for transport.State() != StateReconnecting {
+			time.Sleep(time.Millisecond)
+		}
+		for _, message := range []string{"1", "2"} {
+			if err := transport.Write(message); err != nil {
+				t.Fatalf("%v: write %s: %v", overflow, message, err)
+			}
+		}
+		err = transport.Write("3")
+		switch overflow {
+		case RejectWhenFull:
+			if !errors.Is(err, ErrSendQueueFull) || len(dropped) != 0 {
+				t.Fatalf("reject: %v, dropped %v", err, dropped)
+			}
+		case DropOldest:
+			if err != nil || len(dropped) != 1 || dropped[0] != "1" {
+				t.Fatalf("drop oldest: %v, dropped %v", err, dropped)
+			}
+		}
+		// Close hands back what was never sent.
+		dropped = nil
+		_ = transport.Close()
+		if len(dropped) != 2 {
+			t.Fatalf("%v: unsent on close %v", overflow, dropped)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultRedisURL is used when no URL is configured, like the TypeScript
+// Redis transports.
+const DefaultRedisURL = "redis://localhost:6379"
+
+// RedisError is an error reply from the server, such as BUSYGROUP.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// redisConn speaks just enough RESP2 for the Redis transports: commands go out
+// as arrays of bulk strings and replies are decoded into string, int64, nil,
+// []any or RedisError.
+type redisConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialRedis connects to redis://[user:password@]host[:port][/db], or rediss://
+// for TLS, authenticating and selecting the database when the URL says so.
+func dialRedis(ctx context.Context, rawURL string) (*redisConn, error) {
+	if rawURL == "" {
+		rawURL = DefaultRedisURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	var conn net.Conn
+	switch parsed.Scheme {
+	case "redis":
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "rediss":
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: parsed.Hostname()}}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("kkrpc: unsupported redis URL scheme %q", parsed.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if password, ok := parsed.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if user := parsed.User.Username(); user != "" {
+			args = []string{"AUTH", user, password}
+		}
+		if _, err := c.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db := strings.TrimPrefix(parsed.Path, "/"); db != "" && db != "0" {
+		if _, err := c.do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.receive()
+}
+
+func (c *redisConn) send(args ...string) error {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&builder, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, builder.String())
+	return err
+}
+
+// receive reads one reply. Error replies are returned as the error.
+func (c *redisConn) receive() (any, error) {
+	reply, err := readRedisReply(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if redisErr, ok := reply.(RedisError); ok {
+		return nil, redisErr
+	}
+	return reply, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+func readRedisReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("kkrpc: empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return RedisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := readRedisReply(reader)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("kkrpc: unexpected redis reply %q", line)
+	}
+}
@@ -0,0 +1,158 @@
+package kkrpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const defaultRedisChannel = "kkrpc"
+
+type RedisPubSubOptions struct {
+	// URL defaults to DefaultRedisURL.
+	URL string
+	// Channel is subscribed and published to by every peer; defaults to "kkrpc".
+	Channel string
+	// ReadChannel and WriteChannel override Channel for one direction, e.g.
+	// "api:requests" and "api:responses" on the server and the reverse on the
+	// client.
+	ReadChannel  string
+	WriteChannel string
+	// LocalPeerID identifies this end so it skips its own messages on a shared
+	// channel; defaults to a random id.
+	LocalPeerID string
+	// RemotePeerID, when set, addresses messages to that peer only.
+	RemotePeerID string
+}
+
+// RedisPubSubTransport exchanges messages through Redis Pub/Sub. Unlike
+// RedisStreamsTransport nothing is stored: messages published while a peer is
+// not subscribed are lost, which suits fire-and-forget channels. Messages
+// carry the same kkrpc.bus.v1 envelope as the streams transport.
+type RedisPubSubTransport struct {
+	opts       RedisPubSubOptions
+	publisher  *redisConn
+	subscriber *redisConn
+	messages   chan string
+	done       chan struct{}
+	err        error
+	closed     chan struct{}
+	once       sync.Once
+}
+
+// DialRedisPubSub returns once the read channel is subscribed, so messages
+// published afterwards are not missed.
+func DialRedisPubSub(ctx context.Context, opts RedisPubSubOptions) (*RedisPubSubTransport, error) {
+	if opts.Channel == "" {
+		opts.Channel = defaultRedisChannel
+	}
+	if opts.ReadChannel == "" {
+		opts.ReadChannel = opts.Channel
+	}
+	if opts.WriteChannel == "" {
+		opts.WriteChannel = opts.Channel
+	}
+	if opts.LocalPeerID == "" {
+		opts.LocalPeerID = GenerateUUID()
+	}
+	publisher, err := dialRedis(ctx, opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	subscriber, err := dialRedis(ctx, opts.URL)
+	if err != nil {
+		publisher.Close()
+		return nil, err
+	}
+	t := &RedisPubSubTransport{
+		opts:       opts,
+		publisher:  publisher,
+		subscriber: subscriber,
+		messages:   make(chan string, 64),
+		done:       make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	if err := t.subscribe(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	go t.listen()
+	return t, nil
+}
+
+func (t *RedisPubSubTransport) subscribe() error {
+	// A subscribed connection only receives pushes, so the confirmation is
+	// read with receive instead of do.
+	if err := t.subscriber.send("SUBSCRIBE", t.opts.ReadChannel); err != nil {
+		return err
+	}
+	reply, err := t.subscriber.receive()
+	if err != nil {
+		return err
+	}
+	if push, _ := reply.([]any); len(push) < 1 || push[0] != "subscribe" {
+		return fmt.Errorf("kkrpc: unexpected redis subscribe reply %v", reply)
+	}
+	return nil
+}
+
+func (t *RedisPubSubTransport) listen() {
+	defer close(t.done)
+	for {
+		reply, err := t.subscriber.receive()
+		if err != nil {
+			t.err = err
+			return
+		}
+		// Pushes are ["message", channel, payload]; anything else is ignored.
+		push, _ := reply.([]any)
+		if len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		payload, _ := push[2].(string)
+		if message, ok := decodeBusEnvelope(payload, t.opts.LocalPeerID); ok {
+			select {
+			case t.messages <- message:
+			case <-t.closed:
+				return
+			}
+		}
+	}
+}
+
+func (t *RedisPubSubTransport) Read() (string, error) {
+	select {
+	case message := <-t.messages:
+		return message, nil
+	case <-t.done:
+		select {
+		case <-t.closed:
+			return "", ErrTransportClosed
+		default:
+			return "", t.err
+		}
+	}
+}
+
+func (t *RedisPubSubTransport) Write(message string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	payload, err := encodeBusEnvelope(message, "redis-pubsub", t.opts.LocalPeerID, t.opts.RemotePeerID)
+	if err != nil {
+		return err
+	}
+	_, err = t.publisher.do("PUBLISH", t.opts.WriteChannel, payload)
+	return err
+}
+
+func (t *RedisPubSubTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		t.publisher.Close()
+		t.subscriber.Close()
+	})
+	return nil
+}
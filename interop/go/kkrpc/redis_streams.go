@@ -0,0 +1,231 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisStream      = "kkrpc-stream"
+	defaultRedisStreamBlock = 5 * time.Second
+)
+
+type RedisStreamsOptions struct {
+	// URL defaults to DefaultRedisURL.
+	URL string
+	// Stream is read and written by every peer, as in the TypeScript
+	// transport; defaults to "kkrpc-stream".
+	Stream string
+	// ReadStream and WriteStream override Stream for one direction, so two
+	// Go peers can use a stream per direction instead of filtering a shared one.
+	ReadStream  string
+	WriteStream string
+	// ConsumerGroup defaults to "kkrpc-group-<stream>-<LocalPeerID>", giving
+	// each peer its own group so it sees every message once.
+	ConsumerGroup string
+	// ConsumerName defaults to "consumer-<LocalPeerID>".
+	ConsumerName string
+	// NoConsumerGroup reads with plain XREAD from new entries onwards;
+	// entries are then never acknowledged.
+	NoConsumerGroup bool
+	// Block bounds each blocking read; defaults to 5 seconds.
+	Block time.Duration
+	// MaxLen trims the write stream to about this many entries when > 0.
+	MaxLen int
+	// LocalPeerID identifies this end; messages it wrote are skipped. Required.
+	LocalPeerID string
+	// RemotePeerID, when set, addresses messages to that peer only.
+	RemotePeerID string
+}
+
+// RedisStreamsTransport exchanges messages through Redis Streams, wire
+// compatible with redisStreamsTransport from the TypeScript package: each
+// entry has a "data" field holding a kkrpc.bus.v1 envelope.
+type RedisStreamsTransport struct {
+	opts       RedisStreamsOptions
+	publisher  *redisConn
+	subscriber *redisConn
+	messages   chan string
+	done       chan struct{}
+	err        error
+	closed     chan struct{}
+	once       sync.Once
+}
+
+func DialRedisStreams(ctx context.Context, opts RedisStreamsOptions) (*RedisStreamsTransport, error) {
+	if opts.LocalPeerID == "" {
+		return nil, errors.New("kkrpc: RedisStreamsOptions.LocalPeerID is required")
+	}
+	if opts.Stream == "" {
+		opts.Stream = defaultRedisStream
+	}
+	if opts.ReadStream == "" {
+		opts.ReadStream = opts.Stream
+	}
+	if opts.WriteStream == "" {
+		opts.WriteStream = opts.Stream
+	}
+	if opts.ConsumerGroup == "" {
+		opts.ConsumerGroup = "kkrpc-group-" + opts.ReadStream + "-" + opts.LocalPeerID
+	}
+	if opts.ConsumerName == "" {
+		opts.ConsumerName = "consumer-" + opts.LocalPeerID
+	}
+	if opts.Block <= 0 {
+		opts.Block = defaultRedisStreamBlock
+	}
+	publisher, err := dialRedis(ctx, opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	subscriber, err := dialRedis(ctx, opts.URL)
+	if err != nil {
+		publisher.Close()
+		return nil, err
+	}
+	t := &RedisStreamsTransport{
+		opts:       opts,
+		publisher:  publisher,
+		subscriber: subscriber,
+		messages:   make(chan string, 64),
+		done:       make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	if err := t.setup(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	go t.listen()
+	return t, nil
+}
+
+func (t *RedisStreamsTransport) setup() error {
+	if _, err := t.publisher.do("PING"); err != nil {
+		return err
+	}
+	if t.opts.NoConsumerGroup {
+		return nil
+	}
+	_, err := t.subscriber.do("XGROUP", "CREATE", t.opts.ReadStream, t.opts.ConsumerGroup, "0", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (t *RedisStreamsTransport) listen() {
+	defer close(t.done)
+	lastID := "$"
+	block := strconv.FormatInt(t.opts.Block.Milliseconds(), 10)
+	for {
+		var reply any
+		var err error
+		if t.opts.NoConsumerGroup {
+			reply, err = t.subscriber.do("XREAD", "BLOCK", block, "STREAMS", t.opts.ReadStream, lastID)
+		} else {
+			reply, err = t.subscriber.do("XREADGROUP", "GROUP", t.opts.ConsumerGroup, t.opts.ConsumerName,
+				"BLOCK", block, "STREAMS", t.opts.ReadStream, ">")
+		}
+		if err != nil {
+			t.err = err
+			return
+		}
+		for _, entry := range redisStreamEntries(reply) {
+			lastID = entry.id
+			if message, ok := decodeBusEnvelope(entry.fields["data"], t.opts.LocalPeerID); ok {
+				select {
+				case t.messages <- message:
+				case <-t.closed:
+					return
+				}
+			}
+			if !t.opts.NoConsumerGroup {
+				// Acknowledge on the publisher connection; the subscriber is
+				// reserved for blocking reads.
+				if _, err := t.publisher.do("XACK", t.opts.ReadStream, t.opts.ConsumerGroup, entry.id); err != nil {
+					t.err = err
+					return
+				}
+			}
+		}
+	}
+}
+
+type redisStreamEntry struct {
+	id     string
+	fields map[string]string
+}
+
+// redisStreamEntries flattens an XREAD reply: [[stream, [[id, [k, v, ...]], ...]], ...].
+func redisStreamEntries(reply any) []redisStreamEntry {
+	var entries []redisStreamEntry
+	streams, _ := reply.([]any)
+	for _, stream := range streams {
+		pair, _ := stream.([]any)
+		if len(pair) != 2 {
+			continue
+		}
+		items, _ := pair[1].([]any)
+		for _, item := range items {
+			record, _ := item.([]any)
+			if len(record) != 2 {
+				continue
+			}
+			id, _ := record[0].(string)
+			values, _ := record[1].([]any)
+			fields := make(map[string]string, len(values)/2)
+			for i := 0; i+1 < len(values); i += 2 {
+				key, _ := values[i].(string)
+				value, _ := values[i+1].(string)
+				fields[key] = value
+			}
+			entries = append(entries, redisStreamEntry{id: id, fields: fields})
+		}
+	}
+	return entries
+}
+
+func (t *RedisStreamsTransport) Read() (string, error) {
+	select {
+	case message := <-t.messages:
+		return message, nil
+	case <-t.done:
+		select {
+		case <-t.closed:
+			return "", ErrTransportClosed
+		default:
+			return "", t.err
+		}
+	}
+}
+
+func (t *RedisStreamsTransport) Write(message string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	payload, err := encodeBusEnvelope(message, "redis-streams", t.opts.LocalPeerID, t.opts.RemotePeerID)
+	if err != nil {
+		return err
+	}
+	args := []string{"XADD", t.opts.WriteStream}
+	if t.opts.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", strconv.Itoa(t.opts.MaxLen))
+	}
+	_, err = t.publisher.do(append(args, "*", "data", payload)...)
+	return err
+}
+
+func (t *RedisStreamsTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		t.publisher.Close()
+		t.subscriber.Close()
+	})
+	return nil
+}
@@ -0,0 +1,261 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// fakeRedis implements the handful of stream and pub/sub commands the
+// transports use.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	streams  map[string][][2]string
+	groups   map[string]int
+	acked    map[string]bool
+	changed  chan struct{}
+	sequence int
+	// subscribers is written to only under mu, which orders pushes per conn.
+	subscribers map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeRedis{
+		listener:    listener,
+		streams:     make(map[string][][2]string),
+		groups:      make(map[string]int),
+		acked:       make(map[string]bool),
+		changed:     make(chan struct{}),
+		subscribers: make(map[string][]net.Conn),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return fake
+}
+
+func (f *fakeRedis) URL() string {
+	return "redis://" + f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		request, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		items, _ := request.([]any)
+		args := make([]string, 0, len(items))
+		for _, item := range items {
+			args = append(args, item.(string))
+		}
+		if strings.EqualFold(args[0], "SUBSCRIBE") {
+			f.mu.Lock()
+			f.subscribers[args[1]] = append(f.subscribers[args[1]], conn)
+			_, err := io.WriteString(conn, "*3\r\n"+redisBulk("subscribe")+redisBulk(args[1])+":1\r\n")
+			f.mu.Unlock()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if _, err := io.WriteString(conn, f.handle(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) handle(args []string) string {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "XGROUP":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		key := args[2] + "/" + args[3]
+		if _, exists := f.groups[key]; exists {
+			return "-BUSYGROUP Consumer Group name already exists\r\n"
+		}
+		f.groups[key] = 0
+		return "+OK\r\n"
+	case "XADD":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.sequence++
+		id := fmt.Sprintf("%d-0", f.sequence)
+		f.streams[args[1]] = append(f.streams[args[1]], [2]string{id, args[len(args)-1]})
+		close(f.changed)
+		f.changed = make(chan struct{})
+		return redisBulk(id)
+	case "PUBLISH":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		push := "*3\r\n" + redisBulk("message") + redisBulk(args[1]) + redisBulk(args[2])
+		for _, conn := range f.subscribers[args[1]] {
+			_, _ = io.WriteString(conn, push)
+		}
+		return fmt.Sprintf(":%d\r\n", len(f.subscribers[args[1]]))
+	case "XACK":
+		f.mu.Lock()
+		f.acked[args[3]] = true
+		f.mu.Unlock()
+		return ":1\r\n"
+	case "XREADGROUP":
+		block, _ := strconv.Atoi(args[5])
+		key := args[7] + "/" + args[2]
+		return f.read(args[7], time.Duration(block)*time.Millisecond, func() int { return f.groups[key] }, func(next int) { f.groups[key] = next })
+	case "XREAD":
+		block, _ := strconv.Atoi(args[2])
+		f.mu.Lock()
+		from := len(f.streams[args[4]])
+		f.mu.Unlock()
+		return f.read(args[4], time.Duration(block)*time.Millisecond, func() int { return from }, func(next int) { from = next })
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (f *fakeRedis) read(stream string, block time.Duration, cursor func() int, advance func(int)) string {
+	deadline := time.After(block)
+	for {
+		f.mu.Lock()
+		entries := f.streams[stream]
+		if start := cursor(); start < len(entries) {
+			advance(len(entries))
+			pending := entries[start:]
+			f.mu.Unlock()
+			var builder strings.Builder
+			fmt.Fprintf(&builder, "*1\r\n*2\r\n%s*%d\r\n", redisBulk(stream), len(pending))
+			for _, entry := range pending {
+				fmt.Fprintf(&builder, "*2\r\n%s*2\r\n%s%s", redisBulk(entry[0]), redisBulk("data"), redisBulk(entry[1]))
+			}
+			return builder.String()
+		}
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return "*-1\r\n"
+		}
+	}
+}
+
+func redisBulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func TestRedisStreamsTransportRoundTrip(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	fake := newFakeRedis(t)
+	ctx := context.Background()
+	clientTransport, err := DialRedisStreams(ctx, RedisStreamsOptions{URL: fake.URL(), LocalPeerID: "node", Block: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTransport, err := DialRedisStreams(ctx, RedisStreamsOptions{URL: fake.URL(), LocalPeerID: "go-worker", Block: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(serverTransport, map[string]any{
+		"math": map[string]any{
+			"add": func(args ...any) any { return args[0].(float64) + args[1].(float64) },
+		},
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Call("math.add", i, 1)
+		if err != nil || !valuesEqual(i+1, result) {
+			t.Fatalf("call %d: %#v %v", i, result, err)
+		}
+	}
+	// Both peers share one stream; every entry ends up acknowledged.
+	deadline := time.Now().Add(time.Second)
+	for {
+		fake.mu.Lock()
+		entries, acked := len(fake.streams[defaultRedisStream]), len(fake.acked)
+		fake.mu.Unlock()
+		if entries == 6 && acked == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries, %d acknowledged", entries, acked)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRedisPubSubTransportChannelPair(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	fake := newFakeRedis(t)
+	ctx := context.Background()
+	clientTransport, err := DialRedisPubSub(ctx, RedisPubSubOptions{URL: fake.URL(), ReadChannel: "api:responses", WriteChannel: "api:requests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTransport, err := DialRedisPubSub(ctx, RedisPubSubOptions{URL: fake.URL(), ReadChannel: "api:requests", WriteChannel: "api:responses"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(serverTransport, map[string]any{
+		"echo": func(args ...any) any { return args[0] },
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Call("echo", i)
+		if err != nil || !valuesEqual(i, result) {
+			t.Fatalf("call %d: %#v %v", i, result, err)
+		}
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.streams) != 0 {
+		t.Fatal("pub/sub must not persist messages")
+	}
+}
+
+func TestBusEnvelopeFiltersPeers(t *testing.T) {
+	payload, err := encodeBusEnvelope(`{"t":"r","id":"1","v":2}`+"\n", "redis-streams", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decodeBusEnvelope(payload, "a"); ok {
+		t.Fatal("delivered own message")
+	}
+	if _, ok := decodeBusEnvelope(payload, "c"); ok {
+		t.Fatal("delivered message addressed to another peer")
+	}
+	if message, ok := decodeBusEnvelope(payload, "b"); !ok || message != `{"t":"r","id":"1","v":2}`+"\n" {
+		t.Fatalf("message %q %v", message, ok)
+	}
+}
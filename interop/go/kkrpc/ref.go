@@ -0,0 +1,100 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+const RemoteRefTag = "__kkrpc_ref__"
+
+var ErrHandleReleased = errors.New("remote reference has been released")
+
+type Handle struct {
+	client   *Client
+	id       string
+	kind     string
+	path     []string
+	released atomic.Bool
+}
+
+func (h *Handle) ID() string {
+	return h.id
+}
+
+func (h *Handle) Kind() string {
+	return h.kind
+}
+
+func (h *Handle) Call(args ...any) (any, error) {
+	if h.kind == "function" {
+		return h.request("apply", nil, args, nil)
+	}
+	return h.request("call", nil, args, nil)
+}
+
+func (h *Handle) CallMethod(method string, args ...any) (any, error) {
+	return h.request("call", splitMethod(method), args, nil)
+}
+
+func (h *Handle) Get(path ...string) (any, error) {
+	return h.request("get", path, nil, nil)
+}
+
+func (h *Handle) Set(path []string, value any) (any, error) {
+	return h.request("set", path, nil, value)
+}
+
+func (h *Handle) Func() func(args ...any) (any, error) {
+	return h.Call
+}
+
+func (h *Handle) Release() error {
+	if h.released.Swap(true) {
+		return nil
+	}
+	_, err := h.client.sendRequest(context.Background(), "ref", []string{h.id, "release"}, nil, nil)
+	return err
+}
+
+func (h *Handle) request(action string, path []string, args []any, value any) (any, error) {
+	if h.released.Load() {
+		return nil, ErrHandleReleased
+	}
+	refPath := make([]string, 0, 2+len(h.path)+len(path))
+	refPath = append(refPath, h.id, action)
+	refPath = append(refPath, h.path...)
+	refPath = append(refPath, path...)
+	return h.client.sendRequest(context.Background(), "ref", refPath, args, value)
+}
+
+func (c *Client) decodeValue(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		if typed[RemoteRefTag] == true {
+			return c.newHandle(typed)
+		}
+		if id, ok := typed["id"].(string); ok && typed[StreamRefTag] == "async-iterable" {
+			return c.options.remoteStreams.open(c.transport, c.options, id, c.decodeValue)
+		}
+		decoded := make(map[string]any, len(typed))
+		for key, item := range typed {
+			decoded[key] = c.decodeValue(item)
+		}
+		return decoded
+	case []any:
+		decoded := make([]any, 0, len(typed))
+		for _, item := range typed {
+			decoded = append(decoded, c.decodeValue(item))
+		}
+		return decoded
+	default:
+		return value
+	}
+}
+
+func (c *Client) newHandle(envelope map[string]any) *Handle {
+	id, _ := envelope["id"].(string)
+	kind, _ := envelope["kind"].(string)
+	return &Handle{client: c, id: id, kind: kind, path: pathFromMessage(envelope)}
+}
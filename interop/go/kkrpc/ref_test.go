@@ -0,0 +1,64 @@
+package kkrpc
+
+import (
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestReturnedFunctionBecomesCallableHandle(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport := newServerTestTransport()
+	defer transport.Close()
+	client := NewClient(transport)
+
+	go func() {
+		raw := <-transport.out
+		request, _ := DecodeMessage(raw)
+		response, _ := EncodeMessage(map[string]any{
+			"t":  "r",
+			"id": request["id"],
+			"v":  map[string]any{RemoteRefTag: true, "id": "ref-1", "kind": "function"},
+		})
+		transport.in <- response
+
+		raw = <-transport.out
+		request, _ = DecodeMessage(raw)
+		path := pathFromMessage(request)
+		if request["op"] != "ref" || len(path) != 2 || path[0] != "ref-1" || path[1] != "apply" {
+			response, _ = EncodeMessage(map[string]any{"t": "r", "id": request["id"], "e": map[string]any{"n": "Error", "m": "bad ref request"}})
+		} else {
+			args, _ := request["a"].([]any)
+			response, _ = EncodeMessage(map[string]any{"t": "r", "id": request["id"], "v": args[0].(float64) * 2})
+		}
+		transport.in <- response
+	}()
+
+	result, err := client.Call("makeDoubler")
+	if err != nil {
+		t.Fatalf("makeDoubler: %v", err)
+	}
+	handle, ok := result.(*Handle)
+	if !ok {
+		t.Fatalf("expected *Handle, got %#v", result)
+	}
+	if handle.Kind() != "function" {
+		t.Fatalf("unexpected handle kind: %s", handle.Kind())
+	}
+
+	doubled, err := handle.Func()(21)
+	if err != nil {
+		t.Fatalf("handle call: %v", err)
+	}
+	if doubled != float64(42) {
+		t.Fatalf("unexpected handle result: %#v", doubled)
+	}
+
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case raw := <-transport.out:
+		t.Fatalf("unexpected extra message: %s", raw)
+	}
+}
@@ -0,0 +1,66 @@
+package kkrpc
+
+import (
+	"runtime"
+	"sync"
+)
+
+// callbackRef is referenced only by a callback proxy, so its finalizer runs
+// once the proxy is unreachable. The owner is then told with a batched "cbr"
+// message that it may drop the callback, as the TypeScript channel does from
+// its FinalizationRegistry.
+type callbackRef struct {
+	id string
+}
+
+type callbackReleases struct {
+	mu        sync.Mutex
+	ids       []string
+	scheduled bool
+}
+
+func (s *Server) trackCallback(callbackID string) *callbackRef {
+	ref := &callbackRef{id: callbackID}
+	runtime.SetFinalizer(ref, func(ref *callbackRef) { s.queueCallbackRelease(ref.id) })
+	return ref
+}
+
+func (s *Server) queueCallbackRelease(callbackID string) {
+	s.releases.mu.Lock()
+	defer s.releases.mu.Unlock()
+	s.releases.ids = append(s.releases.ids, callbackID)
+	if s.releases.scheduled {
+		return
+	}
+	s.releases.scheduled = true
+	go s.flushCallbackReleases()
+}
+
+func (s *Server) flushCallbackReleases() {
+	s.releases.mu.Lock()
+	ids := s.releases.ids
+	s.releases.ids = nil
+	s.releases.scheduled = false
+	s.releases.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+	transport, o := s.callbackTransport()
+	if err := writePayload(transport, o, map[string]any{"t": "cbr", "ids": ids}); err != nil {
+		s.options.logger.Printf("kkrpc: release callbacks: %v", err)
+	}
+}
+
+func (c *Client) releaseCallbacks(message map[string]any) {
+	ids, _ := message["ids"].([]any)
+	c.mu.Lock()
+	for _, id := range ids {
+		if callbackID, ok := id.(string); ok {
+			if _, registered := c.callbacks[callbackID]; registered {
+				delete(c.callbacks, callbackID)
+				c.options.resources.releaseHandle()
+			}
+		}
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,179 @@
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ResourceLimits bounds the memory one connection's peer can make this side
+// hold, so a misbehaving plugin fails its own calls instead of exhausting a
+// host shared with others. Zero fields are not limited.
+type ResourceLimits struct {
+	// MaxRequestBytes bounds the encoded size of the peer's requests being
+	// served at once.
+	MaxRequestBytes int64
+	// MaxHandles bounds the callbacks registered for the peer to invoke and
+	// the streams the peer has opened.
+	MaxHandles int
+	// MaxBlobBytes bounds the bytes of the peer's blobs in the blob cache.
+	MaxBlobBytes int64
+}
+
+// ResourceUsage is what a connection's peer currently holds, as limited by
+// ResourceLimits.
+type ResourceUsage struct {
+	RequestBytes int64
+	Handles      int
+	BlobBytes    int64
+}
+
+// resourceLimitError names the RpcError of anything refused for exceeding a
+// limit; its Data carries "resource", "limit" and "used".
+const resourceLimitError = "ResourceLimitError"
+
+// WithResourceLimits accounts for the memory the peer makes this side hold
+// and refuses what would exceed limits with a ResourceLimitError. Requests
+// over budget are answered with it, callbacks over budget fail the call that
+// passes them, and so do blobs; streams over budget end with it. Use zero
+// limits to only track the usage.
+func WithResourceLimits(limits ResourceLimits) Option {
+	return func(o *options) {
+		o.resources = &resourceMeter{limits: limits, requests: make(map[string]int64)}
+	}
+}
+
+// IsResourceLimit reports whether err is a ResourceLimitError.
+func IsResourceLimit(err error) bool {
+	var rpcErr *RpcError
+	return errors.As(err, &rpcErr) && rpcErr.Name == resourceLimitError
+}
+
+// Resources returns what the peer currently holds; it is zero without
+// WithResourceLimits.
+func (c *Client) Resources() ResourceUsage {
+	return c.options.resources.usage()
+}
+
+// Resources returns what the peer currently holds; it is zero without
+// WithResourceLimits.
+func (s *Server) Resources() ResourceUsage {
+	return s.options.resources.usage()
+}
+
+// resourceMeter accounts for one connection. A nil meter accounts nothing.
+type resourceMeter struct {
+	limits ResourceLimits
+	mu     sync.Mutex
+	used   ResourceUsage
+	// requests holds the size of every request read and not yet finished.
+	// Sizes of rejected ones are negative until the server answers them.
+	requests map[string]int64
+}
+
+func limitError(resource string, limit, used int64) *RpcError {
+	return &RpcError{
+		Name:    resourceLimitError,
+		Message: fmt.Sprintf("kkrpc: connection exceeds its %s limit of %d", resource, limit),
+		Data:    map[string]any{"resource": resource, "limit": limit, "used": used},
+	}
+}
+
+// received charges a request as it is read, before it reaches the server.
+func (m *resourceMeter) received(message map[string]any, size int) {
+	if m == nil || message["t"] != "q" {
+		return
+	}
+	requestID, _ := message["id"].(string)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, duplicate := m.requests[requestID]; duplicate {
+		return
+	}
+	if limit := m.limits.MaxRequestBytes; limit > 0 && m.used.RequestBytes+int64(size) > limit {
+		m.requests[requestID] = -1
+		return
+	}
+	m.requests[requestID] = int64(size)
+	m.used.RequestBytes += int64(size)
+}
+
+// admit tells the server whether it may serve the request.
+func (m *resourceMeter) admit(requestID string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests[requestID] >= 0 {
+		return nil
+	}
+	delete(m.requests, requestID)
+	return limitError("request bytes", m.limits.MaxRequestBytes, m.used.RequestBytes)
+}
+
+// release refunds a request once it is answered or dropped.
+func (m *resourceMeter) release(requestID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size, ok := m.requests[requestID]; ok {
+		delete(m.requests, requestID)
+		m.used.RequestBytes -= max(size, 0)
+	}
+}
+
+func (m *resourceMeter) acquireHandle() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limit := m.limits.MaxHandles; limit > 0 && m.used.Handles >= limit {
+		return limitError("handles", int64(limit), int64(m.used.Handles))
+	}
+	m.used.Handles++
+	return nil
+}
+
+func (m *resourceMeter) releaseHandle() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.used.Handles--
+	m.mu.Unlock()
+}
+
+func (m *resourceMeter) chargeBlob(size int64) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limit := m.limits.MaxBlobBytes; limit > 0 && m.used.BlobBytes+size > limit {
+		return limitError("blob bytes", limit, m.used.BlobBytes)
+	}
+	m.used.BlobBytes += size
+	return nil
+}
+
+func (m *resourceMeter) refundBlob(size int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.used.BlobBytes -= size
+	m.mu.Unlock()
+}
+
+func (m *resourceMeter) usage() ResourceUsage {
+	if m == nil {
+		return ResourceUsage{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used
+}
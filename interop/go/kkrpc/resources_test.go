@@ -0,0 +1,99 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestResourceLimitsRefuseRequestsOverBudget(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	serverSide, clientSide := NewPipeTransportPair()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := NewServer(serverSide, map[string]any{
+		"hold": MustFunc(func(payload string) int {
+			close(started)
+			<-release
+			return len(payload)
+		}),
+		"ping": MustFunc(func() string { return "pong" }),
+	}, WithResourceLimits(ResourceLimits{MaxRequestBytes: 300}))
+	client := NewClient(clientSide, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	held := make(chan error, 1)
+	go func() {
+		_, err := client.Call("hold", string(bytes.Repeat([]byte("x"), 150)))
+		held <- err
+	}()
+	<-started
+	if used := server.Resources().RequestBytes; used < 150 {
+		t.Fatalf("request bytes %d while holding", used)
+	}
+	_, err := client.Call("hold", string(bytes.Repeat([]byte("y"), 150)))
+	if !IsResourceLimit(err) {
+		t.Fatalf("second request: %v", err)
+	}
+	close(release)
+	if err := <-held; err != nil {
+		t.Fatal(err)
+	}
+	if result, err := client.Call("ping"); err != nil || result != "pong" {
+		t.Fatalf("ping = %v, %v", result, err)
+	}
+	if used := server.Resources().RequestBytes; used != 0 {
+		t.Fatalf("request bytes %d after the calls", used)
+	}
+}
+
+func TestResourceLimitsBoundCallbackHandles(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	serverSide, clientSide := NewPipeTransportPair()
+	NewServer(serverSide, map[string]any{
+		"subscribe": MustFunc(func(listener Callback) bool { return true }),
+	})
+	client := NewClient(clientSide, WithTimeout(2*time.Second), WithResourceLimits(ResourceLimits{MaxHandles: 1}))
+	defer client.Close()
+
+	if _, err := client.Call("subscribe", Callback(func(...any) {})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Call("subscribe", Callback(func(...any) {})); !IsResourceLimit(err) {
+		t.Fatalf("second subscribe: %v", err)
+	}
+	if handles := client.Resources().Handles; handles != 1 {
+		t.Fatalf("handles %d", handles)
+	}
+}
+
+func TestResourceLimitsChargePeerBlobs(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	serverSide, clientSide := NewPipeTransportPair()
+	cache := NewBlobCache(BlobCacheOptions{})
+	server := NewServer(serverSide, map[string]any{
+		"size": MustFunc(func(blob Blob) int { return len(blob) }),
+	}, WithBlobCache(cache), WithResourceLimits(ResourceLimits{MaxBlobBytes: 8}))
+	client := NewClient(clientSide, WithTimeout(2*time.Second), WithBlobCache(NewBlobCache(BlobCacheOptions{})))
+	defer client.Close()
+
+	if _, err := client.Call("size", Blob("sixteen bytes!!!")); !IsResourceLimit(err) {
+		t.Fatalf("large blob: %v", err)
+	}
+	if size, err := client.Call("size", Blob("four")); err != nil || size != float64(4) {
+		t.Fatalf("size = %v, %v", size, err)
+	}
+	if used := server.Resources().BlobBytes; used != 4 {
+		t.Fatalf("blob bytes %d", used)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("cached %d blobs", cache.Len())
+	}
+}
@@ -0,0 +1,69 @@
+package kkrpc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// StreamTransport frames kkrpc messages as newline-delimited lines over any
+// byte stream.
+type StreamTransport struct {
+	rw     io.ReadWriteCloser
+	reader *bufio.Reader
+	writer *bufio.Writer
+	mu     sync.Mutex
+}
+
+// NewStreamTransport wraps a stream-like object: an SSH session channel, a
+// PTY, a serial port handle, a named pipe. Each message is flushed as it is
+// written, and Close closes rw.
+func NewStreamTransport(rw io.ReadWriteCloser) *StreamTransport {
+	return &StreamTransport{
+		rw:     rw,
+		reader: bufio.NewReader(rw),
+		writer: bufio.NewWriter(rw),
+	}
+}
+
+func (t *StreamTransport) Read() (string, error) {
+	line, err := t.reader.ReadString('\n')
+	if err != nil {
+		return "", streamError(err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (t *StreamTransport) Write(message string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.writer.WriteString(message); err != nil {
+		return streamError(err)
+	}
+	if !strings.HasSuffix(message, "\n") {
+		if err := t.writer.WriteByte('\n'); err != nil {
+			return streamError(err)
+		}
+	}
+	return streamError(t.writer.Flush())
+}
+
+func (t *StreamTransport) Close() error {
+	return t.rw.Close()
+}
+
+// streamError reports the ways a stream says it has ended as
+// ErrTransportClosed.
+func streamError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrClosedPipe), errors.Is(err, net.ErrClosed), errors.Is(err, os.ErrClosed):
+		return ErrTransportClosed
+	}
+	return err
+}
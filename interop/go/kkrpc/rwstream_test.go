@@ -0,0 +1,80 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// pipeStream joins the read end of one os.Pipe and the write end of another,
+// like the two directions of a serial line or SSH session.
+type pipeStream struct {
+	r *os.File
+	w *os.File
+}
+
+func (s pipeStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s pipeStream) Write(p []byte) (int, error) { return s.w.Write(p) }
+func (s pipeStream) Close() error {
+	_ = s.w.Close()
+	return s.r.Close()
+}
+
+func newPipeStreams(t *testing.T) (pipeStream, pipeStream) {
+	t.Helper()
+	ar, bw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, aw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pipeStream{r: ar, w: aw}, pipeStream{r: br, w: bw}
+}
+
+func TestStreamTransportOverPipes(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	local, remote := newPipeStreams(t)
+	server := NewServer(NewStreamTransport(remote), map[string]any{
+		"echo": MustFunc(func(s string) string { return s }),
+	})
+	defer server.Close()
+	client := NewClient(NewStreamTransport(local), WithTimeout(2*time.Second))
+	defer client.Close()
+
+	result, err := client.Call("echo", "over a stream")
+	if err != nil || result != "over a stream" {
+		t.Fatalf("echo = %v, %v", result, err)
+	}
+}
+
+func TestStreamTransportHandlesCRLFAndClose(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	local, remote := newPipeStreams(t)
+	transport := NewStreamTransport(remote)
+
+	// PTYs in cooked mode and many serial devices end lines with CRLF.
+	if _, err := io.WriteString(local, "{\"t\":\"q\"}\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := transport.Read(); err != nil || line != `{"t":"q"}` {
+		t.Fatalf("read %q, %v", line, err)
+	}
+	_ = local.Close()
+	if _, err := transport.Read(); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("read after peer close: %v", err)
+	}
+	_ = transport.Close()
+	if err := transport.Write("{}"); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+}
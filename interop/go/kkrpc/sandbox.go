@@ -0,0 +1,231 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrOutsideSandbox = errors.New("path is outside the sandbox")
+	ErrURLNotAllowed  = errors.New("url is not allowed")
+)
+
+// ArgTransform vets or rewrites the arguments of a call before its handler
+// sees them. An error fails the call without running the handler.
+type ArgTransform func(ctx context.Context, args []any) ([]any, error)
+
+// TransformArgs wraps handler, any method a server can serve, so that
+// transforms run in order on every call's arguments first.
+func TransformArgs(handler any, transforms ...ArgTransform) InvokerFunc {
+	return func(ctx context.Context, args []any) (any, error) {
+		var err error
+		for _, transform := range transforms {
+			if args, err = transform(ctx, args); err != nil {
+				return nil, err
+			}
+		}
+		return invokeHandler(ctx, handler, args, "method not callable")
+	}
+}
+
+// PathArg confines argument i, a path, to sandbox and replaces it with the
+// resolved absolute path. A missing argument is left to the handler.
+func PathArg(i int, sandbox *PathSandbox) ArgTransform {
+	return func(ctx context.Context, args []any) ([]any, error) {
+		if i >= len(args) {
+			return args, nil
+		}
+		raw, ok := args[i].(string)
+		if !ok {
+			return nil, &RpcError{Name: "TypeError", Message: fmt.Sprintf("argument %d must be a path, got %T", i, args[i])}
+		}
+		resolved, err := sandbox.Resolve(raw)
+		if err != nil {
+			return nil, err
+		}
+		args = append([]any(nil), args...)
+		args[i] = resolved
+		return args, nil
+	}
+}
+
+// URLArg checks argument i, a URL, against allow and replaces it with the
+// normalized URL. A missing argument is left to the handler.
+func URLArg(i int, allow *URLAllowlist) ArgTransform {
+	return func(ctx context.Context, args []any) ([]any, error) {
+		if i >= len(args) {
+			return args, nil
+		}
+		raw, ok := args[i].(string)
+		if !ok {
+			return nil, &RpcError{Name: "TypeError", Message: fmt.Sprintf("argument %d must be a url, got %T", i, args[i])}
+		}
+		u, err := allow.Check(raw)
+		if err != nil {
+			return nil, err
+		}
+		args = append([]any(nil), args...)
+		args[i] = u.String()
+		return args, nil
+	}
+}
+
+// PathSandbox confines paths from a peer to a set of root directories.
+type PathSandbox struct {
+	roots []string
+}
+
+// NewPathSandbox confines paths to roots, which must exist. Relative paths
+// resolve against the first root.
+func NewPathSandbox(roots ...string) (*PathSandbox, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("kkrpc: path sandbox needs a root")
+	}
+	sandbox := &PathSandbox{}
+	for _, root := range roots {
+		resolved, err := filepath.Abs(root)
+		if err == nil {
+			resolved, err = filepath.EvalSymlinks(resolved)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kkrpc: sandbox root %s: %w", root, err)
+		}
+		sandbox.roots = append(sandbox.roots, resolved)
+	}
+	return sandbox, nil
+}
+
+// Resolve returns the absolute path p names, with symlinks followed so a link
+// inside a root cannot expose a target outside it, or a PermissionError
+// quoting ErrOutsideSandbox if it lies outside every root. p need not exist
+// yet.
+func (s *PathSandbox) Resolve(p string) (string, error) {
+	if strings.IndexByte(p, 0) >= 0 {
+		return "", s.refuse(p)
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.roots[0], p)
+	}
+	resolved, err := evalExisting(filepath.Clean(p))
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.roots {
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", s.refuse(p)
+}
+
+func (s *PathSandbox) refuse(p string) error {
+	return &RpcError{Name: "PermissionError", Message: fmt.Sprintf("%q: %v", p, ErrOutsideSandbox)}
+}
+
+// evalExisting follows the symlinks of the longest existing prefix of the
+// clean absolute path p; the rest does not exist, so holds no links.
+func evalExisting(p string) (string, error) {
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// URLAllowlist admits the URLs a peer may have this side reach.
+type URLAllowlist struct {
+	patterns []*url.URL
+}
+
+// NewURLAllowlist admits URLs matching any pattern. A pattern is a URL with
+// a scheme and host, where the host may start with "*." to match subdomains
+// and the path, if any, is a prefix ending at a segment boundary:
+// "https://*.example.com/api" admits "https://eu.example.com/api/users" but
+// not "http://example.com/api" nor "https://eu.example.com/apis". A pattern
+// without a port admits only the scheme's default port.
+func NewURLAllowlist(patterns ...string) (*URLAllowlist, error) {
+	allow := &URLAllowlist{}
+	for _, pattern := range patterns {
+		u, err := url.Parse(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("kkrpc: url pattern %q: %w", pattern, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("kkrpc: url pattern %q needs a scheme and host", pattern)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		allow.patterns = append(allow.patterns, u)
+	}
+	return allow, nil
+}
+
+// Check parses raw and returns it with its path cleaned, so "/api/../admin"
+// cannot slip past a prefix, or a PermissionError quoting ErrURLNotAllowed if
+// no pattern admits it.
+func (a *URLAllowlist) Check(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, &RpcError{Name: "TypeError", Message: fmt.Sprintf("%q is not an absolute url", raw)}
+	}
+	if u.Path != "" {
+		cleaned := path.Clean("/" + u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path, u.RawPath = cleaned, ""
+	}
+	for _, pattern := range a.patterns {
+		if urlMatches(pattern, u) {
+			return u, nil
+		}
+	}
+	return nil, &RpcError{Name: "PermissionError", Message: fmt.Sprintf("%q: %v", raw, ErrURLNotAllowed)}
+}
+
+func urlMatches(pattern, u *url.URL) bool {
+	if !strings.EqualFold(pattern.Scheme, u.Scheme) {
+		return false
+	}
+	host, want := strings.ToLower(u.Hostname()), strings.ToLower(pattern.Hostname())
+	if strings.HasPrefix(want, "*.") {
+		if !strings.HasSuffix(host, want[1:]) {
+			return false
+		}
+	} else if host != want {
+		return false
+	}
+	if urlPort(pattern) != urlPort(u) {
+		return false
+	}
+	return u.Path == pattern.Path || strings.HasPrefix(u.Path, pattern.Path+"/")
+}
+
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestPathSandboxConfinesPaths(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	sandbox, err := NewPathSandbox(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+
+	for p, want := range map[string]string{
+		"data/new.txt":                     filepath.Join(resolvedRoot, "data", "new.txt"),
+		filepath.Join(root, "data", "a/b"): filepath.Join(resolvedRoot, "data", "a", "b"),
+		"data/../data/x":                   filepath.Join(resolvedRoot, "data", "x"),
+		".":                                resolvedRoot,
+	} {
+		got, err := sandbox.Resolve(p)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", p, got, err, want)
+		}
+	}
+	for _, p := range []string{"../x", "/etc/passwd", "escape/secret", filepath.Join(root, "escape", "missing", "file"), "a\x00b"} {
+		_, err := sandbox.Resolve(p)
+		var rpcErr *RpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" || !strings.Contains(rpcErr.Message, ErrOutsideSandbox.Error()) {
+			t.Errorf("Resolve(%q) = %v, want a PermissionError", p, err)
+		}
+	}
+	if _, err := NewPathSandbox(filepath.Join(root, "missing")); err == nil {
+		t.Fatal("expected a missing root to fail")
+	}
+}
+
+func TestURLAllowlist(t *testing.T) {
+	allow, err := NewURLAllowlist("https://*.example.com/api", "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for raw, want := range map[string]string{
+		"https://eu.example.com/api":            "https://eu.example.com/api",
+		"https://EU.example.com:443/api/users/": "https://EU.example.com:443/api/users/",
+		"http://localhost:8080/anything":        "http://localhost:8080/anything",
+	} {
+		u, err := allow.Check(raw)
+		if err != nil || u.String() != want {
+			t.Errorf("Check(%q) = %v, %v; want %q", raw, u, err, want)
+		}
+	}
+	for _, raw := range []string{
+		"http://eu.example.com/api",
+		"https://example.com.evil.org/api",
+		"https://eu.example.com/apis",
+		"https://eu.example.com/api/../admin",
+		"https://eu.example.com/api/%2e%2e/admin",
+		"https://eu.example.com:8443/api",
+		"http://localhost/",
+		"https://user@evil.org/api",
+	} {
+		if _, err := allow.Check(raw); err == nil || !strings.Contains(err.Error(), ErrURLNotAllowed.Error()) {
+			t.Errorf("Check(%q) = %v, want refusal", raw, err)
+		}
+	}
+	if _, err := allow.Check("/relative"); err == nil {
+		t.Fatal("expected a relative url to fail")
+	}
+	if _, err := NewURLAllowlist("example.com"); err == nil {
+		t.Fatal("expected a pattern without scheme to fail")
+	}
+}
+
+func TestTransformArgsGuardsHandler(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	root := t.TempDir()
+	sandbox, err := NewPathSandbox(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := NewURLAllowlist("https://api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTransport, serverTransport := NewPipeTransportPair()
+	server := NewServer(serverTransport, map[string]any{
+		"fs": map[string]any{
+			"download": TransformArgs(
+				func(args ...any) any { return args[0].(string) + " -> " + args[1].(string) },
+				URLArg(0, allow),
+				PathArg(1, sandbox),
+			),
+		},
+	})
+	defer server.Close()
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	result, err := client.CallContext(context.Background(), "fs.download", "https://api.example.com/v1/../file", "out.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	want := "https://api.example.com/file -> " + filepath.Join(resolvedRoot, "out.bin")
+	if result != want {
+		t.Fatalf("got %#v, want %#v", result, want)
+	}
+
+	_, err = client.Call("fs.download", "https://api.example.com/file", "../../etc/passwd")
+	var rpcErr *RpcError
+	if !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("expected PermissionError, got %v", err)
+	}
+	_, err = client.Call("fs.download", 42, "x")
+	if !errors.As(err, &rpcErr) || rpcErr.Name != "TypeError" {
+		t.Fatalf("expected TypeError, got %v", err)
+	}
+}
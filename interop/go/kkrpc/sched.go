@@ -0,0 +1,27 @@
+package kkrpc
+
+// schedPoint names a step at which a client goroutine hands control to the
+// test scheduler, so that tests can force an interleaving between response
+// handling and the pending map instead of hoping -race stumbles on it.
+type schedPoint string
+
+const (
+	// schedRegistered: a call is in the pending map and not yet written.
+	schedRegistered schedPoint = "registered"
+	// schedAbandoning: a call's context is done; it is still pending.
+	schedAbandoning schedPoint = "abandoning"
+	// schedClaimed: a response took its call out of the pending map and is
+	// not yet delivered.
+	schedClaimed schedPoint = "claimed"
+	// schedFailing: the pending map was swapped out to fail every call, and
+	// none has been failed yet.
+	schedFailing schedPoint = "failing"
+)
+
+// yield runs the test scheduler at point for the call id, if one is set. It
+// must not be called with c.mu held.
+func (o *options) yield(point schedPoint, id string) {
+	if o.sched != nil {
+		o.sched(point, id)
+	}
+}
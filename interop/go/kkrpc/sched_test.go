@@ -0,0 +1,193 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// scheduler pauses the first client goroutine to reach each of the points it
+// was given until the test resumes it; later arrivals pass straight through.
+type scheduler struct {
+	mu      sync.Mutex
+	paused  map[schedPoint]bool
+	arrived chan schedArrival
+}
+
+type schedArrival struct {
+	point  schedPoint
+	id     string
+	resume chan struct{}
+}
+
+func newScheduler(points ...schedPoint) *scheduler {
+	s := &scheduler{paused: make(map[schedPoint]bool), arrived: make(chan schedArrival, len(points))}
+	for _, point := range points {
+		s.paused[point] = true
+	}
+	return s
+}
+
+func (s *scheduler) option() Option {
+	return func(o *options) { o.sched = s.yield }
+}
+
+func (s *scheduler) yield(point schedPoint, id string) {
+	s.mu.Lock()
+	pause := s.paused[point]
+	delete(s.paused, point)
+	s.mu.Unlock()
+	if !pause {
+		return
+	}
+	resume := make(chan struct{})
+	s.arrived <- schedArrival{point: point, id: id, resume: resume}
+	<-resume
+}
+
+// await returns the goroutine paused at point; close its resume to let it go.
+func (s *scheduler) await(t *testing.T, point schedPoint) schedArrival {
+	t.Helper()
+	select {
+	case arrival := <-s.arrived:
+		if arrival.point != point {
+			t.Fatalf("paused at %s, expected %s", arrival.point, point)
+		}
+		return arrival
+	case <-time.After(2 * time.Second):
+		t.Fatalf("nothing reached %s", point)
+		return schedArrival{}
+	}
+}
+
+type callResult struct {
+	value any
+	err   error
+}
+
+func callAsync(ctx context.Context, client *Client, method string, args ...any) <-chan callResult {
+	done := make(chan callResult, 1)
+	go func() {
+		value, err := client.CallContext(ctx, method, args...)
+		done <- callResult{value, err}
+	}()
+	return done
+}
+
+func schedPair(t *testing.T, sched *scheduler, api map[string]any) *Client {
+	t.Helper()
+	clientTransport, serverTransport := NewPipeTransportPair()
+	server := NewServer(serverTransport, api)
+	client := NewClient(clientTransport, WithTimeout(2*time.Second), sched.option())
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client
+}
+
+var echoAPI = map[string]any{"echo": func(args ...any) any { return args[0] }}
+
+func TestResponseClaimedAfterCallerGaveUp(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	sched := newScheduler(schedClaimed)
+	client := schedPair(t, sched, echoAPI)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := callAsync(ctx, client, "echo", "late")
+	claimed := sched.await(t, schedClaimed)
+	cancel()
+	if result := <-done; !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("abandoned call: %v %v", result.value, result.err)
+	}
+	// Nobody receives the response any more; delivering it must not block
+	// the read loop.
+	close(claimed.resume)
+	if value, err := client.Call("echo", "next"); err != nil || value != "next" {
+		t.Fatalf("call after the late response: %v %v", value, err)
+	}
+	if client.PendingCalls() != 0 {
+		t.Fatalf("%d calls still pending", client.PendingCalls())
+	}
+}
+
+func TestResponseArrivesWhileCallerGivesUp(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	sched := newScheduler(schedAbandoning)
+	release := make(chan struct{})
+	client := schedPair(t, sched, map[string]any{
+		"wait": MustFunc(func() string {
+			<-release
+			return "done"
+		}),
+		"echo": echoAPI["echo"],
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := callAsync(ctx, client, "wait")
+	// Give the request time to reach the handler, then abandon it.
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	abandoning := sched.await(t, schedAbandoning)
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for client.PendingCalls() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("response never claimed the call")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(abandoning.resume)
+	if result := <-done; !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("abandoned call: %v %v", result.value, result.err)
+	}
+	if value, err := client.Call("echo", "next"); err != nil || value != "next" {
+		t.Fatalf("call after the abandoned one: %v %v", value, err)
+	}
+}
+
+func TestFailPendingRacesResponse(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	failure := errors.New("peer gone")
+
+	// The response took the call first: failing the rest must leave it be.
+	sched := newScheduler(schedClaimed)
+	client := schedPair(t, sched, echoAPI)
+	done := callAsync(context.Background(), client, "echo", "answered")
+	claimed := sched.await(t, schedClaimed)
+	client.failPending(failure)
+	close(claimed.resume)
+	if result := <-done; result.err != nil || result.value != "answered" {
+		t.Fatalf("claimed call: %v %v", result.value, result.err)
+	}
+
+	// The calls were failed first: the response finds nothing to deliver to.
+	sched = newScheduler(schedRegistered, schedFailing)
+	client = schedPair(t, sched, echoAPI)
+	done = callAsync(context.Background(), client, "echo", "dropped")
+	registered := sched.await(t, schedRegistered)
+	failed := make(chan struct{})
+	go func() {
+		client.failPending(failure)
+		close(failed)
+	}()
+	failing := sched.await(t, schedFailing)
+	// The request goes out, and is likely answered, while the call is being
+	// failed; either way the response must find nothing to deliver to.
+	close(registered.resume)
+	time.Sleep(20 * time.Millisecond)
+	close(failing.resume)
+	<-failed
+	if result := <-done; !errors.Is(result.err, failure) {
+		t.Fatalf("failed call: %v %v", result.value, result.err)
+	}
+	if value, err := client.Call("echo", "next"); err != nil || value != "next" {
+		t.Fatalf("call after the dropped response: %v %v", value, err)
+	}
+}
@@ -0,0 +1,146 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const IntrospectionMethod = "__kkrpc_introspect__"
+
+// MethodSpec describes one callable path. Params is the number of fixed
+// parameters, or -1 when the arity cannot be determined.
+type MethodSpec struct {
+	Name     string `json:"name"`
+	Params   int    `json:"params"`
+	Variadic bool   `json:"variadic,omitempty"`
+}
+
+type Schema struct {
+	Version int          `json:"version"`
+	Methods []MethodSpec `json:"methods"`
+	Dynamic bool         `json:"dynamic,omitempty"`
+}
+
+const schemaVersion = 1
+
+// Introspect lists the methods the server exposes. Namespaces served by a
+// HandlerFunc or forwarding rule appear as "name.*" with unknown arity.
+func (s *Server) Introspect() Schema {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schema := Schema{Version: schemaVersion, Dynamic: s.options.resolver != nil}
+	collectMethods(&schema.Methods, nil, s.api)
+	for _, rule := range s.forwards {
+		schema.Methods = append(schema.Methods, MethodSpec{Name: strings.Join(rule.pattern, "."), Params: -1, Variadic: true})
+	}
+	sort.Slice(schema.Methods, func(i, j int) bool { return schema.Methods[i].Name < schema.Methods[j].Name })
+	return schema
+}
+
+func collectMethods(methods *[]MethodSpec, prefix []string, node map[string]any) {
+	for key, value := range node {
+		path := append(append([]string(nil), prefix...), key)
+		name := strings.Join(path, ".")
+		switch typed := value.(type) {
+		case map[string]any:
+			collectMethods(methods, path, typed)
+		case Invoker:
+			spec := MethodSpec{Name: name, Params: -1, Variadic: true}
+			if arity, ok := typed.(interface {
+				NumParams() int
+				Variadic() bool
+			}); ok {
+				spec.Params, spec.Variadic = arity.NumParams(), arity.Variadic()
+			}
+			*methods = append(*methods, spec)
+		case HandlerFunc:
+			*methods = append(*methods, MethodSpec{Name: name + ".*", Params: -1, Variadic: true})
+		case func(...any) any, func(context.Context, ...any) any:
+			*methods = append(*methods, MethodSpec{Name: name, Params: -1, Variadic: true})
+		}
+	}
+}
+
+type SchemaDriftError struct {
+	Problems []string
+}
+
+func (e *SchemaDriftError) Error() string {
+	return "kkrpc: schema drift:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// FetchSchema asks the peer for its introspection document.
+func (c *Client) FetchSchema(ctx context.Context) (Schema, error) {
+	var schema Schema
+	result, err := c.CallContext(ctx, IntrospectionMethod)
+	if err != nil {
+		return schema, fmt.Errorf("kkrpc: peer does not support introspection: %w", err)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return schema, err
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return schema, fmt.Errorf("kkrpc: invalid introspection document: %w", err)
+	}
+	return schema, nil
+}
+
+// VerifySchema fetches the peer's schema and checks that every expected method
+// exists with a compatible arity, returning a *SchemaDriftError listing every
+// mismatch.
+func (c *Client) VerifySchema(ctx context.Context, expected []MethodSpec) error {
+	schema, err := c.FetchSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if problems := schema.diff(expected); len(problems) > 0 {
+		return &SchemaDriftError{Problems: problems}
+	}
+	return nil
+}
+
+func (s Schema) diff(expected []MethodSpec) []string {
+	var problems []string
+	for _, want := range expected {
+		have, ok := s.lookup(want.Name)
+		if !ok {
+			if !s.Dynamic {
+				problems = append(problems, "- "+want.Name+": missing on peer")
+			}
+			continue
+		}
+		if reason := arityMismatch(want, have); reason != "" {
+			problems = append(problems, "~ "+want.Name+": "+reason)
+		}
+	}
+	return problems
+}
+
+func (s Schema) lookup(name string) (MethodSpec, bool) {
+	path := splitMethod(name)
+	for _, method := range s.Methods {
+		if method.Name == name || (strings.HasSuffix(method.Name, "*") && matchPattern(splitMethod(method.Name), path)) {
+			return method, true
+		}
+	}
+	return MethodSpec{}, false
+}
+
+func arityMismatch(want, have MethodSpec) string {
+	if have.Params < 0 || want.Params < 0 {
+		return ""
+	}
+	switch {
+	case want.Variadic && !have.Variadic:
+		return fmt.Sprintf("expects variadic params, peer takes exactly %d", have.Params)
+	case have.Variadic && want.Params < have.Params:
+		return fmt.Sprintf("expects %d params, peer requires at least %d", want.Params, have.Params)
+	case !have.Variadic && want.Params != have.Params:
+		return fmt.Sprintf("expects %d params, peer takes %d", want.Params, have.Params)
+	}
+	return ""
+}
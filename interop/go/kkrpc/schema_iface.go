@@ -0,0 +1,44 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SchemaFromInterface derives the expected methods from a Go interface, given
+// as a nil pointer such as (*MathAPI)(nil). Method names are lower-camel-cased
+// and prefixed with namespace; a leading context.Context is not counted.
+func SchemaFromInterface(namespace string, iface any) ([]MethodSpec, error) {
+	ifaceType := reflect.TypeOf(iface)
+	if ifaceType == nil || ifaceType.Kind() != reflect.Pointer || ifaceType.Elem().Kind() != reflect.Interface {
+		return nil, fmt.Errorf("kkrpc: expected a pointer to an interface, got %T", iface)
+	}
+	ifaceType = ifaceType.Elem()
+	specs := make([]MethodSpec, 0, ifaceType.NumMethod())
+	for i := 0; i < ifaceType.NumMethod(); i++ {
+		method := ifaceType.Method(i)
+		params := method.Type.NumIn()
+		if params > 0 && method.Type.In(0) == contextType {
+			params--
+		}
+		variadic := method.Type.IsVariadic()
+		if variadic {
+			params--
+		}
+		name := lowerFirst(method.Name)
+		if namespace != "" {
+			name = namespace + "." + name
+		}
+		specs = append(specs, MethodSpec{Name: name, Params: params, Variadic: variadic})
+	}
+	return specs, nil
+}
+
+func lowerFirst(name string) string {
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(first)) + name[size:]
+}
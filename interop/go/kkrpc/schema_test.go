@@ -0,0 +1,70 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type mathAPI interface {
+	Add(ctx context.Context, a, b float64) (float64, error)
+	Sub(a, b float64) float64
+	Sum(values ...float64) float64
+}
+
+func TestVerifySchemaReportsDrift(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport)
+	server := NewServer(serverTransport, map[string]any{})
+	defer client.Close()
+	defer server.Close()
+	if err := server.RegisterFunc("math.add", func(a, b, c float64) float64 { return a + b + c }); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterFunc("math.sum", func(first float64, rest ...float64) float64 { return first }); err != nil {
+		t.Fatal(err)
+	}
+	_ = server.Handle("plugins", func(ctx context.Context, method string, args json.RawMessage) (any, error) {
+		return nil, nil
+	})
+
+	expected, err := SchemaFromInterface("math", (*mathAPI)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, MethodSpec{Name: "plugins.git.status", Params: 0})
+
+	err = client.VerifySchema(context.Background(), expected)
+	var drift *SchemaDriftError
+	if !errors.As(err, &drift) {
+		t.Fatalf("expected drift error, got %v", err)
+	}
+	message := drift.Error()
+	for _, want := range []string{
+		"~ math.add: expects 2 params, peer takes 3",
+		"- math.sub: missing on peer",
+		"~ math.sum: expects 0 params, peer requires at least 1",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("drift report missing %q:\n%s", want, message)
+		}
+	}
+	if len(drift.Problems) != 3 {
+		t.Fatalf("unexpected problems:\n%s", message)
+	}
+
+	_ = server.RegisterFunc("math.add", func(a, b float64) float64 { return a + b })
+	_ = server.RegisterFunc("math.sub", func(a, b float64) float64 { return a - b })
+	_ = server.RegisterFunc("math.sum", func(values ...float64) float64 { return 0 })
+	if err := client.VerifySchema(context.Background(), expected); err != nil {
+		t.Fatalf("matching schema rejected: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package kkrpc
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSampledRequests bounds the requests remembered until their response.
+	maxSampledRequests = 10000
+	// sampledRequestTTL is how long an unanswered request is remembered once
+	// maxSampledRequests are; a request that is never answered, e.g. because
+	// the peer dropped it, must not hold its place for good.
+	sampledRequestTTL = 5 * time.Minute
+)
+
+// pendingRequests remembers sampled requests until their response. The
+// caller guards it with its own mutex.
+type pendingRequests[T any] struct {
+	entries   map[string]pendingRequest[T]
+	lastSweep time.Time
+}
+
+type pendingRequest[T any] struct {
+	value T
+	added time.Time
+}
+
+func newPendingRequests[T any]() pendingRequests[T] {
+	return pendingRequests[T]{entries: make(map[string]pendingRequest[T])}
+}
+
+// add remembers value under id unless maxSampledRequests are remembered and
+// none of them is older than sampledRequestTTL. Full, it looks for those at
+// most once a second.
+func (p *pendingRequests[T]) add(id string, value T) {
+	now := time.Now()
+	if len(p.entries) >= maxSampledRequests && now.Sub(p.lastSweep) >= time.Second {
+		p.lastSweep = now
+		for key, entry := range p.entries {
+			if now.Sub(entry.added) >= sampledRequestTTL {
+				delete(p.entries, key)
+			}
+		}
+	}
+	if len(p.entries) < maxSampledRequests {
+		p.entries[id] = pendingRequest[T]{value: value, added: now}
+	}
+}
+
+func (p *pendingRequests[T]) get(id string) (T, bool) {
+	entry, ok := p.entries[id]
+	return entry.value, ok
+}
+
+func (p *pendingRequests[T]) take(id string) (T, bool) {
+	entry, ok := p.entries[id]
+	delete(p.entries, id)
+	return entry.value, ok
+}
+
+type SerializationMetrics struct {
+	Method          string
+	Encoded         uint64
+	Decoded         uint64
+	EncodeDuration  time.Duration
+	DecodeDuration  time.Duration
+	EncodedBytes    uint64
+	DecodedBytes    uint64
+	MaxEncodedBytes int
+	MaxDecodedBytes int
+}
+
+type SerializationStats struct {
+	mu      sync.Mutex
+	methods map[string]*SerializationMetrics
+}
+
+func NewSerializationStats() *SerializationStats {
+	return &SerializationStats{methods: make(map[string]*SerializationMetrics)}
+}
+
+func (s *SerializationStats) Snapshot() []SerializationMetrics {
+	s.mu.Lock()
+	snapshot := make([]SerializationMetrics, 0, len(s.methods))
+	for _, entry := range s.methods {
+		snapshot = append(snapshot, *entry)
+	}
+	s.mu.Unlock()
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Method < snapshot[j].Method })
+	return snapshot
+}
+
+func (s *SerializationStats) record(method string, encode bool, elapsed time.Duration, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.methods[method]
+	if !ok {
+		entry = &SerializationMetrics{Method: method}
+		s.methods[method] = entry
+	}
+	if encode {
+		entry.Encoded++
+		entry.EncodeDuration += elapsed
+		entry.EncodedBytes += uint64(size)
+		entry.MaxEncodedBytes = max(entry.MaxEncodedBytes, size)
+		return
+	}
+	entry.Decoded++
+	entry.DecodeDuration += elapsed
+	entry.DecodedBytes += uint64(size)
+	entry.MaxDecodedBytes = max(entry.MaxDecodedBytes, size)
+}
+
+type serializationSampler struct {
+	stats    *SerializationStats
+	rate     float64
+	mu       sync.Mutex
+	requests pendingRequests[*sampledRequest]
+}
+
+// sampledRequest is a sampled request awaiting its response. Requests this
+// side serves are recorded when answered, under the method they resolved to.
+type sampledRequest struct {
+	method  string
+	inbound bool
+	elapsed time.Duration
+	size    int
+}
+
+func (s *serializationSampler) observe(payload map[string]any, encode bool, elapsed time.Duration, size int) {
+	if s == nil {
+		return
+	}
+	id, _ := payload["id"].(string)
+	switch payload["t"] {
+	case "q":
+		if s.rate < 1 && rand.Float64() >= s.rate {
+			return
+		}
+		request := &sampledRequest{inbound: !encode, elapsed: elapsed, size: size}
+		if encode {
+			request.method = strings.Join(payloadPath(payload), ".")
+			s.stats.record(request.method, true, elapsed, size)
+		}
+		s.mu.Lock()
+		s.requests.add(id, request)
+		s.mu.Unlock()
+	case "r":
+		s.mu.Lock()
+		request, ok := s.requests.take(id)
+		s.mu.Unlock()
+		if !ok || request.inbound != encode {
+			return
+		}
+		method := request.method
+		if method == "" {
+			method = UnknownMethod
+		}
+		if request.inbound {
+			s.stats.record(method, false, request.elapsed, request.size)
+		}
+		s.stats.record(method, encode, elapsed, size)
+	case "cb":
+		if s.rate < 1 && rand.Float64() >= s.rate {
+			return
+		}
+		s.stats.record("callback", encode, elapsed, size)
+	}
+}
+
+// resolve names the method of a request this side serves.
+func (s *serializationSampler) resolve(id, method string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if request, ok := s.requests.get(id); ok && request.inbound {
+		request.method = method
+	}
+	s.mu.Unlock()
+}
@@ -0,0 +1,113 @@
+package kkrpc
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// Serve accepts connections from listener until it is closed and serves each
+// over its own Channel, with newline framing, exposing the API newAPI returns
+// for that connection. newAPI runs once per connection, so per-client state
+// can live in the handlers it builds; a nil API refuses the connection. With a
+// tls.NewListener, a verified client certificate becomes the connection's
+// identity (see IdentityFromTLS); opts may still override it.
+//
+// A peer's set writes into the API it is served, so newAPI must not return a
+// map another connection also uses; build one per call, or cloneAPI a shared
+// one as ServeListener does.
+//
+// When a peer disconnects its Channel is closed: calls it had pending fail and
+// its streams are cancelled. Once the listener is closed, Serve closes the
+// connections still open and returns after their channels are torn down.
+func Serve(listener net.Listener, newAPI func(conn net.Conn) map[string]any, opts ...Option) error {
+	var live liveConns
+	defer live.closeAll()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		live.add(conn)
+		go func(conn net.Conn) {
+			defer live.remove(conn)
+			identity, err := connIdentity(conn)
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			api := newAPI(conn)
+			if api == nil {
+				_ = conn.Close()
+				return
+			}
+			connOpts := opts
+			if identity != nil {
+				connOpts = append([]Option{WithIdentity(*identity)}, opts...)
+			}
+			serveChannel(NewConnTransport(conn), api, connOpts)
+		}(conn)
+	}
+}
+
+// serveChannel serves api over transport until the peer disconnects, then
+// closes the channel.
+func serveChannel(transport Transport, api map[string]any, opts []Option) {
+	disconnected := make(chan struct{})
+	channel := NewChannel(transport, api, append([]Option{func(o *options) {
+		o.disconnected = func() { close(disconnected) }
+	}}, opts...)...)
+	<-disconnected
+	_ = channel.Close()
+}
+
+// cloneAPI copies the nested maps of api, so that a set on one connection
+// cannot race, or be seen by, the others. Handlers and other values are shared.
+func cloneAPI(api map[string]any) map[string]any {
+	cloned := make(map[string]any, len(api))
+	for key, value := range api {
+		if nested, ok := value.(map[string]any); ok {
+			value = cloneAPI(nested)
+		}
+		cloned[key] = value
+	}
+	return cloned
+}
+
+// liveConns tracks the connections a listener has accepted, so that they can
+// be closed, handshakes included, when it stops.
+type liveConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func (l *liveConns) add(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns == nil {
+		l.conns = make(map[net.Conn]struct{})
+	}
+	l.conns[conn] = struct{}{}
+	l.wg.Add(1)
+}
+
+func (l *liveConns) remove(conn net.Conn) {
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+	l.wg.Done()
+}
+
+// closeAll closes every connection and waits until their goroutines are done.
+func (l *liveConns) closeAll() {
+	l.mu.Lock()
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+}
@@ -0,0 +1,121 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// counterAPI gives each connection its own counter.
+func counterAPI() map[string]any {
+	var count int
+	return map[string]any{"count": MustFunc(func() int {
+		count++
+		return count
+	})}
+}
+
+func TestServeGivesEachConnectionItsOwnChannel(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted atomic.Int32
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(listener, func(net.Conn) map[string]any {
+			if accepted.Add(1) > 3 {
+				return nil
+			}
+			return counterAPI()
+		})
+	}()
+
+	clients := make([]*Client, 3)
+	for i := range clients {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[i] = NewClient(NewConnTransport(conn), WithTimeout(2*time.Second))
+		defer clients[i].Close()
+	}
+	for round := 1; round <= 2; round++ {
+		for i, client := range clients {
+			if result, err := client.Call("count"); err != nil || result != float64(round) {
+				t.Fatalf("client %d round %d: %v %v", i, round, result, err)
+			}
+		}
+	}
+	_ = clients[0].Close()
+	if result, err := clients[1].Call("count"); err != nil || result != float64(3) {
+		t.Fatalf("after another client left: %v %v", result, err)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := NewClient(NewConnTransport(conn), WithTimeout(2*time.Second))
+	defer refused.Close()
+	if _, err := refused.Call("count"); !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("connection without an API: %v", err)
+	}
+
+	_ = listener.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after the listener closed")
+	}
+	if _, err := clients[2].Call("count"); err == nil {
+		t.Fatal("connection outlived the listener")
+	}
+}
+
+func TestWebSocketListenerServesAPIPerConnection(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := ListenWebSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- listener.ServeAPIFunc(func(*WebSocketTransport) map[string]any { return counterAPI() })
+	}()
+
+	clients := make([]*Client, 2)
+	for i := range clients {
+		transport, err := NewWebSocketTransport("ws://" + listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[i] = NewClient(transport, WithTimeout(2*time.Second))
+		defer clients[i].Close()
+		if result, err := clients[i].Call("count"); err != nil || result != float64(1) {
+			t.Fatalf("client %d: %v %v", i, result, err)
+		}
+	}
+
+	_ = listener.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	for i, client := range clients {
+		if _, err := client.Call("count"); err == nil {
+			t.Fatalf("client %d outlived the listener", i)
+		}
+	}
+}
@@ -1,60 +1,124 @@
 package kkrpc
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var ErrPathNotFound = errors.New("path not found")
+
+type Resolver func(ctx context.Context, path []string) (any, error)
+
 type Server struct {
-	transport Transport
-	api       map[string]any
-	mu        sync.Mutex
+	transport   Transport
+	api         map[string]any
+	options     *options
+	dispatcher  *dispatcher
+	active      map[string]struct{}
+	forwards    []forwardRule
+	blocked     atomic.Int64
+	releases    callbackReleases
+	idempotency *idempotencyTracker
+	stamps      requestStamps
+	running     int
+	idle        chan struct{}
+	closing     bool
+	mu          sync.Mutex
 }
 
-func NewServer(transport Transport, api map[string]any) *Server {
-	server := &Server{transport: transport, api: api}
-	go server.readLoop()
+func NewServer(transport Transport, api map[string]any, opts ...Option) *Server {
+	o := newOptions(opts)
+	server := newServer(transport, api, o)
+	startReading(transport, o, server.handleMessage)
 	return server
 }
 
+func newServer(transport Transport, api map[string]any, o *options) *Server {
+	return &Server{
+		transport:   transport,
+		api:         api,
+		options:     o,
+		dispatcher:  newDispatcher(o.pool, o.maxGoroutines),
+		active:      make(map[string]struct{}),
+		idempotency: newIdempotencyTracker(o.idempotency),
+	}
+}
+
+func (s *Server) MaxGoroutines() int {
+	return s.dispatcher.limit()
+}
+
 func (s *Server) Close() error {
+	s.options.streams.cancel()
 	return s.transport.Close()
 }
 
-func (s *Server) readLoop() {
-	for {
-		line, err := s.transport.Read()
-		if err != nil {
-			if errors.Is(err, ErrTransportClosed) {
-				return
-			}
+func (s *Server) handleMessage(message map[string]any) {
+	messageType, _ := message["t"].(string)
+	if messageType == "sq" {
+		s.options.streams.handleRequest(s.transport, s.options, message)
+		return
+	}
+	if messageType == "sr" {
+		s.options.remoteStreams.handleResponse(message)
+		return
+	}
+	if messageType == "cbe" {
+		s.handleCallbackError(message)
+		return
+	}
+	if messageType != "q" {
+		return
+	}
+	requestID, _ := message["id"].(string)
+	if s.isClosing() {
+		s.sendError(requestID, ErrClosing)
+		return
+	}
+	if s.options.signer != nil {
+		if err := s.options.signer.verify(message, s.options.replay, s.options.logger); err != nil {
+			s.sendError(requestID, err)
 			return
 		}
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			continue
-		}
-		message, err := DecodeMessage(trimmed)
-		if err != nil {
-			continue
-		}
-		messageType, _ := message["t"].(string)
-		if messageType != "q" {
-			continue
-		}
-		op, _ := message["op"].(string)
-		switch op {
-		case "call":
-			s.handleCall(message)
-		case "get":
-			s.handleGet(message)
-		case "set":
-			s.handleSet(message)
-		case "new":
-			s.handleConstruct(message)
-		}
 	}
+	if err := s.options.resources.admit(requestID); err != nil {
+		s.sendError(requestID, err)
+		return
+	}
+	if missing, ok := message["bm"]; ok && s.options.blobs != nil {
+		s.options.blobs.forget(hashList(missing))
+	}
+	if _, ok := message["ts"]; ok {
+		s.stamps.received(message, time.Now())
+	}
+	op, _ := message["op"].(string)
+	switch op {
+	case "call":
+		s.dispatch(message, s.handleCall)
+	case "get":
+		s.serve(message, s.handleGet)
+	case "set":
+		s.serve(message, s.handleSet)
+	case "new":
+		s.dispatch(message, s.handleConstruct)
+	default:
+		s.stamps.forget(requestID)
+		s.options.resources.release(requestID)
+	}
+}
+
+func (s *Server) dispatch(message map[string]any, handle func(context.Context, map[string]any) (any, error)) {
+	if s.wouldDeadlock(message) {
+		requestID, _ := message["id"].(string)
+		s.options.resources.release(requestID)
+		s.sendError(requestID, ErrDeadlock)
+		return
+	}
+	s.dispatcher.runWithSlot(func(slot *dispatchSlot) { s.serveInSlot(message, slot, handle) })
 }
 
 func pathFromMessage(message map[string]any) []string {
@@ -69,48 +133,95 @@ func pathFromMessage(message map[string]any) []string {
 }
 
 func (s *Server) resolvePath(path []string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var target any = s.api
 	for _, part := range path {
+		if _, ok := target.(HandlerFunc); ok {
+			return target, nil
+		}
 		obj, ok := target.(map[string]any)
 		if !ok {
 			return nil, errors.New("invalid path")
 		}
 		value, exists := obj[part]
 		if !exists {
-			return nil, errors.New("path not found")
+			return nil, ErrPathNotFound
 		}
 		target = value
 	}
 	return target, nil
 }
 
-func (s *Server) convertInboundArg(arg any, requestID string) any {
-	envelope, ok := arg.(map[string]any)
-	if !ok {
-		return arg
+func (s *Server) resolveMethod(ctx context.Context, path []string) (any, error) {
+	if forward, ok := s.forwardFor(path); ok {
+		return forward, nil
 	}
-	switch envelope[ArgEnvelopeTag] {
-	case "value":
-		return envelope["v"]
-	case "callback":
-		callbackID, _ := envelope["id"].(string)
-		return Callback(func(callbackArgs ...any) {
-			payload := map[string]any{
-				"t":  "cb",
-				"id": callbackID,
-				"a":  callbackArgs,
-			}
-			message, err := EncodeMessage(payload)
-			if err != nil {
-				return
-			}
-			_ = s.transport.Write(message)
-		})
+	resolved, err := s.resolvePath(path)
+	if !errors.Is(err, ErrPathNotFound) || s.options.resolver == nil {
+		return resolved, err
+	}
+	resolved, resolveErr := s.options.resolver(ctx, path)
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+	if resolved == nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+func (s *Server) convertInboundArg(arg any, requestID string) any {
+	switch typed := arg.(type) {
+	case []any:
+		converted := make([]any, 0, len(typed))
+		for _, value := range typed {
+			converted = append(converted, s.convertInboundArg(value, requestID))
+		}
+		return converted
+	case map[string]any:
+		switch typed[ArgEnvelopeTag] {
+		case "value":
+			return typed["v"]
+		case "callback":
+			callbackID, _ := typed["id"].(string)
+			return s.callbackProxy(callbackID)
+		}
+		if id, ok := typed["id"].(string); ok && typed[StreamRefTag] == "async-iterable" {
+			return s.options.remoteStreams.open(s.transport, s.options, id, func(value any) any { return value })
+		}
+		converted := make(map[string]any, len(typed))
+		for key, value := range typed {
+			converted[key] = s.convertInboundArg(value, requestID)
+		}
+		return converted
 	default:
 		return arg
 	}
 }
 
+// handleCallbackError logs a callback that failed on the peer; callbacks are
+// fire-and-forget, so there is no caller left to hand the error to.
+func (s *Server) handleCallbackError(message map[string]any) {
+	callbackID, _ := message["id"].(string)
+	s.options.logger.Printf("kkrpc: callback %s failed on the peer: %v", callbackID, decodeError(message["e"]))
+}
+
+func (s *Server) callbackProxy(callbackID string) Callback {
+	ref := s.trackCallback(callbackID)
+	return func(callbackArgs ...any) {
+		payload := map[string]any{
+			"t":  "cb",
+			"id": ref.id,
+			"a":  callbackArgs,
+		}
+		transport, o := s.callbackTransport()
+		if err := writePayload(transport, o, payload); err != nil {
+			s.options.logger.Printf("kkrpc: invoke callback %s: %v", ref.id, err)
+		}
+	}
+}
+
 func (s *Server) convertInboundArgs(args []any, requestID string) []any {
 	processed := make([]any, 0, len(args))
 	for _, arg := range args {
@@ -123,107 +234,232 @@ func (s *Server) sendResponse(requestID string, result any) {
 	payload := map[string]any{
 		"t":  "r",
 		"id": requestID,
-		"v":  result,
+		"v":  s.options.encodeBlobs(result),
+	}
+	s.stamps.stamp(requestID, payload)
+	if err := writePayload(s.transport, s.options, payload); err != nil {
+		s.options.logger.Printf("kkrpc: send response %s: %v", requestID, err)
+		s.sendError(requestID, fmt.Errorf("encode result: %w", err))
+		return
 	}
-	message, err := EncodeMessage(payload)
+	s.completeIdempotent(requestID, payload)
+}
+
+func (s *Server) serve(message map[string]any, handle func(context.Context, map[string]any) (any, error)) {
+	s.serveInSlot(message, nil, handle)
+}
+
+func (s *Server) serveInSlot(message map[string]any, slot *dispatchSlot, handle func(context.Context, map[string]any) (any, error)) {
+	requestID, _ := message["id"].(string)
+	call := s.options.serveCall(requestID)
+	s.begin()
+	finish := func(err error) {
+		call.finish(err)
+		s.options.resources.release(requestID)
+		s.end()
+	}
+	ctx, err := s.options.decodeEnvelope(s.requestContext(message, slot), message)
+	if err != nil {
+		s.sendError(requestID, err)
+		finish(err)
+		return
+	}
+	ctx = context.WithValue(ctx, servedCallKey{}, call)
+	if !s.beginIdempotent(ctx, message, requestID) {
+		finish(nil)
+		return
+	}
+	s.mu.Lock()
+	s.active[requestID] = struct{}{}
+	s.mu.Unlock()
+	result, err := handle(ctx, message)
+	s.mu.Lock()
+	delete(s.active, requestID)
+	s.mu.Unlock()
 	if err != nil {
+		s.sendError(requestID, err)
+		finish(err)
 		return
 	}
-	_ = s.transport.Write(message)
+	s.respond(requestID, result, finish)
+}
+
+// respond writes the response before calling finish, so that a Drain waiting
+// on the call only returns once the peer has been answered.
+func (s *Server) respond(requestID string, result any, finish func(error)) {
+	switch typed := result.(type) {
+	case *Future:
+		typed.then(func(value any, err error) {
+			if err != nil {
+				s.sendError(requestID, err)
+				finish(err)
+				return
+			}
+			s.respond(requestID, value, finish)
+		})
+	case error:
+		s.sendError(requestID, typed)
+		finish(typed)
+	case *Stream:
+		s.sendResponse(requestID, s.options.streams.attach(s.transport, s.options, typed))
+		finish(nil)
+	default:
+		s.sendResponse(requestID, result)
+		finish(nil)
+	}
 }
 
 func (s *Server) sendError(requestID string, err error) {
 	payload := map[string]any{
 		"t":  "r",
 		"id": requestID,
-		"e": map[string]any{
-			"n": "Error",
-			"m": err.Error(),
-		},
+		"e":  encodeError(err),
 	}
-	message, encodeErr := EncodeMessage(payload)
-	if encodeErr != nil {
-		return
+	s.stamps.stamp(requestID, payload)
+	if writeErr := writePayload(s.transport, s.options, payload); writeErr != nil {
+		s.options.logger.Printf("kkrpc: send error %s: %v", requestID, writeErr)
 	}
-	_ = s.transport.Write(message)
+	s.completeIdempotent(requestID, payload)
 }
 
-func (s *Server) handleCall(message map[string]any) {
+func (s *Server) handleCall(ctx context.Context, message map[string]any) (any, error) {
 	requestID, _ := message["id"].(string)
 	argsRaw, _ := message["a"].([]any)
 	if argsRaw == nil {
 		argsRaw = []any{}
 	}
 
+	argsRaw, err := s.resolveBlobArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
+
 	path := pathFromMessage(message)
-	resolved, err := s.resolvePath(path)
+	if err := s.authorize(ctx, "call", path, argsRaw); err != nil {
+		return nil, err
+	}
+	if len(path) == 1 && path[0] == IntrospectionMethod {
+		resolvedCall(ctx, path)
+		return s.Introspect(), nil
+	}
+	resolved, err := s.resolveMethod(ctx, path)
 	if err != nil {
-		s.sendError(requestID, err)
-		return
+		return nil, err
 	}
-	callable, ok := resolved.(func(...any) any)
-	if !ok {
-		s.sendError(requestID, errors.New("method not callable"))
-		return
+	resolvedCall(ctx, path)
+	if handler, ok := resolved.(HandlerFunc); ok {
+		return s.invokeRaw(ctx, handler, path, argsRaw)
 	}
+	return invokeHandler(ctx, resolved, s.convertInboundArgs(argsRaw, requestID), "method not callable")
+}
 
-	result := callable(s.convertInboundArgs(argsRaw, requestID)...)
-	s.sendResponse(requestID, result)
+func invokeHandler(ctx context.Context, resolved any, args []any, notCallable string) (any, error) {
+	switch handler := resolved.(type) {
+	case func(...any) any:
+		return handler(args...), nil
+	case func(context.Context, ...any) any:
+		return handler(ctx, args...), nil
+	case Invoker:
+		return handler.Invoke(ctx, args)
+	default:
+		return nil, errors.New(notCallable)
+	}
 }
 
-func (s *Server) handleGet(message map[string]any) {
-	requestID, _ := message["id"].(string)
+func (s *Server) handleGet(ctx context.Context, message map[string]any) (any, error) {
 	path := pathFromMessage(message)
 	if path == nil {
-		s.sendError(requestID, errors.New("missing path"))
-		return
+		return nil, errors.New("missing path")
 	}
-	result, err := s.resolvePath(path)
-	if err != nil {
-		s.sendError(requestID, err)
-		return
+	if err := s.authorize(ctx, "get", path, nil); err != nil {
+		return nil, err
 	}
-	s.sendResponse(requestID, result)
+	value, err := s.resolvePath(path)
+	if err == nil {
+		resolvedCall(ctx, path)
+	}
+	return value, err
 }
 
-func (s *Server) handleSet(message map[string]any) {
-	requestID, _ := message["id"].(string)
+func (s *Server) handleSet(ctx context.Context, message map[string]any) (any, error) {
 	path := pathFromMessage(message)
 	if len(path) == 0 {
-		s.sendError(requestID, errors.New("missing path"))
-		return
+		return nil, errors.New("missing path")
+	}
+	if err := s.authorize(ctx, "set", path, []any{message["v"]}); err != nil {
+		return nil, err
 	}
 	parent, err := s.resolvePath(path[:len(path)-1])
 	if err != nil {
-		s.sendError(requestID, err)
-		return
+		return nil, err
 	}
 	parentMap, ok := parent.(map[string]any)
 	if !ok {
-		s.sendError(requestID, errors.New("set target is not object"))
-		return
+		return nil, errors.New("set target is not object")
 	}
+	resolvedCall(ctx, path)
+	s.mu.Lock()
 	parentMap[path[len(path)-1]] = message["v"]
-	s.sendResponse(requestID, true)
+	s.mu.Unlock()
+	return true, nil
 }
 
-func (s *Server) handleConstruct(message map[string]any) {
+func (s *Server) handleConstruct(ctx context.Context, message map[string]any) (any, error) {
 	requestID, _ := message["id"].(string)
 	argsRaw, _ := message["a"].([]any)
 	if argsRaw == nil {
 		argsRaw = []any{}
 	}
+	argsRaw, err := s.resolveBlobArgs(argsRaw)
+	if err != nil {
+		return nil, err
+	}
 	path := pathFromMessage(message)
-	resolved, err := s.resolvePath(path)
+	if err := s.authorize(ctx, "new", path, argsRaw); err != nil {
+		return nil, err
+	}
+	resolved, err := s.resolveMethod(ctx, path)
 	if err != nil {
-		s.sendError(requestID, err)
-		return
+		return nil, err
 	}
-	constructor, ok := resolved.(func(...any) any)
-	if !ok {
-		s.sendError(requestID, errors.New("constructor not callable"))
-		return
+	resolvedCall(ctx, path)
+	return invokeHandler(ctx, resolved, s.convertInboundArgs(argsRaw, requestID), "constructor not callable")
+}
+
+// Drain waits until no call is running, including those answered later
+// through a Future, or until ctx is done. Stop the transport from delivering
+// new calls first, or Drain may never see the server idle; CloseGracefully
+// does so by refusing them.
+func (s *Server) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	idle := s.idle
+	s.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) begin() {
+	s.mu.Lock()
+	if s.running == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.running++
+	s.mu.Unlock()
+}
+
+func (s *Server) end() {
+	s.mu.Lock()
+	s.running--
+	if s.running == 0 {
+		close(s.idle)
 	}
-	result := constructor(s.convertInboundArgs(argsRaw, requestID)...)
-	s.sendResponse(requestID, result)
+	s.mu.Unlock()
 }
@@ -3,6 +3,8 @@ package kkrpc
 import (
 	"testing"
 	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
 )
 
 type serverTestTransport struct {
@@ -42,7 +44,38 @@ func (t *serverTestTransport) Close() error {
 	return nil
 }
 
+func newConnectedTestTransports() (*serverTestTransport, *serverTestTransport) {
+	left := newServerTestTransport()
+	right := newServerTestTransport()
+	go func() {
+		for {
+			var line string
+			var to *serverTestTransport
+			select {
+			case line = <-left.out:
+				to = right
+			case line = <-right.out:
+				to = left
+			case <-left.closed:
+				return
+			case <-right.closed:
+				return
+			}
+			select {
+			case to.in <- line:
+			case <-left.closed:
+				return
+			case <-right.closed:
+				return
+			}
+		}
+	}()
+	return left, right
+}
+
 func TestServerUnwrapsStableValueEnvelopeArgs(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
 	transport := newServerTestTransport()
 	defer transport.Close()
 
@@ -0,0 +1,231 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxSessionCalls bounds a SessionRecorder; calls past it are counted as
+// dropped instead of growing the capture without limit.
+const maxSessionCalls = 1 << 20
+
+const sessionVersion = 1
+
+// SessionCall is one captured call. At is the time since the first call of
+// the session.
+type SessionCall struct {
+	At     time.Duration `json:"at"`
+	Method string        `json:"method"`
+	Args   []any         `json:"args"`
+}
+
+type Session struct {
+	Version int           `json:"version"`
+	Calls   []SessionCall `json:"calls"`
+	// Dropped counts calls that were not captured: those passing callbacks,
+	// which cannot be replayed, and those past the recorder's limit.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// SessionRecorder captures the calls a client makes, in order and with their
+// timing, so ReplaySession can play the same traffic back as load. Unlike
+// ContractRecorder it keeps every call rather than one per shape.
+type SessionRecorder struct {
+	mu      sync.Mutex
+	started time.Time
+	calls   []SessionCall
+	dropped int
+	rules   []RedactionRule
+}
+
+func NewSessionRecorder() *SessionRecorder {
+	return &SessionRecorder{}
+}
+
+func WithSessionRecorder(recorder *SessionRecorder) Option {
+	return func(o *options) {
+		o.sessions = recorder
+	}
+}
+
+// Redact adds rules removing secrets from the calls captured from now on.
+func (r *SessionRecorder) Redact(rules ...RedactionRule) {
+	r.mu.Lock()
+	r.rules = append(r.rules, rules...)
+	r.mu.Unlock()
+}
+
+func (r *SessionRecorder) record(method string, args []any) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	replayable := true
+	for _, arg := range args {
+		if ShapeOf(arg).Kind == ShapeCallback {
+			replayable = false
+			break
+		}
+	}
+	var sample []any
+	if replayable {
+		if sample = jsonSample(args); sample == nil && len(args) > 0 {
+			replayable = false
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !replayable || len(r.calls) >= maxSessionCalls {
+		r.dropped++
+		return
+	}
+	if r.started.IsZero() {
+		r.started = now
+	}
+	redactSample(r.rules, method, sample)
+	r.calls = append(r.calls, SessionCall{At: now.Sub(r.started), Method: method, Args: sample})
+}
+
+func (r *SessionRecorder) Session() Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Session{Version: sessionVersion, Calls: append([]SessionCall(nil), r.calls...), Dropped: r.dropped}
+}
+
+func (r *SessionRecorder) WriteFile(path string) error {
+	return r.WriteEncryptedFile(path, nil)
+}
+
+// WriteEncryptedFile writes the session sealed with key, from NewCaptureKey,
+// or in the clear if key is nil.
+func (r *SessionRecorder) WriteEncryptedFile(path string, key []byte) error {
+	data, err := json.Marshal(r.Session())
+	if err != nil {
+		return err
+	}
+	return writeCapture(path, data, key)
+}
+
+func LoadSession(path string) (Session, error) {
+	return LoadSessionWithKey(path, nil)
+}
+
+// LoadSessionWithKey loads a session written by WriteEncryptedFile, or one in
+// the clear.
+func LoadSessionWithKey(path string, key []byte) (Session, error) {
+	var session Session
+	data, err := readCapture(path, key)
+	if err != nil {
+		return session, err
+	}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return session, fmt.Errorf("kkrpc: parse session %s: %w", path, err)
+	}
+	if session.Version != sessionVersion {
+		return session, fmt.Errorf("kkrpc: unsupported session version %d", session.Version)
+	}
+	return session, nil
+}
+
+type ReplayOptions struct {
+	// Speed scales the recorded pacing: 2 replays twice as fast. Zero sends
+	// every call as soon as a slot is free.
+	Speed float64
+	// Concurrency bounds the calls in flight; zero means unbounded. When
+	// every slot is busy the schedule slips, as a saturated peer would cause.
+	Concurrency int
+	// Loops plays the session this many times back to back, at least once.
+	Loops int
+	// Metrics, when set, also receives every replayed call, e.g. to watch a
+	// long replay live; the report's per-method figures come from it.
+	Metrics *Metrics
+}
+
+type ReplayReport struct {
+	Calls   uint64
+	Errors  uint64
+	Elapsed time.Duration
+	Methods []MethodMetrics
+}
+
+// Throughput returns the completed calls per second.
+func (r ReplayReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Calls) / r.Elapsed.Seconds()
+}
+
+// ReplaySession plays session against caller and reports how the peer coped.
+// Failed calls are counted, not fatal; cancelling ctx stops the replay and
+// reports what completed.
+func ReplaySession(ctx context.Context, caller Caller, session Session, opts ReplayOptions) ReplayReport {
+	metrics := opts.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+	var slots chan struct{}
+	if opts.Concurrency > 0 {
+		slots = make(chan struct{}, opts.Concurrency)
+	}
+	var length time.Duration
+	if n := len(session.Calls); n > 0 {
+		length = session.Calls[n-1].At
+	}
+	var calls, failed atomic.Uint64
+	started := time.Now()
+	var wg sync.WaitGroup
+replay:
+	for loop := 0; loop < max(opts.Loops, 1); loop++ {
+		for _, call := range session.Calls {
+			if opts.Speed > 0 {
+				at := time.Duration(float64(time.Duration(loop)*length+call.At) / opts.Speed)
+				if wait := time.Until(started.Add(at)); wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-timer.C:
+					case <-ctx.Done():
+						timer.Stop()
+						break replay
+					}
+				}
+			}
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					break replay
+				}
+			} else if ctx.Err() != nil {
+				break replay
+			}
+			wg.Add(1)
+			go func(call SessionCall) {
+				defer wg.Done()
+				if slots != nil {
+					defer func() { <-slots }()
+				}
+				metrics.CallStarted(call.Method)
+				callStarted := time.Now()
+				_, err := caller.CallContext(ctx, call.Method, call.Args...)
+				metrics.CallFinished(call.Method, time.Since(callStarted), err)
+				calls.Add(1)
+				if err != nil {
+					failed.Add(1)
+				}
+			}(call)
+		}
+	}
+	wg.Wait()
+	return ReplayReport{
+		Calls:   calls.Load(),
+		Errors:  failed.Load(),
+		Elapsed: time.Since(started),
+		Methods: metrics.Snapshot(),
+	}
+}
@@ -0,0 +1,110 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestSessionRecorderCapturesReplayableCalls(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	defer left.Close()
+	_ = NewServer(right, map[string]any{
+		"add":  MustFunc(func(a, b int) int { return a + b }),
+		"each": MustFunc(func(visit func(int)) { visit(1) }),
+	})
+	recorder := NewSessionRecorder()
+	client := NewClient(left, WithTimeout(2*time.Second), WithSessionRecorder(recorder))
+	for _, call := range [][]any{{1, 2}, {3, 4}} {
+		if _, err := client.Call("add", call...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Call("each", func(args ...any) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	if err := recorder.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	session, err := LoadSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Calls) != 2 || session.Dropped != 1 {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+	second := session.Calls[1]
+	if session.Calls[0].At != 0 || second.At < 0 || len(second.Args) != 2 || !valuesEqual(second.Args[0], 3) || !valuesEqual(second.Args[1], 4) {
+		t.Fatalf("unexpected calls: %+v", session.Calls)
+	}
+}
+
+func TestReplaySessionBoundsConcurrencyAndCountsErrors(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	defer left.Close()
+	var inFlight, peak atomic.Int64
+	_ = NewServer(right, map[string]any{
+		"work": MustFunc(func() {
+			current := inFlight.Add(1)
+			for {
+				seen := peak.Load()
+				if current <= seen || peak.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			inFlight.Add(-1)
+		}),
+	})
+	client := NewClient(left, WithTimeout(2*time.Second))
+
+	session := Session{Version: sessionVersion, Calls: []SessionCall{
+		{Method: "work"}, {Method: "work"}, {Method: "work"}, {Method: "missing"},
+	}}
+	report := ReplaySession(context.Background(), client, session, ReplayOptions{Concurrency: 2, Loops: 3})
+	if report.Calls != 12 || report.Errors != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if peak.Load() > 2 {
+		t.Fatalf("%d calls ran at once, limit 2", peak.Load())
+	}
+	if len(report.Methods) != 2 || report.Methods[1].Method != "work" || report.Methods[1].Calls != 9 {
+		t.Fatalf("unexpected methods: %+v", report.Methods)
+	}
+}
+
+func TestReplaySessionScalesPacing(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	left, right := NewPipeTransportPair()
+	defer left.Close()
+	_ = NewServer(right, map[string]any{"ping": MustFunc(func() {})})
+	client := NewClient(left, WithTimeout(2*time.Second))
+
+	session := Session{Version: sessionVersion, Calls: []SessionCall{
+		{Method: "ping"}, {At: 400 * time.Millisecond, Method: "ping"},
+	}}
+	report := ReplaySession(context.Background(), client, session, ReplayOptions{Speed: 4})
+	if report.Calls != 2 || report.Elapsed < 100*time.Millisecond || report.Elapsed > time.Second {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report = ReplaySession(ctx, client, session, ReplayOptions{Speed: 1})
+	if report.Calls != 1 {
+		t.Fatalf("cancelled replay made %d calls", report.Calls)
+	}
+}
@@ -0,0 +1,350 @@
+package kkrpc
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultSignatureWindow is how old a signed request may be, and so how long
+// its nonce is remembered, unless WithRequestSigning says otherwise.
+const DefaultSignatureWindow = 5 * time.Minute
+
+const requestSignatureLabel = "kkrpc-request-sig-v1\x00"
+
+// ReplayStore remembers the nonces of signed requests until they expire.
+// Implementations must be safe for concurrent use; a persistent or shared
+// store keeps replay protection across restarts and server replicas.
+type ReplayStore interface {
+	// Remember records nonce until expires and reports false when it was
+	// already recorded and has not expired yet. An error with true means the
+	// nonce was recorded but upkeep failed; the request is served and the
+	// error logged.
+	Remember(nonce string, expires time.Time) (bool, error)
+}
+
+// WithRequestSigning has the client sign every request with HMAC-SHA256 under
+// key, and the server answer unsigned, forged, stale or replayed requests with
+// a PermissionError instead of serving them. Both peers need the same key. A
+// request is stale once older than window, DefaultSignatureWindow when zero,
+// or as far in the future, so clocks must roughly agree.
+//
+// This protects requests passing through a broker (Redis, MQTT) that others
+// can write to. Nonces are kept in memory, shared by every connection built
+// from the returned option; use WithReplayStore to keep them across restarts.
+//
+// Only requests ("t":"q") are signed and verified. Responses, callbacks and
+// stream control frames ("sq") travel unsigned, so a writer on the broker can
+// forge results or callback arguments, though it cannot make the server run a
+// method. Use a transport that authenticates every frame where that matters.
+//
+// A signed request carries "sig": {"ts": <unix ms>, "n": <nonce>, "mac":
+// <base64>}, the HMAC of the label "kkrpc-request-sig-v1\x00", ts, a NUL,
+// the nonce, a NUL and the request without "sig" encoded as JSON with sorted
+// keys.
+func WithRequestSigning(key []byte, window time.Duration) Option {
+	if window <= 0 {
+		window = DefaultSignatureWindow
+	}
+	signer := &requestSigner{key: key, window: window, replay: NewMemoryReplayStore()}
+	return func(o *options) {
+		o.signer = signer
+	}
+}
+
+// WithReplayStore replaces the in-memory nonce store of WithRequestSigning,
+// e.g. with a FileReplayStore.
+func WithReplayStore(store ReplayStore) Option {
+	return func(o *options) {
+		o.replay = store
+	}
+}
+
+type requestSigner struct {
+	key    []byte
+	window time.Duration
+	replay ReplayStore
+}
+
+// sign adds "sig" to a request as the last step before it is written.
+func (s *requestSigner) sign(payload map[string]any) error {
+	if s == nil {
+		return nil
+	}
+	stamp := time.Now().UnixMilli()
+	nonce := GenerateUUID()
+	mac, err := s.mac(payload, stamp, nonce)
+	if err != nil {
+		return err
+	}
+	payload["sig"] = map[string]any{"ts": stamp, "n": nonce, "mac": base64.StdEncoding.EncodeToString(mac)}
+	return nil
+}
+
+func (s *requestSigner) mac(payload map[string]any, stamp int64, nonce string) ([]byte, error) {
+	unsigned := make(map[string]any, len(payload))
+	for key, value := range payload {
+		if key != "sig" {
+			unsigned[key] = value
+		}
+	}
+	// Both ends sign what the server decodes, so structs and integers on the
+	// client must first take the shape they have after a JSON round trip.
+	encoded, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	if encoded, err = json.Marshal(decoded); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(requestSignatureLabel))
+	mac.Write([]byte(strconv.FormatInt(stamp, 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{0})
+	mac.Write(encoded)
+	return mac.Sum(nil), nil
+}
+
+// verify checks a request's signature and consumes its nonce.
+func (s *requestSigner) verify(message map[string]any, store ReplayStore, logger Logger) error {
+	sig, _ := message["sig"].(map[string]any)
+	if sig == nil {
+		return signatureRejected("unsigned request")
+	}
+	stampValue, _ := toFloat64(sig["ts"])
+	stamp := int64(stampValue)
+	nonce, _ := sig["n"].(string)
+	encoded, _ := sig["mac"].(string)
+	got, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || nonce == "" {
+		return signatureRejected("malformed signature")
+	}
+	want, err := s.mac(message, stamp, nonce)
+	if err != nil || !hmac.Equal(got, want) {
+		return signatureRejected("invalid signature")
+	}
+	signed := time.UnixMilli(stamp)
+	if age := time.Since(signed); age > s.window || age < -s.window {
+		return signatureRejected("stale request")
+	}
+	if store == nil {
+		store = s.replay
+	}
+	// The nonce is kept until the request would be stale anyway.
+	fresh, err := store.Remember(nonce, signed.Add(s.window))
+	if err != nil {
+		logger.Printf("kkrpc: replay store: %v", err)
+		if !fresh {
+			return signatureRejected("replay check failed")
+		}
+	}
+	if !fresh {
+		return signatureRejected("replayed request")
+	}
+	return nil
+}
+
+func signatureRejected(reason string) error {
+	return &RpcError{Name: "PermissionError", Message: "kkrpc: " + reason}
+}
+
+// MemoryReplayStore is the default ReplayStore; it forgets everything on
+// restart.
+type MemoryReplayStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	swept  int
+}
+
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *MemoryReplayStore) Remember(nonce string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remember(nonce, expires, time.Now()), nil
+}
+
+func (s *MemoryReplayStore) remember(nonce string, expires, now time.Time) bool {
+	if previous, ok := s.nonces[nonce]; ok && now.Before(previous) {
+		return false
+	}
+	s.nonces[nonce] = expires
+	// Sweep once the map doubled since the last sweep, which keeps the cost
+	// per request constant on average.
+	if len(s.nonces) >= max(2*s.swept, 1024) {
+		s.sweep(now)
+	}
+	return true
+}
+
+func (s *MemoryReplayStore) sweep(now time.Time) {
+	for nonce, expires := range s.nonces {
+		if !now.Before(expires) {
+			delete(s.nonces, nonce)
+		}
+	}
+	s.swept = len(s.nonces)
+}
+
+// FileReplayStore is a ReplayStore persisted in an append-only file, so that
+// requests captured before a restart cannot be replayed after it. Each nonce
+// is synced to disk before its request is served.
+type FileReplayStore struct {
+	memory  *MemoryReplayStore
+	path    string
+	file    *os.File
+	records int
+	// compactAt is the record count at which the file is next compacted.
+	compactAt int
+}
+
+type replayRecord struct {
+	Nonce   string `json:"n"`
+	Expires int64  `json:"exp"`
+}
+
+// OpenFileReplayStore loads the store at path, creating it if needed, and
+// compacts away expired nonces.
+func OpenFileReplayStore(path string) (*FileReplayStore, error) {
+	store := &FileReplayStore{memory: NewMemoryReplayStore(), path: path}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileReplayStore) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	now := time.Now()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record replayRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final line from a crash mid-write; its request was not served.
+			break
+		}
+		if expires := time.UnixMilli(record.Expires); now.Before(expires) {
+			s.memory.nonces[record.Nonce] = expires
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with only the nonces still live. The new file is
+// written and opened for appending before it replaces the old one, so on any
+// failure the store keeps appending to the old file.
+func (s *FileReplayStore) compact() error {
+	s.memory.sweep(time.Now())
+	temp := s.path + ".tmp"
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := writeReplayRecords(file, s.memory.nonces); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, s.path); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	s.records = len(s.memory.nonces)
+	s.compactAt = max(2*s.records, 1024)
+	return nil
+}
+
+func writeReplayRecords(file *os.File, nonces map[string]time.Time) error {
+	writer := bufio.NewWriter(file)
+	for nonce, expires := range nonces {
+		if err := writeReplayRecord(writer, replayRecord{Nonce: nonce, Expires: expires.UnixMilli()}); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func writeReplayRecord(writer *bufio.Writer, record replayRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	return writer.WriteByte('\n')
+}
+
+func (s *FileReplayStore) Remember(nonce string, expires time.Time) (bool, error) {
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+	if !s.memory.remember(nonce, expires, time.Now()) {
+		return false, nil
+	}
+	data, err := json.Marshal(replayRecord{Nonce: nonce, Expires: expires.UnixMilli()})
+	if err == nil {
+		_, err = s.file.Write(append(data, '\n'))
+	}
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// Unrecorded, the nonce must not be accepted either.
+		delete(s.memory.nonces, nonce)
+		return false, err
+	}
+	s.records++
+	if s.records >= s.compactAt {
+		if err := s.compact(); err != nil {
+			// The nonce is on disk; try again once as many records follow.
+			s.compactAt = 2 * s.records
+			return true, fmt.Errorf("compact: %w", err)
+		}
+	}
+	return true, nil
+}
+
+func (s *FileReplayStore) Close() error {
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+	return s.file.Close()
+}
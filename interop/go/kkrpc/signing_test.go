@@ -0,0 +1,172 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type signedOrder struct {
+	Item  string `json:"item"`
+	Count int    `json:"count"`
+}
+
+func TestRequestSigningServesSignedCalls(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	key := []byte("broker-secret")
+	clientTransport, serverTransport := NewPipeTransportPair()
+	NewServer(serverTransport, map[string]any{
+		"order": MustFunc(func(order signedOrder, priority int) string {
+			return strings.Repeat(order.Item, order.Count)
+		}),
+	}, WithRequestSigning(key, 0))
+
+	client := NewClient(clientTransport, WithTimeout(time.Second), WithRequestSigning(key, 0))
+	defer client.Close()
+	defer serverTransport.Close()
+	if result, err := client.Call("order", signedOrder{Item: "ab", Count: 2}, 1); err != nil || result != "abab" {
+		t.Fatalf("signed call: %v %v", result, err)
+	}
+
+	for name, opts := range map[string][]Option{
+		"unsigned":  nil,
+		"wrong key": {WithRequestSigning([]byte("guess"), 0)},
+	} {
+		clientTransport, serverTransport := NewPipeTransportPair()
+		NewServer(serverTransport, map[string]any{"order": MustFunc(func() string { return "served" })}, WithRequestSigning(key, 0))
+		client := NewClient(clientTransport, append(opts, WithTimeout(time.Second))...)
+		_, err := client.Call("order")
+		_ = client.Close()
+		_ = serverTransport.Close()
+		var rpcErr *RpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+			t.Fatalf("%s: expected PermissionError, got %v", name, err)
+		}
+	}
+}
+
+// signedRequest returns a signed request as a server decodes it.
+func signedRequest(t *testing.T, signer *requestSigner) map[string]any {
+	t.Helper()
+	payload := map[string]any{"t": "q", "id": GenerateUUID(), "op": "call", "p": []string{"order"}, "a": []any{1}}
+	if err := signer.sign(payload); err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(payload)
+	var message map[string]any
+	if err := json.Unmarshal(encoded, &message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestRequestSigningRejectsReplayedAndStaleRequests(t *testing.T) {
+	signer := &requestSigner{key: []byte("k"), window: 50 * time.Millisecond, replay: NewMemoryReplayStore()}
+	message := signedRequest(t, signer)
+	if err := signer.verify(message, nil, nopLogger{}); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if err := signer.verify(message, nil, nopLogger{}); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Fatalf("replay: %v", err)
+	}
+
+	message = signedRequest(t, signer)
+	message["a"] = []any{float64(2)}
+	if err := signer.verify(message, nil, nopLogger{}); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Fatalf("tampered request: %v", err)
+	}
+
+	message = signedRequest(t, signer)
+	time.Sleep(100 * time.Millisecond)
+	if err := signer.verify(message, nil, nopLogger{}); err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Fatalf("stale request: %v", err)
+	}
+}
+
+func TestFileReplayStoreSurvivesRestart(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	path := filepath.Join(t.TempDir(), "nonces.log")
+	signer := &requestSigner{key: []byte("k"), window: time.Minute, replay: NewMemoryReplayStore()}
+	message := signedRequest(t, signer)
+
+	store, err := OpenFileReplayStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.verify(message, store, nopLogger{}); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if fresh, err := store.Remember("expired", time.Now().Add(-time.Second)); !fresh || err != nil {
+		t.Fatalf("remember expired nonce: %v %v", fresh, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileReplayStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := signer.verify(message, store, nopLogger{}); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Fatalf("replay after restart: %v", err)
+	}
+	if _, kept := store.memory.nonces["expired"]; kept {
+		t.Fatal("expired nonce survived compaction")
+	}
+}
+
+func TestFileReplayStoreSurvivesFailedCompaction(t *testing.T) {
+	kkrpctest.AssertNoFDLeaks(t)
+	path := filepath.Join(t.TempDir(), "nonces.log")
+	signer := &requestSigner{key: []byte("k"), window: time.Minute, replay: NewMemoryReplayStore()}
+	store, err := OpenFileReplayStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory in the way of the temporary file fails every compaction.
+	if err := os.Mkdir(path+".tmp", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	store.compactAt = store.records + 1
+
+	first := signedRequest(t, signer)
+	logger := &recordingLogger{lines: make(chan string, 1)}
+	if err := signer.verify(first, store, logger); err != nil {
+		t.Fatalf("request rejected after a failed compaction: %v", err)
+	}
+	if line := <-logger.lines; !strings.Contains(line, "compact") {
+		t.Fatalf("compaction failure logged as %q", line)
+	}
+	second := signedRequest(t, signer)
+	if err := signer.verify(second, store, nopLogger{}); err != nil {
+		t.Fatalf("store unusable after a failed compaction: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(path + ".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	store, err = OpenFileReplayStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, message := range []map[string]any{first, second} {
+		if err := signer.verify(message, store, nopLogger{}); err == nil || !strings.Contains(err.Error(), "replayed") {
+			t.Fatalf("replay after restart: %v", err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package kkrpc
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerReleasesCollectedCallbacks(t *testing.T) {
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport)
+	server := NewServer(serverTransport, map[string]any{
+		"notify": func(args ...any) any {
+			args[0].(Callback)("hi")
+			return true
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	for i := 0; i < 50; i++ {
+		if _, err := client.Call("notify", Callback(func(...any) {})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.callbackCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callbacks still registered", client.callbackCount())
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *Client) callbackCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.callbacks)
+}
+
+func (c *Client) pendingCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+type soakSample struct {
+	at         time.Duration
+	calls      int64
+	goroutines int
+	heap       uint64
+	pending    int
+	callbacks  int
+	p50, p99   time.Duration
+}
+
+// TestSoak drives calls with callbacks for KKRPC_SOAK (a duration such as
+// "2h") and fails on goroutine growth, unbounded pending/callback maps, heap
+// growth or latency drift. Run with -timeout 0:
+//
+//	KKRPC_SOAK=2h go test -run TestSoak -timeout 0 -v ./kkrpc
+func TestSoak(t *testing.T) {
+	value := os.Getenv("KKRPC_SOAK")
+	if value == "" {
+		t.Skip("set KKRPC_SOAK=<duration> to run the soak test")
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		t.Fatalf("KKRPC_SOAK: %v", err)
+	}
+	interval := min(time.Minute, max(duration/20, time.Second))
+
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport, WithTimeout(10*time.Second))
+	server := NewServer(serverTransport, map[string]any{
+		"work": func(ctx context.Context, args ...any) any {
+			progress := args[1].(Callback)
+			progress(args[0])
+			return args[0]
+		},
+	})
+	defer client.Close()
+	defer server.Close()
+
+	baseline := runtime.NumGoroutine()
+	var calls atomic.Int64
+	var latencyMu sync.Mutex
+	var latencies []time.Duration
+	var failures atomic.Int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	var workers sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for n := 0; ctx.Err() == nil; n++ {
+				done := make(chan struct{}, 1)
+				started := time.Now()
+				_, err := client.Call("work", n, func(any) { done <- struct{}{} })
+				elapsed := time.Since(started)
+				if err != nil {
+					failures.Add(1)
+					continue
+				}
+				select {
+				case <-done:
+				case <-time.After(10 * time.Second):
+					failures.Add(1)
+					continue
+				}
+				calls.Add(1)
+				latencyMu.Lock()
+				latencies = append(latencies, elapsed)
+				latencyMu.Unlock()
+			}
+		}()
+	}
+
+	var samples []soakSample
+	started := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-ticker.C:
+		}
+		latencyMu.Lock()
+		window := latencies
+		latencies = nil
+		latencyMu.Unlock()
+		runtime.GC()
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		sample := soakSample{
+			at:         time.Since(started).Round(time.Second),
+			calls:      calls.Load(),
+			goroutines: runtime.NumGoroutine(),
+			heap:       mem.HeapAlloc,
+			pending:    client.pendingCount(),
+			callbacks:  client.callbackCount(),
+		}
+		sample.p50, sample.p99 = percentiles(window)
+		samples = append(samples, sample)
+		t.Logf("%8s calls=%d goroutines=%d heap=%dKiB pending=%d callbacks=%d p50=%s p99=%s",
+			sample.at, sample.calls, sample.goroutines, sample.heap>>10, sample.pending, sample.callbacks, sample.p50, sample.p99)
+	}
+	workers.Wait()
+
+	if failures.Load() > 0 {
+		t.Errorf("%d calls failed", failures.Load())
+	}
+	if len(samples) < 3 {
+		t.Fatalf("soak too short to judge: %d samples", len(samples))
+	}
+	warm, last := samples[1], samples[len(samples)-1]
+	if last.goroutines > baseline+64 {
+		t.Errorf("goroutines grew from %d to %d", baseline, last.goroutines)
+	}
+	if last.pending > 64 || last.callbacks > 10000 {
+		t.Errorf("maps not bounded: pending=%d callbacks=%d", last.pending, last.callbacks)
+	}
+	if last.heap > 2*warm.heap+16<<20 {
+		t.Errorf("heap grew from %dKiB to %dKiB", warm.heap>>10, last.heap>>10)
+	}
+	if warm.p99 > 0 && last.p99 > 5*warm.p99+10*time.Millisecond {
+		t.Errorf("p99 latency drifted from %s to %s", warm.p99, last.p99)
+	}
+}
+
+func percentiles(latencies []time.Duration) (time.Duration, time.Duration) {
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[len(latencies)/2], latencies[len(latencies)*99/100]
+}
@@ -0,0 +1,362 @@
+package kkrpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line so
+// proxies do not time it out.
+const sseKeepAlive = 15 * time.Second
+
+// sseSessionHeader names the session on POSTs when the query parameter is not
+// convenient.
+const sseSessionHeader = "X-Kkrpc-Session"
+
+// SSEServerTransport is one peer connected through SSEHandler. Messages to the
+// peer are pushed as server-sent events on its open GET request; messages from
+// the peer arrive as POSTs naming its session.
+type SSEServerTransport struct {
+	session  string
+	inbound  chan string
+	outbound chan string
+	closed   chan struct{}
+	once     sync.Once
+	onClose  func()
+}
+
+func (t *SSEServerTransport) Session() string {
+	return t.session
+}
+
+func (t *SSEServerTransport) Read() (string, error) {
+	select {
+	case message := <-t.inbound:
+		return message, nil
+	case <-t.closed:
+		return "", ErrTransportClosed
+	}
+}
+
+func (t *SSEServerTransport) Write(message string) error {
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.outbound <- strings.TrimRight(message, "\n"):
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+// Close ends the peer's event stream.
+func (t *SSEServerTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+		t.onClose()
+	})
+	return nil
+}
+
+type sseHandler struct {
+	accept   func(*SSEServerTransport)
+	mu       sync.Mutex
+	sessions map[string]*SSEServerTransport
+}
+
+// SSEHandler serves kkrpc to clients that cannot open WebSockets. A GET opens
+// a text/event-stream whose first event, named "session", carries the session
+// id; every later event's data is one kkrpc message. The client sends its
+// messages by POSTing them, one per line, with ?session=<id> or an
+// X-Kkrpc-Session header. accept runs on its own goroutine for each session.
+func SSEHandler(accept func(*SSEServerTransport)) http.Handler {
+	return &sseHandler{accept: accept, sessions: make(map[string]*SSEServerTransport)}
+}
+
+func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.stream(w, r)
+	case http.MethodPost:
+		h.receive(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *sseHandler) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	transport := &SSEServerTransport{
+		session:  newSessionID(),
+		inbound:  make(chan string, 64),
+		outbound: make(chan string, 64),
+		closed:   make(chan struct{}),
+	}
+	transport.onClose = func() {
+		h.mu.Lock()
+		delete(h.sessions, transport.session)
+		h.mu.Unlock()
+	}
+	h.mu.Lock()
+	h.sessions[transport.session] = transport
+	h.mu.Unlock()
+	defer transport.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	if _, err := fmt.Fprintf(w, "event: session\ndata: %s\n\n", transport.session); err != nil {
+		return
+	}
+	flusher.Flush()
+	go h.accept(transport)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case message := <-transport.outbound:
+			err = writeSSEData(w, message)
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		case <-transport.closed:
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSEData(w io.Writer, message string) error {
+	var builder strings.Builder
+	for _, line := range strings.Split(message, "\n") {
+		builder.WriteString("data: ")
+		builder.WriteString(line)
+		builder.WriteByte('\n')
+	}
+	builder.WriteByte('\n')
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (h *sseHandler) receive(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if session == "" {
+		session = r.Header.Get(sseSessionHeader)
+	}
+	h.mu.Lock()
+	transport := h.sessions[session]
+	h.mu.Unlock()
+	if transport == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHTTPRequest))
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		select {
+		case transport.inbound <- line:
+		case <-transport.closed:
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type SSEClientOptions struct {
+	// Headers are added to the event stream request and every POST.
+	Headers http.Header
+	// Client performs the requests; defaults to http.DefaultClient. Leave its
+	// Timeout unset, it would cut the event stream.
+	Client *http.Client
+}
+
+// SSEClientTransport connects to an SSEHandler, reading messages from the
+// event stream and POSTing the ones it writes.
+type SSEClientTransport struct {
+	url     string
+	client  *http.Client
+	headers http.Header
+	events  chan string
+	done    chan struct{}
+	err     error
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// DialSSE opens the event stream at url and waits for the session event.
+func DialSSE(ctx context.Context, rawURL string, opts SSEClientOptions) (*SSEClientTransport, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	request, err := http.NewRequestWithContext(streamCtx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for key, values := range opts.Headers {
+		request.Header[key] = values
+	}
+	request.Header.Set("Accept", "text/event-stream")
+	response, err := client.Do(request)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("HTTP error %d", response.StatusCode)
+	}
+	reader := bufio.NewReader(response.Body)
+	event, session, err := readSSEEvent(reader)
+	if err != nil || event != "session" || session == "" {
+		response.Body.Close()
+		cancel()
+		return nil, errors.New("kkrpc: event stream did not start with a session")
+	}
+	postURL, err := url.Parse(rawURL)
+	if err != nil {
+		response.Body.Close()
+		cancel()
+		return nil, err
+	}
+	query := postURL.Query()
+	query.Set("session", session)
+	postURL.RawQuery = query.Encode()
+
+	t := &SSEClientTransport{
+		url:     postURL.String(),
+		client:  client,
+		headers: opts.Headers,
+		events:  make(chan string, 64),
+		done:    make(chan struct{}),
+		ctx:     streamCtx,
+		cancel:  cancel,
+	}
+	go t.readEvents(reader, response.Body)
+	return t, nil
+}
+
+// readSSEEvent returns the next event's name and data, skipping comments.
+func readSSEEvent(reader *bufio.Reader) (string, string, error) {
+	var event string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data != nil {
+				return event, strings.Join(data, "\n"), nil
+			}
+			event = ""
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+func (t *SSEClientTransport) readEvents(reader *bufio.Reader, body io.Closer) {
+	defer close(t.done)
+	defer body.Close()
+	for {
+		event, data, err := readSSEEvent(reader)
+		if err != nil {
+			t.err = err
+			return
+		}
+		if event != "" && event != "message" {
+			continue
+		}
+		select {
+		case t.events <- data:
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *SSEClientTransport) Read() (string, error) {
+	select {
+	case message := <-t.events:
+		return message, nil
+	case <-t.done:
+		if t.ctx.Err() != nil || errors.Is(t.err, io.EOF) {
+			return "", ErrTransportClosed
+		}
+		return "", t.err
+	}
+}
+
+func (t *SSEClientTransport) Write(message string) error {
+	if t.ctx.Err() != nil {
+		return ErrTransportClosed
+	}
+	request, err := http.NewRequestWithContext(t.ctx, http.MethodPost, t.url, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	for key, values := range t.headers {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", "text/plain")
+	response, err := t.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("HTTP error %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Close ends the event stream; the server closes the session in turn.
+func (t *SSEClientTransport) Close() error {
+	t.cancel()
+	<-t.done
+	return nil
+}
@@ -0,0 +1,69 @@
+package kkrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestSSETransportRoundTripWithCallbacks(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	handler := SSEHandler(func(transport *SSEServerTransport) {
+		NewChannel(transport, map[string]any{
+			"greet": func(args ...any) any { return "hello " + toString(args[0]) },
+			"ticks": func(args ...any) any {
+				onTick := args[1].(Callback)
+				for i := 0; i < int(args[0].(float64)); i++ {
+					onTick(i)
+				}
+				return true
+			},
+		})
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	transport, err := DialSSE(context.Background(), server.URL+"/events", SSEClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	defer client.Close()
+
+	if result, err := client.Call("greet", "sse"); err != nil || result != "hello sse" {
+		t.Fatalf("greet: %#v %v", result, err)
+	}
+	ticks := make(chan any, 3)
+	if _, err := client.Call("ticks", 3, func(args ...any) { ticks <- args[0] }); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case tick := <-ticks:
+			seen[toString(tick)] = true
+		case <-time.After(time.Second):
+			t.Fatalf("tick %d not pushed", i)
+		}
+	}
+	if !seen["0"] || !seen["1"] || !seen["2"] {
+		t.Fatalf("ticks %v", seen)
+	}
+}
+
+func TestSSEHandlerRejectsUnknownSession(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	handler := SSEHandler(func(*SSEServerTransport) {})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/events?session=nope", strings.NewReader("{}")))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("status %d", recorder.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+type SSHOptions struct {
+	// Host is the remote host, or an alias from ssh_config.
+	Host string
+	User string
+	// Port defaults to ssh's, normally 22 or the ssh_config value.
+	Port int
+	// Command starts the remote process serving kkrpc on its stdin and
+	// stdout, e.g. "bun /srv/app/server.ts". It is passed to the remote
+	// shell as is.
+	Command string
+	// IdentityFile selects the private key, as ssh -i.
+	IdentityFile string
+	// Args are extra ssh flags placed before the host, e.g.
+	// {"-o", "StrictHostKeyChecking=accept-new"}.
+	Args []string
+	// SSHPath is the ssh client binary; it defaults to "ssh" on PATH.
+	SSHPath string
+}
+
+// SSHTransport runs kkrpc over the stdin and stdout of a command executed on
+// a remote host through the OpenSSH client, so authentication, host keys,
+// agents and jump hosts follow the user's ssh configuration.
+type SSHTransport struct {
+	*ProcessTransport
+}
+
+// DialSSH starts the remote command. ssh runs in batch mode, so it fails
+// instead of prompting for a password or host key; use keys or an agent.
+// ctx only bounds the start; Close ends the session.
+func DialSSH(ctx context.Context, opts SSHOptions) (*SSHTransport, error) {
+	if opts.Host == "" || opts.Command == "" {
+		return nil, errors.New("kkrpc: SSHOptions.Host and Command are required")
+	}
+	path := opts.SSHPath
+	if path == "" {
+		path = "ssh"
+	}
+	args := []string{"-T", "-o", "BatchMode=yes"}
+	if opts.User != "" {
+		args = append(args, "-l", opts.User)
+	}
+	if opts.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(opts.Port))
+	}
+	if opts.IdentityFile != "" {
+		args = append(args, "-i", opts.IdentityFile)
+	}
+	args = append(args, opts.Args...)
+	// "--" keeps a host starting with "-" from being read as an option.
+	args = append(args, "--", opts.Host, opts.Command)
+
+	process, err := StartProcess(ctx, ProcessOptions{Path: path, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return &SSHTransport{process}, nil
+}
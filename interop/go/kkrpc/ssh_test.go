@@ -0,0 +1,114 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// TestSSHHelperProcess is the remote command of the fake ssh below: it serves
+// kkrpc on stdin and stdout until stdin closes.
+func TestSSHHelperProcess(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if os.Getenv("KKRPC_SSH_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	done := make(chan struct{})
+	stdin := &eofSignal{Reader: os.Stdin, done: done}
+	NewServer(NewStdioTransport(stdin, os.Stdout), map[string]any{
+		"host": MustFunc(func() string { return "remote" }),
+	})
+	<-done
+	os.Exit(0)
+}
+
+type eofSignal struct {
+	io.Reader
+	done chan struct{}
+}
+
+func (r *eofSignal) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		close(r.done)
+	}
+	return n, err
+}
+
+// fakeSSH writes an ssh stand-in that records its arguments and runs script.
+func fakeSSH(t *testing.T, script string) (path string, argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	path = filepath.Join(dir, "ssh")
+	argsFile = filepath.Join(dir, "args")
+	body := "#!/bin/sh\nprintf '%s\\n' \"$@\" > '" + argsFile + "'\n" + script + "\n"
+	if err := os.WriteFile(path, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path, argsFile
+}
+
+func TestSSHTransportRunsRemoteCommand(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs /bin/sh")
+	}
+	t.Setenv("KKRPC_SSH_HELPER", "1")
+	path, argsFile := fakeSSH(t, "exec '"+os.Args[0]+"' -test.run='^TestSSHHelperProcess$'")
+	transport, err := DialSSH(context.Background(), SSHOptions{
+		Host:    "build-box",
+		User:    "deploy",
+		Port:    2222,
+		Command: "bun /srv/app/server.ts",
+		Args:    []string{"-o", "StrictHostKeyChecking=accept-new"},
+		SSHPath: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, WithTimeout(5*time.Second))
+	result, err := client.Call("host")
+	if err != nil || result != "remote" {
+		t.Fatalf("host = %v, %v", result, err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Err(); err != nil {
+		t.Fatalf("clean shutdown reported %v", err)
+	}
+
+	args, _ := os.ReadFile(argsFile)
+	want := "-T -o BatchMode=yes -l deploy -p 2222 -o StrictHostKeyChecking=accept-new -- build-box bun /srv/app/server.ts"
+	if got := strings.Join(strings.Fields(string(args)), " "); got != want {
+		t.Fatalf("ssh args:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestSSHTransportReportsFailure(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs /bin/sh")
+	}
+	path, _ := fakeSSH(t, "echo 'deploy@build-box: Permission denied (publickey).' >&2\nexit 255")
+	transport, err := DialSSH(context.Background(), SSHOptions{Host: "build-box", Command: "true", SSHPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	_, err = transport.Read()
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") || !strings.Contains(err.Error(), "255") {
+		t.Fatalf("expected the ssh failure, got %v", err)
+	}
+}
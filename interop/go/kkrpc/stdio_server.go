@@ -0,0 +1,159 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrDrainTimeout = errors.New("kkrpc: calls still running after the drain timeout")
+
+type StdioServerOptions struct {
+	// Stdin and Stdout default to the descriptors from ParentFDs when the
+	// parent passed some, and otherwise to the process's.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// DrainTimeout bounds the wait for running calls on shutdown; it defaults
+	// to 10s.
+	DrainTimeout time.Duration
+	// Signals stop the server; they default to SIGINT and SIGTERM.
+	Signals []os.Signal
+	// Options configure the server, after the KKRPC_* environment variables
+	// read by LoadConfig.
+	Options []Option
+	// SharedKey, when set, makes the peer prove it holds the same key before
+	// the API is served; see AuthenticatePeer. ServeStdio returns
+	// ErrPeerAuthentication for a peer that does not.
+	SharedKey []byte
+}
+
+// ServeStdio serves api on stdin and stdout until stdin closes, ctx is done or
+// one of the signals arrives. It then stops reading, lets running calls finish
+// and write their responses, and returns nil, or ErrDrainTimeout if some are
+// still running after DrainTimeout.
+func ServeStdio(ctx context.Context, api map[string]any, opts StdioServerOptions) error {
+	stdin, stdout := opts.Stdin, opts.Stdout
+	if stdin == nil && stdout == nil {
+		in, out, err := ParentFDs()
+		switch {
+		case err == nil:
+			defer in.Close()
+			defer out.Close()
+			stdin, stdout = in, out
+		case !errors.Is(err, ErrNoParentFDs):
+			return err
+		}
+	}
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 10 * time.Second
+	}
+	signals := opts.Signals
+	if signals == nil {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	input := &stopReader{Reader: stdin, stopped: make(chan struct{})}
+	var transport Transport = NewStdioTransport(input, stdout)
+	if len(opts.SharedKey) > 0 {
+		authCtx, cancel := context.WithTimeout(ctx, peerAuthTimeout)
+		authenticated, err := AuthenticatePeer(authCtx, transport, opts.SharedKey, PeerServer)
+		cancel()
+		if err != nil {
+			_ = transport.Close()
+			return err
+		}
+		transport = authenticated
+	}
+	server := NewServer(transport, api, append(config.Options(), opts.Options...)...)
+	defer server.Close()
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+	defer signal.Stop(received)
+	select {
+	case <-input.stopped:
+	case <-ctx.Done():
+	case sig := <-received:
+		server.options.logger.Printf("kkrpc: %v, draining", sig)
+	}
+	// Calls read from here on are dropped: the peer is gone or shutting us
+	// down.
+	input.stop(nil)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+	defer cancel()
+	if err := server.Drain(drainCtx); err != nil {
+		return ErrDrainTimeout
+	}
+	if err := input.err; err != nil {
+		return fmt.Errorf("kkrpc: read stdin: %w", err)
+	}
+	return nil
+}
+
+// RunStdioServer runs ServeStdio with the process's stdio and exits: with
+// status 0 after a clean shutdown, 1 otherwise. Use it as the whole main of a
+// sidecar or plugin.
+func RunStdioServer(api map[string]any, opts ...Option) {
+	err := ServeStdio(context.Background(), api, StdioServerOptions{Options: opts})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// stopReader reports when its reader fails and, once stopped, hides any
+// further input from the server.
+type stopReader struct {
+	io.Reader
+	mu      sync.Mutex
+	stopped chan struct{}
+	done    bool
+	err     error
+}
+
+func (r *stopReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+	if done {
+		return 0, io.EOF
+	}
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		r.stop(err)
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *stopReader) stop(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done, r.err = true, err
+	close(r.stopped)
+}
@@ -0,0 +1,86 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestServeStdioDrainsRunningCalls(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	defer serverOut.Close()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeStdio(context.Background(), map[string]any{
+			"flush": MustFunc(func() string {
+				close(started)
+				<-release
+				return "flushed"
+			}),
+		}, StdioServerOptions{Stdin: serverIn, Stdout: serverOut})
+	}()
+
+	client := NewClient(NewStdioTransport(clientIn, clientOut), WithTimeout(2*time.Second))
+	result := make(chan any, 1)
+	go func() {
+		value, err := client.Call("flush")
+		if err != nil {
+			value = err
+		}
+		result <- value
+	}()
+	<-started
+	_ = clientOut.Close()
+	select {
+	case err := <-served:
+		t.Fatalf("returned with a call running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if value := <-result; value != "flushed" {
+		t.Fatalf("flush = %v", value)
+	}
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeStdioGivesUpAfterDrainTimeout(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	defer clientIn.Close()
+	defer clientOut.Close()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeStdio(ctx, map[string]any{
+			"hang": MustFunc(func() {
+				close(started)
+				<-release
+			}),
+		}, StdioServerOptions{Stdin: serverIn, Stdout: serverOut, DrainTimeout: 20 * time.Millisecond})
+	}()
+	client := NewClient(NewStdioTransport(clientIn, clientOut))
+	go func() { _, _ = client.Call("hang") }()
+	<-started
+	cancel()
+	if err := <-served; !errors.Is(err, ErrDrainTimeout) {
+		t.Fatalf("served: %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StreamRefTag marks a result that the peer reads as an async iterable, the
+// same envelope the TypeScript StreamingRPCChannel uses.
+const StreamRefTag = "__kkrpc_next_stream__"
+
+const (
+	// streamCreditWindow is how many items a consumer lets the producer send
+	// ahead of it; it asks for more once streamCreditReplenish are consumed.
+	streamCreditWindow    = 32
+	streamCreditReplenish = 16
+)
+
+var ErrStreamClosed = errors.New("stream closed")
+
+// Stream is a sequence of values a handler pushes to the caller. Return it from
+// a handler and call Send from another goroutine; Send blocks while the
+// consumer has no credit left, so a slow consumer throttles the producer
+// instead of being flooded. Finish with Close. A Stream can also be passed as a
+// call argument; the handler receives it as a *RemoteStream.
+type Stream struct {
+	mu       sync.Mutex
+	id       string
+	write    func(payload map[string]any) error
+	credit   int
+	changed  chan struct{}
+	pulled   bool
+	finished bool
+	final    map[string]any
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func NewStream() *Stream {
+	return &Stream{changed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Done is closed once the consumer stops reading or the connection closes.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send delivers value once the consumer has credit for it.
+func (s *Stream) Send(ctx context.Context, value any) error {
+	for {
+		s.mu.Lock()
+		if s.finished {
+			s.mu.Unlock()
+			return ErrStreamClosed
+		}
+		if s.credit > 0 {
+			s.credit--
+			write, id := s.write, s.id
+			s.mu.Unlock()
+			return write(map[string]any{"t": "sr", "id": GenerateUUID(), "sid": id, "d": false, "v": value})
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-s.done:
+			return ErrStreamClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close ends the stream, with err surfacing to the consumer if not nil.
+func (s *Stream) Close(err error) error {
+	final := map[string]any{"t": "sr", "d": true}
+	if err != nil {
+		final = map[string]any{"t": "sr", "e": encodeError(err)}
+	}
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return ErrStreamClosed
+	}
+	s.finished = true
+	s.notify()
+	write, id := s.write, s.id
+	if !s.pulled {
+		// The consumer may not know the stream yet; the first pull sends this.
+		s.final = final
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	s.cancel()
+	final["id"], final["sid"] = GenerateUUID(), id
+	return write(final)
+}
+
+// attach binds the stream to the connection it is returned on.
+func (s *Stream) attach(id string, write func(map[string]any) error) {
+	s.mu.Lock()
+	s.id, s.write = id, write
+	s.notify()
+	s.mu.Unlock()
+}
+
+func (s *Stream) grant(credit int) {
+	s.mu.Lock()
+	s.credit += max(credit, 1)
+	s.pulled = true
+	final, write, id := s.final, s.write, s.id
+	s.final = nil
+	s.notify()
+	s.mu.Unlock()
+	if final != nil {
+		s.cancel()
+		final["id"], final["sid"] = GenerateUUID(), id
+		_ = write(final)
+	}
+}
+
+func (s *Stream) cancel() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+func (s *Stream) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// streamSet holds the Streams of one connection, returned by its handlers or
+// passed as call arguments, until their consumers finish them.
+type streamSet struct {
+	mu      sync.Mutex
+	streams map[string]*Stream
+}
+
+func (set *streamSet) attach(transport Transport, o *options, stream *Stream) map[string]any {
+	id := GenerateUUID()
+	set.mu.Lock()
+	if set.streams == nil {
+		set.streams = make(map[string]*Stream)
+	}
+	set.streams[id] = stream
+	set.mu.Unlock()
+	go func() {
+		<-stream.done
+		set.mu.Lock()
+		delete(set.streams, id)
+		set.mu.Unlock()
+	}()
+	stream.attach(id, func(payload map[string]any) error {
+		return writePayload(transport, o, payload)
+	})
+	return map[string]any{StreamRefTag: "async-iterable", "id": id}
+}
+
+func (set *streamSet) handleRequest(transport Transport, o *options, message map[string]any) {
+	requestID, _ := message["id"].(string)
+	streamID, _ := message["sid"].(string)
+	op, _ := message["op"].(string)
+	set.mu.Lock()
+	stream := set.streams[streamID]
+	set.mu.Unlock()
+	reply := map[string]any{"t": "sr", "id": requestID, "sid": streamID}
+	switch {
+	case op == "pull" && stream != nil:
+		credit, _ := toFloat64(message["n"])
+		stream.grant(int(credit))
+		return
+	case op == "return" || (op == "throw" && stream != nil):
+		// Go producers cannot observe a thrown value; both end the stream.
+		if stream != nil {
+			stream.cancel()
+		}
+		reply["d"] = true
+		reply["v"] = message["v"]
+	default:
+		reply["e"] = encodeError(fmt.Errorf("Unknown RPC stream %s", streamID))
+	}
+	if err := writePayload(transport, o, reply); err != nil {
+		o.logger.Printf("kkrpc: stream reply %s: %v", streamID, err)
+	}
+}
+
+func (set *streamSet) cancel() {
+	set.mu.Lock()
+	streams := make([]*Stream, 0, len(set.streams))
+	for _, stream := range set.streams {
+		streams = append(streams, stream)
+	}
+	set.mu.Unlock()
+	for _, stream := range streams {
+		stream.cancel()
+	}
+}
+
+type streamItem struct {
+	value any
+	done  bool
+	err   error
+}
+
+// RemoteStream reads a stream the peer returned or passed as an argument,
+// whether a Go Stream or a TypeScript async iterable. Items are fetched ahead in a bounded window.
+type RemoteStream struct {
+	transport Transport
+	options   *options
+	decode    func(any) any
+	id        string
+	mu        sync.Mutex
+	buffer    []streamItem
+	ready     chan struct{}
+	started   bool
+	finished  bool
+	consumed  int
+}
+
+// Next returns the next value, or io.EOF once the stream has ended.
+func (r *RemoteStream) Next(ctx context.Context) (any, error) {
+	for {
+		r.mu.Lock()
+		if len(r.buffer) > 0 {
+			item := r.buffer[0]
+			r.buffer = r.buffer[1:]
+			if item.err != nil || item.done {
+				r.buffer = nil
+				r.mu.Unlock()
+				if item.err != nil {
+					return nil, item.err
+				}
+				return nil, io.EOF
+			}
+			r.consumed++
+			replenish := 0
+			if r.consumed >= streamCreditReplenish {
+				replenish, r.consumed = r.consumed, 0
+			}
+			r.mu.Unlock()
+			if replenish > 0 {
+				r.pull(replenish)
+			}
+			return item.value, nil
+		}
+		if r.finished {
+			r.mu.Unlock()
+			return nil, io.EOF
+		}
+		start := !r.started
+		r.started = true
+		ready := r.ready
+		r.mu.Unlock()
+		if start {
+			r.pull(streamCreditWindow)
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close tells the producer to stop. Items already buffered are dropped.
+func (r *RemoteStream) Close() error {
+	r.mu.Lock()
+	if r.finished {
+		r.mu.Unlock()
+		return nil
+	}
+	r.finished = true
+	r.buffer = nil
+	r.signal()
+	r.mu.Unlock()
+	r.options.remoteStreams.forget(r.id)
+	return writePayload(r.transport, r.options, map[string]any{
+		"t": "sq", "id": GenerateUUID(), "sid": r.id, "op": "return",
+	})
+}
+
+func (r *RemoteStream) pull(credit int) {
+	payload := map[string]any{"t": "sq", "id": GenerateUUID(), "sid": r.id, "op": "pull", "n": credit}
+	if err := writePayload(r.transport, r.options, payload); err != nil {
+		r.push(streamItem{err: err})
+	}
+}
+
+func (r *RemoteStream) push(item streamItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return
+	}
+	r.buffer = append(r.buffer, item)
+	if item.done || item.err != nil {
+		r.finished = true
+		r.options.remoteStreams.forget(r.id)
+	}
+	r.signal()
+}
+
+func (r *RemoteStream) signal() {
+	close(r.ready)
+	r.ready = make(chan struct{})
+}
+
+// remoteStreamSet holds the RemoteStreams of one connection, routing the
+// items their producers send.
+type remoteStreamSet struct {
+	mu      sync.Mutex
+	streams map[string]*RemoteStream
+}
+
+// open starts reading stream id; decode turns each item into its Go value.
+func (set *remoteStreamSet) open(transport Transport, o *options, id string, decode func(any) any) *RemoteStream {
+	stream := &RemoteStream{transport: transport, options: o, decode: decode, id: id, ready: make(chan struct{})}
+	if err := o.resources.acquireHandle(); err != nil {
+		stream.buffer, stream.finished = []streamItem{{err: err}}, true
+		return stream
+	}
+	set.mu.Lock()
+	if set.streams == nil {
+		set.streams = make(map[string]*RemoteStream)
+	}
+	set.streams[id] = stream
+	set.mu.Unlock()
+	return stream
+}
+
+func (set *remoteStreamSet) forget(id string) {
+	set.mu.Lock()
+	stream, ok := set.streams[id]
+	delete(set.streams, id)
+	set.mu.Unlock()
+	if ok {
+		stream.options.resources.releaseHandle()
+	}
+}
+
+func (set *remoteStreamSet) handleResponse(message map[string]any) {
+	streamID, _ := message["sid"].(string)
+	set.mu.Lock()
+	stream := set.streams[streamID]
+	set.mu.Unlock()
+	if stream == nil {
+		return
+	}
+	if errValue, exists := message["e"]; exists {
+		stream.push(streamItem{err: decodeError(errValue)})
+		return
+	}
+	done, _ := message["d"].(bool)
+	stream.push(streamItem{value: stream.decode(message["v"]), done: done})
+}
@@ -0,0 +1,167 @@
+package kkrpc
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamChunkSize is the largest chunk NewReaderStream sends.
+const streamChunkSize = 64 << 10
+
+// NewReaderStream returns a Stream sending r's contents as []byte chunks of up
+// to 64 KiB, for handlers serving files or other large payloads. It stops when
+// the consumer goes away; r is closed at the end if it is an io.Closer. A read
+// error other than io.EOF ends the stream with that error.
+func NewReaderStream(r io.Reader) *Stream {
+	stream := NewStream()
+	go func() {
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-stream.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		buf := make([]byte, streamChunkSize)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if sendErr := stream.Send(ctx, append([]byte(nil), buf[:n]...)); sendErr != nil {
+					return
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				_ = stream.Close(nil)
+				return
+			}
+			if err != nil {
+				_ = stream.Close(err)
+				return
+			}
+		}
+	}()
+	return stream
+}
+
+// CallToWriter calls a method returning a stream of byte chunks, such as a
+// NewReaderStream, and writes the chunks to w as they arrive instead of
+// buffering the whole payload. It returns the number of bytes written.
+func (c *Client) CallToWriter(w io.Writer, method string, args ...any) (int64, error) {
+	return c.CallToWriterContext(context.Background(), w, method, args...)
+}
+
+// CallToWriterContext is CallToWriter bounded by ctx, which covers the whole
+// transfer. A w with a Flush method, like http.ResponseWriter, is flushed
+// after every chunk.
+func (c *Client) CallToWriterContext(ctx context.Context, w io.Writer, method string, args ...any) (int64, error) {
+	result, err := c.CallContext(ctx, method, args...)
+	if err != nil {
+		return 0, err
+	}
+	stream, ok := result.(*RemoteStream)
+	if !ok {
+		return 0, fmt.Errorf("kkrpc: %s returned %T, not a stream", method, result)
+	}
+	defer stream.Close()
+	flusher, _ := w.(interface{ Flush() })
+	var written int64
+	for {
+		value, err := stream.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		chunk, err := decodeBytes(value)
+		if err != nil {
+			return written, fmt.Errorf("kkrpc: %s: %w", method, err)
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// CallFromReader calls method with args followed by r's contents as a stream
+// of byte chunks, read only as fast as the handler consumes them, so a large
+// upload never sits in memory. The handler reads that last argument with
+// NewStreamReader.
+func (c *Client) CallFromReader(method string, r io.Reader, args ...any) (any, error) {
+	return c.CallFromReaderContext(context.Background(), method, r, args...)
+}
+
+// CallFromReaderContext is CallFromReader bounded by ctx. Unless ctx has a
+// deadline, the Client's timeout applies to the whole upload.
+func (c *Client) CallFromReaderContext(ctx context.Context, method string, r io.Reader, args ...any) (any, error) {
+	stream := NewReaderStream(r)
+	defer stream.cancel()
+	return c.CallContext(ctx, method, append(args[:len(args):len(args)], stream)...)
+}
+
+// NewStreamReader reads a stream of byte chunks, such as the upload of
+// CallFromReader, as an io.Reader. ctx bounds every read; Close stops the
+// producer.
+func NewStreamReader(ctx context.Context, stream *RemoteStream) io.ReadCloser {
+	return &streamReader{ctx: ctx, stream: stream}
+}
+
+type streamReader struct {
+	ctx    context.Context
+	stream *RemoteStream
+	chunk  []byte
+	err    error
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		value, err := r.stream.Next(r.ctx)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		if r.chunk, err = decodeBytes(value); err != nil {
+			r.err = err
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	return r.stream.Close()
+}
+
+// decodeBytes returns the bytes of a value: []byte as MessagePack
+// decodes it, or the base64 string JSON encodes []byte as.
+func decodeBytes(value any) ([]byte, error) {
+	switch chunk := value.(type) {
+	case []byte:
+		return chunk, nil
+	case string:
+		data, err := base64.StdEncoding.DecodeString(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("not base64: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("%T is not bytes", value)
+	}
+}
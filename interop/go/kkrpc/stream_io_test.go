@@ -0,0 +1,120 @@
+package kkrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestCallToWriterStreamsChunks(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 40000)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	server := NewServer(serverTransport, map[string]any{
+		"download": func(args ...any) any {
+			return NewReaderStream(bytes.NewReader(payload))
+		},
+		"broken": func(args ...any) any {
+			return NewReaderStream(&failingReader{data: payload[:100], err: errors.New("disk read failed")})
+		},
+		"size": func(args ...any) any { return len(payload) },
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	var out bytes.Buffer
+	n, err := client.CallToWriter(&out, "download")
+	if err != nil || n != int64(len(payload)) || !bytes.Equal(out.Bytes(), payload) {
+		t.Fatalf("download: %d bytes, %v", n, err)
+	}
+
+	out.Reset()
+	n, err = client.CallToWriter(&out, "broken")
+	if err == nil || !strings.Contains(err.Error(), "disk read failed") || n != 100 {
+		t.Fatalf("broken: %d bytes, %v", n, err)
+	}
+
+	if _, err := client.CallToWriter(io.Discard, "size"); err == nil || !strings.Contains(err.Error(), "not a stream") {
+		t.Fatalf("size: %v", err)
+	}
+}
+
+func TestCallFromReaderUploadsChunks(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	payload := bytes.Repeat([]byte("fedcba9876543210"), 40000)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	server := NewServer(serverTransport, map[string]any{
+		"upload": func(args ...any) any {
+			data, err := io.ReadAll(NewStreamReader(context.Background(), args[1].(*RemoteStream)))
+			if err != nil {
+				return err
+			}
+			return map[string]any{"name": args[0], "equal": bytes.Equal(data, payload)}
+		},
+		"peek": func(args ...any) any {
+			reader := NewStreamReader(context.Background(), args[0].(*RemoteStream))
+			defer reader.Close()
+			head := make([]byte, 4)
+			_, err := io.ReadFull(reader, head)
+			if err != nil {
+				return err
+			}
+			return string(head)
+		},
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	result, err := client.CallFromReader("upload", bytes.NewReader(payload), "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(map[string]any); got["name"] != "video.mp4" || got["equal"] != true {
+		t.Fatalf("upload: %v", got)
+	}
+
+	source := &closeRecorder{Reader: bytes.NewReader(payload), closed: make(chan struct{})}
+	head, err := client.CallFromReader("peek", source)
+	if err != nil || head != "fedc" {
+		t.Fatalf("peek = %v, %v", head, err)
+	}
+	select {
+	case <-source.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("abandoned upload kept reading")
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (r *closeRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
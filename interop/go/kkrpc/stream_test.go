@@ -0,0 +1,127 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestStreamDeliversWithBackpressure(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	var sent atomic.Int64
+	producerDone := make(chan error, 1)
+	server := NewServer(serverTransport, map[string]any{
+		"events": func(args ...any) any {
+			stream := NewStream()
+			count := int(args[0].(float64))
+			go func() {
+				for i := 0; i < count; i++ {
+					if err := stream.Send(context.Background(), i); err != nil {
+						producerDone <- err
+						return
+					}
+					sent.Add(1)
+				}
+				producerDone <- stream.Close(nil)
+			}()
+			return stream
+		},
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	result, err := client.Call("events", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, ok := result.(*RemoteStream)
+	if !ok {
+		t.Fatalf("result %#v", result)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 100; i++ {
+		value, err := events.Next(ctx)
+		if err != nil || !valuesEqual(i, value) {
+			t.Fatalf("item %d: %#v %v", i, value, err)
+		}
+		if i == 0 {
+			time.Sleep(20 * time.Millisecond)
+			if ahead := sent.Load(); ahead > streamCreditWindow {
+				t.Fatalf("producer ran %d items ahead", ahead)
+			}
+		}
+	}
+	if _, err := events.Next(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("end: %v", err)
+	}
+	if err := <-producerDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamConsumerCloseStopsProducer(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	stopped := make(chan error, 1)
+	server := NewServer(serverTransport, map[string]any{
+		"ticks": func(args ...any) any {
+			stream := NewStream()
+			go func() {
+				for i := 0; ; i++ {
+					if err := stream.Send(context.Background(), i); err != nil {
+						stopped <- err
+						return
+					}
+				}
+			}()
+			return stream
+		},
+		"failing": func(args ...any) any {
+			stream := NewStream()
+			stream.Close(errors.New("source unavailable"))
+			return stream
+		},
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	result, err := client.Call("ticks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticks := result.(*RemoteStream)
+	if _, err := ticks.Next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ticks.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, ErrStreamClosed) {
+			t.Fatalf("producer stopped with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("producer not stopped")
+	}
+
+	result, err = client.Call("failing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rpcErr *RpcError
+	if _, err := result.(*RemoteStream).Next(context.Background()); !errors.As(err, &rpcErr) || rpcErr.Message != "source unavailable" {
+		t.Fatalf("failing: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package kkrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// IdentityFromTLS returns the identity of a peer that presented a client
+// certificate the server verified (tls.VerifyClientCertIfGiven or
+// RequireAndVerifyClientCert); unverified certificates prove nothing. The
+// subject is the certificate's common name, or its first URI SAN (a SPIFFE
+// ID, say) without one. Claims hold "cn", "o", "ou", "dns", "uris", "emails",
+// "serial", "issuer", "fingerprint" (hex SHA-256 of the certificate) and
+// "exp" (its expiry in Unix seconds).
+func IdentityFromTLS(state tls.ConnectionState) (Identity, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+	cert := state.VerifiedChains[0][0]
+	uris := make([]any, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	claims := map[string]any{
+		"cn":          cert.Subject.CommonName,
+		"o":           stringsToAny(cert.Subject.Organization),
+		"ou":          stringsToAny(cert.Subject.OrganizationalUnit),
+		"dns":         stringsToAny(cert.DNSNames),
+		"uris":        uris,
+		"emails":      stringsToAny(cert.EmailAddresses),
+		"serial":      cert.SerialNumber.String(),
+		"issuer":      cert.Issuer.String(),
+		"fingerprint": hex.EncodeToString(fingerprint[:]),
+		"exp":         float64(cert.NotAfter.Unix()),
+	}
+	subject := cert.Subject.CommonName
+	if subject == "" && len(uris) > 0 {
+		subject = uris[0].(string)
+	}
+	return Identity{Subject: subject, Claims: claims}, true
+}
+
+// stringsToAny gives claims the shape they have when decoded from JSON.
+func stringsToAny(values []string) []any {
+	converted := make([]any, len(values))
+	for i, value := range values {
+		converted[i] = value
+	}
+	return converted
+}
+
+// connIdentity completes the TLS handshake of conn, if it is a *tls.Conn,
+// and returns the verified client certificate's identity.
+func connIdentity(conn net.Conn) (*Identity, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	if identity, ok := IdentityFromTLS(tlsConn.ConnectionState()); ok {
+		return &identity, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,157 @@
+package kkrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type testPKI struct {
+	roots  *x509.CertPool
+	server tls.Certificate
+	client tls.Certificate
+}
+
+// newTestPKI issues a server certificate for 127.0.0.1 and a client
+// certificate for "plugin-a" from one CA.
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	issue := func(serial int64, template *x509.Certificate) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template.SerialNumber = big.NewInt(serial)
+		template.NotBefore, template.NotAfter = ca.NotBefore, ca.NotAfter
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	spiffe, _ := url.Parse("spiffe://example.org/plugin-a")
+	pki := testPKI{roots: x509.NewCertPool()}
+	pki.roots.AddCert(ca)
+	pki.server = issue(2, &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	pki.client = issue(3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "plugin-a", Organization: []string{"Acme"}},
+		URIs:        []*url.URL{spiffe},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return pki
+}
+
+func (p testPKI) serverConfig() *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{p.server}, ClientCAs: p.roots, ClientAuth: tls.VerifyClientCertIfGiven}
+}
+
+func (p testPKI) clientConfig(withCert bool) *tls.Config {
+	config := &tls.Config{RootCAs: p.roots}
+	if withCert {
+		config.Certificates = []tls.Certificate{p.client}
+	}
+	return config
+}
+
+var identityAPI = map[string]any{
+	"whoami": func(ctx context.Context, args ...any) any {
+		identity, _ := IdentityFromContext(ctx)
+		return identity.Subject + " " + identity.Claims["uris"].([]any)[0].(string)
+	},
+}
+
+func TestServeListenerTakesIdentityFromClientCertificate(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	pki := newTestPKI(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := tls.NewListener(inner, pki.serverConfig())
+	defer listener.Close()
+	go func() {
+		_ = ServeListener(listener, identityAPI, WithAuthorizer(ACL{{Subject: "plugin-a", Methods: []string{"*"}}}))
+	}()
+
+	conn, err := tls.Dial("tcp", inner.Addr().String(), pki.clientConfig(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(NewConnTransport(conn), WithTimeout(2*time.Second))
+	defer client.Close()
+	if result, err := client.Call("whoami"); err != nil || result != "plugin-a spiffe://example.org/plugin-a" {
+		t.Fatalf("%#v %v", result, err)
+	}
+
+	anonymous, err := tls.Dial("tcp", inner.Addr().String(), pki.clientConfig(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger := NewClient(NewConnTransport(anonymous), WithTimeout(2*time.Second))
+	defer stranger.Close()
+	if _, err := stranger.Call("whoami"); err == nil {
+		t.Fatal("expected a peer without a certificate to be refused")
+	}
+}
+
+func TestWebSocketListenerTakesIdentityFromClientCertificate(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	pki := newTestPKI(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := NewWebSocketListener(tls.NewListener(inner, pki.serverConfig()))
+	defer listener.Close()
+	go func() { _ = listener.ServeAPI(identityAPI) }()
+
+	transport, err := NewWebSocketTransport("wss://"+inner.Addr().String(), WithWebSocketTLS(pki.clientConfig(true)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	defer client.Close()
+	if result, err := client.Call("whoami"); err != nil || result != "plugin-a spiffe://example.org/plugin-a" {
+		t.Fatalf("%#v %v", result, err)
+	}
+}
+
+func TestIdentityFromTLSNeedsVerifiedChain(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, _ := x509.ParseCertificate(pki.client.Certificate[0])
+	if _, ok := IdentityFromTLS(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); ok {
+		t.Fatal("an unverified certificate must not yield an identity")
+	}
+	identity, ok := IdentityFromTLS(tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	if !ok || identity.Subject != "plugin-a" || identity.Claims["o"].([]any)[0] != "Acme" || len(identity.Claims["fingerprint"].(string)) != 64 {
+		t.Fatalf("%#v", identity)
+	}
+}
@@ -1,6 +1,12 @@
 package kkrpc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+)
 
 var ErrTransportClosed = errors.New("transport closed")
 
@@ -9,3 +15,172 @@ type Transport interface {
 	Write(message string) error
 	Close() error
 }
+
+func startReading(transport Transport, o *options, handle func(map[string]any)) {
+	if o.resumeID != "" {
+		// The session must be known before any callback is invoked.
+		_ = writePayload(transport, o, resumePayload(o.resumeID))
+		if reconnecting, ok := transport.(*ReconnectingTransport); ok {
+			reconnecting.greet(resumePayload(o.resumeID))
+		}
+	}
+	if len(o.codecs.names()) > 0 {
+		_ = writePayload(transport, o, o.codecs.handshake(false))
+	}
+	if o.creditWindow > 0 {
+		_ = writePayload(transport, o, creditPayload(o.creditWindow))
+	}
+	go readMessages(transport, o, startHeartbeat(transport, o), handle)
+}
+
+func writePayload(transport Transport, o *options, payload map[string]any) error {
+	message, err := o.encodePayload(payload)
+	if err != nil {
+		return err
+	}
+	return transport.Write(message)
+}
+
+func (o *options) encodePayload(payload map[string]any) (string, error) {
+	if err := o.runOutboundHooks(payload); err != nil {
+		return "", err
+	}
+	// Requests are signed as hooks left them.
+	if payload["t"] == "q" {
+		if err := o.signer.sign(payload); err != nil {
+			return "", err
+		}
+	}
+	started := time.Now()
+	message, err := o.codecs.encode(payload)
+	if err != nil {
+		return "", err
+	}
+	o.serialization.observe(payload, true, time.Since(started), len(message))
+	o.sizes.observe(payload, len(message), true)
+	return message, nil
+}
+
+func (o *options) decodeMessage(raw string) (map[string]any, error) {
+	if err := o.limits.check(raw); err != nil {
+		return nil, err
+	}
+	if o.pooledDecode {
+		return decodeMessagePooled(raw)
+	}
+	return decodeJSONMessage(raw)
+}
+
+func readMessages(transport Transport, o *options, beat *heartbeat, handle func(map[string]any)) {
+	defer beat.stop()
+	var restarts readLoopRestarts
+	for {
+		err := readUntilFailure(transport, o, beat, handle)
+		if loopErr, ok := err.(*ReadLoopError); ok {
+			if superviseReadLoop(transport, o, loopErr, &restarts) {
+				continue
+			}
+			err = fmt.Errorf("%w: %w", ErrTransportClosed, loopErr)
+		}
+		// No response can arrive any more.
+		if o.failPending != nil {
+			if o.peerClosed.Load() {
+				err = ErrPeerClosed
+			} else if !errors.Is(err, ErrTransportClosed) {
+				err = fmt.Errorf("%w: %v", ErrTransportClosed, err)
+			}
+			o.failPending(err)
+		}
+		if o.disconnected != nil {
+			o.disconnected()
+		}
+		return
+	}
+}
+
+// readUntilFailure handles messages until reading fails, returning the error,
+// or until handling one panics, returning a *ReadLoopError.
+func readUntilFailure(transport Transport, o *options, beat *heartbeat, handle func(map[string]any)) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &ReadLoopError{Panic: recovered, Stack: debug.Stack()}
+		}
+	}()
+	for {
+		line, err := transport.Read()
+		beat.seen()
+		if err != nil {
+			return err
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		started := time.Now()
+		message, err := o.decodeMessage(trimmed)
+		if err != nil {
+			rejectMessage(transport, o, err, trimmed)
+			continue
+		}
+		if message["t"] == "hs" {
+			if reply := o.codecs.acceptHandshake(message); reply != nil {
+				_ = writePayload(transport, o, reply)
+			}
+			continue
+		}
+		if message["t"] == "resume" {
+			o.resume(transport, message)
+			continue
+		}
+		if message["t"] == "credit" {
+			o.flow.setWindow(message)
+			continue
+		}
+		if message["t"] == "ping" {
+			_ = writePayload(transport, o, pongPayload())
+			continue
+		}
+		if message["t"] == "pong" {
+			continue
+		}
+		if message["t"] == "bye" {
+			o.peerClosed.Store(true)
+			continue
+		}
+		if message["t"] == "protocol_error" {
+			protocolErr := protocolErrorFromMessage(message)
+			o.logger.Printf("kkrpc: peer rejected message %v: %v", message["id"], protocolErr)
+			if o.onProtoErr != nil {
+				o.onProtoErr(protocolErr)
+			}
+		}
+		message, err = o.codecs.decode(message, o.limits)
+		if err != nil {
+			rejectMessage(transport, o, err, trimmed)
+			continue
+		}
+		if o.handleExtension(transport, message) {
+			continue
+		}
+		o.serialization.observe(message, false, time.Since(started), len(trimmed))
+		o.sizes.observe(message, len(trimmed), false)
+		o.resources.received(message, len(trimmed))
+		handle(message)
+	}
+}
+
+// rejectMessage drops a frame that could not be decoded. The peer is told only
+// if the frame broke a limit or looks like a kkrpc message; other lines, such
+// as a child's log output on stdout, are ignored as the other implementations
+// ignore malformed frames.
+func rejectMessage(transport Transport, o *options, err error, raw string) {
+	o.logger.Printf("kkrpc: drop message: %v", err)
+	var protocolErr *ProtocolError
+	isLimit := errors.As(err, &protocolErr) && limitErrorCodes[protocolErr.Code]
+	if !isLimit && frameHeader(raw).t == "" {
+		return
+	}
+	if err := writePayload(transport, o, protocolErrorPayload(err, raw)); err != nil {
+		o.logger.Printf("kkrpc: send protocol error: %v", err)
+	}
+}
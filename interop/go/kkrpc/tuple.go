@@ -0,0 +1,54 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// DecodeTuple unpacks a result into the pointers in targets. A Go func with
+// several non-error results is answered with a JSON array holding them in
+// order, which is a TS tuple; a single target receives the value as is.
+func DecodeTuple(value any, targets ...any) error {
+	if len(targets) == 1 {
+		return decodeInto(value, targets[0])
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return fmt.Errorf("kkrpc: expected a %d-tuple, got %T", len(targets), value)
+	}
+	if len(items) != len(targets) {
+		return fmt.Errorf("kkrpc: expected a %d-tuple, got %d values", len(targets), len(items))
+	}
+	for i, target := range targets {
+		if err := decodeInto(items[i], target); err != nil {
+			return fmt.Errorf("kkrpc: tuple element %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func decodeInto(value any, target any) error {
+	pointer := reflect.ValueOf(target)
+	if pointer.Kind() != reflect.Pointer || pointer.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+	decoded, err := decodeValueAs(value, pointer.Elem().Type())
+	if err != nil {
+		return err
+	}
+	pointer.Elem().Set(decoded)
+	return nil
+}
+
+// CallTuple calls method and decodes its result into results, one pointer per
+// return value of the remote function.
+func (c *Client) CallTuple(ctx context.Context, method string, args []any, results ...any) error {
+	value, err := c.CallContext(ctx, method, args...)
+	if err != nil {
+		return err
+	}
+	return DecodeTuple(value, results...)
+}
@@ -0,0 +1,82 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+type tupleUser struct {
+	Name  string    `json:"name"`
+	Color testColor `json:"color,omitempty"`
+	Tags  []string  `json:"tags"`
+	skip  bool
+}
+
+func TestCallTupleUnpacksMultipleResults(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	clientTransport, serverTransport := newConnectedTestTransports()
+	client := NewClient(clientTransport)
+	server := NewServer(serverTransport, map[string]any{})
+	defer client.Close()
+	defer server.Close()
+	_ = server.RegisterFunc("math.divmod", func(a, b int) (int, int, error) { return a / b, a % b, nil })
+	_ = server.RegisterFunc("users.get", func(id string) (tupleUser, bool) { return tupleUser{Name: id}, true })
+
+	var quotient, remainder int
+	if err := client.CallTuple(context.Background(), "math.divmod", []any{7, 2}, &quotient, &remainder); err != nil {
+		t.Fatal(err)
+	}
+	if quotient != 3 || remainder != 1 {
+		t.Fatalf("got %d, %d", quotient, remainder)
+	}
+
+	var user tupleUser
+	var found bool
+	if err := client.CallTuple(context.Background(), "users.get", []any{"ada"}, &user, &found); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "ada" || !found {
+		t.Fatalf("got %+v %v", user, found)
+	}
+
+	var single int
+	if err := client.CallTuple(context.Background(), "math.divmod", []any{7, 2}, &single); err == nil {
+		t.Fatal("expected arity mismatch when unpacking a tuple into one scalar")
+	}
+	if err := DecodeTuple([]any{1.0}, &quotient, &remainder); err == nil || !strings.Contains(err.Error(), "2-tuple, got 1") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	server := NewServer(newServerTestTransport(), map[string]any{})
+	defer server.Close()
+	_ = server.RegisterFunc("math.divmod", func(a, b int) (int, int) { return a / b, a % b })
+	_ = server.RegisterFunc("users.get", func(ctx context.Context, id string) (*tupleUser, error) { return nil, nil })
+	_ = server.RegisterFunc("log", func(lines ...string) {})
+
+	var out strings.Builder
+	if err := server.GenerateTypeScript(&out, "API"); err != nil {
+		t.Fatal(err)
+	}
+	want := "export interface API {\n" +
+		"\tlog(...rest: string[]): Promise<void>\n" +
+		"\tmath: {\n" +
+		"\t\tdivmod(arg0: number, arg1: number): Promise<[number, number]>\n" +
+		"\t}\n" +
+		"\tusers: {\n" +
+		"\t\tget(arg0: string): Promise<{ name: string; color?: testColor; tags: string[] } | null>\n" +
+		"\t}\n" +
+		"}\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
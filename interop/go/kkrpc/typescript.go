@@ -0,0 +1,155 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// TypeScriptSignature renders f as a TS method signature. Parameters are named
+// arg0, arg1, ...; several results become a tuple and no result becomes void.
+func (f *Func) TypeScriptSignature(name string) string {
+	params := make([]string, 0, len(f.params)+1)
+	for i, paramType := range f.params {
+		params = append(params, fmt.Sprintf("arg%d: %s", i, typeScriptType(paramType)))
+	}
+	if f.variadic != nil {
+		params = append(params, fmt.Sprintf("...rest: %s[]", typeScriptType(f.variadic)))
+	}
+	return fmt.Sprintf("%s(%s): Promise<%s>", name, strings.Join(params, ", "), f.typeScriptResult())
+}
+
+func (f *Func) typeScriptResult() string {
+	fnType := f.fn.Type()
+	results := make([]string, 0, f.results)
+	for i := 0; i < f.results; i++ {
+		results = append(results, typeScriptType(fnType.Out(i)))
+	}
+	switch len(results) {
+	case 0:
+		return "void"
+	case 1:
+		return results[0]
+	default:
+		return "[" + strings.Join(results, ", ") + "]"
+	}
+}
+
+func typeScriptType(t reflect.Type) string {
+	enums.RLock()
+	info := enums.types[t]
+	enums.RUnlock()
+	if info != nil {
+		return info.name
+	}
+	switch t {
+	case timeType:
+		return "string"
+	case rawMessageType, anyType:
+		return "unknown"
+	case callbackType:
+		return "(...args: unknown[]) => void"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Pointer:
+		return typeScriptType(t.Elem()) + " | null"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return arrayType(typeScriptType(t.Elem()))
+	case reflect.Map:
+		return fmt.Sprintf("Record<string, %s>", typeScriptType(t.Elem()))
+	case reflect.Struct:
+		return structType(t)
+	case reflect.Func:
+		params := make([]string, 0, t.NumIn())
+		for i := 0; i < t.NumIn(); i++ {
+			params = append(params, fmt.Sprintf("arg%d: %s", i, typeScriptType(t.In(i))))
+		}
+		return "(" + strings.Join(params, ", ") + ") => void"
+	}
+	return "unknown"
+}
+
+func arrayType(elem string) string {
+	if strings.ContainsAny(elem, " |") {
+		return "(" + elem + ")[]"
+	}
+	return elem + "[]"
+}
+
+func structType(t reflect.Type) string {
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		optional := ""
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName, opts, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+			if strings.Contains(opts, "omitempty") {
+				optional = "?"
+			}
+		}
+		fields = append(fields, fmt.Sprintf("%s%s: %s", name, optional, typeScriptType(field.Type)))
+	}
+	return "{ " + strings.Join(fields, "; ") + " }"
+}
+
+// GenerateTypeScript writes a TS interface named name describing every
+// function registered with RegisterFunc, nested by namespace.
+func (s *Server) GenerateTypeScript(w io.Writer, name string) error {
+	s.mu.Lock()
+	body := typeScriptNamespace(s.api, "\t")
+	s.mu.Unlock()
+	_, err := fmt.Fprintf(w, "export interface %s {\n%s}\n", name, body)
+	return err
+}
+
+func typeScriptNamespace(node map[string]any, indent string) string {
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	for _, key := range keys {
+		switch typed := node[key].(type) {
+		case map[string]any:
+			fmt.Fprintf(&builder, "%s%s: {\n%s%s}\n", indent, key, typeScriptNamespace(typed, indent+"\t"), indent)
+		case *Func:
+			fmt.Fprintf(&builder, "%s%s\n", indent, typed.TypeScriptSignature(key))
+		case func(...any) any:
+			fmt.Fprintf(&builder, "%s%s(...args: unknown[]): Promise<unknown>\n", indent, key)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,213 @@
+package kkrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MaxUDPDatagram is the largest payload a single IPv4 UDP datagram can carry.
+const MaxUDPDatagram = 65507
+
+var errNoUDPPeer = errors.New("kkrpc: udp peer unknown until it sends a datagram")
+
+type UDPOptions struct {
+	// Sequenced numbers request messages and retransmits them until the peer
+	// acknowledges them. Responses and callbacks stay fire-and-forget. Both
+	// peers must agree on this setting.
+	Sequenced bool
+	// RetryInterval and MaxAttempts configure retransmission of sequenced
+	// requests; see AckOptions. Calls whose request is never acknowledged
+	// still fail through the client timeout.
+	RetryInterval time.Duration
+	MaxAttempts   int
+	// MaxDatagram caps the size of a message. Defaults to MaxUDPDatagram;
+	// lower it to stay below the path MTU.
+	MaxDatagram int
+	// Secure wraps the socket in a datagram security layer such as DTLS, so
+	// every message is encrypted. It receives the connected socket and
+	// whether this side is the listener, and returns the secured conn once
+	// the handshake is done; each Read and Write on it must carry exactly
+	// one message. A listener secures the first peer to send a datagram.
+	// Both peers must set it.
+	Secure func(conn net.Conn, listener bool) (net.Conn, error)
+}
+
+// UDPTransport sends every message as one datagram. A dialled transport talks
+// to a fixed address; a listening transport replies to whichever peer sent the
+// most recent datagram.
+type UDPTransport struct {
+	raw      Transport
+	local    net.Addr
+	sequence *AckTransport
+}
+
+type udpConn struct {
+	conn     *net.UDPConn
+	dialled  bool
+	max      int
+	buffer   []byte
+	peerMu   sync.Mutex
+	peer     *net.UDPAddr
+	closed   chan struct{}
+	closeErr error
+	once     sync.Once
+}
+
+func DialUDP(addr string, opts UDPOptions) (*UDPTransport, error) {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Secure != nil {
+		secured, err := opts.Secure(conn, false)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return wrapUDPTransport(newSecureUDPConn(secured, conn, udpMax(opts)), conn.LocalAddr(), opts), nil
+	}
+	return newUDPTransport(conn, true, remote, opts), nil
+}
+
+func ListenUDP(addr string, opts UDPOptions) (*UDPTransport, error) {
+	local, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", local)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Secure != nil {
+		return wrapUDPTransport(acceptSecureUDPConn(conn, opts), conn.LocalAddr(), opts), nil
+	}
+	return newUDPTransport(conn, false, nil, opts), nil
+}
+
+func udpMax(opts UDPOptions) int {
+	if opts.MaxDatagram <= 0 || opts.MaxDatagram > MaxUDPDatagram {
+		return MaxUDPDatagram
+	}
+	return opts.MaxDatagram
+}
+
+func newUDPTransport(conn *net.UDPConn, dialled bool, peer *net.UDPAddr, opts UDPOptions) *UDPTransport {
+	raw := &udpConn{
+		conn:    conn,
+		dialled: dialled,
+		max:     udpMax(opts),
+		buffer:  make([]byte, MaxUDPDatagram),
+		peer:    peer,
+		closed:  make(chan struct{}),
+	}
+	return wrapUDPTransport(raw, conn.LocalAddr(), opts)
+}
+
+func wrapUDPTransport(raw Transport, local net.Addr, opts UDPOptions) *UDPTransport {
+	transport := &UDPTransport{raw: raw, local: local}
+	if opts.Sequenced {
+		transport.sequence = NewAckTransport(raw, AckOptions{
+			RetryInterval: opts.RetryInterval,
+			MaxAttempts:   opts.MaxAttempts,
+			Reliable:      isRequestMessage,
+		})
+	}
+	return transport
+}
+
+// LocalAddr returns the bound address, useful after listening on port 0.
+func (t *UDPTransport) LocalAddr() net.Addr {
+	return t.local
+}
+
+func (t *UDPTransport) Read() (string, error) {
+	if t.sequence != nil {
+		return t.sequence.Read()
+	}
+	return t.raw.Read()
+}
+
+func (t *UDPTransport) Write(message string) error {
+	if t.sequence != nil {
+		return t.sequence.Write(message)
+	}
+	return t.raw.Write(message)
+}
+
+func (t *UDPTransport) Close() error {
+	if t.sequence != nil {
+		return t.sequence.Close()
+	}
+	return t.raw.Close()
+}
+
+func (c *udpConn) Read() (string, error) {
+	for {
+		n, from, err := c.conn.ReadFromUDP(c.buffer)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return "", ErrTransportClosed
+			default:
+			}
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				// ICMP port unreachable from a peer that is not up yet.
+				continue
+			}
+			return "", err
+		}
+		if !c.dialled {
+			c.peerMu.Lock()
+			c.peer = from
+			c.peerMu.Unlock()
+		}
+		message := strings.TrimSpace(string(c.buffer[:n]))
+		if message == "" {
+			continue
+		}
+		return message, nil
+	}
+}
+
+func (c *udpConn) Write(message string) error {
+	if len(message) > c.max {
+		return fmt.Errorf("kkrpc: message of %d bytes exceeds udp datagram limit %d", len(message), c.max)
+	}
+	if c.dialled {
+		_, err := c.conn.Write([]byte(message))
+		return err
+	}
+	c.peerMu.Lock()
+	peer := c.peer
+	c.peerMu.Unlock()
+	if peer == nil {
+		return errNoUDPPeer
+	}
+	_, err := c.conn.WriteToUDP([]byte(message), peer)
+	return err
+}
+
+func (c *udpConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
+
+func isRequestMessage(message string) bool {
+	var header struct {
+		T string `json:"t"`
+	}
+	return json.Unmarshal([]byte(message), &header) == nil && header.T == "q"
+}
@@ -0,0 +1,166 @@
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// secureUDPConn carries one message per Read and Write of a conn secured by
+// UDPOptions.Secure. A listener's conn becomes ready once its first peer has
+// completed the handshake.
+type secureUDPConn struct {
+	max    int
+	buffer []byte
+	ready  chan struct{}
+	conn   net.Conn
+	err    error
+	closed chan struct{}
+	once   sync.Once
+	// socket is closed on Close to abort a handshake still in progress.
+	socket *net.UDPConn
+}
+
+func newSecureUDPConn(conn net.Conn, socket *net.UDPConn, max int) *secureUDPConn {
+	c := &secureUDPConn{
+		max:    max,
+		buffer: make([]byte, MaxUDPDatagram),
+		ready:  make(chan struct{}),
+		conn:   conn,
+		closed: make(chan struct{}),
+		socket: socket,
+	}
+	close(c.ready)
+	return c
+}
+
+func acceptSecureUDPConn(socket *net.UDPConn, opts UDPOptions) *secureUDPConn {
+	c := &secureUDPConn{
+		max:    udpMax(opts),
+		buffer: make([]byte, MaxUDPDatagram),
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+		socket: socket,
+	}
+	go func() {
+		defer close(c.ready)
+		c.conn, c.err = opts.Secure(&udpPeerConn{UDPConn: socket}, true)
+		if c.err != nil {
+			c.err = fmt.Errorf("kkrpc: securing udp peer: %w", c.err)
+			_ = socket.Close()
+		}
+	}()
+	return c
+}
+
+func (c *secureUDPConn) Read() (string, error) {
+	select {
+	case <-c.ready:
+	case <-c.closed:
+		return "", ErrTransportClosed
+	}
+	if c.err != nil {
+		return "", c.err
+	}
+	for {
+		n, err := c.conn.Read(c.buffer)
+		if err != nil {
+			select {
+			case <-c.closed:
+				return "", ErrTransportClosed
+			default:
+			}
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				continue
+			}
+			return "", err
+		}
+		if message := strings.TrimSpace(string(c.buffer[:n])); message != "" {
+			return message, nil
+		}
+	}
+}
+
+func (c *secureUDPConn) Write(message string) error {
+	if len(message) > c.max {
+		return fmt.Errorf("kkrpc: message of %d bytes exceeds udp datagram limit %d", len(message), c.max)
+	}
+	select {
+	case <-c.ready:
+	default:
+		return errNoUDPPeer
+	}
+	if c.err != nil {
+		return c.err
+	}
+	_, err := c.conn.Write([]byte(message))
+	return err
+}
+
+func (c *secureUDPConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		select {
+		case <-c.ready:
+			if c.conn != nil {
+				// The security layer may say goodbye before the socket goes.
+				err = c.conn.Close()
+				_ = c.socket.Close()
+				return
+			}
+		default:
+		}
+		err = c.socket.Close()
+	})
+	return err
+}
+
+// udpPeerConn presents a listening socket as a conn to the first peer that
+// sends it a datagram, for a security layer that expects a connected conn.
+// Datagrams from other addresses are dropped.
+type udpPeerConn struct {
+	*net.UDPConn
+	mu   sync.Mutex
+	peer *net.UDPAddr
+}
+
+func (c *udpPeerConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := c.UDPConn.ReadFromUDP(b)
+		if err != nil {
+			return n, err
+		}
+		c.mu.Lock()
+		if c.peer == nil {
+			c.peer = from
+		}
+		match := c.peer.IP.Equal(from.IP) && c.peer.Port == from.Port
+		c.mu.Unlock()
+		if match {
+			return n, nil
+		}
+	}
+}
+
+func (c *udpPeerConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	peer := c.peer
+	c.mu.Unlock()
+	if peer == nil {
+		return 0, errNoUDPPeer
+	}
+	return c.UDPConn.WriteToUDP(b, peer)
+}
+
+func (c *udpPeerConn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peer == nil {
+		return nil
+	}
+	return c.peer
+}
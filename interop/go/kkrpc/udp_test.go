@@ -0,0 +1,153 @@
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestUDPTransportRoundTrip(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	for _, sequenced := range []bool{false, true} {
+		opts := UDPOptions{Sequenced: sequenced, RetryInterval: 50 * time.Millisecond}
+		serverTransport, err := ListenUDP("127.0.0.1:0", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientTransport, err := DialUDP(serverTransport.LocalAddr().String(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := NewServer(serverTransport, map[string]any{
+			"math": map[string]any{
+				"add": func(args ...any) any { return args[0].(float64) + args[1].(float64) },
+			},
+		})
+		client := NewClient(clientTransport, WithTimeout(2*time.Second))
+
+		result, err := client.Call("math.add", 2, 3)
+		if err != nil || !valuesEqual(5, result) {
+			t.Fatalf("sequenced=%v: %#v %v", sequenced, result, err)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestUDPTransportRejectsOversizedMessage(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport, err := DialUDP("127.0.0.1:9", UDPOptions{MaxDatagram: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	if err := transport.Write(`{"t":"q","id":"1","m":"math.add"}`); err == nil {
+		t.Fatal("expected oversized message to fail")
+	}
+}
+
+func TestUDPListenerNeedsPeerBeforeWriting(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	transport, err := ListenUDP("127.0.0.1:0", UDPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	if err := transport.Write("{}\n"); err != errNoUDPPeer {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// xorConn stands in for DTLS: it shakes hands, then scrambles every datagram.
+type xorConn struct {
+	net.Conn
+	sealed *atomic.Int64
+}
+
+func (c xorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := range b[:n] {
+		b[i] ^= 0x5a
+	}
+	return n, err
+}
+
+func (c xorConn) Write(b []byte) (int, error) {
+	sealed := make([]byte, len(b))
+	for i := range b {
+		sealed[i] = b[i] ^ 0x5a
+	}
+	c.sealed.Add(1)
+	return c.Conn.Write(sealed)
+}
+
+func xorSecure(sealed *atomic.Int64) func(net.Conn, bool) (net.Conn, error) {
+	return func(conn net.Conn, listener bool) (net.Conn, error) {
+		buffer := make([]byte, 16)
+		if listener {
+			if n, err := conn.Read(buffer); err != nil || string(buffer[:n]) != "hello" {
+				return nil, fmt.Errorf("bad hello %q: %v", buffer[:n], err)
+			}
+			_, err := conn.Write([]byte("welcome"))
+			return xorConn{conn, sealed}, err
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			return nil, err
+		}
+		if n, err := conn.Read(buffer); err != nil || string(buffer[:n]) != "welcome" {
+			return nil, fmt.Errorf("bad welcome %q: %v", buffer[:n], err)
+		}
+		return xorConn{conn, sealed}, nil
+	}
+}
+
+func TestUDPTransportSecure(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	var sealed atomic.Int64
+	opts := UDPOptions{Secure: xorSecure(&sealed)}
+	serverTransport, err := ListenUDP("127.0.0.1:0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := serverTransport.Write("{}"); err != errNoUDPPeer {
+		t.Fatalf("write before handshake: %v", err)
+	}
+	clientTransport, err := DialUDP(serverTransport.LocalAddr().String(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(serverTransport, map[string]any{
+		"echo": func(args ...any) any { return args[0] },
+	})
+	client := NewClient(clientTransport, WithTimeout(2*time.Second))
+	defer client.Close()
+	defer server.Close()
+
+	result, err := client.Call("echo", "secret")
+	if err != nil || result != "secret" {
+		t.Fatalf("%#v %v", result, err)
+	}
+	if sealed.Load() < 2 {
+		t.Fatalf("request and response should both be sealed, got %d writes", sealed.Load())
+	}
+}
+
+func TestUDPTransportSecureDialFailure(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	_, err := DialUDP("127.0.0.1:9", UDPOptions{Secure: func(net.Conn, bool) (net.Conn, error) {
+		return nil, errors.New("handshake refused")
+	}})
+	if err == nil || err.Error() != "handshake refused" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
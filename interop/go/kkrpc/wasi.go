@@ -0,0 +1,81 @@
+package kkrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type WASIOptions struct {
+	// Module is the .wasm file of the guest, built for WASI, e.g. a Go plugin
+	// compiled with GOOS=wasip1 serving kkrpc on os.Stdin and os.Stdout.
+	Module string
+	// Args are passed to the guest after its program name.
+	Args []string
+	// Env sets guest environment variables, as "KEY=value". The guest does not
+	// inherit the host environment.
+	Env []string
+	// Dirs are host directories the guest may access, mounted at the same path.
+	Dirs []string
+	// Runtime is the WASI runtime CLI: "wasmtime" (the default), "wazero" or
+	// "wasmer".
+	Runtime string
+	// RuntimePath is the runtime binary; it defaults to Runtime on PATH.
+	RuntimePath string
+	// Stderr also receives the guest's stderr.
+	Stderr io.Writer
+}
+
+// ProcessOptions returns the command running the guest, for StartProcess or
+// SuperviseProcess.
+func (o WASIOptions) ProcessOptions() (ProcessOptions, error) {
+	if o.Module == "" {
+		return ProcessOptions{}, errors.New("kkrpc: WASIOptions.Module is required")
+	}
+	runtime := o.Runtime
+	if runtime == "" {
+		runtime = "wasmtime"
+	}
+	var envFlag, dirFlag string
+	args := []string{"run"}
+	switch runtime {
+	case "wasmtime", "wasmer":
+		envFlag, dirFlag = "--env", "--dir"
+	case "wazero":
+		envFlag, dirFlag = "-env", "-mount"
+	default:
+		return ProcessOptions{}, fmt.Errorf("kkrpc: unknown WASI runtime %q", runtime)
+	}
+	for _, env := range o.Env {
+		args = append(args, envFlag, env)
+	}
+	for _, dir := range o.Dirs {
+		args = append(args, dirFlag, dir)
+	}
+	args = append(args, o.Module)
+	if len(o.Args) > 0 {
+		// wasmtime passes everything after the module to the guest; the
+		// others need "--" to stop reading their own flags.
+		if runtime != "wasmtime" {
+			args = append(args, "--")
+		}
+		args = append(args, o.Args...)
+	}
+	path := o.RuntimePath
+	if path == "" {
+		path = runtime
+	}
+	return ProcessOptions{Path: path, Args: args, Stderr: o.Stderr}, nil
+}
+
+// StartWASI runs a WASI module under a runtime CLI and speaks kkrpc over its
+// stdin and stdout, so plugins compiled to WebAssembly expose APIs to the
+// host like any child process.
+func StartWASI(ctx context.Context, opts WASIOptions) (*ProcessTransport, error) {
+	process, err := opts.ProcessOptions()
+	if err != nil {
+		return nil, err
+	}
+	return StartProcess(ctx, process)
+}
@@ -0,0 +1,73 @@
+//go:build !kkrpc_minimal
+
+package kkrpc
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestWASIOptionsBuildRuntimeCommands(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	opts := WASIOptions{
+		Module: "plugin.wasm",
+		Args:   []string{"--verbose"},
+		Env:    []string{"LEVEL=debug"},
+		Dirs:   []string{"/data"},
+	}
+	cases := map[string]string{
+		"":         "wasmtime run --env LEVEL=debug --dir /data plugin.wasm --verbose",
+		"wazero":   "wazero run -env LEVEL=debug -mount /data plugin.wasm -- --verbose",
+		"wasmer":   "wasmer run --env LEVEL=debug --dir /data plugin.wasm -- --verbose",
+		"wasm3000": "",
+	}
+	for runtime, want := range cases {
+		opts.Runtime = runtime
+		process, err := opts.ProcessOptions()
+		if want == "" {
+			if err == nil {
+				t.Fatalf("%s: expected an error", runtime)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := process.Path + " " + strings.Join(process.Args, " "); got != want {
+			t.Fatalf("%s:\n got %s\nwant %s", runtime, got, want)
+		}
+	}
+}
+
+func TestStartWASIServesGuest(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("needs /bin/sh")
+	}
+	// A fake runtime standing in for wasmtime runs the helper process as the
+	// guest.
+	t.Setenv("KKRPC_PROCESS_HELPER", "1")
+	path, argsFile := fakeSSH(t, "exec '"+os.Args[0]+"' -test.run='^TestProcessHelperProcess$'")
+	transport, err := StartWASI(context.Background(), WASIOptions{Module: "plugin.wasm", RuntimePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(transport, WithTimeout(5*time.Second))
+	if _, err := client.Call("pid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(argsFile)
+	if got := strings.Join(strings.Fields(string(args)), " "); got != "run plugin.wasm" {
+		t.Fatalf("runtime args %q", got)
+	}
+}
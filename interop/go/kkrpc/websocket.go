@@ -4,33 +4,159 @@ import (
 	"bufio"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 type WebSocketTransport struct {
-	conn   net.Conn
-	reader *bufio.Reader
-	mu     sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	masked  bool
+	deflate *deflateState
+	// identity is set by a server's WebSocketAuth.
+	identity    *Identity
+	subprotocol string
+	mu          sync.Mutex
 }
 
-func NewWebSocketTransport(rawURL string) (*WebSocketTransport, error) {
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+type WebSocketOption func(*webSocketConfig)
+
+type webSocketConfig struct {
+	tlsConfig    *tls.Config
+	compress     bool
+	auth         WebSocketAuth
+	header       http.Header
+	subprotocols []string
+}
+
+// WithWebSocketTLS sets the TLS configuration used for wss:// URLs: custom root
+// CAs, client certificates, ServerName for SNI or InsecureSkipVerify. ServerName
+// defaults to the URL host.
+func WithWebSocketTLS(config *tls.Config) WebSocketOption {
+	return func(c *webSocketConfig) {
+		c.tlsConfig = config
+	}
+}
+
+// WithWebSocketHeaders adds header to the handshake request of a client, e.g.
+// Authorization or Cookie for a gateway in front of the server. A Host header
+// replaces the URL's host; the headers of the handshake itself are refused.
+func WithWebSocketHeaders(header http.Header) WebSocketOption {
+	return func(c *webSocketConfig) {
+		if c.header == nil {
+			c.header = http.Header{}
+		}
+		for name, values := range header {
+			for _, value := range values {
+				c.header.Add(name, value)
+			}
+		}
+	}
+}
+
+// WithWebSocketSubprotocols has a client offer protocols in its handshake and
+// a server pick the first of protocols, in its own order of preference, that
+// the client offered. A server that picks none still accepts the connection;
+// see Subprotocol.
+func WithWebSocketSubprotocols(protocols ...string) WebSocketOption {
+	return func(c *webSocketConfig) {
+		c.subprotocols = append(c.subprotocols, protocols...)
+	}
+}
+
+// reservedHandshakeHeaders are written by the handshake itself.
+var reservedHandshakeHeaders = []string{
+	"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version",
+	"Sec-Websocket-Extensions", "Sec-Websocket-Protocol",
+}
+
+// handshakeHeader returns the extra headers for a handshake to host, and the
+// Host to send.
+func (c *webSocketConfig) handshakeHeader(host string) (string, http.Header, error) {
+	header := c.header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	for _, name := range reservedHandshakeHeaders {
+		if _, ok := header[name]; ok {
+			return "", nil, fmt.Errorf("websocket: header %s is set by the handshake", name)
+		}
+	}
+	if override := header.Get("Host"); override != "" {
+		host = override
+	}
+	header.Del("Host")
+	for _, protocol := range c.subprotocols {
+		if protocol == "" || strings.ContainsAny(protocol, " ,\t\r\n") {
+			return "", nil, fmt.Errorf("websocket: invalid subprotocol %q", protocol)
+		}
+	}
+	if len(c.subprotocols) > 0 {
+		header.Set("Sec-WebSocket-Protocol", strings.Join(c.subprotocols, ", "))
+	}
+	return host, header, nil
+}
+
+func (c *webSocketConfig) supports(protocol string) bool {
+	for _, supported := range c.subprotocols {
+		if supported == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// Subprotocol returns the subprotocol the server selected during the
+// handshake, or "" when it selected none.
+func (t *WebSocketTransport) Subprotocol() string {
+	return t.subprotocol
+}
+
+func newWebSocketConfig(opts []WebSocketOption) *webSocketConfig {
+	config := &webSocketConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+func NewWebSocketTransport(rawURL string, opts ...WebSocketOption) (*WebSocketTransport, error) {
+	config := newWebSocketConfig(opts)
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, err
 	}
-	if parsed.Scheme != "ws" {
+	defaultPort := "80"
+	switch parsed.Scheme {
+	case "ws":
+	case "wss":
+		defaultPort = "443"
+	default:
 		return nil, fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
 	}
 	host := parsed.Hostname()
 	port := parsed.Port()
 	if port == "" {
-		port = "80"
+		port = defaultPort
 	}
 	path := parsed.Path
 	if path == "" {
@@ -40,7 +166,12 @@ func NewWebSocketTransport(rawURL string) (*WebSocketTransport, error) {
 		path = path + "?" + parsed.RawQuery
 	}
 
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	hostHeader, extraHeader, err := config.handshakeHeader(parsed.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := config.dial(parsed.Scheme, host, port)
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +181,21 @@ func NewWebSocketTransport(rawURL string) (*WebSocketTransport, error) {
 		return nil, err
 	}
 	secKey := base64.StdEncoding.EncodeToString(keyBytes)
-	request := strings.Join([]string{
+	headers := []string{
 		fmt.Sprintf("GET %s HTTP/1.1", path),
-		fmt.Sprintf("Host: %s", parsed.Host),
+		fmt.Sprintf("Host: %s", hostHeader),
 		"Upgrade: websocket",
 		"Connection: Upgrade",
 		fmt.Sprintf("Sec-WebSocket-Key: %s", secKey),
 		"Sec-WebSocket-Version: 13",
-		"\r\n",
-	}, "\r\n")
+	}
+	if config.compress {
+		headers = append(headers, "Sec-WebSocket-Extensions: "+clientDeflateOffer())
+	}
+	var extra strings.Builder
+	// Write replaces line breaks in values, which could otherwise inject headers.
+	_ = extraHeader.Write(&extra)
+	request := strings.Join(headers, "\r\n") + "\r\n" + extra.String() + "\r\n"
 
 	if _, err := conn.Write([]byte(request)); err != nil {
 		_ = conn.Close()
@@ -80,89 +217,244 @@ func NewWebSocketTransport(rawURL string) (*WebSocketTransport, error) {
 		return nil, fmt.Errorf("websocket accept mismatch")
 	}
 
-	return &WebSocketTransport{conn: conn, reader: reader}, nil
+	responseHeader := parseHandshakeHeader(response)
+	deflate, err := acceptClientDeflate(responseHeader)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if deflate != nil && !config.compress {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: server enabled compression that was not offered")
+	}
+	subprotocol := responseHeader.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !config.supports(subprotocol) {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: server selected subprotocol %q that was not offered", subprotocol)
+	}
+
+	return &WebSocketTransport{conn: conn, reader: reader, masked: true, deflate: deflate, subprotocol: subprotocol}, nil
+}
+
+func (c *webSocketConfig) dial(scheme string, host string, port string) (net.Conn, error) {
+	address := net.JoinHostPort(host, port)
+	if scheme != "wss" {
+		return net.Dial("tcp", address)
+	}
+	tlsConfig := &tls.Config{}
+	if c.tlsConfig != nil {
+		tlsConfig = c.tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	return tls.Dial("tcp", address, tlsConfig)
+}
+
+// maxWebSocketMessage bounds a message as received, before inflating it.
+const maxWebSocketMessage = 64 << 20
+
+// errWebSocketProtocol marks frames that break RFC 6455. The connection is
+// then closed with status 1002 instead of being read any further.
+var errWebSocketProtocol = errors.New("websocket: protocol error")
+
+type webSocketFrame struct {
+	fin        bool
+	compressed bool
+	opcode     byte
+	payload    []byte
 }
 
+// Read returns the next message, reassembled from its fragments. Pings are
+// answered on the way.
 func (t *WebSocketTransport) Read() (string, error) {
+	var message []byte
+	var opcode byte
+	compressed := false
+	for {
+		frame, err := t.readFrame()
+		if err != nil {
+			return "", t.failRead(err)
+		}
+		switch frame.opcode {
+		case wsOpClose:
+			_ = t.conn.Close()
+			return "", ErrTransportClosed
+		case wsOpPing:
+			if err := t.writeFrame(wsOpPong, frame.payload, false); err != nil {
+				return "", err
+			}
+			continue
+		case wsOpPong:
+			if opcode == 0 {
+				// An empty message, which readers skip, still tells a
+				// heartbeat that the peer is alive.
+				return "", nil
+			}
+			continue
+		case wsOpContinuation:
+			if opcode == 0 {
+				return "", t.failRead(fmt.Errorf("%w: continuation without a message", errWebSocketProtocol))
+			}
+			if frame.compressed {
+				return "", t.failRead(fmt.Errorf("%w: RSV1 on a continuation frame", errWebSocketProtocol))
+			}
+		case wsOpText, wsOpBinary:
+			if opcode != 0 {
+				return "", t.failRead(fmt.Errorf("%w: new message inside a fragmented one", errWebSocketProtocol))
+			}
+			opcode, compressed = frame.opcode, frame.compressed
+		default:
+			return "", t.failRead(fmt.Errorf("%w: unknown opcode %#x", errWebSocketProtocol, frame.opcode))
+		}
+		if len(message)+len(frame.payload) > maxWebSocketMessage {
+			return "", t.failRead(fmt.Errorf("%w: message exceeds %d bytes", errWebSocketProtocol, maxWebSocketMessage))
+		}
+		message = append(message, frame.payload...)
+		if frame.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if message, err = t.deflate.decompress(message); err != nil {
+			return "", t.failRead(fmt.Errorf("%w: %v", errWebSocketProtocol, err))
+		}
+	}
+	if opcode == wsOpText && !utf8.Valid(message) {
+		return "", t.failRead(fmt.Errorf("%w: text message is not UTF-8", errWebSocketProtocol))
+	}
+	return string(message), nil
+}
+
+// readFrame reads one frame, refusing any that the peer could not have sent
+// under RFC 6455 before allocating its payload.
+func (t *WebSocketTransport) readFrame() (webSocketFrame, error) {
 	header, err := t.readExact(2)
 	if err != nil {
-		return "", err
+		return webSocketFrame{}, err
+	}
+	frame := webSocketFrame{fin: header[0]&0x80 != 0, compressed: header[0]&0x40 != 0, opcode: header[0] & 0x0F}
+	if header[0]&0x30 != 0 {
+		return frame, fmt.Errorf("%w: reserved bits set", errWebSocketProtocol)
 	}
-	byte1 := header[0]
-	byte2 := header[1]
-	opcode := byte1 & 0x0F
-	if opcode == 0x8 {
-		return "", ErrTransportClosed
+	if frame.compressed && t.deflate == nil {
+		return frame, fmt.Errorf("%w: compressed frame without negotiated extension", errWebSocketProtocol)
 	}
-	length := int(byte2 & 0x7F)
-	if length == 126 {
+	// Clients mask every frame and servers none.
+	masked := header[1]&0x80 != 0
+	if masked == t.masked {
+		return frame, fmt.Errorf("%w: unexpected masking", errWebSocketProtocol)
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
 		buf, err := t.readExact(2)
 		if err != nil {
-			return "", err
+			return frame, err
 		}
-		length = int(buf[0])<<8 | int(buf[1])
-	} else if length == 127 {
+		length = uint64(binary.BigEndian.Uint16(buf))
+	case 127:
 		buf, err := t.readExact(8)
 		if err != nil {
-			return "", err
-		}
-		length = 0
-		for _, b := range buf {
-			length = length<<8 + int(b)
+			return frame, err
 		}
+		length = binary.BigEndian.Uint64(buf)
+	}
+	if frame.opcode&0x8 != 0 && (!frame.fin || frame.compressed || length > 125) {
+		return frame, fmt.Errorf("%w: malformed control frame", errWebSocketProtocol)
+	}
+	if length > maxWebSocketMessage {
+		return frame, fmt.Errorf("%w: frame of %d bytes", errWebSocketProtocol, length)
 	}
-	masked := (byte2 & 0x80) != 0
-	mask := []byte{0, 0, 0, 0}
+	var mask []byte
 	if masked {
-		mask, err = t.readExact(4)
-		if err != nil {
-			return "", err
+		if mask, err = t.readExact(4); err != nil {
+			return frame, err
 		}
 	}
-	payload, err := t.readExact(length)
-	if err != nil {
-		return "", err
+	if frame.payload, err = t.readExact(int(length)); err != nil {
+		return frame, err
 	}
-	if masked {
-		for i := range payload {
-			payload[i] ^= mask[i%4]
+	for i := range mask {
+		for j := i; j < len(frame.payload); j += 4 {
+			frame.payload[j] ^= mask[i]
 		}
 	}
-	return string(payload), nil
+	return frame, nil
+}
+
+// failRead closes the connection once it cannot be read any more, so that a
+// server whose peer hung up releases the socket even if nothing else holds
+// the transport. After a protocol error, it tells the peer why first.
+func (t *WebSocketTransport) failRead(err error) error {
+	if errors.Is(err, errWebSocketProtocol) {
+		_ = t.writeFrame(wsOpClose, []byte{0x03, 0xEA}, false)
+	}
+	_ = t.conn.Close()
+	return err
 }
 
 func (t *WebSocketTransport) Write(message string) error {
+	return t.writeFrame(wsOpText, []byte(message), len(message) >= deflateMinSize)
+}
+
+// writeCompressed lets a compression hook override the size threshold; it
+// has no effect unless permessage-deflate was negotiated.
+func (t *WebSocketTransport) writeCompressed(message string, compress bool) error {
+	return t.writeFrame(wsOpText, []byte(message), compress)
+}
+
+// ping sends a ping frame, which every WebSocket peer answers with a pong
+// without involving its application.
+func (t *WebSocketTransport) ping() error {
+	return t.writeFrame(wsOpPing, nil, false)
+}
+
+func (t *WebSocketTransport) writeFrame(opcode byte, payload []byte, compress bool) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	payload := []byte(message)
+	byte1 := 0x80 | opcode
+	if t.deflate != nil && compress {
+		compressed, err := t.deflate.compress(payload)
+		if err != nil {
+			return err
+		}
+		payload = compressed
+		byte1 |= 0x40
+	}
 	length := len(payload)
-	byte1 := byte(0x80 | 0x1)
-	maskKey := make([]byte, 4)
-	if _, err := rand.Read(maskKey); err != nil {
-		return err
+	var maskBit byte
+	if t.masked {
+		maskBit = 0x80
 	}
 	var header []byte
 	if length <= 125 {
-		header = []byte{byte1, byte(0x80 | length)}
+		header = []byte{byte1, maskBit | byte(length)}
 	} else if length <= 65535 {
-		header = []byte{byte1, 0x80 | 126, byte(length >> 8), byte(length)}
+		header = []byte{byte1, maskBit | 126, byte(length >> 8), byte(length)}
 	} else {
-		header = []byte{byte1, 0x80 | 127,
+		header = []byte{byte1, maskBit | 127,
 			0, 0, 0, 0,
 			byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length),
 		}
 	}
-	masked := make([]byte, length)
-	for i, b := range payload {
-		masked[i] = b ^ maskKey[i%4]
+	if t.masked {
+		maskKey := make([]byte, 4)
+		if _, err := rand.Read(maskKey); err != nil {
+			return err
+		}
+		header = append(header, maskKey...)
+		masked := make([]byte, length)
+		for i, b := range payload {
+			masked[i] = b ^ maskKey[i%4]
+		}
+		payload = masked
 	}
 	if _, err := t.conn.Write(header); err != nil {
 		return err
 	}
-	if _, err := t.conn.Write(maskKey); err != nil {
-		return err
-	}
-	_, err := t.conn.Write(masked)
+	_, err := t.conn.Write(payload)
 	return err
 }
 
@@ -191,6 +483,17 @@ func readHTTPResponse(reader *bufio.Reader) (string, error) {
 	return builder.String(), nil
 }
 
+// parseHandshakeHeader returns the header fields of a handshake response.
+func parseHandshakeHeader(response string) http.Header {
+	header := http.Header{}
+	for _, line := range strings.Split(response, "\r\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+	return header
+}
+
 func computeAccept(key string) string {
 	hasher := sha1.New()
 	_, _ = hasher.Write([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
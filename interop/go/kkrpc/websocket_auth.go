@@ -0,0 +1,136 @@
+package kkrpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ErrUnauthenticated = errors.New("kkrpc: no credentials")
+
+// WebSocketAuth authenticates the upgrade request of a connection to a
+// WebSocket server. An error refuses the connection with 401 Unauthorized.
+type WebSocketAuth func(r *http.Request) (Identity, error)
+
+// WithWebSocketAuth has a WebSocket server authenticate every connection
+// during its handshake. The identity is then the transport's, and ServeAPI
+// serves the connection with it; see WithIdentity. Without it, a server behind
+// TLS takes the identity of a verified client certificate; see
+// IdentityFromTLS.
+func WithWebSocketAuth(auth WebSocketAuth) WebSocketOption {
+	return func(c *webSocketConfig) {
+		c.auth = auth
+	}
+}
+
+// authenticate falls back to the verified client certificate, if any.
+func (c *webSocketConfig) authenticate(r *http.Request) (*Identity, error) {
+	if c.auth == nil {
+		if r.TLS != nil {
+			if identity, ok := IdentityFromTLS(*r.TLS); ok {
+				return &identity, nil
+			}
+		}
+		return nil, nil
+	}
+	identity, err := c.auth(r)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// Identity returns the identity the server's WebSocketAuth established for
+// this connection.
+func (t *WebSocketTransport) Identity() (Identity, bool) {
+	if t.identity == nil {
+		return Identity{}, false
+	}
+	return *t.identity, true
+}
+
+// withIdentity puts the connection's identity before opts, which may still
+// override it.
+func (t *WebSocketTransport) withIdentity(opts []Option) []Option {
+	if t.identity == nil {
+		return opts
+	}
+	return append([]Option{WithIdentity(*t.identity)}, opts...)
+}
+
+// JWTValidation is what a token must satisfy besides a valid signature and,
+// when it has them, "exp" and "nbf".
+type JWTValidation struct {
+	// Key verifies the signature; see ParseJWT.
+	Key any
+	// Issuer, when set, must equal the "iss" claim.
+	Issuer string
+	// Audience, when set, must be the "aud" claim or one of its entries.
+	Audience string
+	// SubjectClaim names the claim that becomes Identity.Subject; "sub" by
+	// default.
+	SubjectClaim string
+	// Validate, when set, checks the remaining claims, e.g. a tenant or a
+	// required scope.
+	Validate func(claims map[string]any) error
+}
+
+// ValidateJWT verifies token as ParseJWT does and then checks it against v.
+func ValidateJWT(token string, v JWTValidation) (Identity, error) {
+	identity, err := ParseJWT(token, v.Key)
+	if err != nil {
+		return Identity{}, err
+	}
+	claims := identity.Claims
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return Identity{}, fmt.Errorf("%w: issuer %v", ErrInvalidToken, claims["iss"])
+	}
+	if v.Audience != "" && !jwtAudience(claims["aud"], v.Audience) {
+		return Identity{}, fmt.Errorf("%w: audience %v", ErrInvalidToken, claims["aud"])
+	}
+	if v.SubjectClaim != "" {
+		identity.Subject, _ = claims[v.SubjectClaim].(string)
+	}
+	if v.Validate != nil {
+		if err := v.Validate(claims); err != nil {
+			return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	}
+	return identity, nil
+}
+
+func jwtAudience(claim any, audience string) bool {
+	switch typed := claim.(type) {
+	case string:
+		return typed == audience
+	case []any:
+		for _, value := range typed {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWTAuth authenticates WebSocket connections by a JWT that v accepts, taken
+// from an "Authorization: Bearer" header or, since browsers cannot set headers
+// on a WebSocket, from the access_token query parameter. Pair it with
+// ClaimAuthorizer to authorize by the token's scopes.
+func JWTAuth(v JWTValidation) WebSocketAuth {
+	return func(r *http.Request) (Identity, error) {
+		token := r.URL.Query().Get("access_token")
+		if header := r.Header.Get("Authorization"); header != "" {
+			scheme, credentials, _ := strings.Cut(header, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				return Identity{}, fmt.Errorf("%w: unsupported authorization scheme %q", ErrInvalidToken, scheme)
+			}
+			token = strings.TrimSpace(credentials)
+		}
+		if token == "" {
+			return Identity{}, ErrUnauthenticated
+		}
+		return ValidateJWT(token, v)
+	}
+}
@@ -0,0 +1,141 @@
+package kkrpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	deflateExtension     = "permessage-deflate"
+	deflateWindowSize    = 32 << 10
+	deflateMinSize       = 256
+	maxInflatedFrameSize = 64 << 20
+)
+
+var (
+	deflateSyncTail  = []byte{0x00, 0x00, 0xff, 0xff}
+	deflateFinalTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	deflateWriters   = sync.Pool{New: func() any {
+		writer, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return writer
+	}}
+)
+
+// WithWebSocketCompression offers the permessage-deflate extension (RFC 7692)
+// during the handshake. Messages of at least 256 bytes are compressed once the
+// peer accepts; otherwise the connection stays uncompressed.
+func WithWebSocketCompression(enabled bool) WebSocketOption {
+	return func(c *webSocketConfig) {
+		c.compress = enabled
+	}
+}
+
+// deflateState tracks a negotiated permessage-deflate session. Outgoing
+// messages never use context takeover; incoming ones keep a sliding window
+// unless the peer agreed not to.
+type deflateState struct {
+	readTakeover bool
+	window       []byte
+}
+
+func (d *deflateState) compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := deflateWriters.Get().(*flate.Writer)
+	defer deflateWriters.Put(writer)
+	writer.Reset(&buf)
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateSyncTail), nil
+}
+
+func (d *deflateState) decompress(payload []byte) ([]byte, error) {
+	input := io.MultiReader(bytes.NewReader(payload), bytes.NewReader(deflateFinalTail))
+	reader := flate.NewReaderDict(input, d.window)
+	defer reader.Close()
+	out, err := io.ReadAll(io.LimitReader(reader, maxInflatedFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxInflatedFrameSize {
+		return nil, fmt.Errorf("websocket: inflated message exceeds %d bytes", maxInflatedFrameSize)
+	}
+	if d.readTakeover {
+		d.window = append(d.window, out...)
+		if excess := len(d.window) - deflateWindowSize; excess > 0 {
+			d.window = append(d.window[:0], d.window[excess:]...)
+		}
+	}
+	return out, nil
+}
+
+type extensionParams map[string]string
+
+func parseExtensions(values []string) map[string]extensionParams {
+	extensions := make(map[string]extensionParams)
+	for _, value := range values {
+		for _, offer := range strings.Split(value, ",") {
+			parts := strings.Split(offer, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if name == "" {
+				continue
+			}
+			params := make(extensionParams)
+			for _, part := range parts[1:] {
+				key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+				params[strings.ToLower(key)] = strings.Trim(value, `"`)
+			}
+			if _, seen := extensions[name]; !seen {
+				extensions[name] = params
+			}
+		}
+	}
+	return extensions
+}
+
+// clientDeflateOffer is sent by the client; it promises not to reuse its
+// compression context so the server can release it between messages.
+func clientDeflateOffer() string {
+	return deflateExtension + "; client_no_context_takeover"
+}
+
+func acceptClientDeflate(header http.Header) (*deflateState, error) {
+	extensions := parseExtensions(header.Values("Sec-WebSocket-Extensions"))
+	params, ok := extensions[deflateExtension]
+	if !ok {
+		if len(extensions) > 0 {
+			return nil, errors.New("websocket: server selected an extension that was not offered")
+		}
+		return nil, nil
+	}
+	if _, ok := params["client_max_window_bits"]; ok {
+		return nil, errors.New("websocket: server sent client_max_window_bits without an offer")
+	}
+	_, noTakeover := params["server_no_context_takeover"]
+	return &deflateState{readTakeover: !noTakeover}, nil
+}
+
+// acceptServerDeflate answers a client offer. The server never reuses its own
+// compression context, and keeps a window for the client's unless the client
+// promised not to use one.
+func acceptServerDeflate(request *http.Request) (*deflateState, string) {
+	params, ok := parseExtensions(request.Header.Values("Sec-WebSocket-Extensions"))[deflateExtension]
+	if !ok {
+		return nil, ""
+	}
+	_, noTakeover := params["client_no_context_takeover"]
+	response := deflateExtension + "; server_no_context_takeover"
+	if noTakeover {
+		response += "; client_no_context_takeover"
+	}
+	return &deflateState{readTakeover: !noTakeover}, response
+}
@@ -0,0 +1,206 @@
+package kkrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+// testFrame builds a WebSocket frame field by field, so that a test can get
+// any of them wrong.
+type testFrame struct {
+	opcode  byte
+	partial bool   // clears FIN
+	rsv     byte   // RSV1-3 as they sit in the first byte
+	mask    []byte // nil sends the frame unmasked
+	payload []byte
+	// length, when set, is encoded instead of the payload's length, with
+	// extended forced to 2 or 8 bytes.
+	length   uint64
+	extended int
+}
+
+func (f testFrame) bytes() []byte {
+	first := f.rsv | f.opcode
+	if !f.partial {
+		first |= 0x80
+	}
+	length := f.length
+	if length == 0 {
+		length = uint64(len(f.payload))
+	}
+	var maskBit byte
+	if f.mask != nil {
+		maskBit = 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case f.extended == 8 || (f.extended == 0 && length > 65535):
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, length)
+	case f.extended == 2 || (f.extended == 0 && length > 125):
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|byte(length))
+	}
+	frame = append(frame, f.mask...)
+	for i, b := range f.payload {
+		if f.mask != nil {
+			b ^= f.mask[i%4]
+		}
+		frame = append(frame, b)
+	}
+	return frame
+}
+
+func frames(list ...testFrame) []byte {
+	var out []byte
+	for _, frame := range list {
+		out = append(out, frame.bytes()...)
+	}
+	return out
+}
+
+var testMask = []byte{0x12, 0x34, 0x56, 0x78}
+
+// webSocketPeer serves an echo API on the server end of a connection and
+// returns the client end for a test to write raw frames to.
+func webSocketPeer(t *testing.T) net.Conn {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	transport := &WebSocketTransport{conn: serverConn, reader: bufio.NewReader(serverConn)}
+	NewChannel(transport, map[string]any{"echo": func(args ...any) any { return args[0] }})
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+	return clientConn
+}
+
+// readServerFrames reads unmasked frames until the server closes the
+// connection or a second passes.
+func readServerFrames(conn net.Conn) ([]webSocketFrame, bool) {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := &WebSocketTransport{conn: conn, reader: bufio.NewReader(conn), masked: true}
+	var received []webSocketFrame
+	for {
+		frame, err := reader.readFrame()
+		if err != nil {
+			return received, err == io.EOF || err == io.ErrClosedPipe
+		}
+		received = append(received, frame)
+	}
+}
+
+func TestWebSocketRejectsMalformedFrames(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	request := []byte(`{"t":"q","id":"1","op":"call","p":["echo"],"a":["hi"]}`)
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"unmasked client frame", frames(testFrame{opcode: wsOpText, payload: request})},
+		{"reserved bits", frames(testFrame{opcode: wsOpText, rsv: 0x20, mask: testMask, payload: request})},
+		{"compressed without extension", frames(testFrame{opcode: wsOpText, rsv: 0x40, mask: testMask, payload: request})},
+		{"unknown opcode", frames(testFrame{opcode: 0x3, mask: testMask, payload: request})},
+		{"bogus 64-bit length", frames(testFrame{opcode: wsOpText, mask: testMask, length: 1 << 63, extended: 8})},
+		{"oversized length", frames(testFrame{opcode: wsOpText, mask: testMask, length: maxWebSocketMessage + 1, extended: 8})},
+		{"fragmented ping", frames(testFrame{opcode: wsOpPing, partial: true, mask: testMask})},
+		{"long ping", frames(testFrame{opcode: wsOpPing, mask: testMask, payload: bytes.Repeat([]byte("p"), 126)})},
+		{"orphan continuation", frames(testFrame{opcode: wsOpContinuation, mask: testMask, payload: request})},
+		{"interleaved message", frames(
+			testFrame{opcode: wsOpText, partial: true, mask: testMask, payload: request[:10]},
+			testFrame{opcode: wsOpText, mask: testMask, payload: request},
+		)},
+		{"invalid UTF-8", frames(testFrame{opcode: wsOpText, mask: testMask, payload: []byte{'"', 0xff, '"'}})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := webSocketPeer(t)
+			go func() { _, _ = conn.Write(tc.data) }()
+			received, closed := readServerFrames(conn)
+			if !closed {
+				t.Fatal("server kept the connection open")
+			}
+			last := received[len(received)-1]
+			if len(received) != 1 || last.opcode != wsOpClose || !bytes.Equal(last.payload, []byte{0x03, 0xEA}) {
+				t.Fatalf("expected only a 1002 close frame, got %+v", received)
+			}
+		})
+	}
+}
+
+func TestWebSocketReassemblesSplitFrames(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	request := []byte(`{"t":"q","id":"1","op":"call","p":["echo"],"a":["hi"]}`)
+	data := frames(
+		testFrame{opcode: wsOpText, partial: true, mask: testMask, payload: request[:7], extended: 2},
+		testFrame{opcode: wsOpPing, mask: testMask, payload: []byte("beat")},
+		testFrame{opcode: wsOpContinuation, partial: true, mask: testMask, payload: request[7:30]},
+		testFrame{opcode: wsOpContinuation, mask: testMask, payload: request[30:]},
+	)
+	conn := webSocketPeer(t)
+	go func() {
+		// One byte per write splits every header and payload across reads.
+		for i := range data {
+			if _, err := conn.Write(data[i : i+1]); err != nil {
+				return
+			}
+		}
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := &WebSocketTransport{conn: conn, reader: bufio.NewReader(conn), masked: true}
+	pong, err := reader.readFrame()
+	if err != nil || pong.opcode != wsOpPong || string(pong.payload) != "beat" {
+		t.Fatalf("pong: %+v %v", pong, err)
+	}
+	response, err := reader.readFrame()
+	if err != nil || !bytes.Contains(response.payload, []byte(`"v":"hi"`)) {
+		t.Fatalf("response: %s %v", response.payload, err)
+	}
+}
+
+// discardConn takes the pongs and close frames of a transport under fuzzing.
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+func FuzzWebSocketRead(f *testing.F) {
+	request := []byte(`{"t":"q","id":"1"}`)
+	f.Add(frames(testFrame{opcode: wsOpText, mask: testMask, payload: request}), false)
+	f.Add(frames(testFrame{opcode: wsOpText, payload: request}), true)
+	f.Add(frames(
+		testFrame{opcode: wsOpBinary, partial: true, mask: testMask, payload: request[:4]},
+		testFrame{opcode: wsOpPing, mask: testMask},
+		testFrame{opcode: wsOpContinuation, mask: testMask, payload: request[4:]},
+	), false)
+	f.Add(frames(testFrame{opcode: wsOpText, rsv: 0x40, payload: []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00}}), true)
+	f.Add(frames(testFrame{opcode: wsOpText, length: 1 << 62, extended: 8}), true)
+	f.Fuzz(func(t *testing.T, data []byte, client bool) {
+		transport := &WebSocketTransport{
+			conn:    discardConn{},
+			reader:  bufio.NewReader(bytes.NewReader(data)),
+			masked:  client,
+			deflate: &deflateState{readTakeover: true},
+		}
+		// Every Read consumes at least a frame header, so this ends.
+		for {
+			message, err := transport.Read()
+			if err != nil {
+				return
+			}
+			if len(message) > maxInflatedFrameSize {
+				t.Fatalf("message of %d bytes", len(message))
+			}
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package kkrpc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const webSocketHandshakeTimeout = 10 * time.Second
+
+type WebSocketListener struct {
+	listener net.Listener
+	config   *webSocketConfig
+}
+
+func ListenWebSocket(addr string, opts ...WebSocketOption) (*WebSocketListener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewWebSocketListener(listener, opts...), nil
+}
+
+func NewWebSocketListener(listener net.Listener, opts ...WebSocketOption) *WebSocketListener {
+	return &WebSocketListener{listener: listener, config: newWebSocketConfig(opts)}
+}
+
+func (l *WebSocketListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *WebSocketListener) Close() error {
+	return l.listener.Close()
+}
+
+// Accept waits for the next connection and completes its WebSocket handshake.
+// Connections that fail the handshake are closed and skipped.
+func (l *WebSocketListener) Accept() (*WebSocketTransport, error) {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		transport, err := l.config.accept(conn)
+		if err == nil {
+			return transport, nil
+		}
+	}
+}
+
+// Serve accepts connections until the listener is closed, completing each
+// handshake on its own goroutine before passing the transport to handle.
+func (l *WebSocketListener) Serve(handle func(*WebSocketTransport)) error {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func(conn net.Conn) {
+			transport, err := l.config.accept(conn)
+			if err != nil {
+				return
+			}
+			handle(transport)
+		}(conn)
+	}
+}
+
+// ServeAPI exposes api to every peer that connects, each over its own Channel
+// so the peer can expose an API back. Each connection gets its own copy of
+// api's maps, so a peer's set is only seen on its own connection.
+func (l *WebSocketListener) ServeAPI(api map[string]any, opts ...Option) error {
+	return l.ServeAPIFunc(func(*WebSocketTransport) map[string]any { return cloneAPI(api) }, opts...)
+}
+
+// ServeAPIFunc is ServeAPI with the API newAPI returns for each connection,
+// once its handshake is done; a nil API closes the connection. Like Serve, it
+// closes each Channel when its peer disconnects and every connection once the
+// listener is closed. As with Serve, newAPI must not hand the same map to two
+// connections.
+func (l *WebSocketListener) ServeAPIFunc(newAPI func(*WebSocketTransport) map[string]any, opts ...Option) error {
+	var live liveConns
+	defer live.closeAll()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		live.add(conn)
+		go func(conn net.Conn) {
+			defer live.remove(conn)
+			transport, err := l.config.accept(conn)
+			if err != nil {
+				return
+			}
+			api := newAPI(transport)
+			if api == nil {
+				_ = transport.Close()
+				return
+			}
+			serveChannel(transport, api, transport.withIdentity(opts))
+		}(conn)
+	}
+}
+
+func (c *webSocketConfig) accept(conn net.Conn) (*WebSocketTransport, error) {
+	_ = conn.SetDeadline(time.Now().Add(webSocketHandshakeTimeout))
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		request.TLS = &state
+	}
+	accept, err := validateWebSocketRequest(request)
+	if err != nil {
+		_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		_ = conn.Close()
+		return nil, err
+	}
+	identity, err := c.authenticate(request)
+	if err != nil {
+		_, _ = conn.Write([]byte("HTTP/1.1 401 Unauthorized\r\nConnection: close\r\n\r\n"))
+		_ = conn.Close()
+		return nil, err
+	}
+	deflate, extensions := c.negotiate(request)
+	subprotocol := c.selectSubprotocol(request)
+	if _, err := conn.Write([]byte(switchingProtocolsResponse(accept, extensions, subprotocol))); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &WebSocketTransport{conn: conn, reader: reader, deflate: deflate, identity: identity, subprotocol: subprotocol}, nil
+}
+
+// WebSocketHandler upgrades requests on an existing net/http server and hands
+// each connection to accept.
+func WebSocketHandler(accept func(*WebSocketTransport), opts ...WebSocketOption) http.Handler {
+	config := newWebSocketConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptKey, err := validateWebSocketRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		identity, err := config.authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "websocket upgrade not supported", http.StatusInternalServerError)
+			return
+		}
+		conn, buffered, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+		deflate, extensions := config.negotiate(r)
+		subprotocol := config.selectSubprotocol(r)
+		if _, err := conn.Write([]byte(switchingProtocolsResponse(acceptKey, extensions, subprotocol))); err != nil {
+			_ = conn.Close()
+			return
+		}
+		accept(&WebSocketTransport{conn: conn, reader: buffered.Reader, deflate: deflate, identity: identity, subprotocol: subprotocol})
+	})
+}
+
+func validateWebSocketRequest(r *http.Request) (string, error) {
+	if r.Method != http.MethodGet {
+		return "", fmt.Errorf("websocket upgrade requires GET, got %s", r.Method)
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return "", errors.New("not a websocket upgrade request")
+	}
+	if version := r.Header.Get("Sec-WebSocket-Version"); version != "13" {
+		return "", fmt.Errorf("unsupported websocket version %q", version)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return "", errors.New("missing Sec-WebSocket-Key")
+	}
+	return computeAccept(key), nil
+}
+
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *webSocketConfig) negotiate(r *http.Request) (*deflateState, string) {
+	if !c.compress {
+		return nil, ""
+	}
+	return acceptServerDeflate(r)
+}
+
+// selectSubprotocol picks the server's most preferred subprotocol among those
+// the client offered, or "".
+func (c *webSocketConfig) selectSubprotocol(r *http.Request) string {
+	for _, protocol := range c.subprotocols {
+		if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+func switchingProtocolsResponse(accept string, extensions string, subprotocol string) string {
+	headers := []string{
+		"HTTP/1.1 101 Switching Protocols",
+		"Upgrade: websocket",
+		"Connection: Upgrade",
+		"Sec-WebSocket-Accept: " + accept,
+	}
+	if extensions != "" {
+		headers = append(headers, "Sec-WebSocket-Extensions: "+extensions)
+	}
+	if subprotocol != "" {
+		headers = append(headers, "Sec-WebSocket-Protocol: "+subprotocol)
+	}
+	return strings.Join(append(headers, "\r\n"), "\r\n")
+}
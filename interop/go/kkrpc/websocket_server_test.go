@@ -0,0 +1,363 @@
+package kkrpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc/kkrpctest"
+)
+
+func TestWebSocketListenerServesAPI(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := ListenWebSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = listener.ServeAPI(map[string]any{
+			"math": map[string]any{
+				"add": func(args ...any) any { return args[0].(float64) + args[1].(float64) },
+			},
+			"echo": func(args ...any) any { return args[0] },
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		transport, err := NewWebSocketTransport("ws://" + listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		client := NewClient(transport)
+		result, err := client.Call("math.add", 2, i)
+		if err != nil || result != float64(2+i) {
+			t.Fatalf("client %d: %v %v", i, result, err)
+		}
+		large := strings.Repeat("x", 70000)
+		if echoed, err := client.Call("echo", large); err != nil || echoed != large {
+			t.Fatalf("large frame round trip failed: %v", err)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestWebSocketListenerKeepsSetsPerConnection(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := ListenWebSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = listener.ServeAPI(map[string]any{
+			"settings": map[string]any{"theme": "light", "font": "mono"},
+		})
+	}()
+
+	clients := make([]*Client, 2)
+	for i := range clients {
+		transport, err := NewWebSocketTransport("ws://" + listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		clients[i] = NewClient(transport, WithTimeout(2*time.Second))
+		defer clients[i].Close()
+	}
+	// One peer sets while the other reads the whole map; run with -race.
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			if _, err := clients[0].Set([]string{"settings", "theme"}, fmt.Sprint("dark-", i)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 50; i++ {
+		settings, err := clients[1].Get([]string{"settings"})
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if theme := settings.(map[string]any)["theme"]; theme != "light" {
+			t.Fatalf("set on one connection seen on another: %v", theme)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if theme, err := clients[0].Get([]string{"settings", "theme"}); err != nil || theme != "dark-49" {
+		t.Fatalf("set not kept on its own connection: %v %v", theme, err)
+	}
+}
+
+func TestWebSocketHandlerUpgradesHTTP(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	server := httptest.NewServer(WebSocketHandler(func(transport *WebSocketTransport) {
+		NewServer(transport, map[string]any{
+			"echo": func(args ...any) any { return args[0] },
+		})
+	}))
+	defer server.Close()
+
+	transport, err := NewWebSocketTransport("ws" + strings.TrimPrefix(server.URL, "http") + "/rpc")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := NewClient(transport)
+	defer client.Close()
+	large := strings.Repeat("y", 1000)
+	if result, err := client.Call("echo", large); err != nil || result != large {
+		t.Fatalf("echo: %v", err)
+	}
+}
+
+func TestWebSocketTransportDialsWSS(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	server := httptest.NewTLSServer(WebSocketHandler(func(transport *WebSocketTransport) {
+		NewServer(transport, map[string]any{
+			"echo": func(args ...any) any { return args[0] },
+		})
+	}))
+	defer server.Close()
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	if _, err := NewWebSocketTransport(url); err == nil {
+		t.Fatal("expected certificate verification to fail without the test CA")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	transport, err := NewWebSocketTransport(url, WithWebSocketTLS(&tls.Config{RootCAs: roots, ServerName: "example.com"}))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := NewClient(transport)
+	defer client.Close()
+	if result, err := client.Call("echo", "secure"); err != nil || result != "secure" {
+		t.Fatalf("echo over wss: %v %v", result, err)
+	}
+}
+
+func TestWebSocketCompressionNegotiation(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	listener, err := ListenWebSocket("127.0.0.1:0", WithWebSocketCompression(true))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = listener.ServeAPI(map[string]any{
+			"echo": func(args ...any) any { return args[0] },
+		})
+	}()
+	url := "ws://" + listener.Addr().String()
+
+	compressed, err := NewWebSocketTransport(url, WithWebSocketCompression(true))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if compressed.deflate == nil {
+		t.Fatal("compression was not negotiated")
+	}
+	client := NewClient(compressed)
+	defer client.Close()
+	for _, payload := range []string{"short", strings.Repeat("kkrpc ", 20000), strings.Repeat("again ", 500)} {
+		if result, err := client.Call("echo", payload); err != nil || result != payload {
+			t.Fatalf("echo over deflate failed for %d bytes: %v", len(payload), err)
+		}
+	}
+
+	plain, err := NewWebSocketTransport(url)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if plain.deflate != nil {
+		t.Fatal("compression negotiated without an offer")
+	}
+	_ = plain.Close()
+}
+
+func TestDeflateContextTakeoverWindow(t *testing.T) {
+	reader := &deflateState{readTakeover: true}
+	first := []byte(strings.Repeat("shared prefix ", 50))
+	writer, _ := flate.NewWriter(nil, flate.BestSpeed)
+	stream := &bytes.Buffer{}
+	writer.Reset(stream)
+	_, _ = writer.Write(first)
+	_ = writer.Flush()
+	message1 := append([]byte(nil), stream.Bytes()...)
+	stream.Reset()
+	_, _ = writer.Write(first)
+	_ = writer.Flush()
+	message2 := append([]byte(nil), stream.Bytes()...)
+
+	for i, message := range [][]byte{message1, message2} {
+		out, err := reader.decompress(message[:len(message)-4])
+		if err != nil || string(out) != string(first) {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+}
+
+func TestWebSocketJWTAuth(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	secret := []byte("ws-secret")
+	validation := JWTValidation{Key: secret, Issuer: "https://auth.example.com", Audience: "kkrpc"}
+	listener, err := ListenWebSocket("127.0.0.1:0", WithWebSocketAuth(JWTAuth(validation)))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = listener.ServeAPI(map[string]any{
+			"whoami": func(ctx context.Context, args ...any) any {
+				identity, _ := IdentityFromContext(ctx)
+				return identity.Subject
+			},
+			"admin": map[string]any{"reset": func(args ...any) any { return true }},
+		}, WithAuthorizer(ClaimAuthorizer{}))
+	}()
+	url := "ws://" + listener.Addr().String() + "/?access_token="
+
+	token := signJWT(t, "HS256", map[string]any{
+		"sub": "browser-1", "iss": "https://auth.example.com", "aud": []any{"kkrpc"}, "scope": "whoami",
+	}, hs256(secret))
+	transport, err := NewWebSocketTransport(url + token)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client := NewClient(transport, WithTimeout(2*time.Second))
+	defer client.Close()
+	if result, err := client.Call("whoami"); err != nil || result != "browser-1" {
+		t.Fatalf("whoami: %#v %v", result, err)
+	}
+	if _, err := client.Call("admin.reset"); err == nil {
+		t.Fatal("expected the scope to exclude admin.reset")
+	}
+
+	wrongAudience := signJWT(t, "HS256", map[string]any{"sub": "b", "iss": "https://auth.example.com", "aud": "other"}, hs256(secret))
+	for _, bad := range []string{"", "garbage", wrongAudience} {
+		if _, err := NewWebSocketTransport(url + bad); err == nil {
+			t.Fatalf("token %q should be refused", bad)
+		}
+	}
+}
+
+func TestJWTAuthReadsBearerHeader(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	secret := []byte("k")
+	auth := JWTAuth(JWTValidation{Key: secret, SubjectClaim: "email", Validate: func(claims map[string]any) error {
+		if claims["tenant"] != "acme" {
+			return errors.New("wrong tenant")
+		}
+		return nil
+	}})
+	request := httptest.NewRequest("GET", "/rpc", nil)
+	request.Header.Set("Authorization", "Bearer "+signJWT(t, "HS256", map[string]any{"email": "a@acme.test", "tenant": "acme"}, hs256(secret)))
+	identity, err := auth(request)
+	if err != nil || identity.Subject != "a@acme.test" {
+		t.Fatalf("%#v %v", identity, err)
+	}
+	request.Header.Set("Authorization", "Bearer "+signJWT(t, "HS256", map[string]any{"tenant": "other"}, hs256(secret)))
+	if _, err := auth(request); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected tenant check to fail: %v", err)
+	}
+	request.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	if _, err := auth(request); err == nil {
+		t.Fatal("expected basic auth to be refused")
+	}
+	if _, err := auth(httptest.NewRequest("GET", "/rpc", nil)); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated: %v", err)
+	}
+}
+
+func TestWebSocketHandshakeHeadersReachGateway(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	upgrade := WebSocketHandler(func(transport *WebSocketTransport) {
+		NewServer(transport, map[string]any{"echo": func(args ...any) any { return args[0] }})
+	})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gateway-token" || r.Host != "rpc.internal" {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "abc" {
+			http.Error(w, "no session", http.StatusUnauthorized)
+			return
+		}
+		upgrade.ServeHTTP(w, r)
+	}))
+	defer gateway.Close()
+	url := "ws" + strings.TrimPrefix(gateway.URL, "http")
+
+	if _, err := NewWebSocketTransport(url); err == nil {
+		t.Fatal("gateway let a handshake without credentials through")
+	}
+	transport, err := NewWebSocketTransport(url, WithWebSocketHeaders(http.Header{
+		"Authorization": {"Bearer gateway-token"},
+		"Cookie":        {"session=abc"},
+		"Host":          {"rpc.internal"},
+	}))
+	if err != nil {
+		t.Fatalf("dial with headers: %v", err)
+	}
+	client := NewClient(transport, WithTimeout(time.Second))
+	defer client.Close()
+	if result, err := client.Call("echo", "hi"); err != nil || result != "hi" {
+		t.Fatalf("echo: %v %v", result, err)
+	}
+
+	if _, err := NewWebSocketTransport(url, WithWebSocketHeaders(http.Header{"Sec-WebSocket-Key": {"x"}})); err == nil {
+		t.Fatal("handshake header override accepted")
+	}
+}
+
+func TestWebSocketSubprotocolNegotiation(t *testing.T) {
+	kkrpctest.AssertNoGoroutineLeaks(t)
+	kkrpctest.AssertNoFDLeaks(t)
+	selected := make(chan string, 4)
+	server := httptest.NewServer(WebSocketHandler(func(transport *WebSocketTransport) {
+		selected <- transport.Subprotocol()
+		_ = transport.Close()
+	}, WithWebSocketSubprotocols("kkrpc.v2", "kkrpc.v1")))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for _, tc := range []struct {
+		offer []string
+		want  string
+	}{
+		{[]string{"kkrpc.v1", "kkrpc.v2"}, "kkrpc.v2"},
+		{[]string{"kkrpc.v1"}, "kkrpc.v1"},
+		{[]string{"graphql-ws"}, ""},
+		{nil, ""},
+	} {
+		transport, err := NewWebSocketTransport(url, WithWebSocketSubprotocols(tc.offer...))
+		if err != nil {
+			t.Fatalf("offer %v: %v", tc.offer, err)
+		}
+		if got := transport.Subprotocol(); got != tc.want || <-selected != tc.want {
+			t.Fatalf("offer %v: selected %q, want %q", tc.offer, got, tc.want)
+		}
+		_ = transport.Close()
+	}
+}
@@ -0,0 +1,135 @@
+//go:build !kkrpc_minimal
+
+// Package clipboard exposes the system clipboard over kkrpc. It drives the
+// platform's own tools (pbcopy/pbpaste, wl-copy/wl-paste, xclip or xsel, and
+// PowerShell on Windows) instead of linking native libraries.
+package clipboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// DefaultMaxBytes bounds the text written or read in one call.
+const DefaultMaxBytes = 1 << 20
+
+var ErrUnavailable = errors.New("no clipboard tool found")
+
+type Options struct {
+	// CopyCommand and PasteCommand override the detected tools, as argv.
+	// CopyCommand receives the text on stdin; PasteCommand prints it.
+	CopyCommand  []string
+	PasteCommand []string
+	// ReadOnly refuses writeText.
+	ReadOnly bool
+	// MaxBytes defaults to DefaultMaxBytes.
+	MaxBytes int
+}
+
+type Service struct {
+	copy     []string
+	paste    []string
+	readOnly bool
+	maxBytes int
+}
+
+// New fails with ErrUnavailable when no clipboard tool is installed.
+func New(opts Options) (*Service, error) {
+	service := &Service{copy: opts.CopyCommand, paste: opts.PasteCommand, readOnly: opts.ReadOnly, maxBytes: opts.MaxBytes}
+	if service.maxBytes <= 0 {
+		service.maxBytes = DefaultMaxBytes
+	}
+	if service.copy == nil || service.paste == nil {
+		copyCommand, pasteCommand := detect()
+		if service.copy == nil {
+			service.copy = copyCommand
+		}
+		if service.paste == nil {
+			service.paste = pasteCommand
+		}
+	}
+	if service.copy == nil || service.paste == nil {
+		return nil, ErrUnavailable
+	}
+	return service, nil
+}
+
+// detect returns the first installed tool pair for this platform.
+func detect() (copyCommand []string, pasteCommand []string) {
+	var candidates [][2][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][2][]string{{{"pbcopy"}, {"pbpaste"}}}
+	case "windows":
+		candidates = [][2][]string{{
+			{"powershell", "-NoProfile", "-Command", "$input | Set-Clipboard"},
+			{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+		}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, [2][]string{{"wl-copy"}, {"wl-paste", "--no-newline"}})
+		}
+		candidates = append(candidates,
+			[2][]string{{"xclip", "-selection", "clipboard"}, {"xclip", "-selection", "clipboard", "-o"}},
+			[2][]string{{"xsel", "--clipboard", "--input"}, {"xsel", "--clipboard", "--output"}},
+		)
+	}
+	for _, pair := range candidates {
+		if _, err := exec.LookPath(pair[0][0]); err == nil {
+			return pair[0], pair[1]
+		}
+	}
+	return nil, nil
+}
+
+// API returns the methods to mount, e.g. under "clipboard".
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"readText":  kkrpc.MustFunc(s.ReadText),
+		"writeText": kkrpc.MustFunc(s.WriteText),
+	}
+}
+
+func (s *Service) ReadText(ctx context.Context) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.paste[0], s.paste[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", toolError(err, stderr.String())
+	}
+	if stdout.Len() > s.maxBytes {
+		return "", fmt.Errorf("clipboard holds more than %d bytes", s.maxBytes)
+	}
+	return stdout.String(), nil
+}
+
+func (s *Service) WriteText(ctx context.Context, text string) error {
+	if s.readOnly {
+		return &kkrpc.RpcError{Name: "PermissionError", Message: "clipboard is read-only"}
+	}
+	if len(text) > s.maxBytes {
+		return fmt.Errorf("text exceeds %d bytes", s.maxBytes)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.copy[0], s.copy[1:]...)
+	cmd.Stdin, cmd.Stderr = strings.NewReader(text), &stderr
+	if err := cmd.Run(); err != nil {
+		return toolError(err, stderr.String())
+	}
+	return nil
+}
+
+func toolError(err error, stderr string) error {
+	if message := strings.TrimSpace(stderr); message != "" {
+		return fmt.Errorf("%v: %s", err, message)
+	}
+	return err
+}
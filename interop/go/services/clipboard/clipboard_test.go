@@ -0,0 +1,42 @@
+//go:build !kkrpc_minimal
+
+package clipboard
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func TestWriteThenReadText(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "clipboard")
+	service, err := New(Options{
+		CopyCommand:  []string{"sh", "-c", `cat > "$0"`, file},
+		PasteCommand: []string{"cat", file},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := service.WriteText(ctx, "héllo\nworld"); err != nil {
+		t.Fatal(err)
+	}
+	text, err := service.ReadText(ctx)
+	if err != nil || text != "héllo\nworld" {
+		t.Fatalf("read %q %v", text, err)
+	}
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	service, err := New(Options{CopyCommand: []string{"true"}, PasteCommand: []string{"true"}, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rpcErr *kkrpc.RpcError
+	if err := service.WriteText(context.Background(), "x"); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("write: %v", err)
+	}
+}
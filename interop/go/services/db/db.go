@@ -0,0 +1,240 @@
+//go:build !kkrpc_minimal
+
+// Package db exposes parameterized SQL queries over kkrpc. Query results are
+// streamed in chunks of rows, so a TypeScript UI can page through large
+// tables that live in a Go-owned database without loading them at once.
+//
+// The package works with any database/sql driver; the application opens the
+// *sql.DB (for example with a SQLite driver) and hands it to New.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// DefaultChunkSize is how many rows one stream item carries.
+const DefaultChunkSize = 100
+
+var ErrReadOnly = errors.New("database is read-only")
+
+type Options struct {
+	// DB is the database to query. Required.
+	DB *sql.DB
+	// ReadOnly refuses exec calls and runs each query in a read-only
+	// transaction that is rolled back once its rows are read, so the
+	// database refuses writes such as DELETE ... RETURNING. Drivers that
+	// cannot open read-only transactions fail every query.
+	ReadOnly bool
+	// Allow, when set, vets each statement before it runs, e.g. against an
+	// allow-list of prepared queries.
+	Allow func(ctx context.Context, query string) error
+	// ChunkSize defaults to DefaultChunkSize.
+	ChunkSize int
+	// MaxRows ends a query stream early when > 0.
+	MaxRows int
+}
+
+// Column describes one result column. Type is the driver's database type
+// name, such as "INTEGER" or "TEXT", when the driver reports it.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+// Chunk is one item of a query stream. The first chunk carries the columns
+// and every chunk after it up to ChunkSize rows, each a value per column.
+type Chunk struct {
+	Columns []Column `json:"columns,omitempty"`
+	Rows    [][]any  `json:"rows,omitempty"`
+}
+
+// ExecResult is what exec returns.
+type ExecResult struct {
+	RowsAffected int64 `json:"rowsAffected"`
+	LastInsertID int64 `json:"lastInsertId"`
+}
+
+type Service struct {
+	db        *sql.DB
+	readOnly  bool
+	allow     func(ctx context.Context, query string) error
+	chunkSize int
+	maxRows   int
+}
+
+func New(opts Options) (*Service, error) {
+	if opts.DB == nil {
+		return nil, errors.New("db: Options.DB is required")
+	}
+	service := &Service{db: opts.DB, readOnly: opts.ReadOnly, allow: opts.Allow, chunkSize: opts.ChunkSize, maxRows: opts.MaxRows}
+	if service.chunkSize <= 0 {
+		service.chunkSize = DefaultChunkSize
+	}
+	return service, nil
+}
+
+// API returns the methods to mount, e.g. under "db".
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"query": kkrpc.MustFunc(s.Query),
+		"exec":  kkrpc.MustFunc(s.Exec),
+	}
+}
+
+// Query runs a statement with positional params and streams its rows.
+// Closing the stream cancels the query.
+func (s *Service) Query(ctx context.Context, query string, params []any) (*kkrpc.Stream, error) {
+	if err := s.check(ctx, query); err != nil {
+		return nil, err
+	}
+	// The rows are read after the call has returned, so the query must not
+	// be bound to the request context.
+	queryCtx, cancel := context.WithCancel(context.Background())
+	rows, done, err := s.rows(queryCtx, query, params)
+	if err != nil {
+		cancel()
+		return nil, queryError(err)
+	}
+	columns, err := columnsOf(rows)
+	if err != nil {
+		rows.Close()
+		done()
+		cancel()
+		return nil, queryError(err)
+	}
+	stream := kkrpc.NewStream()
+	go func() {
+		<-stream.Done()
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		defer done()
+		defer rows.Close()
+		_ = stream.Close(s.send(stream, rows, columns))
+	}()
+	return stream, nil
+}
+
+// rows runs query, inside a read-only transaction when the service is
+// read-only; done ends that transaction once the rows are closed.
+func (s *Service) rows(ctx context.Context, query string, params []any) (rows *sql.Rows, done func(), err error) {
+	if !s.readOnly {
+		rows, err = s.db.QueryContext(ctx, query, params...)
+		return rows, func() {}, err
+	}
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err = tx.QueryContext(ctx, query, params...)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, nil, err
+	}
+	return rows, func() { _ = tx.Rollback() }, nil
+}
+
+func (s *Service) send(stream *kkrpc.Stream, rows *sql.Rows, columns []Column) error {
+	ctx := context.Background()
+	if err := stream.Send(ctx, Chunk{Columns: columns}); err != nil {
+		return err
+	}
+	values := make([]any, len(columns))
+	targets := make([]any, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	chunk := make([][]any, 0, s.chunkSize)
+	total := 0
+	for rows.Next() {
+		if err := rows.Scan(targets...); err != nil {
+			return queryError(err)
+		}
+		row := make([]any, len(values))
+		for i, value := range values {
+			row[i] = jsonValue(value)
+		}
+		chunk = append(chunk, row)
+		total++
+		if len(chunk) == s.chunkSize {
+			if err := stream.Send(ctx, Chunk{Rows: chunk}); err != nil {
+				return err
+			}
+			chunk = make([][]any, 0, s.chunkSize)
+		}
+		if s.maxRows > 0 && total >= s.maxRows {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return queryError(err)
+	}
+	if len(chunk) > 0 {
+		return stream.Send(ctx, Chunk{Rows: chunk})
+	}
+	return nil
+}
+
+// Exec runs a statement that returns no rows.
+func (s *Service) Exec(ctx context.Context, query string, params []any) (ExecResult, error) {
+	if s.readOnly {
+		return ExecResult{}, &kkrpc.RpcError{Name: "PermissionError", Message: ErrReadOnly.Error()}
+	}
+	if err := s.check(ctx, query); err != nil {
+		return ExecResult{}, err
+	}
+	result, err := s.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return ExecResult{}, queryError(err)
+	}
+	var out ExecResult
+	// Not every driver reports both; missing counts stay zero.
+	out.RowsAffected, _ = result.RowsAffected()
+	out.LastInsertID, _ = result.LastInsertId()
+	return out, nil
+}
+
+func (s *Service) check(ctx context.Context, query string) error {
+	if s.allow == nil {
+		return nil
+	}
+	if err := s.allow(ctx, query); err != nil {
+		return &kkrpc.RpcError{Name: "PermissionError", Message: err.Error()}
+	}
+	return nil
+}
+
+func columnsOf(rows *sql.Rows) ([]Column, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, len(types))
+	for i, columnType := range types {
+		columns[i] = Column{Name: columnType.Name(), Type: columnType.DatabaseTypeName()}
+	}
+	return columns, nil
+}
+
+// jsonValue converts the values drivers scan into types JSON carries
+// faithfully: text stays text and times become RFC 3339 strings.
+func jsonValue(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
+
+func queryError(err error) error {
+	return &kkrpc.RpcError{Name: "QueryError", Message: err.Error()}
+}
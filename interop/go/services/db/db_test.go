@@ -0,0 +1,187 @@
+//go:build !kkrpc_minimal
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// fakeDriver answers every query with as many (id, name) rows as its first
+// parameter asks for, and every exec with one affected row. In a read-only
+// transaction, queries other than SELECTs fail as a database would fail them.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+type fakeConn struct {
+	readOnly bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{conn: c, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("no transactions") }
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.readOnly = opts.ReadOnly
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error   { c.readOnly = false; return nil }
+func (c *fakeConn) Rollback() error { c.readOnly = false; return nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.conn.readOnly && !strings.HasPrefix(s.query, "SELECT") {
+		return nil, errors.New("attempt to write a readonly database")
+	}
+	count, _ := args[0].(float64)
+	return &fakeRows{count: int(count)}, nil
+}
+
+type fakeRows struct {
+	count int
+	next  int
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "name"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == r.count {
+		return io.EOF
+	}
+	r.next++
+	dest[0] = int64(r.next)
+	dest[1] = []byte(fmt.Sprintf("person %d", r.next))
+	return nil
+}
+
+func init() {
+	sql.Register("kkrpc-fake", fakeDriver{})
+}
+
+func serve(t *testing.T, opts Options) *kkrpc.Client {
+	database, err := sql.Open("kkrpc-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	opts.DB = database
+	service, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, map[string]any{"db": service.API()})
+	t.Cleanup(func() { server.Close() })
+	return kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+}
+
+func TestQueryStreamsRowsInChunks(t *testing.T) {
+	client := serve(t, Options{ChunkSize: 100})
+	result, err := client.Call("db.query", "SELECT id, name FROM people LIMIT ?", []any{250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := result.(*kkrpc.RemoteStream)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	first, err := chunks.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := first.(map[string]any)["columns"].([]any)
+	if len(columns) != 2 || columns[1].(map[string]any)["name"] != "name" {
+		t.Fatalf("columns %#v", columns)
+	}
+	var sizes []int
+	var last []any
+	for {
+		chunk, err := chunks.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := chunk.(map[string]any)["rows"].([]any)
+		sizes = append(sizes, len(rows))
+		last = rows[len(rows)-1].([]any)
+	}
+	if fmt.Sprint(sizes) != "[100 100 50]" {
+		t.Fatalf("chunk sizes %v", sizes)
+	}
+	if last[0] != float64(250) || last[1] != "person 250" {
+		t.Fatalf("last row %#v", last)
+	}
+}
+
+func TestExecHonoursReadOnly(t *testing.T) {
+	var rpcErr *kkrpc.RpcError
+	readOnly := serve(t, Options{ReadOnly: true})
+	if _, err := readOnly.Call("db.exec", "DELETE FROM people", []any{}); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("read-only exec: %v", err)
+	}
+	writable := serve(t, Options{})
+	result, err := writable.Call("db.exec", "DELETE FROM people WHERE id = ?", []any{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.(map[string]any)["rowsAffected"] != float64(1) {
+		t.Fatalf("result %#v", result)
+	}
+}
+
+func TestQueryHonoursReadOnly(t *testing.T) {
+	var rpcErr *kkrpc.RpcError
+	readOnly := serve(t, Options{ReadOnly: true})
+	if _, err := readOnly.Call("db.query", "DELETE FROM people RETURNING id", []any{1}); !errors.As(err, &rpcErr) || rpcErr.Name != "QueryError" {
+		t.Fatalf("write through a read-only query: %v", err)
+	}
+	result, err := readOnly.Call("db.query", "SELECT id, name FROM people LIMIT ?", []any{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := result.(*kkrpc.RemoteStream)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rows := 0
+	for {
+		chunk, err := chunks.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunkRows, ok := chunk.(map[string]any)["rows"].([]any); ok {
+			rows += len(chunkRows)
+		}
+	}
+	if rows != 3 {
+		t.Fatalf("read-only select returned %d rows", rows)
+	}
+}
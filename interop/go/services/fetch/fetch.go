@@ -0,0 +1,252 @@
+//go:build !kkrpc_minimal
+
+// Package fetch lets kkrpc peers make HTTP requests through the Go process,
+// for browser contexts that CORS keeps from reaching an origin directly.
+// Response bodies are streamed back in chunks and closing the stream cancels
+// the request.
+//
+// Only allow-listed hosts can be reached, and loopback, private and
+// link-local addresses are refused unless AllowPrivate is set, so a peer
+// cannot use the proxy to probe the network it runs in.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+const (
+	// DefaultChunkSize is the largest body chunk sent in one stream item.
+	DefaultChunkSize = 32 << 10
+	// DefaultMaxBodyBytes bounds the body streamed for one response.
+	DefaultMaxBodyBytes = 64 << 20
+	maxRedirects        = 10
+)
+
+var ErrPrivateAddress = errors.New("address is private")
+
+type Options struct {
+	// Hosts lists the hosts callers may fetch from; "*.example.com" matches
+	// subdomains. A service without hosts refuses every request.
+	Hosts []string
+	// AllowPrivate permits loopback, private and link-local addresses.
+	AllowPrivate bool
+	// Allow, when set, additionally vets each request before it is sent.
+	Allow func(ctx context.Context, req *http.Request) error
+	// ChunkSize defaults to DefaultChunkSize.
+	ChunkSize int
+	// MaxBodyBytes defaults to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// Timeout bounds the whole exchange, body included, when > 0.
+	Timeout time.Duration
+}
+
+// Init is the optional second argument of fetch, like the fetch API's.
+type Init struct {
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// Event is one item of a fetch stream: first a "response" with the status
+// and headers, then "body" chunks. Chunks travel as base64 strings in JSON
+// mode and as binary with the msgpack codec.
+type Event struct {
+	Type       string              `json:"type"`
+	Status     int                 `json:"status,omitempty"`
+	StatusText string              `json:"statusText,omitempty"`
+	URL        string              `json:"url,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Chunk      []byte              `json:"chunk,omitempty"`
+}
+
+type Service struct {
+	hosts        []string
+	allow        func(ctx context.Context, req *http.Request) error
+	chunkSize    int
+	maxBodyBytes int64
+	timeout      time.Duration
+	client       *http.Client
+}
+
+func New(opts Options) *Service {
+	service := &Service{
+		hosts:        opts.Hosts,
+		allow:        opts.Allow,
+		chunkSize:    opts.ChunkSize,
+		maxBodyBytes: opts.MaxBodyBytes,
+		timeout:      opts.Timeout,
+	}
+	if service.chunkSize <= 0 {
+		service.chunkSize = DefaultChunkSize
+	}
+	if service.maxBodyBytes <= 0 {
+		service.maxBodyBytes = DefaultMaxBodyBytes
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !opts.AllowPrivate {
+		// Checking the connected address, not the name, also covers DNS
+		// answers that point a public name at a private address.
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return fmt.Errorf("%s: %w", host, ErrPrivateAddress)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	service.client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return service.check(req.Context(), req)
+		},
+	}
+	return service
+}
+
+// API returns the methods to mount, e.g. under "net".
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"fetch": kkrpc.MustFunc(s.Fetch),
+	}
+}
+
+// Fetch sends the request and streams the response. Non-2xx statuses are
+// not errors; the caller reads them from the first event.
+func (s *Service) Fetch(ctx context.Context, rawURL string, init Init) (*kkrpc.Stream, error) {
+	method := strings.ToUpper(init.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	// The body is read after the call has returned, so the request must not
+	// be bound to the request context.
+	requestCtx, cancel := context.WithCancel(context.Background())
+	if s.timeout > 0 {
+		requestCtx, cancel = context.WithTimeout(context.Background(), s.timeout)
+	}
+	var body io.Reader
+	if init.Body != "" {
+		body = strings.NewReader(init.Body)
+	}
+	req, err := http.NewRequestWithContext(requestCtx, method, rawURL, body)
+	if err != nil {
+		cancel()
+		return nil, &kkrpc.RpcError{Name: "TypeError", Message: err.Error()}
+	}
+	for name, value := range init.Headers {
+		req.Header.Set(name, value)
+	}
+	if err := s.check(ctx, req); err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		var rpcErr *kkrpc.RpcError
+		if errors.As(err, &rpcErr) {
+			return nil, rpcErr
+		}
+		if errors.Is(err, ErrPrivateAddress) {
+			return nil, &kkrpc.RpcError{Name: "PermissionError", Message: err.Error()}
+		}
+		return nil, &kkrpc.RpcError{Name: "NetworkError", Message: err.Error()}
+	}
+	stream := kkrpc.NewStream()
+	go func() {
+		<-stream.Done()
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		_ = stream.Close(s.send(stream, resp))
+	}()
+	return stream, nil
+}
+
+func (s *Service) send(stream *kkrpc.Stream, resp *http.Response) error {
+	ctx := context.Background()
+	first := Event{
+		Type:       "response",
+		Status:     resp.StatusCode,
+		StatusText: strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		URL:        resp.Request.URL.String(),
+		Headers:    resp.Header,
+	}
+	if err := stream.Send(ctx, first); err != nil {
+		return err
+	}
+	reader := io.LimitReader(resp.Body, s.maxBodyBytes+1)
+	var sent int64
+	for {
+		// Forward whatever has arrived instead of waiting for a full chunk,
+		// so slow and long-lived responses stream through.
+		buffer := make([]byte, s.chunkSize)
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			sent += int64(n)
+			if sent > s.maxBodyBytes {
+				return &kkrpc.RpcError{Name: "RangeError", Message: fmt.Sprintf("response body exceeds %d bytes", s.maxBodyBytes)}
+			}
+			if err := stream.Send(ctx, Event{Type: "body", Chunk: buffer[:n]}); err != nil {
+				return err
+			}
+		}
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return &kkrpc.RpcError{Name: "NetworkError", Message: err.Error()}
+		}
+	}
+}
+
+// check vets the first request and every redirect.
+func (s *Service) check(ctx context.Context, req *http.Request) error {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("scheme %q is not allowed", req.URL.Scheme)}
+	}
+	if !s.hostAllowed(req.URL) {
+		return &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("host %q is not allowed", req.URL.Hostname())}
+	}
+	if s.allow != nil {
+		if err := s.allow(ctx, req); err != nil {
+			return &kkrpc.RpcError{Name: "PermissionError", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+func (s *Service) hostAllowed(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range s.hosts {
+		pattern = strings.ToLower(pattern)
+		if pattern == host {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
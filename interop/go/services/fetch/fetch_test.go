@@ -0,0 +1,142 @@
+//go:build !kkrpc_minimal
+
+package fetch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func serve(t *testing.T, opts Options) *kkrpc.Client {
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, map[string]any{"net": New(opts).API()})
+	t.Cleanup(func() { server.Close() })
+	return kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+}
+
+func next(t *testing.T, stream *kkrpc.RemoteStream) map[string]any {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	value, err := stream.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value.(map[string]any)
+}
+
+func TestFetchStreamsBodyInChunks(t *testing.T) {
+	body := strings.Repeat("kkrpc ", 2000)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, body)
+	}))
+	defer origin.Close()
+	client := serve(t, Options{Hosts: []string{"127.0.0.1"}, AllowPrivate: true, ChunkSize: 4096})
+
+	result, err := client.Call("net.fetch", origin.URL, map[string]any{"method": "post", "body": "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := result.(*kkrpc.RemoteStream)
+	first := next(t, stream)
+	if first["status"] != float64(201) || first["statusText"] != "Created" {
+		t.Fatalf("response %#v", first)
+	}
+	if methods := first["headers"].(map[string]any)["X-Method"].([]any); methods[0] != "POST" {
+		t.Fatalf("headers %#v", first["headers"])
+	}
+	var received strings.Builder
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		value, err := stream.Next(ctx)
+		cancel()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := base64.StdEncoding.DecodeString(value.(map[string]any)["chunk"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunk) > 4096 {
+			t.Fatalf("chunk of %d bytes", len(chunk))
+		}
+		received.Write(chunk)
+	}
+	if received.String() != body {
+		t.Fatalf("received %d bytes", received.Len())
+	}
+}
+
+func TestFetchRefusesUnlistedAndPrivateHosts(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	var rpcErr *kkrpc.RpcError
+
+	unlisted := serve(t, Options{Hosts: []string{"example.com"}, AllowPrivate: true})
+	if _, err := unlisted.Call("net.fetch", origin.URL); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("unlisted host: %v", err)
+	}
+	private := serve(t, Options{Hosts: []string{"127.0.0.1"}})
+	if _, err := private.Call("net.fetch", origin.URL); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("private address: %v", err)
+	}
+}
+
+func TestClosingStreamCancelsRequest(t *testing.T) {
+	cancelled := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer origin.Close()
+	client := serve(t, Options{Hosts: []string{"127.0.0.1"}, AllowPrivate: true})
+
+	result, err := client.Call("net.fetch", origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := result.(*kkrpc.RemoteStream)
+	next(t, stream)
+	next(t, stream)
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("origin request was not cancelled")
+	}
+}
+
+func TestHostPatterns(t *testing.T) {
+	service := New(Options{Hosts: []string{"api.example.com", "*.cdn.example.com"}})
+	for host, want := range map[string]bool{
+		"api.example.com":      true,
+		"API.example.com":      true,
+		"img.cdn.example.com":  true,
+		"cdn.example.com":      false,
+		"evil.com":             false,
+		"api.example.com.evil": false,
+	} {
+		u, _ := http.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		if got := service.hostAllowed(u.URL); got != want {
+			t.Errorf("%s: got %v", host, got)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+//go:build !kkrpc_minimal
+
+// Package fswatch exposes file watching over kkrpc. Each watch call returns a
+// stream of change events that ends when the caller closes it, and only paths
+// under the configured roots can be watched.
+//
+// Changes are detected by polling unless Options.Watcher says otherwise.
+// Polling keeps the module free of external dependencies and behaves the same
+// on every platform and filesystem; the fswatch/native module watches with OS
+// notifications instead.
+package fswatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+const (
+	// DefaultInterval is how often watched paths are rescanned.
+	DefaultInterval = 500 * time.Millisecond
+	// DefaultMaxEntries bounds how many files one watch may track.
+	DefaultMaxEntries = 10000
+)
+
+var ErrOutsideRoots = errors.New("path is outside the watchable roots")
+
+const (
+	OpCreate = "create"
+	OpWrite  = "write"
+	OpRemove = "remove"
+)
+
+// Event is one change seen under a watched path.
+type Event struct {
+	Path  string `json:"path"`
+	Op    string `json:"op"`
+	IsDir bool   `json:"isDir"`
+}
+
+// WatchOptions are the optional second argument of watch.
+type WatchOptions struct {
+	Recursive bool `json:"recursive"`
+}
+
+// Watcher detects the changes under one path for a Service.
+type Watcher interface {
+	// Watch sets up a watch on root, a resolved path under the roots, and
+	// returns the function running it. run calls send for each change until
+	// ctx is done or send fails, returning nil. When root is removed it sends
+	// a remove event for root and returns nil; any other failure ends the
+	// watch with an error.
+	Watch(root string, recursive bool) (run func(ctx context.Context, send func(Event) error) error, err error)
+}
+
+type Options struct {
+	// Roots are the directories callers may watch, including everything below
+	// them. A service without roots refuses every watch.
+	Roots []string
+	// Allow, when set, additionally vets each watch, e.g. against the caller
+	// identity carried in the request metadata.
+	Allow func(ctx context.Context, path string) error
+	// Watcher, when set, replaces polling.
+	Watcher Watcher
+	// Interval is how often polling rescans; it defaults to DefaultInterval.
+	Interval time.Duration
+	// MaxEntries bounds polling; it defaults to DefaultMaxEntries.
+	MaxEntries int
+}
+
+type Service struct {
+	roots   []string
+	allow   func(ctx context.Context, path string) error
+	watcher Watcher
+}
+
+func New(opts Options) (*Service, error) {
+	service := &Service{allow: opts.Allow, watcher: opts.Watcher}
+	if service.watcher == nil {
+		poll := &poller{interval: opts.Interval, maxEntries: opts.MaxEntries}
+		if poll.interval <= 0 {
+			poll.interval = DefaultInterval
+		}
+		if poll.maxEntries <= 0 {
+			poll.maxEntries = DefaultMaxEntries
+		}
+		service.watcher = poll
+	}
+	for _, root := range opts.Roots {
+		resolved, err := resolve(root)
+		if err != nil {
+			return nil, fmt.Errorf("fswatch: root %s: %w", root, err)
+		}
+		service.roots = append(service.roots, resolved)
+	}
+	return service, nil
+}
+
+// API returns the methods to mount, e.g. under "fs":
+//
+//	api := map[string]any{"fs": service.API()}
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"watch": kkrpc.MustFunc(s.Watch),
+		"stat":  kkrpc.MustFunc(s.Stat),
+	}
+}
+
+// Watch streams changes to path, which may be a file or a directory. The
+// stream ends once path is removed, and with an error if the watcher fails,
+// e.g. when polling finds path unreadable or past the entry limit.
+func (s *Service) Watch(ctx context.Context, path string, opts WatchOptions) (*kkrpc.Stream, error) {
+	resolved, err := s.authorize(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	run, err := s.watcher.Watch(resolved, opts.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	stream := kkrpc.NewStream()
+	go func() {
+		watchCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-stream.Done()
+			cancel()
+		}()
+		err := run(watchCtx, func(event Event) error { return stream.Send(watchCtx, event) })
+		if watchCtx.Err() == nil {
+			_ = stream.Close(err)
+		}
+	}()
+	return stream, nil
+}
+
+func (s *Service) authorize(ctx context.Context, path string) (string, error) {
+	resolved, err := resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if !s.withinRoots(resolved) {
+		return "", &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("%s: %v", path, ErrOutsideRoots)}
+	}
+	if s.allow != nil {
+		if err := s.allow(ctx, resolved); err != nil {
+			return "", err
+		}
+	}
+	return resolved, nil
+}
+
+func (s *Service) withinRoots(path string) bool {
+	for _, root := range s.roots {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve makes path absolute and follows symlinks so a link inside a root
+// cannot expose a target outside it.
+func resolve(path string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(absolute)
+}
+
+// poller is the default Watcher, rescanning root every interval.
+type poller struct {
+	interval   time.Duration
+	maxEntries int
+}
+
+type entry struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+func (p *poller) Watch(root string, recursive bool) (func(ctx context.Context, send func(Event) error) error, error) {
+	snapshot, err := p.scan(root, recursive)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, send func(Event) error) error {
+		return p.poll(ctx, send, root, recursive, snapshot)
+	}, nil
+}
+
+func (p *poller) scan(root string, recursive bool) (map[string]entry, error) {
+	snapshot := make(map[string]entry)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && errors.Is(err, fs.ErrNotExist) {
+				return nil // removed while scanning
+			}
+			return err
+		}
+		if path != root && d.IsDir() && !recursive {
+			snapshot[path] = entry{isDir: true}
+			return fs.SkipDir
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		snapshot[path] = entry{modTime: info.ModTime(), size: info.Size(), isDir: d.IsDir()}
+		if len(snapshot) > p.maxEntries {
+			return fmt.Errorf("fswatch: more than %d entries under %s", p.maxEntries, root)
+		}
+		return nil
+	})
+	return snapshot, err
+}
+
+func (p *poller) poll(ctx context.Context, send func(Event) error, root string, recursive bool, previous map[string]entry) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		current, err := p.scan(root, recursive)
+		if errors.Is(err, fs.ErrNotExist) {
+			// The watched path itself is gone: report it and end the stream.
+			_ = send(Event{Path: root, Op: OpRemove, IsDir: previous[root].isDir})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, event := range diff(previous, current) {
+			if err := send(event); err != nil {
+				return nil
+			}
+		}
+		previous = current
+	}
+}
+
+func diff(previous, current map[string]entry) []Event {
+	var events []Event
+	for path, now := range current {
+		before, existed := previous[path]
+		switch {
+		case !existed:
+			events = append(events, Event{Path: path, Op: OpCreate, IsDir: now.isDir})
+		case !now.isDir && (!now.modTime.Equal(before.modTime) || now.size != before.size):
+			events = append(events, Event{Path: path, Op: OpWrite})
+		}
+	}
+	for path, before := range previous {
+		if _, exists := current[path]; !exists {
+			events = append(events, Event{Path: path, Op: OpRemove, IsDir: before.isDir})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// Stat describes path, subject to the same permission checks as Watch.
+func (s *Service) Stat(ctx context.Context, path string) (map[string]any, error) {
+	resolved, err := s.authorize(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"path": resolved, "isDir": info.IsDir(), "size": info.Size(), "modTime": info.ModTime().UnixMilli()}, nil
+}
@@ -0,0 +1,126 @@
+//go:build !kkrpc_minimal
+
+package fswatch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func TestWatchStreamsChangesUnderRoot(t *testing.T) {
+	root := t.TempDir()
+	service, err := New(Options{Roots: []string{root}, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, map[string]any{"fs": service.API()})
+	client := kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+	defer server.Close()
+
+	var rpcErr *kkrpc.RpcError
+	if _, err := client.Call("fs.watch", os.TempDir()); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("outside root: %v", err)
+	}
+
+	result, err := client.Call("fs.watch", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := result.(*kkrpc.RemoteStream)
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	file := filepath.Join(resolvedRoot, "notes.txt")
+	if err := os.WriteFile(file, []byte("draft"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	event, err := events.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := event.(map[string]any)
+	if got["path"] != file || got["op"] != OpCreate {
+		t.Fatalf("event %#v", got)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	event, err = events.Next(ctx)
+	if err != nil || event.(map[string]any)["op"] != OpRemove {
+		t.Fatalf("remove event %#v %v", event, err)
+	}
+	if err := events.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffReportsWrites(t *testing.T) {
+	now := time.Now()
+	events := diff(
+		map[string]entry{"/a": {modTime: now, size: 1}, "/b": {modTime: now}},
+		map[string]entry{"/a": {modTime: now.Add(time.Second), size: 1}, "/c": {isDir: true}},
+	)
+	want := []Event{{Path: "/a", Op: OpWrite}, {Path: "/b", Op: OpRemove}, {Path: "/c", Op: OpCreate, IsDir: true}}
+	if len(events) != len(want) {
+		t.Fatalf("events %#v", events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d: %#v", i, events[i])
+		}
+	}
+}
+
+type scriptedWatcher struct {
+	events []Event
+	err    error
+}
+
+func (w scriptedWatcher) Watch(root string, recursive bool) (func(ctx context.Context, send func(Event) error) error, error) {
+	return func(ctx context.Context, send func(Event) error) error {
+		for _, event := range w.events {
+			if err := send(event); err != nil {
+				return nil
+			}
+		}
+		return w.err
+	}, nil
+}
+
+func TestWatchUsesConfiguredWatcher(t *testing.T) {
+	root := t.TempDir()
+	resolvedRoot, _ := filepath.EvalSymlinks(root)
+	service, err := New(Options{Roots: []string{root}, Watcher: scriptedWatcher{
+		events: []Event{{Path: filepath.Join(resolvedRoot, "a"), Op: OpCreate}},
+		err:    errors.New("watch limit reached"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, map[string]any{"fs": service.API()})
+	client := kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+	defer server.Close()
+
+	result, err := client.Call("fs.watch", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := result.(*kkrpc.RemoteStream)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if event, err := events.Next(ctx); err != nil || event.(map[string]any)["op"] != OpCreate {
+		t.Fatalf("event %#v %v", event, err)
+	}
+	if _, err := events.Next(ctx); err == nil || !strings.Contains(err.Error(), "watch limit reached") {
+		t.Fatalf("expected the watcher's error to end the stream, got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+module kkrpc-interop/services/fswatch/native
+
+go 1.21
+
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	kkrpc-interop v0.0.0
+)
+
+require golang.org/x/sys v0.4.0 // indirect
+
+replace kkrpc-interop => ../../..
@@ -0,0 +1,180 @@
+//go:build !kkrpc_minimal
+
+// Package native is an fswatch.Watcher driven by the operating system's file
+// notifications (inotify, kqueue, ReadDirectoryChangesW) through fsnotify, so
+// changes arrive as they happen instead of at the next poll:
+//
+//	service, err := fswatch.New(fswatch.Options{Roots: roots, Watcher: &native.Watcher{}})
+//
+// It lives in its own module so that fswatch and kkrpc stay free of external
+// dependencies.
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+
+	"kkrpc-interop/services/fswatch"
+)
+
+// Watcher watches with fsnotify. fsnotify watches one directory at a time, so
+// a recursive watch adds every directory below the watched one, and the
+// directories created later as they appear.
+type Watcher struct {
+	// MaxDirs bounds how many directories one watch may add; it defaults to
+	// fswatch.DefaultMaxEntries. Operating systems cap watches per user too,
+	// e.g. fs.inotify.max_user_watches on Linux.
+	MaxDirs int
+}
+
+func (w *Watcher) Watch(root string, recursive bool) (func(ctx context.Context, send func(fswatch.Event) error) error, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	t := &tree{notify: notify, root: root, recursive: recursive && info.IsDir(), dirs: make(map[string]bool), maxDirs: w.MaxDirs}
+	if t.maxDirs <= 0 {
+		t.maxDirs = fswatch.DefaultMaxEntries
+	}
+	if err := t.add(root, nil); err != nil {
+		notify.Close()
+		return nil, err
+	}
+	return func(ctx context.Context, send func(fswatch.Event) error) error {
+		defer notify.Close()
+		return t.run(ctx, send)
+	}, nil
+}
+
+// tree is the state of one watch.
+type tree struct {
+	notify    *fsnotify.Watcher
+	root      string
+	recursive bool
+	// dirs holds the watched directories.
+	dirs    map[string]bool
+	maxDirs int
+}
+
+// add watches path and, for a recursive watch, the directories below it,
+// calling found for each entry below path.
+func (t *tree) add(path string, found func(path string, isDir bool) error) error {
+	if !t.recursive {
+		if err := t.notify.Add(path); err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			t.dirs[path] = true
+		}
+		return nil
+	}
+	return filepath.WalkDir(path, func(entry string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != path && errors.Is(err, fs.ErrNotExist) {
+				return nil // removed while walking
+			}
+			return err
+		}
+		if entry != path && found != nil {
+			if err := found(entry, d.IsDir()); err != nil {
+				return err
+			}
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if len(t.dirs) >= t.maxDirs {
+			return fmt.Errorf("fswatch: more than %d directories under %s", t.maxDirs, t.root)
+		}
+		if err := t.notify.Add(entry); err != nil {
+			return err
+		}
+		t.dirs[entry] = true
+		return nil
+	})
+}
+
+func (t *tree) run(ctx context.Context, send func(fswatch.Event) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-t.notify.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case event, ok := <-t.notify.Events:
+			if !ok {
+				return nil
+			}
+			done, err := t.handle(event, send)
+			if done || err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle reports one notification, returning true once the watch is over.
+func (t *tree) handle(event fsnotify.Event, send func(fswatch.Event) error) (bool, error) {
+	path := event.Name
+	switch {
+	case event.Has(fsnotify.Create):
+		info, err := os.Lstat(path)
+		if err != nil {
+			return false, nil // already gone again
+		}
+		if err := send(fswatch.Event{Path: path, Op: fswatch.OpCreate, IsDir: info.IsDir()}); err != nil {
+			return true, nil
+		}
+		if !info.IsDir() || !t.recursive {
+			return false, nil
+		}
+		// Entries created before the directory was watched have no events.
+		stopped := errors.New("send failed")
+		err = t.add(path, func(entry string, isDir bool) error {
+			if send(fswatch.Event{Path: entry, Op: fswatch.OpCreate, IsDir: isDir}) != nil {
+				return stopped
+			}
+			return nil
+		})
+		if errors.Is(err, stopped) {
+			return true, nil
+		}
+		return false, err
+	case event.Has(fsnotify.Write):
+		return send(fswatch.Event{Path: path, Op: fswatch.OpWrite}) != nil, nil
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		isDir := t.dirs[path]
+		t.forget(path)
+		if err := send(fswatch.Event{Path: path, Op: fswatch.OpRemove, IsDir: isDir}); err != nil {
+			return true, nil
+		}
+		// The watched path itself is gone: end the stream.
+		return path == t.root, nil
+	}
+	return false, nil
+}
+
+// forget drops path and the directories below it; the OS removed their
+// watches along with them.
+func (t *tree) forget(path string) {
+	prefix := path + string(filepath.Separator)
+	for dir := range t.dirs {
+		if dir == path || strings.HasPrefix(dir, prefix) {
+			delete(t.dirs, dir)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+//go:build !kkrpc_minimal
+
+package native
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+	"kkrpc-interop/services/fswatch"
+)
+
+func TestWatchReportsChangesAsTheyHappen(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := fswatch.New(fswatch.Options{Roots: []string{root}, Watcher: &Watcher{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, map[string]any{"fs": service.API()})
+	client := kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+	defer server.Close()
+
+	result, err := client.Call("fs.watch", root, map[string]any{"recursive": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := result.(*kkrpc.RemoteStream)
+	defer events.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	next := func(op, path string) {
+		t.Helper()
+		// Some platforms report a write along with a create; skip those.
+		for {
+			event, err := events.Next(ctx)
+			if err != nil {
+				t.Fatalf("waiting for %s %s: %v", op, path, err)
+			}
+			got := event.(map[string]any)
+			if got["op"] == op && got["path"] == path {
+				return
+			}
+			if got["op"] != fswatch.OpWrite {
+				t.Fatalf("waiting for %s %s, got %#v", op, path, got)
+			}
+		}
+	}
+
+	dir := filepath.Join(root, "drafts")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	next(fswatch.OpCreate, dir)
+	// The new directory is watched too.
+	file := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(file, []byte("draft"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	next(fswatch.OpCreate, file)
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	next(fswatch.OpRemove, file)
+}
+
+func TestWatchEndsWhenRootIsRemoved(t *testing.T) {
+	parent, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(parent, "watched")
+	if err := os.Mkdir(root, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	run, err := (&Watcher{}).Watch(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan fswatch.Event, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- run(context.Background(), func(event fswatch.Event) error {
+			sent <- event
+			return nil
+		})
+	}()
+	if err := os.Remove(root); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch did not end")
+	}
+	close(sent)
+	var last fswatch.Event
+	for event := range sent {
+		last = event
+	}
+	if last.Path != root || last.Op != fswatch.OpRemove {
+		t.Fatalf("last event %#v", last)
+	}
+}
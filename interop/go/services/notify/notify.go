@@ -0,0 +1,103 @@
+//go:build !kkrpc_minimal
+
+// Package notify shows desktop notifications on behalf of kkrpc peers, using
+// notify-send on Linux and osascript on macOS.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// MaxLength bounds the title and the body of a notification.
+const MaxLength = 1024
+
+var ErrUnavailable = errors.New("no notification tool found")
+
+// Notification is the argument of show.
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+}
+
+type Options struct {
+	// Command overrides the detected tool, as argv; the title and the body
+	// are appended as the last two arguments.
+	Command []string
+	// Allow, when set, vets each notification, e.g. to rate-limit a peer.
+	Allow func(ctx context.Context, n Notification) error
+}
+
+type Service struct {
+	command []string
+	allow   func(ctx context.Context, n Notification) error
+}
+
+// New fails with ErrUnavailable when the platform has no supported tool.
+func New(opts Options) (*Service, error) {
+	service := &Service{command: opts.Command, allow: opts.Allow}
+	if service.command == nil {
+		service.command = detect()
+	}
+	if service.command == nil {
+		return nil, ErrUnavailable
+	}
+	return service, nil
+}
+
+func detect() []string {
+	var command []string
+	switch runtime.GOOS {
+	case "darwin":
+		// The texts arrive as script arguments, so they need no quoting.
+		command = []string{"osascript", "-e", "on run argv", "-e",
+			"display notification (item 2 of argv) with title (item 1 of argv)", "-e", "end run"}
+	case "windows":
+		return nil
+	default:
+		command = []string{"notify-send", "--"}
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil
+	}
+	return command
+}
+
+// API returns the methods to mount, e.g. under "notify".
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"show": kkrpc.MustFunc(s.Show),
+	}
+}
+
+func (s *Service) Show(ctx context.Context, n Notification) error {
+	if n.Title == "" {
+		return &kkrpc.RpcError{Name: "TypeError", Message: "notification title is required"}
+	}
+	if len(n.Title) > MaxLength || len(n.Body) > MaxLength {
+		return &kkrpc.RpcError{Name: "TypeError", Message: fmt.Sprintf("notification text exceeds %d bytes", MaxLength)}
+	}
+	if s.allow != nil {
+		if err := s.allow(ctx, n); err != nil {
+			return err
+		}
+	}
+	args := append(append([]string(nil), s.command[1:]...), n.Title, n.Body)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command[0], args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%v: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
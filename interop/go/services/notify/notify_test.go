@@ -0,0 +1,28 @@
+//go:build !kkrpc_minimal
+
+package notify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShowPassesTitleAndBodyAsArguments(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "shown")
+	service, err := New(Options{Command: []string{"sh", "-c", `printf '%s|%s' "$1" "$2" > "$0"`, file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Show(context.Background(), Notification{Title: `Build "main"`, Body: "$(done)"}); err != nil {
+		t.Fatal(err)
+	}
+	shown, err := os.ReadFile(file)
+	if err != nil || string(shown) != `Build "main"|$(done)` {
+		t.Fatalf("shown %q %v", shown, err)
+	}
+	if err := service.Show(context.Background(), Notification{Body: "untitled"}); err == nil {
+		t.Fatal("notification without title was shown")
+	}
+}
@@ -0,0 +1,90 @@
+//go:build !kkrpc_minimal
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var ErrUnavailable = errors.New("no keyring tool found")
+
+// Keychain stores secrets in the OS keyring under one service name, through
+// the macOS security tool or libsecret's secret-tool on Linux.
+//
+// On macOS the security tool takes the value as an argument, so it is
+// briefly visible to other processes of the same user; secret-tool reads it
+// from stdin.
+type Keychain struct {
+	service string
+	tool    string
+}
+
+// NewKeychain fails with ErrUnavailable when the platform tool is missing.
+func NewKeychain(service string) (*Keychain, error) {
+	tool := "secret-tool"
+	switch runtime.GOOS {
+	case "darwin":
+		tool = "security"
+	case "windows":
+		return nil, ErrUnavailable
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, ErrUnavailable
+	}
+	return &Keychain{service: service, tool: tool}, nil
+}
+
+func (k *Keychain) Get(ctx context.Context, key string) (string, error) {
+	if k.tool == "security" {
+		out, err := k.run(ctx, "", "find-generic-password", "-s", k.service, "-a", key, "-w")
+		return strings.TrimSuffix(out, "\n"), err
+	}
+	out, err := k.run(ctx, "", "lookup", "service", k.service, "key", key)
+	if err == nil && out == "" {
+		return "", ErrNotFound
+	}
+	return out, err
+}
+
+func (k *Keychain) Set(ctx context.Context, key string, value string) error {
+	if k.tool == "security" {
+		_, err := k.run(ctx, "", "add-generic-password", "-U", "-s", k.service, "-a", key, "-w", value)
+		return err
+	}
+	_, err := k.run(ctx, value, "store", "--label", k.service+" "+key, "service", k.service, "key", key)
+	return err
+}
+
+func (k *Keychain) Delete(ctx context.Context, key string) error {
+	if k.tool == "security" {
+		_, err := k.run(ctx, "", "delete-generic-password", "-s", k.service, "-a", key)
+		return err
+	}
+	_, err := k.run(ctx, "", "clear", "service", k.service, "key", key)
+	return err
+}
+
+// run maps the tools' "not found" exits (44 for security, 1 with no output
+// for secret-tool lookup) to ErrNotFound.
+func (k *Keychain) run(ctx context.Context, stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, k.tool, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		if (k.tool == "security" && code == 44) || (k.tool == "secret-tool" && code == 1 && stdout.Len() == 0 && stderr.Len() == 0) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("%s %s: %v: %s", k.tool, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), err
+}
@@ -0,0 +1,172 @@
+//go:build !kkrpc_minimal
+
+// Package secrets exposes a keyring over kkrpc with per-caller access rules.
+//
+// Request metadata is asserted by the peer and proves nothing, so the caller
+// identity is not read from it. Instead the application authenticates each
+// connection (a session cookie on the WebSocket upgrade, a token on a
+// stdio handshake, ...) and mounts API(caller) for that connection only:
+//
+//	http.Handle("/rpc", authenticated(func(user string, conn *kkrpc.WebSocketTransport) {
+//		kkrpc.NewServer(conn, map[string]any{"secrets": vault.API(user)})
+//	}))
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+
+	"kkrpc-interop/kkrpc"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Store holds the secrets. Keychain is backed by the OS keyring;
+// NewMemoryStore suits tests.
+type Store interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Rule grants Caller (or everyone, with "*") access to the keys matching
+// any of Keys, which are path.Match patterns such as "github/*".
+type Rule struct {
+	Caller string
+	Keys   []string
+	Read   bool
+	Write  bool
+}
+
+type Options struct {
+	// Store is where secrets live. Required.
+	Store Store
+	// Rules are checked in order and access is denied unless one grants it.
+	Rules []Rule
+	// Audit, when set, is told about every access attempt, without the value.
+	Audit func(caller string, op string, key string, err error)
+}
+
+type Service struct {
+	store Store
+	rules []Rule
+	audit func(caller string, op string, key string, err error)
+}
+
+func New(opts Options) (*Service, error) {
+	if opts.Store == nil {
+		return nil, errors.New("secrets: Options.Store is required")
+	}
+	for _, rule := range opts.Rules {
+		for _, pattern := range rule.Keys {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("secrets: rule key %q: %w", pattern, err)
+			}
+		}
+	}
+	return &Service{store: opts.Store, rules: opts.Rules, audit: opts.Audit}, nil
+}
+
+// API returns the methods to mount for an authenticated caller, e.g. under
+// "secrets".
+func (s *Service) API(caller string) map[string]any {
+	return map[string]any{
+		"get": kkrpc.MustFunc(func(ctx context.Context, key string) (string, error) {
+			if err := s.authorize(caller, "get", key); err != nil {
+				return "", err
+			}
+			value, err := s.store.Get(ctx, key)
+			s.record(caller, "get", key, err)
+			return value, storeError(err)
+		}),
+		"set": kkrpc.MustFunc(func(ctx context.Context, key string, value string) error {
+			if err := s.authorize(caller, "set", key); err != nil {
+				return err
+			}
+			err := s.store.Set(ctx, key, value)
+			s.record(caller, "set", key, err)
+			return storeError(err)
+		}),
+		"delete": kkrpc.MustFunc(func(ctx context.Context, key string) error {
+			if err := s.authorize(caller, "delete", key); err != nil {
+				return err
+			}
+			err := s.store.Delete(ctx, key)
+			s.record(caller, "delete", key, err)
+			return storeError(err)
+		}),
+	}
+}
+
+func (s *Service) authorize(caller string, op string, key string) error {
+	write := op != "get"
+	for _, rule := range s.rules {
+		if rule.Caller != "*" && rule.Caller != caller {
+			continue
+		}
+		if (write && !rule.Write) || (!write && !rule.Read) {
+			continue
+		}
+		for _, pattern := range rule.Keys {
+			if matched, _ := path.Match(pattern, key); matched {
+				return nil
+			}
+		}
+	}
+	err := &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("%s may not %s %q", caller, op, key)}
+	s.record(caller, op, key, err)
+	return err
+}
+
+func (s *Service) record(caller string, op string, key string, err error) {
+	if s.audit != nil {
+		s.audit(caller, op, key, err)
+	}
+}
+
+func storeError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return &kkrpc.RpcError{Name: "NotFound", Message: err.Error()}
+	}
+	return err
+}
+
+// MemoryStore keeps secrets in process memory.
+type MemoryStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: make(map[string]string)}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *MemoryStore) Set(ctx context.Context, key string, value string) error {
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.values, key)
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !kkrpc_minimal
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func invoke(t *testing.T, api map[string]any, method string, args ...any) (any, error) {
+	t.Helper()
+	return api[method].(*kkrpc.Func).Invoke(context.Background(), args)
+}
+
+func TestRulesScopeCallersToKeys(t *testing.T) {
+	var failures []string
+	vault, err := New(Options{
+		Store: NewMemoryStore(),
+		Rules: []Rule{
+			{Caller: "ci", Keys: []string{"github/*"}, Read: true, Write: true},
+			{Caller: "*", Keys: []string{"public/*"}, Read: true},
+		},
+		Audit: func(caller string, op string, key string, err error) {
+			if err != nil {
+				failures = append(failures, caller+" "+op+" "+key)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, ui := vault.API("ci"), vault.API("ui")
+
+	if _, err := invoke(t, ci, "set", "github/token", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := invoke(t, ci, "get", "github/token"); err != nil || value != "s3cret" {
+		t.Fatalf("get %v %v", value, err)
+	}
+	var rpcErr *kkrpc.RpcError
+	if _, err := invoke(t, ui, "get", "github/token"); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("ui read: %v", err)
+	}
+	if _, err := invoke(t, ui, "set", "public/banner", "hi"); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("ui write: %v", err)
+	}
+	if _, err := invoke(t, ui, "get", "public/banner"); !errors.As(err, &rpcErr) || rpcErr.Name != "NotFound" {
+		t.Fatalf("missing secret: %v", err)
+	}
+	if len(failures) != 3 || failures[0] != "ui get github/token" {
+		t.Fatalf("audit %v", failures)
+	}
+}
+
+func TestNewRejectsBadPatterns(t *testing.T) {
+	if _, err := New(Options{Store: NewMemoryStore(), Rules: []Rule{{Caller: "*", Keys: []string{"["}}}}); err == nil {
+		t.Fatal("malformed pattern accepted")
+	}
+}
@@ -0,0 +1,345 @@
+//go:build !kkrpc_minimal
+
+// Package shell lets kkrpc peers run allow-listed programs and stream their
+// output. Commands are executed directly from an argv list, never through a
+// shell, so arguments cannot smuggle in extra commands.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// outputChunk is the largest piece of output sent in one event.
+const outputChunk = 32 << 10
+
+// DefaultMaxOutput bounds what Run collects per stream.
+const DefaultMaxOutput = 1 << 20
+
+var ErrNotAllowed = errors.New("command not allowed")
+
+type Options struct {
+	// Commands lists the programs callers may run, by the name they pass or
+	// by absolute path. A service without commands refuses every spawn.
+	Commands []string
+	// Dirs restricts working directories to these trees when not empty.
+	Dirs []string
+	// Allow, when set, additionally vets each command line, with the
+	// environment variables the caller passes.
+	Allow func(ctx context.Context, command string, args []string, env map[string]string) error
+	// Env is the base environment of every process; defaults to os.Environ().
+	// Variables passed by callers are added on top.
+	Env []string
+	// EnvAllow names the environment variables callers may set. Any other is
+	// refused: variables like LD_PRELOAD, PATH or GIT_SSH_COMMAND would run
+	// code of the caller's choosing inside an allowed command.
+	EnvAllow []string
+	// Timeout kills processes that run longer, when > 0.
+	Timeout time.Duration
+	// MaxOutput bounds the stdout and stderr Run collects; defaults to
+	// DefaultMaxOutput.
+	MaxOutput int
+}
+
+// SpawnOptions are the optional last argument of spawn and run.
+type SpawnOptions struct {
+	Cwd   string            `json:"cwd,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+	Stdin string            `json:"stdin,omitempty"`
+}
+
+// Event is one item of a spawn stream. The first is "start" with the id to
+// pass to kill, then "stdout" and "stderr" chunks, and finally "exit".
+type Event struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"`
+	Data  string `json:"data,omitempty"`
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// Result is what run returns once the process has exited.
+type Result struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Code   int    `json:"code"`
+}
+
+type Service struct {
+	opts      Options
+	commands  map[string]bool
+	envAllow  map[string]bool
+	mu        sync.Mutex
+	processes map[string]context.CancelFunc
+}
+
+func New(opts Options) *Service {
+	if opts.Env == nil {
+		opts.Env = os.Environ()
+	}
+	if opts.MaxOutput <= 0 {
+		opts.MaxOutput = DefaultMaxOutput
+	}
+	service := &Service{
+		opts:      opts,
+		commands:  make(map[string]bool),
+		envAllow:  make(map[string]bool),
+		processes: make(map[string]context.CancelFunc),
+	}
+	for _, command := range opts.Commands {
+		service.commands[command] = true
+	}
+	for _, name := range opts.EnvAllow {
+		service.envAllow[name] = true
+	}
+	return service
+}
+
+// API returns the methods to mount, e.g. under "shell".
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"spawn": kkrpc.MustFunc(s.Spawn),
+		"run":   kkrpc.MustFunc(s.Run),
+		"kill":  kkrpc.MustFunc(s.Kill),
+	}
+}
+
+// Spawn starts command and streams its events. Closing the stream kills the
+// process.
+func (s *Service) Spawn(ctx context.Context, command string, args []string, opts SpawnOptions) (*kkrpc.Stream, error) {
+	cmd, cancel, err := s.prepare(ctx, command, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	id := s.track(cancel)
+	stream := kkrpc.NewStream()
+	go func() {
+		<-stream.Done()
+		cancel()
+	}()
+	go func() {
+		defer s.untrack(id)
+		defer cancel()
+		// Sends after the consumer has gone fail fast; the process is
+		// killed through stream.Done meanwhile.
+		sendCtx := context.Background()
+		if stream.Send(sendCtx, Event{Type: "start", ID: id}) != nil {
+			// Nobody is listening: kill the process, and still reap it
+			// and its pipes.
+			cancel()
+			_, _ = io.Copy(io.Discard, stdout)
+			_, _ = io.Copy(io.Discard, stderr)
+			_ = cmd.Wait()
+			return
+		}
+		var pipes sync.WaitGroup
+		var sendMu sync.Mutex
+		forward := func(kind string, reader io.Reader) {
+			defer pipes.Done()
+			buffer := make([]byte, outputChunk)
+			for {
+				n, err := reader.Read(buffer)
+				if n > 0 {
+					sendMu.Lock()
+					sendErr := stream.Send(sendCtx, Event{Type: kind, Data: string(buffer[:n])})
+					sendMu.Unlock()
+					if sendErr != nil {
+						cancel()
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}
+		pipes.Add(2)
+		go forward("stdout", stdout)
+		go forward("stderr", stderr)
+		pipes.Wait()
+		exit := exitEvent(cmd.Wait())
+		if stream.Send(sendCtx, exit) == nil {
+			_ = stream.Close(nil)
+		}
+	}()
+	return stream, nil
+}
+
+// Run starts command, waits for it and returns its collected output.
+func (s *Service) Run(ctx context.Context, command string, args []string, opts SpawnOptions) (Result, error) {
+	cmd, cancel, err := s.prepare(ctx, command, args, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer cancel()
+	stdout := &limitedBuffer{limit: s.opts.MaxOutput}
+	stderr := &limitedBuffer{limit: s.opts.MaxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		return Result{}, err
+	}
+	id := s.track(cancel)
+	defer s.untrack(id)
+	exit := exitEvent(cmd.Wait())
+	if exit.Error != "" && exit.Code < 0 {
+		return Result{}, errors.New(exit.Error)
+	}
+	return Result{Stdout: stdout.String(), Stderr: stderr.String(), Code: exit.Code}, nil
+}
+
+// Kill stops a process started by spawn or run. It reports whether the id
+// was still running.
+func (s *Service) Kill(id string) bool {
+	s.mu.Lock()
+	cancel, ok := s.processes[id]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (s *Service) prepare(ctx context.Context, command string, args []string, opts SpawnOptions) (*exec.Cmd, context.CancelFunc, error) {
+	path, err := s.authorize(ctx, command, args, opts.Env)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, err := s.workingDir(opts.Cwd)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The process outlives the request context, so it gets its own.
+	processCtx, cancel := context.WithCancel(context.Background())
+	if s.opts.Timeout > 0 {
+		processCtx, cancel = context.WithTimeout(context.Background(), s.opts.Timeout)
+	}
+	cmd := exec.CommandContext(processCtx, path, args...)
+	cmd.Dir = dir
+	cmd.Env = append([]string(nil), s.opts.Env...)
+	for key, value := range opts.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	if opts.Stdin != "" {
+		cmd.Stdin = strings.NewReader(opts.Stdin)
+	}
+	return cmd, cancel, nil
+}
+
+func (s *Service) authorize(ctx context.Context, command string, args []string, env map[string]string) (string, error) {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", err
+	}
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	if !s.commands[command] && !s.commands[path] {
+		return "", &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("%s: %v", command, ErrNotAllowed)}
+	}
+	for key := range env {
+		if !s.envAllow[key] {
+			return "", &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("environment variable %q not allowed", key)}
+		}
+	}
+	if s.opts.Allow != nil {
+		if err := s.opts.Allow(ctx, command, args, env); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+func (s *Service) workingDir(cwd string) (string, error) {
+	if cwd == "" {
+		if len(s.opts.Dirs) > 0 {
+			return s.opts.Dirs[0], nil
+		}
+		return "", nil
+	}
+	if len(s.opts.Dirs) == 0 {
+		return cwd, nil
+	}
+	resolved, err := filepath.EvalSymlinks(cwd)
+	if err != nil {
+		return "", err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range s.opts.Dirs {
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", &kkrpc.RpcError{Name: "PermissionError", Message: fmt.Sprintf("working directory %s is not allowed", cwd)}
+}
+
+func (s *Service) track(cancel context.CancelFunc) string {
+	id := kkrpc.GenerateUUID()
+	s.mu.Lock()
+	s.processes[id] = cancel
+	s.mu.Unlock()
+	return id
+}
+
+func (s *Service) untrack(id string) {
+	s.mu.Lock()
+	delete(s.processes, id)
+	s.mu.Unlock()
+}
+
+// exitEvent reports the exit code, or -1 with the reason when the process
+// did not exit normally, e.g. because it was killed.
+func exitEvent(err error) Event {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return Event{Type: "exit"}
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return Event{Type: "exit", Code: exitErr.ExitCode()}
+	default:
+		return Event{Type: "exit", Code: -1, Error: err.Error()}
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written and drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+//go:build !kkrpc_minimal
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+func serve(t *testing.T, opts Options) *kkrpc.Client {
+	clientTransport, serverTransport := kkrpc.NewPipeTransportPair()
+	server := kkrpc.NewServer(serverTransport, map[string]any{"shell": New(opts).API()})
+	t.Cleanup(func() { server.Close() })
+	return kkrpc.NewClient(clientTransport, kkrpc.WithTimeout(2*time.Second))
+}
+
+func TestSpawnStreamsOutputAndExitCode(t *testing.T) {
+	client := serve(t, Options{Commands: []string{"sh"}})
+
+	var rpcErr *kkrpc.RpcError
+	if _, err := client.Call("shell.spawn", "echo", []string{"hi"}); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("unlisted command: %v", err)
+	}
+
+	result, err := client.Call("shell.spawn", "sh", []string{"-c", "echo out; echo err >&2; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := result.(*kkrpc.RemoteStream)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	output := map[string]string{}
+	var exit map[string]any
+	for exit == nil {
+		value, err := events.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := value.(map[string]any)
+		switch event["type"] {
+		case "stdout", "stderr":
+			output[event["type"].(string)] += event["data"].(string)
+		case "exit":
+			exit = event
+		}
+	}
+	if output["stdout"] != "out\n" || output["stderr"] != "err\n" {
+		t.Fatalf("output %#v", output)
+	}
+	if exit["code"] != float64(3) {
+		t.Fatalf("exit %#v", exit)
+	}
+}
+
+func TestKillStopsProcess(t *testing.T) {
+	client := serve(t, Options{Commands: []string{"sleep"}})
+	result, err := client.Call("shell.spawn", "sleep", []string{"10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := result.(*kkrpc.RemoteStream)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start, err := events.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if killed, err := client.Call("shell.kill", start.(map[string]any)["id"]); err != nil || killed != true {
+		t.Fatalf("kill: %v %v", killed, err)
+	}
+	exit, err := events.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := exit.(map[string]any); event["type"] != "exit" || event["code"] != float64(-1) {
+		t.Fatalf("exit %#v", event)
+	}
+}
+
+func TestRunCollectsOutputWithinDirs(t *testing.T) {
+	dir := t.TempDir()
+	client := serve(t, Options{Commands: []string{"pwd"}, Dirs: []string{dir}})
+	var rpcErr *kkrpc.RpcError
+	if _, err := client.Call("shell.run", "pwd", []string{}, map[string]any{"cwd": "/"}); !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+		t.Fatalf("outside dirs: %v", err)
+	}
+	result, err := client.Call("shell.run", "pwd", []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(map[string]any); got["code"] != float64(0) || got["stdout"] == "" {
+		t.Fatalf("result %#v", got)
+	}
+}
+
+func TestCallerEnvironmentNeedsAllowing(t *testing.T) {
+	vetted := make(chan map[string]string, 1)
+	client := serve(t, Options{
+		Commands: []string{"sh"},
+		EnvAllow: []string{"GREETING"},
+		Allow: func(ctx context.Context, command string, args []string, env map[string]string) error {
+			vetted <- env
+			return nil
+		},
+	})
+	var rpcErr *kkrpc.RpcError
+	for _, name := range []string{"LD_PRELOAD", "PATH", "GIT_SSH_COMMAND"} {
+		_, err := client.Call("shell.run", "sh", []string{"-c", "true"}, map[string]any{"env": map[string]any{name: "/tmp/x"}})
+		if !errors.As(err, &rpcErr) || rpcErr.Name != "PermissionError" {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	result, err := client.Call("shell.run", "sh", []string{"-c", "echo $GREETING"}, map[string]any{"env": map[string]any{"GREETING": "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.(map[string]any); got["stdout"] != "hi\n" {
+		t.Fatalf("result %#v", got)
+	}
+	if env := <-vetted; env["GREETING"] != "hi" {
+		t.Fatalf("Allow saw %v", env)
+	}
+}
@@ -0,0 +1,77 @@
+//go:build !kkrpc_minimal
+
+// Package sysinfo exposes basic facts about the host and the Go process over
+// kkrpc, such as the platform, CPU count and memory use.
+package sysinfo
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// Info describes the host. It deliberately leaves out the environment and
+// user details, which callers should get from a dedicated, vetted API.
+type Info struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Hostname  string `json:"hostname"`
+	CPUs      int    `json:"cpus"`
+	GoVersion string `json:"goVersion"`
+	PID       int    `json:"pid"`
+	// StartedAt is when the service was created, in Unix milliseconds.
+	StartedAt int64 `json:"startedAt"`
+}
+
+// Memory reports the Go runtime's memory use in bytes.
+type Memory struct {
+	HeapAlloc  uint64 `json:"heapAlloc"`
+	HeapInuse  uint64 `json:"heapInuse"`
+	Sys        uint64 `json:"sys"`
+	NumGC      uint32 `json:"numGC"`
+	Goroutines int    `json:"goroutines"`
+}
+
+type Service struct {
+	started time.Time
+}
+
+func New() *Service {
+	return &Service{started: time.Now()}
+}
+
+// API returns the methods to mount, e.g. under "sys".
+func (s *Service) API() map[string]any {
+	return map[string]any{
+		"info":   kkrpc.MustFunc(s.Info),
+		"memory": kkrpc.MustFunc(s.Memory),
+	}
+}
+
+func (s *Service) Info() Info {
+	// A missing hostname is reported as empty rather than failing the call.
+	hostname, _ := os.Hostname()
+	return Info{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Hostname:  hostname,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+		PID:       os.Getpid(),
+		StartedAt: s.started.UnixMilli(),
+	}
+}
+
+func (s *Service) Memory() Memory {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return Memory{
+		HeapAlloc:  stats.HeapAlloc,
+		HeapInuse:  stats.HeapInuse,
+		Sys:        stats.Sys,
+		NumGC:      stats.NumGC,
+		Goroutines: runtime.NumGoroutine(),
+	}
+}
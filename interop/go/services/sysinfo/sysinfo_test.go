@@ -0,0 +1,19 @@
+//go:build !kkrpc_minimal
+
+package sysinfo
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestInfoDescribesProcess(t *testing.T) {
+	info := New().Info()
+	if info.OS != runtime.GOOS || info.CPUs < 1 || info.PID != os.Getpid() || info.StartedAt == 0 {
+		t.Fatalf("info %+v", info)
+	}
+	if memory := New().Memory(); memory.Sys == 0 || memory.Goroutines < 1 {
+		t.Fatalf("memory %+v", memory)
+	}
+}
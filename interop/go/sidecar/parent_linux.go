@@ -0,0 +1,21 @@
+package sidecar
+
+import (
+	"os"
+	"syscall"
+)
+
+// watchParent asks the kernel for SIGTERM when the parent exits; Start already
+// shuts down on it.
+func watchParent(s *Sidecar) {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_PDEATHSIG, uintptr(syscall.SIGTERM), 0)
+	if errno != 0 {
+		s.logger.Printf("cannot set the parent-death signal, polling instead: %v", errno)
+		go s.pollParent(os.Getppid, parentPollInterval)
+		return
+	}
+	// The parent may have died before the signal was set.
+	if os.Getppid() != s.ppid {
+		s.finish(ErrParentDied)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package sidecar
+
+import "os"
+
+func watchParent(s *Sidecar) {
+	go s.pollParent(os.Getppid, parentPollInterval)
+}
@@ -0,0 +1,17 @@
+package sidecar
+
+import "os"
+
+// watchParent waits on the parent's process handle. Windows never reparents,
+// so the parent pid alone cannot tell that it died.
+func watchParent(s *Sidecar) {
+	parent, err := os.FindProcess(s.ppid)
+	if err != nil {
+		s.finish(ErrParentDied)
+		return
+	}
+	go func() {
+		_, _ = parent.Wait()
+		s.finish(ErrParentDied)
+	}()
+}
@@ -0,0 +1,247 @@
+// Package sidecar runs a Go binary as the kkrpc endpoint of a Tauri app that
+// spawns it as a sidecar and talks to it over stdio, like Kunkun extensions.
+// It keeps stdout for the protocol, sends every log line to stderr, and shuts
+// down when the app closes the pipe or signals the process.
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+// ErrHostClosed reports that the app closed the sidecar's stdin, which Tauri
+// does when the app exits or drops the child.
+var ErrHostClosed = errors.New("sidecar: host closed stdin")
+
+// ErrParentDied reports that the process that started the sidecar exited
+// without closing its stdin, as a crashed host may.
+var ErrParentDied = errors.New("sidecar: parent process died")
+
+// parentPollInterval is how often platforms without a parent-death
+// notification check the parent pid.
+var parentPollInterval = time.Second
+
+type Options struct {
+	// API is exposed to the app; the returned channel can also call the
+	// app's API.
+	API map[string]any
+	// Options configure the channel, after a logger writing to stderr and the
+	// KKRPC_* environment variables read by kkrpc.LoadConfig.
+	Options []kkrpc.Option
+	// LogPrefix starts every log line; it defaults to the binary name.
+	LogPrefix string
+	// OnShutdown runs once the app goes away and running calls have
+	// finished, before the channel closes. Both are bounded by
+	// ShutdownTimeout.
+	OnShutdown func(ctx context.Context) error
+	// ShutdownTimeout defaults to 5s; Tauri kills sidecars soon after.
+	ShutdownTimeout time.Duration
+	// ExitOnParentDeath also shuts the sidecar down when its parent process
+	// dies while stdin stays open, e.g. because a grandchild inherited it.
+	// Linux uses the parent-death signal, which the kernel sends when the
+	// thread that started the sidecar exits: leave this off for hosts that
+	// spawn from short-lived threads. Windows waits on the parent's handle and
+	// other systems poll the parent pid every second.
+	ExitOnParentDeath bool
+
+	// Stdin, Stdout and Stderr replace the process's for tests. Left nil, the
+	// real ones are used and os.Stdout is pointed at stderr, so a stray
+	// fmt.Println cannot corrupt the protocol.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+type Sidecar struct {
+	opts    Options
+	channel *kkrpc.Channel
+	logger  *log.Logger
+	signals chan os.Signal
+	ppid    int
+
+	done     chan struct{}
+	doneOnce sync.Once
+	reason   error
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// Start exposes the API on stdio and returns at once; call Wait to block
+// until the app goes away.
+func Start(opts Options) *Sidecar {
+	stdin, stdout, stderr := opts.Stdin, opts.Stdout, opts.Stderr
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	if opts.LogPrefix == "" {
+		opts.LogPrefix = filepath.Base(os.Args[0])
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = 5 * time.Second
+	}
+	logger := log.New(stderr, opts.LogPrefix+": ", log.LstdFlags|log.Lmsgprefix)
+	if opts.Stderr == nil {
+		log.SetOutput(stderr)
+	}
+
+	s := &Sidecar{opts: opts, logger: logger, signals: make(chan os.Signal, 1), done: make(chan struct{})}
+	// StreamTransport accepts the CRLF line endings written on Windows and
+	// writes one message per line, which is how Tauri's shell plugin splits
+	// stdout into events.
+	transport := kkrpc.NewStreamTransport(stdio{
+		Reader: &eofReader{Reader: stdin, sidecar: s},
+		Writer: stdout,
+	})
+	config, err := kkrpc.LoadConfig()
+	if err != nil {
+		logger.Printf("ignoring the environment: %v", err)
+	}
+	channelOpts := append([]kkrpc.Option{kkrpc.WithLogger(logger)}, config.Options()...)
+	channelOpts = append(channelOpts, opts.Options...)
+	s.channel = kkrpc.NewChannel(transport, opts.API, channelOpts...)
+
+	signal.Notify(s.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-s.signals:
+			s.finish(s.signalReason(sig))
+		case <-s.done:
+		}
+	}()
+	if opts.ExitOnParentDeath {
+		s.ppid = os.Getppid()
+		watchParent(s)
+	}
+	return s
+}
+
+// Run starts the sidecar and waits for the app to go away.
+func Run(opts Options) error {
+	return Start(opts).Wait()
+}
+
+// Channel calls the app and serves the API.
+func (s *Sidecar) Channel() *kkrpc.Channel {
+	return s.channel
+}
+
+// Logger writes to stderr, which Tauri surfaces as the child's stderr events.
+func (s *Sidecar) Logger() *log.Logger {
+	return s.logger
+}
+
+// Done is closed when the app closes stdin or the process is signalled.
+func (s *Sidecar) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the sidecar is done, or nil while it runs.
+func (s *Sidecar) Err() error {
+	select {
+	case <-s.done:
+		return s.reason
+	default:
+		return nil
+	}
+}
+
+// Shutdown ends the sidecar from within, as if the app had gone away.
+func (s *Sidecar) Shutdown() {
+	s.finish(nil)
+}
+
+// Wait blocks until Done, lets running calls finish, runs OnShutdown and
+// closes the channel. It returns the error of OnShutdown.
+func (s *Sidecar) Wait() error {
+	<-s.done
+	s.waitOnce.Do(func() {
+		signal.Stop(s.signals)
+		if s.reason != nil {
+			s.logger.Printf("shutting down: %v", s.reason)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+		defer cancel()
+		if err := s.channel.Server().Drain(ctx); err != nil {
+			s.logger.Printf("calls still running at shutdown: %v", err)
+		}
+		if s.opts.OnShutdown != nil {
+			s.waitErr = s.opts.OnShutdown(ctx)
+		}
+		_ = s.channel.Close()
+	})
+	return s.waitErr
+}
+
+func (s *Sidecar) signalReason(sig os.Signal) error {
+	if s.opts.ExitOnParentDeath && os.Getppid() != s.ppid {
+		return ErrParentDied
+	}
+	return fmt.Errorf("sidecar: received %v", sig)
+}
+
+// pollParent finishes the sidecar once it has been reparented, which is how
+// the death of its parent shows on systems without a notification for it.
+func (s *Sidecar) pollParent(getppid func() int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if getppid() != s.ppid {
+				s.finish(ErrParentDied)
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Sidecar) finish(reason error) {
+	s.doneOnce.Do(func() {
+		s.reason = reason
+		close(s.done)
+	})
+}
+
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// Close leaves the process's stdio open; only the app closes the pipes.
+func (stdio) Close() error {
+	return nil
+}
+
+// eofReader ends the sidecar once stdin is exhausted.
+type eofReader struct {
+	io.Reader
+	sidecar *Sidecar
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		r.sidecar.finish(ErrHostClosed)
+	}
+	return n, err
+}
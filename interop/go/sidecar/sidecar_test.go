@@ -0,0 +1,119 @@
+//go:build !kkrpc_minimal
+
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kkrpc-interop/kkrpc"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSidecarServesAppAndShutsDownOnEOF(t *testing.T) {
+	appToSidecar, sidecarIn := io.Pipe()
+	sidecarOut, sidecarToApp := io.Pipe()
+	stderr := &lockedBuffer{}
+	shutdown := make(chan struct{})
+	s := Start(Options{
+		API: map[string]any{
+			"version": kkrpc.MustFunc(func() string { return "1.2.0" }),
+		},
+		LogPrefix: "ext",
+		OnShutdown: func(ctx context.Context) error {
+			close(shutdown)
+			return nil
+		},
+		Stdin:  appToSidecar,
+		Stdout: sidecarToApp,
+		Stderr: stderr,
+	})
+
+	// The app side, writing CRLF line endings as it may on Windows.
+	app := kkrpc.NewClient(kkrpc.NewStdioTransport(sidecarOut, crlfWriter{sidecarIn}), kkrpc.WithTimeout(2*time.Second))
+	version, err := app.Call("version")
+	if err != nil || version != "1.2.0" {
+		t.Fatalf("version = %v, %v", version, err)
+	}
+
+	_ = sidecarIn.Close()
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("sidecar did not notice the closed stdin")
+	}
+	if !errors.Is(s.Err(), ErrHostClosed) {
+		t.Fatalf("reason %v", s.Err())
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-shutdown:
+	default:
+		t.Fatal("OnShutdown did not run")
+	}
+	if log := stderr.String(); !strings.Contains(log, "ext: shutting down: sidecar: host closed stdin") {
+		t.Fatalf("stderr %q", log)
+	}
+}
+
+type crlfWriter struct {
+	io.Writer
+}
+
+func (w crlfWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n")))
+	return min(n, len(p)), err
+}
+
+func TestSidecarExitsWhenReparented(t *testing.T) {
+	appToSidecar, _ := io.Pipe()
+	_, sidecarToApp := io.Pipe()
+	s := Start(Options{LogPrefix: "ext", Stdin: appToSidecar, Stdout: sidecarToApp, Stderr: io.Discard})
+	s.ppid = 4242
+	ppid := make(chan int, 1)
+	ppid <- 4242
+	go s.pollParent(func() int {
+		select {
+		case pid := <-ppid:
+			return pid
+		default:
+			return 1
+		}
+	}, time.Millisecond)
+
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("sidecar outlived its parent")
+	}
+	if !errors.Is(s.Err(), ErrParentDied) {
+		t.Fatalf("reason %v", s.Err())
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
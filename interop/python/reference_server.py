@@ -0,0 +1,43 @@
+"""Stdio reference server mirroring interop/node/server.ts for cross-language tests."""
+
+import os
+import sys
+
+sys.path.insert(0, os.path.dirname(os.path.abspath(__file__)))
+
+from kkrpc import RpcServer, StdioTransport
+
+
+def with_callback(value, cb):
+    cb(f"callback:{value}")
+    return "callback-sent"
+
+
+API = {
+    "math": {
+        "add": lambda a, b: a + b,
+    },
+    "echo": lambda value: value,
+    "withCallback": with_callback,
+    "counter": 42,
+    "settings": {
+        "theme": "light",
+        "notifications": {
+            "enabled": True,
+        },
+    },
+}
+
+
+def main() -> None:
+    transport = StdioTransport(sys.stdin, sys.stdout)
+    server = RpcServer(transport, API)
+    # The read loop ends when stdin closes, which is how the harness stops us.
+    server._reader_thread.join()
+
+
+if __name__ == "__main__":
+    try:
+        main()
+    except KeyboardInterrupt:
+        pass